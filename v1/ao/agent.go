@@ -4,6 +4,8 @@ package ao
 
 import (
 	"context"
+	"io"
+	"net/http"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	aolog "github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
@@ -30,6 +32,9 @@ var (
 
 func init() {
 	initDisabled()
+	initControlSocket()
+	initConfigWatcher()
+	initRemoteConfig()
 }
 
 func initDisabled() {
@@ -64,9 +69,69 @@ func WaitForReady(ctx context.Context) bool {
 //
 // This function should be called only once.
 func Shutdown(ctx context.Context) error {
+	closeControlSocket()
+	closeConfigWatcher()
+	closeRemoteConfig()
 	return reporter.Shutdown(ctx)
 }
 
+// FlushMetrics sends the pending metrics to the collector immediately instead
+// of waiting for the next collection interval, which is useful for tests and
+// short-lived jobs. It blocks until the metrics are sent or the context is
+// canceled. Shutdown flushes the metrics the same way before the agent stops.
+func FlushMetrics(ctx context.Context) error {
+	return reporter.FlushMetrics(ctx)
+}
+
+// SendDeployMarker sends a marker of the deploy of the version, e.g., a git tag
+// or commit, with an optional description, so the backend can annotate the
+// timeline and the changes of the latency can be correlated to the deploys.
+// The time of the deploy is the time of the call. It blocks until the marker
+// is sent or the context is canceled.
+func SendDeployMarker(ctx context.Context, version, description string) error {
+	if Disabled() {
+		return nil
+	}
+	return reporter.SendDeployMarker(ctx, version, description)
+}
+
+// WritePrometheusHistograms writes the response time histograms of the
+// transactions since the agent started to w in the Prometheus text exposition
+// format, i.e., as classic histogram buckets with the le labels, which are
+// cumulative and never reset.
+// The bucket bounds can be configured via APPOPTICS_HISTOGRAM_BUCKETS, and the
+// namespace of the metric names via APPOPTICS_METRICS_NAMESPACE.
+// The ResourceAttributes are written as well as the labels of the target_info
+// metric, so the histograms can be associated with the service.
+func WritePrometheusHistograms(w io.Writer) error {
+	if err := reporter.WritePrometheusTargetInfo(w, ResourceAttributes()); err != nil {
+		return err
+	}
+	return reporter.WritePrometheusHistograms(w)
+}
+
+// InitError returns the error of initializing the agent, e.g., an invalid
+// service key or configuration file, if the agent is configured to fail closed
+// (APPOPTICS_FAIL_CLOSED=true), so that the application can refuse to start
+// without observability. It always returns nil in the default fail-open mode,
+// in which the error is only logged and the agent no-ops.
+//
+// The connection to the collector is established in the background and is not
+// covered, use WaitForReady with a deadline to check it.
+//
+// The error is exposed by a getter as the agent is initialized when the package
+// is imported, before any function of the application runs, so there is no
+// constructor to return it from.
+func InitError() error {
+	if !config.GetFailClosed() {
+		return nil
+	}
+	if err := config.GetLoadError(); err != nil {
+		return err
+	}
+	return reporter.InitError()
+}
+
 // Closed denotes if the agent is closed (by either calling Shutdown explicitly
 // or being triggered from some internal error).
 func Closed() bool {
@@ -84,7 +149,107 @@ func SetLogLevel(level string) error {
 	return nil
 }
 
+// SetSampleRate changes the local sample rate of the AppOptics agent at
+// runtime, which applies to the traces started afterwards, until the
+// configuration is reloaded. The rate is out of 1000000, e.g., 500000 is 50%.
+// It follows the same precedence as APPOPTICS_SAMPLE_RATE, so it doesn't
+// raise the sample rate if the collector overrides the local settings.
+//
+// Raising the sample rate also sends the unsampled traces captured within the
+// APPOPTICS_RETROACTIVE_CAPTURE_WINDOW, if it's enabled.
+func SetSampleRate(rate int) error {
+	old := config.GetSampleRate()
+	if err := config.SetSampleRate(rate); err != nil {
+		return err
+	}
+	reporter.ReapplyLocalSettings()
+	if rate > old {
+		reporter.FlushRetroactiveTraces()
+	}
+	return nil
+}
+
+// SetTracingMode changes the local tracing mode of the AppOptics agent at
+// runtime, the same way as SetSampleRate. Valid modes: enabled, disabled
+func SetTracingMode(mode string) error {
+	if err := config.SetTracingMode(config.TracingMode(mode)); err != nil {
+		return err
+	}
+	reporter.ReapplyLocalSettings()
+	return nil
+}
+
+// ConfigDelta is the config items changed by ReloadConfig. Its String method
+// lists the items with the old values as the defaults, and the values of the
+// service key and secret fields are masked.
+type ConfigDelta = config.Delta
+
+// ReloadConfig reloads the configuration from the config file and environment
+// variables at runtime, e.g., to change APPOPTICS_TRACING_MODE and
+// APPOPTICS_SAMPLE_RATE without a restart, and returns the config items changed
+// since the last load. It's safe for concurrent use, and the traces started
+// concurrently see either the old or the new sampling config, never a mix. The
+// current config is kept if the new one fails to load, e.g., it's invalid.
+//
+// The sample rate and tracing mode changed by SetSampleRate and SetTracingMode
+// are discarded, and the transaction filters are reloaded as well. The config
+// fetched from the remote config source, if any, is applied again over the
+// config reloaded. Most of the other config items, e.g., the collector address, are only read when the agent
+// starts and don't take effect until a restart.
+//   delta, err := ao.ReloadConfig()
+//   if delta.Changed("Sampling.SampleRate") { ... }
+func ReloadConfig() (ConfigDelta, error) {
+	delta, err := config.ReloadConfig()
+	reporter.ReapplyLocalSettings()
+	reporter.ReloadURLsConfig(config.GetTransactionFiltering())
+	reporter.ReloadTenants()
+	reloadRemoteConfig()
+	return delta, err
+}
+
 // GetLogLevel returns the current logging level of the AppOptics agent
 func GetLogLevel() string {
 	return aolog.LevelStr[aolog.Level()]
 }
+
+// TraceRingHandler keeps the most recent `size` finished traces in memory,
+// bounded by `maxBytes` bytes of events, and returns an http.Handler which
+// renders them as JSON. The events are still sent to the collector as usual.
+//
+// It's meant for on-box debugging and is not mounted anywhere by default. A
+// default value is used for any argument which is not positive. It should be
+// called before any trace is started.
+func TraceRingHandler(size, maxBytes int) http.Handler {
+	ring := reporter.NewTraceRing(size, maxBytes)
+	reporter.EnableTraceRing(ring)
+	return ring
+}
+
+// AgentDiagnostics is a snapshot of the internal state of the agent, e.g., the
+// depth of the message queues, the number of reconnections to the collector,
+// the last error returned by the collector and the version of the sampling
+// settings.
+type AgentDiagnostics reporter.Diagnostics
+
+// Diagnostics returns a snapshot of the internal state of the agent. It's safe
+// for concurrent use and is cheap enough to be logged periodically or exposed
+// on a debug endpoint.
+func Diagnostics() AgentDiagnostics {
+	return AgentDiagnostics(reporter.GetDiagnostics())
+}
+
+// IDGenerator generates the IDs of the traces and spans, e.g., to produce
+// predictable IDs in tests. The trace IDs are 20 bytes and the span IDs are 8
+// bytes, and the generator fills the slices provided. It must be safe for
+// concurrent use.
+type IDGenerator interface {
+	GenerateTraceID(id []byte) error
+	GenerateSpanID(id []byte) error
+}
+
+// SetIDGenerator replaces the generator of the trace and span IDs, which is
+// based on crypto/rand by default. The default generator is restored if g is
+// nil. A trace or span is not reported if the generator returns an error.
+func SetIDGenerator(g IDGenerator) {
+	reporter.SetIDGenerator(g)
+}
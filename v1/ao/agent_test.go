@@ -4,9 +4,15 @@ package ao
 
 import (
 	"context"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -33,3 +39,109 @@ func TestShutdown(t *testing.T) {
 	defer cancel()
 	assert.False(t, WaitForReady(ctx))
 }
+
+func TestDiagnostics(t *testing.T) {
+	r := reporter.SetTestReporter()
+	defer r.Close(0)
+
+	d := Diagnostics()
+	assert.Equal(t, "test", d.Reporter)
+	assert.False(t, d.Closed)
+	assert.Equal(t, 1000000, d.SampleRate)
+	assert.False(t, d.SettingsUpdated.IsZero())
+}
+
+func TestSendDeployMarker(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	assert.NoError(t, SendDeployMarker(context.Background(), "v1.2.3", "new checkout"))
+
+	r.Close(1)
+	g.AssertGraph(t, r.EventBufs, 1, g.AssertNodeMap{
+		{"go", "single"}: {Callback: func(n g.Node) {
+			assert.Equal(t, 1, n.Map["__DeployMarker"])
+			assert.Equal(t, "v1.2.3", n.Map["DeployVersion"])
+			assert.Equal(t, "new checkout", n.Map["DeployDescription"])
+			assert.NotZero(t, n.Map["Timestamp_u"])
+		}},
+	})
+}
+
+// seqIDGenerator generates the IDs from a sequence number.
+type seqIDGenerator struct {
+	sync.Mutex
+	seq byte
+}
+
+func (g *seqIDGenerator) next(id []byte) {
+	g.Lock()
+	defer g.Unlock()
+	g.seq++
+	for i := range id {
+		id[i] = g.seq
+	}
+}
+
+func (g *seqIDGenerator) GenerateTraceID(id []byte) error { g.next(id); return nil }
+func (g *seqIDGenerator) GenerateSpanID(id []byte) error  { g.next(id); return nil }
+
+func TestSetIDGenerator(t *testing.T) {
+	r := reporter.SetTestReporter()
+	SetIDGenerator(&seqIDGenerator{})
+	defer SetIDGenerator(nil)
+
+	tr := NewTrace("test")
+	// the trace ID and the op ID of the metadata, then the op ID of the entry event
+	assert.Equal(t, "2B"+strings.Repeat("01", 20)+strings.Repeat("03", 8)+"01", tr.MetadataString())
+	assert.Equal(t, strings.Repeat("01", 20), tr.LoggableTraceID()[:40])
+	tr.End()
+
+	SetIDGenerator(nil)
+	tr = NewTrace("test")
+	assert.NotEqual(t, strings.Repeat("01", 20), tr.LoggableTraceID()[:40])
+	tr.End()
+
+	r.Close(4)
+}
+
+func TestInitError(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_FAIL_CLOSED")
+		config.Load()
+	}()
+	os.Setenv("APPOPTICS_SERVICE_KEY", "invalid")
+
+	// fail-open: the error is only logged
+	os.Setenv("APPOPTICS_FAIL_CLOSED", "false")
+	assert.Error(t, config.Load())
+	assert.NoError(t, InitError())
+
+	// fail-closed: the error is surfaced
+	os.Setenv("APPOPTICS_FAIL_CLOSED", "true")
+	assert.Error(t, config.Load())
+	assert.Error(t, InitError())
+	assert.Contains(t, InitError().Error(), config.ErrInvalidServiceKey.Error())
+}
+
+func TestReloadConfig(t *testing.T) {
+	r := reporter.SetTestReporter()
+	defer r.Close(0)
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_SAMPLE_RATE")
+		config.Load()
+		reporter.ReapplyLocalSettings()
+	}()
+
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	os.Setenv("APPOPTICS_SAMPLE_RATE", "500000")
+	delta, err := ReloadConfig()
+	assert.NoError(t, err)
+	assert.True(t, delta.Changed("Sampling.SampleRate"))
+	assert.Equal(t, 500000, Diagnostics().SampleRate)
+
+	delta, err = ReloadConfig()
+	assert.NoError(t, err)
+	assert.Empty(t, delta.Keys())
+}
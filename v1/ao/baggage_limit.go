@@ -0,0 +1,76 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// the minimum interval between two warnings of the trimmed baggage
+const trimmedBaggageWarnInterval = time.Minute
+
+// the time in nanoseconds of the last warning of the trimmed baggage
+var lastTrimmedBaggageWarn int64
+
+// TrimBaggage returns the baggage items to be propagated downstream within
+// the maximum total size of their keys and values (APPOPTICS_BAGGAGE_MAX_BYTES)
+// and the maximum number of them (APPOPTICS_BAGGAGE_MAX_ITEMS). The largest
+// items are dropped first, so that as many items as possible are kept, and
+// the items of the same size are dropped in the reverse order of their keys.
+// A warning is logged at most once per minute if any item is dropped. The
+// baggage is returned as is if it's within the limits, otherwise a trimmed
+// copy is returned.
+func TrimBaggage(baggage map[string]string) map[string]string {
+	return TrimBaggageWithKeyPrefix(baggage, "")
+}
+
+// TrimBaggageWithKeyPrefix is the same as TrimBaggage, except that the size of
+// an item includes the prefix added to its key when it's propagated, e.g.,
+// ot-baggage- by the OpenTracing text maps, so the bytes actually sent are
+// limited.
+func TrimBaggageWithKeyPrefix(baggage map[string]string, prefix string) map[string]string {
+	maxBytes, maxItems := config.GetBaggageLimits()
+	itemSize := func(k string) int { return len(prefix) + len(k) + len(baggage[k]) }
+	size := 0
+	for k := range baggage {
+		size += itemSize(k)
+	}
+	if size <= maxBytes && len(baggage) <= maxItems {
+		return baggage
+	}
+
+	keys := make([]string, 0, len(baggage))
+	for k := range baggage {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if si, sj := itemSize(keys[i]), itemSize(keys[j]); si != sj {
+			return si < sj
+		}
+		return keys[i] < keys[j]
+	})
+	trimmed := make(map[string]string)
+	size = 0
+	for _, k := range keys {
+		// the items left are not smaller
+		if len(trimmed) >= maxItems || size+itemSize(k) > maxBytes {
+			break
+		}
+		trimmed[k] = baggage[k]
+		size += itemSize(k)
+	}
+
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&lastTrimmedBaggageWarn)
+	if now-last >= int64(trimmedBaggageWarnInterval) &&
+		atomic.CompareAndSwapInt64(&lastTrimmedBaggageWarn, last, now) {
+		log.Warningf("Dropped %d of %d baggage items exceeding the limits of %d bytes and %d items.",
+			len(baggage)-len(trimmed), len(baggage), maxBytes, maxItems)
+	}
+	return trimmed
+}
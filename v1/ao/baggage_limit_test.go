@@ -0,0 +1,67 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setBaggageLimits(maxBytes, maxItems string) func() {
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	os.Setenv("APPOPTICS_BAGGAGE_MAX_BYTES", maxBytes)
+	os.Setenv("APPOPTICS_BAGGAGE_MAX_ITEMS", maxItems)
+	config.Load()
+	return func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_BAGGAGE_MAX_BYTES")
+		os.Unsetenv("APPOPTICS_BAGGAGE_MAX_ITEMS")
+		config.Load()
+	}
+}
+
+func TestTrimBaggage(t *testing.T) {
+	baggage := map[string]string{"a": "1", "b": "22", "c": "333", "d": "4"}
+	// within the default limits
+	assert.Equal(t, baggage, ao.TrimBaggage(baggage))
+
+	// the largest items are dropped first, then by the reverse order of keys
+	defer setBaggageLimits("", "2")()
+	assert.Equal(t, map[string]string{"a": "1", "d": "4"}, ao.TrimBaggage(baggage))
+	assert.Len(t, baggage, 4) // not modified
+
+	setBaggageLimits("8", "")
+	assert.Equal(t, map[string]string{"a": "1", "b": "22", "d": "4"}, ao.TrimBaggage(baggage))
+	setBaggageLimits("7", "")
+	assert.Equal(t, map[string]string{"a": "1", "b": "22", "d": "4"}, ao.TrimBaggage(baggage))
+	setBaggageLimits("6", "")
+	assert.Equal(t, map[string]string{"a": "1", "d": "4"}, ao.TrimBaggage(baggage))
+
+	// the key prefix is counted as well
+	setBaggageLimits("8", "")
+	assert.Equal(t, map[string]string{"a": "1", "d": "4"}, ao.TrimBaggageWithKeyPrefix(baggage, "p-"))
+
+	assert.Nil(t, ao.TrimBaggage(nil))
+}
+
+func TestMarshalContextTrimmedBaggage(t *testing.T) {
+	defer setBaggageLimits("16", "")()
+	r := reporter.SetTestReporter()
+	tr := ao.NewTrace("test")
+	ctx := ao.WithBaggage(ao.NewContext(context.Background(), tr),
+		map[string]string{"tenant": "acme", "note": strings.Repeat("x", 32)})
+
+	rctx, err := ao.UnmarshalContext(ao.MarshalContext(ctx))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"tenant": "acme"}, ao.BaggageFromContext(rctx))
+	tr.End()
+	r.Close(2)
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+const (
+	keyCacheName = "CacheName"
+	keyCacheHit  = "CacheHit"
+)
+
+// RecordCacheHit records a hit of the cache named cacheName. It reports an
+// info event with the CacheName and CacheHit KVs on the span associated with
+// the context, if any, and increments the CacheHitCount metric of the cache.
+// The metrics of at most 50 cache names are reported per interval, the others
+// are aggregated as "other".
+func RecordCacheHit(ctx context.Context, cacheName string) {
+	recordCacheAccess(ctx, cacheName, true)
+}
+
+// RecordCacheMiss records a miss of the cache named cacheName. It's the same
+// as RecordCacheHit except that it increments the CacheMissCount metric.
+func RecordCacheMiss(ctx context.Context, cacheName string) {
+	recordCacheAccess(ctx, cacheName, false)
+}
+
+func recordCacheAccess(ctx context.Context, cacheName string, hit bool) {
+	if Disabled() {
+		return
+	}
+	reporter.RecordCacheAccess(cacheName, hit)
+	Info(ctx, keyCacheName, cacheName, keyCacheHit, hit)
+}
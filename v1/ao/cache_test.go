@@ -0,0 +1,37 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordCache(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("cached"))
+	ao.RecordCacheHit(ctx, "users")
+	ao.RecordCacheMiss(ctx, "orders")
+	ao.EndTrace(ctx)
+
+	// no-op without a trace
+	ao.RecordCacheHit(context.Background(), "users")
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeKVMap{
+		{"cached", "entry", "", ""}: {},
+		{"cached", "info", "CacheName", "users"}: {Edges: g.Edges{{"cached", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, true, n.Map["CacheHit"])
+		}},
+		{"cached", "info", "CacheName", "orders"}: {Edges: g.Edges{{"cached", "info"}}, Callback: func(n g.Node) {
+			assert.Equal(t, false, n.Map["CacheHit"])
+		}},
+		{"cached", "exit", "", ""}: {Edges: g.Edges{{"cached", "info"}}},
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// The values of the SpanStatus KV reported by the exit event of a span which
+// is cancelled, rather than failed.
+const (
+	// SpanStatusCancelled is reported if the context of the span is canceled,
+	// e.g., the client gave up the request, or the span ends with the error
+	// context.Canceled.
+	SpanStatusCancelled = "cancelled"
+	// SpanStatusDeadlineExceeded is reported if the deadline of the context of
+	// the span is exceeded, or the span ends with the error
+	// context.DeadlineExceeded.
+	SpanStatusDeadlineExceeded = "deadline_exceeded"
+)
+
+// cancelStatus returns the span status of the error if it's caused by the
+// cancellation of a context, or an empty string otherwise.
+func cancelStatus(err error) string {
+	switch errors.Cause(err) {
+	case context.Canceled:
+		return SpanStatusCancelled
+	case context.DeadlineExceeded:
+		return SpanStatusDeadlineExceeded
+	default:
+		return ""
+	}
+}
+
+// cancelStatusLocked returns the span status if the span is cancelled, either
+// by reporting a cancellation error or by the context it's started with. The
+// context of a child span is checked only while its parent is active, as it
+// may be cancelled once the parent ends, e.g., the request context once the
+// HTTP handler returns, which doesn't cancel the spans still running
+// asynchronously. The caller must hold the lock of the span.
+func (s *span) cancelStatusLocked() string {
+	err := s.cancelErr
+	if err == nil && s.ctxErr != nil && (s.parent == nil || s.parent.ok()) {
+		err = s.ctxErr()
+	}
+	return cancelStatus(err)
+}
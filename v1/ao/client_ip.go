@@ -0,0 +1,63 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+// The headers set by the proxies to carry the address of the original client.
+const (
+	ForwardedForHeaderName = "X-Forwarded-For"
+	RealIPHeaderName       = "X-Real-IP"
+)
+
+// ClientIP resolves the IP address of the client of an inbound request, which
+// is reported by the server spans. The remote address of the connection is
+// used, unless the forwarding headers are trusted (APPOPTICS_TRUST_FORWARDED_FOR),
+// in which case the X-Forwarded-For header is walked from right to left,
+// skipping the addresses of the trusted proxies (APPOPTICS_TRUSTED_PROXIES),
+// and the first other address is used, as the addresses on its left may be
+// forged by the client. The leftmost address is used if all of them are
+// trusted. The X-Real-IP header is used if X-Forwarded-For has no valid IP.
+func ClientIP(remoteAddr, forwardedFor, realIP string) string {
+	if config.GetTrustForwardedFor() {
+		if ip := forwardedClientIP(forwardedFor); ip != nil {
+			return ip.String()
+		}
+		if ip := net.ParseIP(strings.TrimSpace(realIP)); ip != nil {
+			return ip.String()
+		}
+	}
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		return host
+	}
+	return remoteAddr
+}
+
+// forwardedClientIP returns the rightmost address of the X-Forwarded-For header
+// which is not of a trusted proxy. The walk stops at an invalid address.
+func forwardedClientIP(forwardedFor string) net.IP {
+	var client net.IP
+	addrs := strings.Split(forwardedFor, ",")
+	for i := len(addrs) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(addrs[i]))
+		if ip == nil {
+			break
+		}
+		client = ip
+		if !config.IsTrustedProxy(ip) {
+			break
+		}
+	}
+	return client
+}
+
+// clientIPFromHTTPRequest resolves the IP address of the client of the request.
+func clientIPFromHTTPRequest(r *http.Request) string {
+	return ClientIP(r.RemoteAddr, r.Header.Get(ForwardedForHeaderName), r.Header.Get(RealIPHeaderName))
+}
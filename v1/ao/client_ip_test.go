@@ -0,0 +1,51 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	assert.Equal(t, "10.0.0.1", ao.ClientIP("10.0.0.1:80", "203.0.113.7", "198.51.100.3"))
+	assert.Equal(t, "::1", ao.ClientIP("[::1]:80", "", ""))
+	assert.Equal(t, "10.0.0.1", ao.ClientIP("10.0.0.1", "", ""))
+	assert.Equal(t, "", ao.ClientIP("", "", ""))
+
+	os.Setenv("APPOPTICS_TRUST_FORWARDED_FOR", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_TRUST_FORWARDED_FOR")
+		config.Load()
+	}()
+	// the address appended by the nearest proxy is used
+	assert.Equal(t, "10.0.0.2", ao.ClientIP("10.0.0.1:80", " 203.0.113.7 , 10.0.0.2", "198.51.100.3"))
+	assert.Equal(t, "2001:db8::1", ao.ClientIP("10.0.0.1:80", "2001:db8::1", ""))
+	// X-Real-IP is used if X-Forwarded-For is missing or invalid
+	assert.Equal(t, "198.51.100.3", ao.ClientIP("10.0.0.1:80", "", "198.51.100.3"))
+	assert.Equal(t, "198.51.100.3", ao.ClientIP("10.0.0.1:80", "unknown", "198.51.100.3"))
+	// falls back to the remote address if none is valid
+	assert.Equal(t, "10.0.0.1", ao.ClientIP("10.0.0.1:80", "unknown", "bogus"))
+
+	// the config items are only validated with a valid service key
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	os.Setenv("APPOPTICS_TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_TRUSTED_PROXIES")
+	}()
+	// the trusted proxies are skipped from right to left
+	assert.Equal(t, "203.0.113.7", ao.ClientIP("10.0.0.1:80", " 203.0.113.7 , 10.0.0.2", "198.51.100.3"))
+	assert.Equal(t, "198.51.100.9",
+		ao.ClientIP("10.0.0.1:80", "203.0.113.7, 198.51.100.9, 192.0.2.1, 10.0.0.2", ""))
+	// the forged address on the left of the client is ignored
+	assert.Equal(t, "203.0.113.7", ao.ClientIP("10.0.0.1:80", "1.2.3.4, 203.0.113.7, 10.0.0.2", ""))
+	// the leftmost address is used if all of them are trusted
+	assert.Equal(t, "10.0.0.3", ao.ClientIP("10.0.0.1:80", "10.0.0.3, 10.0.0.2", ""))
+}
@@ -3,14 +3,26 @@
 
 package ao
 
-import "context"
+import (
+	"context"
+	"math/rand"
+	"strings"
+	"unicode"
+)
 
 // BeginQuerySpan returns a Span that reports metadata used by AppOptics to filter
 // query latency heatmaps and charts by span name, query statement, DB host and table.
 // Parameter "flavor" specifies the flavor of the query statement, such as "mysql", "postgresql", or "mongodb".
 // Call or defer the returned Span's End() to time the query's client-side latency.
+//
+// The query spans of a sampled trace can be sampled further by the SQL statement
+// type, e.g., SELECT or INSERT, via the SQLSampleRates configuration.
 func BeginQuerySpan(ctx context.Context, spanName, query, flavor, remoteHost string, args ...interface{}) Span {
+	if IsSampled(ctx) && !settingsOf(TraceFromContext(ctx)).sampleQuery(query) {
+		return nullSpan{}
+	}
 	qsKVs := []interface{}{"Spec", "query", "Query", query, "Flavor", flavor, "RemoteHost", remoteHost}
+	qsKVs = mergeKVs(qsKVs, remoteSpanKVs(RemoteKindDB, Peer{Address: remoteHost, DBSystem: flavor}))
 	kvs := mergeKVs(qsKVs, args)
 	l, _ := BeginSpan(ctx, spanName, kvs...)
 	return l
@@ -24,6 +36,7 @@ func BeginQuerySpan(ctx context.Context, spanName, query, flavor, remoteHost str
 // Call or defer the returned Span's End() to time the request's client-side latency.
 func BeginCacheSpan(ctx context.Context, spanName, op, key, remoteHost string, hit bool, args ...interface{}) Span {
 	csKVs := []interface{}{"Spec", "cache", "KVOp", op, "KVKey", key, "KVHit", hit, "RemoteHost", remoteHost}
+	csKVs = mergeKVs(csKVs, remoteSpanKVs(RemoteKindCache, Peer{Address: remoteHost}))
 	kvs := mergeKVs(csKVs, args)
 	l, _ := BeginSpan(ctx, spanName, kvs...)
 	return l
@@ -36,6 +49,7 @@ func BeginCacheSpan(ctx context.Context, spanName, op, key, remoteHost string, h
 // Call or defer the returned Span's End() to time the call's client-side latency.
 func BeginRemoteURLSpan(ctx context.Context, spanName, remoteURL string, args ...interface{}) Span {
 	rsKVs := []interface{}{"Spec", "rsc", "IsService", true, "RemoteURL", remoteURL}
+	rsKVs = mergeKVs(rsKVs, remoteSpanKVs(RemoteKindHTTP, urlPeer(remoteURL)))
 	kvs := mergeKVs(rsKVs, args)
 	l, _ := BeginSpan(ctx, spanName, kvs...)
 	return l
@@ -52,9 +66,47 @@ func BeginRPCSpan(ctx context.Context, spanName, protocol, controller, remoteHos
 		"RemoteProtocol", protocol,
 		"RemoteHost", remoteHost,
 		"RemoteController", controller}
+	rsKVs = mergeKVs(rsKVs, remoteSpanKVs(RemoteKindRPC, Peer{Service: controller, Address: remoteHost}))
 
 	kvs := mergeKVs(rsKVs, args)
 	l, _ := BeginSpan(ctx, spanName, kvs...)
 
 	return l
 }
+
+// the random number generator of the SQL statement type sampling, which is
+// replaceable for testing
+var sqlSampleRand = rand.Intn
+
+// sqlStatementType returns the type of the SQL statement in upper case, i.e.,
+// its first keyword, e.g., SELECT. The leading comments and parentheses are
+// skipped.
+func sqlStatementType(query string) string {
+	for {
+		query = strings.TrimLeftFunc(query, func(r rune) bool {
+			return unicode.IsSpace(r) || r == '('
+		})
+		switch {
+		case strings.HasPrefix(query, "--"):
+			if i := strings.IndexByte(query, '\n'); i >= 0 {
+				query = query[i+1:]
+				continue
+			}
+			return ""
+		case strings.HasPrefix(query, "/*"):
+			if i := strings.Index(query, "*/"); i >= 0 {
+				query = query[i+2:]
+				continue
+			}
+			return ""
+		}
+		break
+	}
+	end := strings.IndexFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if end < 0 {
+		end = len(query)
+	}
+	return strings.ToUpper(query[:end])
+}
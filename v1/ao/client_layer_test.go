@@ -3,6 +3,7 @@
 package ao_test
 
 import (
+	"os"
 	"runtime/debug"
 	"testing"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"context"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	"github.com/stretchr/testify/assert"
@@ -73,3 +75,46 @@ func TestSpans(t *testing.T) {
 		{"myExample", "exit"}: {Edges: g.Edges{{"redis", "exit"}, {"myServiceClient", "exit"}, {"querySpan", "exit"}, {"myExample", "entry"}}},
 	})
 }
+
+func TestQuerySpanSampling(t *testing.T) {
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	os.Setenv("APPOPTICS_SQL_SAMPLE_RATES", "select=0,UPDATE=1000000")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_SQL_SAMPLE_RATES")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter() // enable test reporter
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("myExample"))
+
+	// the SELECT statements are never traced
+	ao.BeginQuerySpan(ctx, "select", "SELECT * FROM t", "mysql", "remote.host").End()
+	ao.BeginQuerySpan(ctx, "select", " /* comment */ (select 1)", "mysql", "remote.host").End()
+	ao.BeginQuerySpan(ctx, "select", "-- comment\nSelect 1", "mysql", "remote.host").End()
+	// the others are traced at the full rate
+	ao.BeginQuerySpan(ctx, "update", "UPDATE t SET a = 1", "mysql", "remote.host").End()
+	ao.BeginQuerySpan(ctx, "insert", "insert into t values (1)", "mysql", "remote.host").End()
+	ao.End(ctx)
+
+	r.Close(6)
+	g.AssertGraph(t, r.EventBufs, 6, g.AssertNodeMap{
+		{"myExample", "entry"}: {},
+		{"update", "entry"}:    {Edges: g.Edges{{"myExample", "entry"}}},
+		{"update", "exit"}:     {Edges: g.Edges{{"update", "entry"}}},
+		{"insert", "entry"}:    {Edges: g.Edges{{"myExample", "entry"}}},
+		{"insert", "exit"}:     {Edges: g.Edges{{"insert", "entry"}}},
+		{"myExample", "exit"}:  {Edges: g.Edges{{"update", "exit"}, {"insert", "exit"}, {"myExample", "entry"}}},
+	})
+
+	// no query spans are created in an unsampled trace
+	r = reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+	ctx = ao.NewContext(context.Background(), ao.NewTrace("myExample"))
+	l := ao.BeginQuerySpan(ctx, "insert", "INSERT INTO t VALUES (1)", "mysql", "remote.host")
+	assert.False(t, l.IsReporting())
+	l.End()
+	ao.End(ctx)
+	r.Close(0)
+	assert.Empty(t, r.EventBufs)
+}
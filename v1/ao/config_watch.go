@@ -0,0 +1,153 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	aolog "github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+)
+
+// the config file is reloaded after it has not been changed for this long,
+// so an editor writing it in several steps triggers only one reload
+const configWatchDebounce = time.Second
+
+// configWatcher reloads the configuration when the config file is changed.
+type configWatcher struct {
+	watcher  *fsnotify.Watcher
+	path     string
+	debounce time.Duration
+	// the number of reloads of the config, for testing
+	reloads int64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+var (
+	globalConfigWatcher *configWatcher
+	configWatcherLock   sync.Mutex
+)
+
+// initConfigWatcher starts watching the config file if it's enabled.
+func initConfigWatcher() {
+	if !config.GetWatchConfigFile() || disabled {
+		return
+	}
+	path := config.GetConfigFile()
+	if path == "" {
+		aolog.Warning("No config file to watch.")
+		return
+	}
+	cw, err := watchConfigFile(path, configWatchDebounce)
+	if err != nil {
+		aolog.Warningf("Failed to watch the config file: %v", err)
+		return
+	}
+	configWatcherLock.Lock()
+	globalConfigWatcher = cw
+	configWatcherLock.Unlock()
+	aolog.Infof("Watching the config file %s", path)
+}
+
+// closeConfigWatcher stops watching the config file, if it's being watched.
+func closeConfigWatcher() {
+	configWatcherLock.Lock()
+	cw := globalConfigWatcher
+	globalConfigWatcher = nil
+	configWatcherLock.Unlock()
+	if cw != nil {
+		cw.close()
+	}
+}
+
+// watchConfigFile reloads the configuration when the config file at the path
+// is changed, once it's been unchanged for the debounce duration. The directory
+// is watched instead of the file itself, so that the file replaced by a rename,
+// as many editors do, is still watched.
+func watchConfigFile(path string, debounce time.Duration) (*configWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the watcher")
+	}
+	path = filepath.Clean(path)
+	if err := w.Add(filepath.Dir(path)); err != nil {
+		w.Close()
+		return nil, errors.Wrap(err, "failed to watch the config file")
+	}
+
+	cw := &configWatcher{
+		watcher:  w,
+		path:     path,
+		debounce: debounce,
+		done:     make(chan struct{}),
+	}
+	cw.wg.Add(1)
+	go cw.watch()
+	return cw, nil
+}
+
+// watch waits for the changes of the config file until the watcher is closed.
+func (cw *configWatcher) watch() {
+	defer cw.wg.Done()
+	// it's nil, which blocks forever, until a change is pending
+	var pending <-chan time.Time
+	for {
+		select {
+		case <-cw.done:
+			return
+		case ev, ok := <-cw.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(ev.Name) != cw.path || ev.Op == fsnotify.Chmod {
+				continue
+			}
+			pending = time.After(cw.debounce)
+		case err, ok := <-cw.watcher.Errors:
+			if !ok {
+				return
+			}
+			aolog.Warningf("Error watching the config file: %v", err)
+		case <-pending:
+			pending = nil
+			cw.reload()
+		}
+	}
+}
+
+// reload reloads the configuration if the config file is valid, otherwise the
+// current configuration is kept.
+func (cw *configWatcher) reload() {
+	// an empty file is most likely being written, and the following write
+	// triggers another reload
+	if fi, err := os.Stat(cw.path); err == nil && fi.Size() == 0 {
+		return
+	}
+	if err := config.CheckConfigFile(); err != nil {
+		aolog.Warningf("The config file is not reloaded: %v", err)
+		return
+	}
+	delta, err := ReloadConfig()
+	atomic.AddInt64(&cw.reloads, 1)
+	if err != nil {
+		aolog.Warningf("Failed to reload the config file: %v", err)
+		return
+	}
+	aolog.Infof("The config file is reloaded, changed items:\n%s", delta)
+}
+
+// close stops watching the config file and waits for the pending reload, if
+// any, to finish.
+func (cw *configWatcher) close() {
+	close(cw.done)
+	cw.watcher.Close()
+	cw.wg.Wait()
+}
@@ -0,0 +1,75 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigWatcher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ao")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "appoptics-goagent.yaml")
+	write := func(content string) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	write("SampleRate: 1000000\n")
+
+	r := reporter.SetTestReporter()
+	defer r.Close(0)
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	os.Setenv("APPOPTICS_CONFIG_FILE", path)
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_CONFIG_FILE")
+		config.Load()
+		reporter.ReapplyLocalSettings()
+	}()
+	require.NoError(t, config.Load())
+
+	cw, err := watchConfigFile(path, 100*time.Millisecond)
+	require.NoError(t, err)
+
+	// the writes within the debounce duration trigger only one reload
+	for _, rate := range []string{"1", "10", "100", "1000"} {
+		write("Sampling:\n  SampleRate: " + rate + "\n")
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&cw.reloads) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&cw.reloads))
+	assert.Equal(t, 1000, config.GetSampleRate())
+	assert.Equal(t, 1000, Diagnostics().SampleRate)
+
+	// the invalid config file is not applied
+	write("TransactionSettings:\n  - Type: invalid\n")
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, int64(1), atomic.LoadInt64(&cw.reloads))
+	assert.Equal(t, 1000, config.GetSampleRate())
+
+	// the file replaced by a rename is still watched
+	tmp := filepath.Join(dir, "tmp.yaml")
+	require.NoError(t, ioutil.WriteFile(tmp, []byte("Sampling:\n  SampleRate: 2000\n"), 0644))
+	require.NoError(t, os.Rename(tmp, path))
+	require.Eventually(t, func() bool {
+		return config.GetSampleRate() == 2000
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cw.close()
+	write("Sampling:\n  SampleRate: 3000\n")
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, 2000, config.GetSampleRate())
+}
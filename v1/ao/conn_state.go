@@ -0,0 +1,70 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// connStateTracker keeps the last state of each connection of a server, so
+// that a state transition moves the connection between the gauges.
+type connStateTracker struct {
+	states sync.Map // net.Conn -> http.ConnState
+}
+
+// InstrumentConnState attaches a ConnState hook to the server to report the
+// connection metrics: the number of connections accepted (ConnectionsNew) and
+// closed (ConnectionsClosed) in each report cycle, and the number of
+// connections currently active (ConnectionsActive) and idle
+// (ConnectionsIdle). A hijacked connection is no longer managed by the server
+// and is counted as closed. The existing ConnState hook of the server, if any,
+// is still called. It must be called before the server is started.
+//   srv := &http.Server{Addr: ":8080", Handler: mux}
+//   ao.InstrumentConnState(srv)
+//   srv.ListenAndServe()
+func InstrumentConnState(srv *http.Server) {
+	if Disabled() || srv == nil {
+		return
+	}
+	tracker := &connStateTracker{}
+	next := srv.ConnState
+	srv.ConnState = func(c net.Conn, state http.ConnState) {
+		tracker.transit(c, state)
+		if next != nil {
+			next(c, state)
+		}
+	}
+}
+
+// transit records the transition of the connection to the new state.
+func (t *connStateTracker) transit(c net.Conn, state http.ConnState) {
+	var prev http.ConnState = -1
+	if v, ok := t.states.Load(c); ok {
+		prev = v.(http.ConnState)
+	}
+
+	switch prev {
+	case http.StateActive:
+		reporter.AddActiveConns(-1)
+	case http.StateIdle:
+		reporter.AddIdleConns(-1)
+	}
+
+	switch state {
+	case http.StateNew:
+		reporter.RecordConnNew()
+	case http.StateActive:
+		reporter.AddActiveConns(1)
+	case http.StateIdle:
+		reporter.AddIdleConns(1)
+	case http.StateHijacked, http.StateClosed:
+		t.states.Delete(c)
+		reporter.RecordConnClosed()
+		return
+	}
+	t.states.Store(c, state)
+}
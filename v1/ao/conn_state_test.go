@@ -0,0 +1,77 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentConnState(t *testing.T) {
+	active, idle := reporter.ActiveAndIdleConns()
+	var states []http.ConnState
+	srv := &http.Server{ConnState: func(c net.Conn, state http.ConnState) {
+		states = append(states, state)
+	}}
+	ao.InstrumentConnState(srv)
+
+	c1, c2 := &net.TCPConn{}, &net.TCPConn{}
+	srv.ConnState(c1, http.StateNew)
+	srv.ConnState(c2, http.StateNew)
+	srv.ConnState(c1, http.StateActive)
+	srv.ConnState(c2, http.StateActive)
+	srv.ConnState(c1, http.StateIdle)
+	gotActive, gotIdle := reporter.ActiveAndIdleConns()
+	assert.Equal(t, active+1, gotActive)
+	assert.Equal(t, idle+1, gotIdle)
+
+	srv.ConnState(c1, http.StateActive)
+	srv.ConnState(c2, http.StateHijacked)
+	gotActive, gotIdle = reporter.ActiveAndIdleConns()
+	assert.Equal(t, active+1, gotActive)
+	assert.Equal(t, idle, gotIdle)
+
+	srv.ConnState(c1, http.StateIdle)
+	srv.ConnState(c1, http.StateClosed)
+	gotActive, gotIdle = reporter.ActiveAndIdleConns()
+	assert.Equal(t, active, gotActive)
+	assert.Equal(t, idle, gotIdle)
+
+	// the existing hook is chained
+	assert.Len(t, states, 9)
+}
+
+func TestInstrumentConnStateServer(t *testing.T) {
+	active, idle := reporter.ActiveAndIdleConns()
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotActive, _ := reporter.ActiveAndIdleConns()
+		assert.Equal(t, active+1, gotActive)
+		w.WriteHeader(http.StatusOK)
+	}))
+	ao.InstrumentConnState(s.Config)
+	s.Start()
+
+	resp, err := http.Get(s.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	// the keep-alive connection turns idle once the response is sent
+	assert.Eventually(t, func() bool {
+		gotActive, gotIdle := reporter.ActiveAndIdleConns()
+		return gotActive == active && gotIdle == idle+1
+	}, time.Second, 10*time.Millisecond)
+
+	s.Close()
+	assert.Eventually(t, func() bool {
+		gotActive, gotIdle := reporter.ActiveAndIdleConns()
+		return gotActive == active && gotIdle == idle
+	}, time.Second, 10*time.Millisecond)
+}
@@ -8,6 +8,7 @@ type contextKeyT interface{}
 
 var contextKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.Trace")
 var contextSpanKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.Span")
+var contextTracingDisabledKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.TracingDisabled")
 
 // NewContext returns a copy of the parent context and associates it with a Trace.
 func NewContext(ctx context.Context, t Trace) context.Context {
@@ -19,6 +20,30 @@ func newSpanContext(ctx context.Context, l Span) context.Context {
 	return context.WithValue(ctx, contextSpanKey, l)
 }
 
+// WithTracingDisabled returns a copy of the parent context in which no spans
+// or profiles are created, e.g., BeginSpan returns a no-op Span. It's meant for
+// a noisy subtree of a traced request and has no effect on the spans outside
+// of it. Tracing can be re-enabled for a nested subtree by WithTracingEnabled.
+func WithTracingDisabled(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextTracingDisabledKey, true)
+}
+
+// WithTracingEnabled returns a copy of the parent context in which tracing is
+// re-enabled if it's disabled by WithTracingDisabled. The spans created in it
+// are children of the last span created before tracing was disabled.
+func WithTracingEnabled(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextTracingDisabledKey, false)
+}
+
+// tracingDisabled checks if tracing is disabled for the context.
+func tracingDisabled(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	disabled, _ := ctx.Value(contextTracingDisabledKey).(bool)
+	return disabled
+}
+
 // FromContext returns the Span bound to the context, if any.
 func FromContext(ctx context.Context) Span {
 	l, ok := fromContext(ctx)
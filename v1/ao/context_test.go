@@ -126,3 +126,38 @@ func BenchmarkTraceFromContextEmpty(b *testing.B) {
 		TraceFromContext(ctx)
 	}
 }
+
+func TestTracingDisabled(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx := NewContext(context.Background(), NewTrace("test"))
+	assert.False(t, tracingDisabled(ctx))
+
+	// no spans are created in the disabled subtree
+	dctx := WithTracingDisabled(ctx)
+	s, sctx := BeginSpan(dctx, "noisy")
+	assert.False(t, s.ok())
+	assert.True(t, tracingDisabled(sctx))
+	s.End()
+	BeginProfile(dctx, "noisy-profile").End()
+
+	// it's re-enabled in a nested subtree, the span is a child of the trace
+	s, _ = BeginSpan(WithTracingEnabled(sctx), "nested")
+	assert.True(t, s.ok())
+	s.End()
+
+	// the parent is not affected
+	s, _ = BeginSpan(ctx, "after")
+	assert.True(t, s.ok())
+	s.End()
+	EndTrace(ctx)
+
+	r.Close(6)
+	g.AssertGraph(t, r.EventBufs, 6, g.AssertNodeMap{
+		{"test", "entry"}:   {},
+		{"nested", "entry"}: {Edges: g.Edges{{"test", "entry"}}},
+		{"nested", "exit"}:  {Edges: g.Edges{{"nested", "entry"}}},
+		{"after", "entry"}:  {Edges: g.Edges{{"test", "entry"}}},
+		{"after", "exit"}:   {Edges: g.Edges{{"after", "entry"}}},
+		{"test", "exit"}:    {Edges: g.Edges{{"nested", "exit"}, {"after", "exit"}, {"test", "entry"}}},
+	})
+}
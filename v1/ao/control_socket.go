@@ -0,0 +1,186 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	aolog "github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/pkg/errors"
+)
+
+// The commands accepted by the control socket, one per line, e.g.,
+// "sample_rate 500000" or "tracing_mode disabled". Each command is answered
+// with a line of either "ok" or "error: <reason>".
+const (
+	controlSampleRate  = "sample_rate"
+	controlTracingMode = "tracing_mode"
+)
+
+const (
+	// the permissions of the control socket, which only allow the user the
+	// application runs as to connect to it
+	controlSocketPerm = 0600
+	// the connection is closed if no command is received within the timeout
+	controlIdleTimeout = 30 * time.Second
+)
+
+// controlSocket is a local Unix socket an operator changes the sampling
+// settings through at runtime, without changing the environment variables or
+// the config file.
+type controlSocket struct {
+	ln   net.Listener
+	path string
+	wg   sync.WaitGroup
+}
+
+var (
+	globalControlSocket *controlSocket
+	controlSocketLock   sync.Mutex
+)
+
+// initControlSocket starts listening on the control socket if it's configured.
+func initControlSocket() {
+	path := config.GetControlSocket()
+	if path == "" || disabled {
+		return
+	}
+	cs, err := listenControlSocket(path)
+	if err != nil {
+		aolog.Warningf("Failed to listen on the control socket: %v", err)
+		return
+	}
+	controlSocketLock.Lock()
+	globalControlSocket = cs
+	controlSocketLock.Unlock()
+	aolog.Infof("Listening on the control socket %s", path)
+}
+
+// closeControlSocket stops listening on the control socket, if any.
+func closeControlSocket() {
+	controlSocketLock.Lock()
+	cs := globalControlSocket
+	globalControlSocket = nil
+	controlSocketLock.Unlock()
+	if cs != nil {
+		cs.close()
+	}
+}
+
+// listenControlSocket creates the control socket at the path and serves the
+// commands sent to it. A stale socket left at the path is replaced, but any
+// other type of file is not.
+func listenControlSocket(path string) (*controlSocket, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, errors.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, errors.Wrap(err, "failed to remove the stale socket")
+		}
+	}
+
+	// The commands are authenticated by the permissions of the socket file. It's
+	// created in a private directory and moved to the path once its permissions
+	// are restricted, so it's never accessible to the others.
+	dir, err := ioutil.TempDir(filepath.Dir(path), ".aoctl")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the control socket")
+	}
+	defer os.RemoveAll(dir)
+	tmp := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", tmp)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the control socket")
+	}
+	// the socket file is removed by close as it's moved
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := os.Chmod(tmp, controlSocketPerm); err != nil {
+		ln.Close()
+		return nil, errors.Wrap(err, "failed to restrict the control socket")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		ln.Close()
+		return nil, errors.Wrap(err, "failed to create the control socket")
+	}
+
+	cs := &controlSocket{ln: ln, path: path}
+	cs.wg.Add(1)
+	go cs.serve()
+	return cs, nil
+}
+
+// serve accepts the connections until the socket is closed.
+func (cs *controlSocket) serve() {
+	defer cs.wg.Done()
+	for {
+		conn, err := cs.ln.Accept()
+		if err != nil {
+			return
+		}
+		go cs.handle(conn)
+	}
+}
+
+// handle executes the commands received from the connection.
+func (cs *controlSocket) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(controlIdleTimeout))
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		reply := "ok"
+		if err := execControlCommand(line); err != nil {
+			reply = "error: " + err.Error()
+		}
+		if _, err := fmt.Fprintln(conn, reply); err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(controlIdleTimeout))
+	}
+}
+
+// close stops listening and removes the socket file.
+func (cs *controlSocket) close() {
+	cs.ln.Close()
+	cs.wg.Wait()
+	os.Remove(cs.path)
+}
+
+// execControlCommand validates and executes a command of the control socket.
+func execControlCommand(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) != 2 {
+		return errors.Errorf("invalid command: %q", line)
+	}
+	var err error
+	switch fields[0] {
+	case controlSampleRate:
+		rate, convErr := strconv.Atoi(fields[1])
+		if convErr != nil {
+			return errors.Errorf("invalid sample rate: %s", fields[1])
+		}
+		err = SetSampleRate(rate)
+	case controlTracingMode:
+		err = SetTracingMode(fields[1])
+	default:
+		return errors.Errorf("unknown command: %s", fields[0])
+	}
+	if err == nil {
+		aolog.Infof("Control socket command applied: %s", line)
+	}
+	return err
+}
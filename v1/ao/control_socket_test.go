@@ -0,0 +1,106 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControlSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ao")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "control.sock")
+
+	r := reporter.SetTestReporter() // 100% sampling rate
+	defer func() {
+		config.Load()
+		reporter.ReapplyLocalSettings()
+	}()
+
+	cs, err := listenControlSocket(path)
+	require.NoError(t, err)
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(controlSocketPerm), fi.Mode().Perm())
+	// the private directory the socket is created in is removed
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	conn, err := net.Dial("unix", path)
+	require.NoError(t, err)
+	defer conn.Close()
+	replies := bufio.NewScanner(conn)
+	send := func(cmd string) string {
+		_, err := conn.Write([]byte(cmd + "\n"))
+		require.NoError(t, err)
+		require.True(t, replies.Scan())
+		return replies.Text()
+	}
+	sampled := func() bool {
+		tr := NewTrace("control")
+		defer tr.End()
+		return tr.IsSampled()
+	}
+
+	assert.True(t, sampled())
+	assert.Equal(t, "ok", send("tracing_mode disabled"))
+	assert.Equal(t, config.DisabledTracingMode, config.GetTracingMode())
+	assert.False(t, sampled())
+	assert.Equal(t, "ok", send("tracing_mode enabled"))
+	assert.True(t, sampled())
+	assert.Equal(t, "ok", send("sample_rate 0"))
+	assert.Equal(t, 0, config.GetSampleRate())
+	assert.False(t, sampled())
+
+	// the invalid commands are rejected and change nothing
+	for _, cmd := range []string{
+		"sample_rate 2000000",
+		"sample_rate half",
+		"tracing_mode sometimes",
+		"sample_rate",
+		"set sample_rate 1",
+	} {
+		assert.Contains(t, send(cmd), "error: ", cmd)
+	}
+	assert.Equal(t, 0, config.GetSampleRate())
+	assert.Equal(t, config.EnabledTracingMode, config.GetTracingMode())
+
+	cs.close()
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+	r.Close(4)
+}
+
+func TestControlSocketPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ao")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a regular file is not replaced
+	path := filepath.Join(dir, "file")
+	require.NoError(t, ioutil.WriteFile(path, []byte("data"), 0600))
+	_, err = listenControlSocket(path)
+	assert.Error(t, err)
+
+	// a stale socket is replaced
+	path = filepath.Join(dir, "stale.sock")
+	ln, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	cs, err := listenControlSocket(path)
+	require.NoError(t, err)
+	cs.close()
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// errorKey identifies the identical errors.
+type errorKey struct{ class, msg string }
+
+// errorDedup collapses the identical errors, by their class and message,
+// reported within a trace into a single error event with their count. The
+// error event is reported by the span of the first error when it ends, with
+// the timestamp and the back trace of the first error. An identical error
+// reported after that starts a new error event.
+//
+// It is safe for concurrent use.
+type errorDedup struct {
+	sync.Mutex
+	// the errors not reported yet
+	pending map[errorKey]*spanError
+}
+
+func newErrorDedup() *errorDedup {
+	return &errorDedup{pending: make(map[errorKey]*spanError)}
+}
+
+// add counts the error, and returns true if it's the first one of its kind to
+// be reported, or false if it's collapsed into a pending one.
+func (d *errorDedup) add(e *spanError) bool {
+	d.Lock()
+	defer d.Unlock()
+	k := errorKey{e.class, e.msg}
+	if p, ok := d.pending[k]; ok {
+		p.count++
+		return false
+	}
+	e.count = 1
+	d.pending[k] = e
+	return true
+}
+
+// remove stops collapsing the errors into the pending ones and returns the KVs
+// of their error events.
+func (d *errorDedup) remove(errs []*spanError) [][]interface{} {
+	d.Lock()
+	defer d.Unlock()
+	kvs := make([][]interface{}, 0, len(errs))
+	for _, e := range errs {
+		delete(d.pending, errorKey{e.class, e.msg})
+		kvs = append(kvs, e.kvs())
+	}
+	return kvs
+}
+
+// dedupError keeps the error until the span ends, if the identical errors of
+// the trace are collapsed, see errorDedup.
+func (s *span) dedupError(e *spanError) bool {
+	if s.settings == nil || s.settings.errorDedup == nil {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ended {
+		return false
+	}
+	if s.settings.errorDedup.add(e) {
+		s.dedupErrors = append(s.dedupErrors, e)
+	}
+	return true
+}
+
+// reportDedupErrorsLocked reports the errors kept by the span, with the
+// numbers of the identical ones. The caller must hold the lock of the span.
+func (s *span) reportDedupErrorsLocked() {
+	if len(s.dedupErrors) == 0 {
+		return
+	}
+	for _, kvs := range s.settings.errorDedup.remove(s.dedupErrors) {
+		_ = s.aoCtx.ReportEvent(reporter.LabelError, s.layerName(), kvs...)
+	}
+	s.dedupErrors = nil
+}
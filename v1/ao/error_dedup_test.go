@@ -0,0 +1,79 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestDedupErrors(t *testing.T) {
+	os.Setenv("APPOPTICS_DEDUP_ERRORS", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_DEDUP_ERRORS")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("dedup"))
+	l, _ := ao.BeginSpan(ctx, "retries")
+	for i := 0; i < 4; i++ {
+		l.Err(errors.New("connection refused"))
+	}
+	l.Error("timeout", "connection refused")
+	// identical to the pending one of the other span
+	l2, _ := ao.BeginSpan(ctx, "other")
+	l2.Err(errors.New("connection refused"))
+	l2.End()
+	l.End()
+	ao.EndTrace(ctx)
+
+	r.Close(8)
+	g.AssertGraph(t, r.EventBufs, 8, g.AssertNodeKVMap{
+		{"dedup", "entry", "", ""}:   {},
+		{"retries", "entry", "", ""}: {Edges: g.Edges{{"dedup", "entry"}}},
+		{"other", "entry", "", ""}:   {Edges: g.Edges{{"dedup", "entry"}}},
+		{"other", "exit", "", ""}:    {Edges: g.Edges{{"other", "entry"}}},
+		{"retries", "error", "ErrorClass", "error"}: {Edges: g.Edges{{"retries", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "connection refused", n.Map["ErrorMsg"])
+			assert.Equal(t, 5, n.Map["ErrorCount"])
+		}},
+		{"retries", "error", "ErrorClass", "timeout"}: {Edges: g.Edges{{"retries", "error"}}, Callback: func(n g.Node) {
+			assert.Equal(t, 1, n.Map["ErrorCount"])
+		}},
+		{"retries", "exit", "", ""}: {Edges: g.Edges{{"retries", "error"}}},
+		{"dedup", "exit", "", ""}:   {Edges: g.Edges{{"other", "exit"}, {"retries", "exit"}, {"dedup", "entry"}}},
+	})
+}
+
+func TestDedupErrorsDisabled(t *testing.T) {
+	r := reporter.SetTestReporter()
+	tr := ao.NewTrace("dedup")
+	for i := 0; i < 3; i++ {
+		tr.Error("error", "connection refused")
+	}
+	tr.End()
+
+	r.Close(5)
+	var errs int
+	for _, buf := range r.EventBufs {
+		m := bson.M{}
+		require.NoError(t, bson.Unmarshal(buf, m))
+		if m["Label"] == "error" {
+			errs++
+			assert.NotContains(t, m, "ErrorCount")
+		}
+	}
+	assert.Equal(t, 3, errs)
+}
@@ -0,0 +1,49 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import "github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+
+// EventProcessor transforms or enriches an event before it's sent, e.g., to
+// map a raw path to a logical feature name. It's called for every event and
+// must be cheap and safe for concurrent use.
+type EventProcessor func(e *Event)
+
+// Event is an event passed to the event processors, or the channels returned by
+// EventsChannel. Its KVs can be read and
+// changed, except the ones which identify the event in its trace, e.g.,
+// X-Trace, Edge, Label and Layer, which are left intact.
+type Event struct {
+	e *reporter.ProcessedEvent
+}
+
+// Label returns the label of the event, e.g., entry, exit or info
+func (e *Event) Label() string { return e.e.Label() }
+
+// Layer returns the name of the span of the event
+func (e *Event) Layer() string { return e.e.Layer() }
+
+// Get returns the value of the KV, and false if the event has no such KV.
+func (e *Event) Get(key string) (interface{}, bool) { return e.e.Get(key) }
+
+// Set adds the KV to the event, or replaces its value if the event has it
+// already.
+func (e *Event) Set(key string, value interface{}) { e.e.Set(key, value) }
+
+// Delete removes the KV from the event.
+func (e *Event) Delete(key string) { e.e.Delete(key) }
+
+// SetEventProcessors replaces the event processors, which are called in order
+// for every event just before it's serialized. The processors are removed if
+// none is provided.
+func SetEventProcessors(processors ...EventProcessor) {
+	var ps []reporter.EventProcessor
+	for _, p := range processors {
+		if p == nil {
+			continue
+		}
+		p := p
+		ps = append(ps, func(e *reporter.ProcessedEvent) { p(&Event{e}) })
+	}
+	reporter.SetEventProcessors(ps...)
+}
@@ -0,0 +1,60 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventProcessors(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	ao.SetEventProcessors(
+		func(e *ao.Event) {
+			if path, ok := e.Get("URL"); ok && strings.HasPrefix(path.(string), "/checkout") {
+				e.Set("Feature", "checkout")
+			}
+			e.Delete("Secret")
+		},
+		// called after the first one
+		func(e *ao.Event) {
+			if _, ok := e.Get("Feature"); ok {
+				e.Set("FeatureLabel", e.Layer()+":"+e.Label())
+			}
+			// the KVs identifying the event can't be changed
+			e.Set("Edge", "bogus")
+			e.Set("X-Trace", "bogus")
+			e.Set("Layer", "bogus")
+		},
+	)
+	defer ao.SetEventProcessors()
+
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("web"))
+	s, _ := ao.BeginSpan(ctx, "handler", "URL", "/checkout/cart", "Secret", "s3cr3t")
+	s.Info("K", "V")
+	s.End()
+	ao.EndTrace(ctx)
+
+	r.Close(5)
+	g.AssertGraph(t, r.EventBufs, 5, g.AssertNodeMap{
+		{"web", "entry"}: {Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "Feature")
+		}},
+		{"handler", "entry"}: {Edges: g.Edges{{"web", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "checkout", n.Map["Feature"])
+			assert.Equal(t, "handler:entry", n.Map["FeatureLabel"])
+			assert.NotContains(t, n.Map, "Secret")
+		}},
+		{"handler", "info"}: {Edges: g.Edges{{"handler", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "V", n.Map["K"])
+		}},
+		{"handler", "exit"}: {Edges: g.Edges{{"handler", "info"}}},
+		{"web", "exit"}:     {Edges: g.Edges{{"handler", "exit"}, {"web", "entry"}}},
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// eventsChannel is a channel which the events sent are teed into.
+type eventsChannel struct {
+	ch      chan Event
+	dropped int64
+}
+
+var eventsChannels struct {
+	sync.RWMutex
+	chans map[<-chan Event]*eventsChannel
+}
+
+// EventsChannel returns a channel which receives a copy of every event sent by
+// the reporter, e.g., for a custom processing pipeline, in addition to the
+// reporter. The events are passed after the event processors, and have the
+// X-Trace KV of the event. The events are shared by all the channels, so they
+// should not be changed. An event is dropped if the channel's buffer is full,
+// so a slow consumer never blocks the application, and the drops are counted
+// by EventsChannelDrops. The events of the unsampled traces kept by
+// APPOPTICS_KEEP_ERRORS or APPOPTICS_ADAPTIVE_SAMPLING are passed only once
+// the traces are kept. The channel must be closed by CloseEventsChannel once
+// it's no longer consumed.
+func EventsChannel(buffer int) <-chan Event {
+	if buffer < 0 {
+		buffer = 0
+	}
+	c := &eventsChannel{ch: make(chan Event, buffer)}
+
+	eventsChannels.Lock()
+	defer eventsChannels.Unlock()
+	if eventsChannels.chans == nil {
+		eventsChannels.chans = make(map[<-chan Event]*eventsChannel)
+	}
+	eventsChannels.chans[c.ch] = c
+	reporter.SetEventSink(sinkToEventsChannels)
+	return c.ch
+}
+
+// CloseEventsChannel stops teeing the events into the channel returned by
+// EventsChannel and closes it. It's a no-op if the channel is closed already.
+func CloseEventsChannel(ch <-chan Event) {
+	eventsChannels.Lock()
+	defer eventsChannels.Unlock()
+	c, ok := eventsChannels.chans[ch]
+	if !ok {
+		return
+	}
+	delete(eventsChannels.chans, ch)
+	close(c.ch)
+	if len(eventsChannels.chans) == 0 {
+		reporter.SetEventSink(nil)
+	}
+}
+
+// EventsChannelDrops returns the number of the events dropped as the buffer of
+// the channel returned by EventsChannel is full.
+func EventsChannelDrops(ch <-chan Event) int64 {
+	eventsChannels.RLock()
+	defer eventsChannels.RUnlock()
+	if c, ok := eventsChannels.chans[ch]; ok {
+		return atomic.LoadInt64(&c.dropped)
+	}
+	return 0
+}
+
+// sinkToEventsChannels passes the event to all the channels without blocking.
+func sinkToEventsChannels(e *reporter.ProcessedEvent) {
+	eventsChannels.RLock()
+	defer eventsChannels.RUnlock()
+	for _, c := range eventsChannels.chans {
+		select {
+		case c.ch <- Event{e}:
+		default:
+			atomic.AddInt64(&c.dropped, 1)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventsChannel(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ch := ao.EventsChannel(10)
+
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("channel"))
+	l, _ := ao.BeginSpan(ctx, "child", "K", "V")
+	l.End()
+	ao.EndTrace(ctx)
+	r.Close(4)
+
+	var events []string
+	for len(ch) > 0 {
+		e := <-ch
+		events = append(events, e.Layer()+":"+e.Label())
+		md, ok := e.Get("X-Trace")
+		assert.True(t, ok)
+		assert.True(t, reporter.ValidMetadata(md.(string)))
+		if e.Layer() == "child" && e.Label() == "entry" {
+			v, _ := e.Get("K")
+			assert.Equal(t, "V", v)
+		}
+	}
+	// the events are still reported as usual
+	assert.Len(t, r.EventBufs, 4)
+	assert.Equal(t, []string{"channel:entry", "child:entry", "child:exit", "channel:exit"}, events)
+	assert.Zero(t, ao.EventsChannelDrops(ch))
+
+	ao.CloseEventsChannel(ch)
+	ao.CloseEventsChannel(ch)
+	_, ok := <-ch
+	assert.False(t, ok)
+}
+
+func TestEventsChannelSlowConsumer(t *testing.T) {
+	r := reporter.SetTestReporter()
+	slow := ao.EventsChannel(1)
+	defer ao.CloseEventsChannel(slow)
+	fast := ao.EventsChannel(10)
+	defer ao.CloseEventsChannel(fast)
+
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("channel"))
+	l, _ := ao.BeginSpan(ctx, "child")
+	l.End()
+	ao.EndTrace(ctx)
+	r.Close(4)
+
+	// the events beyond the buffer are dropped and counted, without holding
+	// back the reporter or the other channels
+	assert.Len(t, r.EventBufs, 4)
+	assert.Len(t, slow, 1)
+	assert.EqualValues(t, 3, ao.EventsChannelDrops(slow))
+	assert.Len(t, fast, 4)
+	assert.Zero(t, ao.EventsChannelDrops(fast))
+}
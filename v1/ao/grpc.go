@@ -0,0 +1,31 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+// GRPCTransactionName returns the transaction name of a gRPC server span of the
+// full method, e.g., /helloworld.Greeter/SayHello, by the granularity configured
+// (APPOPTICS_GRPC_TRANSACTION_NAMING): the service, e.g., helloworld.Greeter,
+// or the service and method, e.g., helloworld.Greeter/SayHello. It's the server
+// name and the method name, e.g., greeter.SayHello, if it's not configured.
+func GRPCTransactionName(serverName, fullMethod string) string {
+	name := strings.TrimPrefix(fullMethod, "/")
+	service, method := name, ""
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		service, method = name[:i], name[i+1:]
+	}
+
+	switch config.GetGRPCTransactionNaming() {
+	case config.GRPCTransactionNamingService:
+		return service
+	case config.GRPCTransactionNamingMethod:
+		return name
+	default:
+		return serverName + "." + method
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGRPCTransactionName(t *testing.T) {
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_GRPC_TRANSACTION_NAMING")
+		config.Load()
+	}()
+
+	for _, tc := range []struct{ naming, fullMethod, txn string }{
+		{"", "/helloworld.Greeter/SayHello", "greeter.SayHello"},
+		{"service", "/helloworld.Greeter/SayHello", "helloworld.Greeter"},
+		{"method", "/helloworld.Greeter/SayHello", "helloworld.Greeter/SayHello"},
+		{"Service", "helloworld.Greeter", "helloworld.Greeter"},
+		{"invalid", "/helloworld.Greeter/SayHello", "greeter.SayHello"},
+	} {
+		os.Setenv("APPOPTICS_GRPC_TRANSACTION_NAMING", tc.naming)
+		config.Load()
+		assert.Equal(t, tc.txn, ao.GRPCTransactionName("greeter", tc.fullMethod), tc.naming)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+const (
+	httpClientAttemptSpanName = "http.Client.attempt"
+
+	keyAttempt        = "Attempt"
+	keyAttemptOutcome = "AttemptOutcome"
+)
+
+// The values of the AttemptOutcome KV reported by the span of an attempt of an
+// HTTP client request.
+const (
+	// AttemptOutcomeSuccess is reported if a response with a status code
+	// lower than 400 is received.
+	AttemptOutcomeSuccess = "success"
+	// AttemptOutcomeFailure is reported if a response with a status code of
+	// 400 or higher is received.
+	AttemptOutcomeFailure = "failure"
+	// AttemptOutcomeError is reported if no response is received.
+	AttemptOutcomeError = "error"
+)
+
+// key used for the HTTP client span of the requests made by an HTTPTransport
+var httpClientSpanKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.HTTPClientSpan")
+
+// httpClientCall is the state of a logical HTTP client request shared by its
+// attempts.
+type httpClientCall struct {
+	attempts int32
+}
+
+// BeginAttempt starts a child span of the HTTP client span for an attempt of
+// the request, e.g., a retry, and injects the trace context of the attempt
+// into the request, so the server continues the trace from the attempt. The
+// attempts are numbered from 1 in the order they begin. The outcome of the
+// attempt is reported by AddHTTPResponse of the returned span, which must
+// have End() called.
+//   l := ao.BeginHTTPClientSpan(ctx, req)
+//   defer l.End()
+//   for {
+//       a := l.BeginAttempt(req)
+//       resp, err := client.Do(req)
+//       a.AddHTTPResponse(resp, err)
+//       a.End()
+//       // ...
+//   }
+func (l HTTPClientSpan) BeginAttempt(req *http.Request) HTTPClientSpan {
+	if req == nil || l.call == nil || !l.ok() {
+		return HTTPClientSpan{Span: nullSpan{}}
+	}
+	n := int(atomic.AddInt32(&l.call.attempts, 1))
+	a := l.BeginSpan(httpClientAttemptSpanName, keyAttempt, n)
+	if md := PropagationMetadata(a); md != "" {
+		req.Header.Set(HTTPHeaderName, md)
+	}
+	return HTTPClientSpan{Span: a, attempt: n}
+}
+
+// attemptOutcome returns the outcome of an attempt by its response.
+func attemptOutcome(resp *http.Response, err error) string {
+	switch {
+	case err != nil || resp == nil:
+		return AttemptOutcomeError
+	case resp.StatusCode >= 400:
+		return AttemptOutcomeFailure
+	default:
+		return AttemptOutcomeSuccess
+	}
+}
+
+// HTTPAttemptTransport is an http.RoundTripper which reports each attempt of a
+// request as a child span of the HTTP client span started by an HTTPTransport,
+// so the attempts made by a retrying RoundTripper wrapped by an HTTPTransport
+// are visible. The requests not made through an HTTPTransport are passed to the
+// underlying RoundTripper as is.
+//   client := &http.Client{Transport: &ao.HTTPTransport{
+//       Base: &RetryingTransport{Base: &ao.HTTPAttemptTransport{}},
+//   }}
+type HTTPAttemptTransport struct {
+	// Base is the RoundTripper which makes the requests. It's
+	// http.DefaultTransport if it's nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *HTTPAttemptTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	l, ok := req.Context().Value(httpClientSpanKey).(HTTPClientSpan)
+	if !ok || !l.ok() {
+		return base.RoundTrip(req)
+	}
+
+	// a RoundTripper must not modify the request
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+
+	a := l.BeginAttempt(r)
+	defer a.End()
+	resp, err := base.RoundTrip(r)
+	a.AddHTTPResponse(resp, err)
+	return resp, err
+}
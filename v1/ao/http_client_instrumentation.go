@@ -5,8 +5,13 @@ package ao
 
 import (
 	"net/http"
+	"net/http/httptrace"
+	"sync"
 
 	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
 )
 
 // HTTPClientSpan is a Span that aids in reporting HTTP client requests.
@@ -17,17 +22,33 @@ import (
 //   resp, err := client.Do(req)
 //   l.AddHTTPResponse(resp, err)
 //   // ...
-type HTTPClientSpan struct{ Span }
+type HTTPClientSpan struct {
+	Span
+	// the state shared by the attempts of the request
+	call *httpClientCall
+	// the number of the attempt if it's the span of an attempt, or 0
+	attempt int
+}
 
 // BeginHTTPClientSpan stores trace metadata in the headers of an HTTP client request, allowing the
-// trace to be continued on the other end. It returns a Span that must have End() called to
+// trace to be continued on the other end. The request ID of the context, if any, is injected as
+// well unless the request already has one. It returns a Span that must have End() called to
 // benchmark the client request, and should have AddHTTPResponse(r, err) called to process response
 // metadata.
 func BeginHTTPClientSpan(ctx context.Context, req *http.Request) HTTPClientSpan {
 	if req != nil {
 		l := BeginRemoteURLSpan(ctx, "http.Client", req.URL.String())
-		req.Header.Set(HTTPHeaderName, l.MetadataString())
-		return HTTPClientSpan{Span: l}
+		if md := PropagationMetadata(l); md != "" {
+			req.Header.Set(HTTPHeaderName, md)
+		}
+		if id := RequestID(ctx); id != "" && config.GetRequestID() && req.Header.Get(RequestIDHeaderName) == "" {
+			req.Header.Set(RequestIDHeaderName, id)
+		}
+		if p := SamplingPriorityFromContext(ctx); p != PriorityUnset {
+			req.Header.Set(TraceStateHeaderName,
+				traceStateWithPriority(req.Header.Get(TraceStateHeaderName), p))
+		}
+		return HTTPClientSpan{Span: l, call: &httpClientCall{}}
 	}
 	return HTTPClientSpan{Span: nullSpan{}}
 }
@@ -40,6 +61,9 @@ func (l HTTPClientSpan) AddHTTPResponse(resp *http.Response, err error) {
 		if err != nil {
 			l.Err(err)
 		}
+		if l.attempt > 0 {
+			l.AddEndArgs(keyAttemptOutcome, attemptOutcome(resp, err))
+		}
 		if resp != nil {
 			l.AddEndArgs(keyRemoteStatus, resp.StatusCode, keyContentLength, resp.ContentLength)
 			if md := resp.Header.Get(HTTPHeaderName); md != "" {
@@ -48,3 +72,84 @@ func (l HTTPClientSpan) AddHTTPResponse(resp *http.Response, err error) {
 		}
 	}
 }
+
+// HTTPTransport is an http.RoundTripper which traces the requests made with a
+// context bound to a span, e.g., by ao.BeginSpan, with an HTTPClientSpan, and
+// propagates the trace context downstream. The durations of the DNS lookup,
+// connect and TLS handshake of a request are reported as KVs of its span, if
+// they happened. The other requests, including the agent's own requests to the
+// collector or the cloud metadata services, are passed to the underlying
+// RoundTripper as is. The attempts of a retrying RoundTripper can be reported
+// as the child spans with an HTTPAttemptTransport.
+type HTTPTransport struct {
+	// Base is the RoundTripper which makes the requests. It's
+	// http.DefaultTransport if it's nil.
+	Base http.RoundTripper
+}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (t *HTTPTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if _, ok := fromContext(req.Context()); !ok || utils.IsInternalRequest(req.Context()) {
+		return base.RoundTrip(req)
+	}
+
+	// a RoundTripper must not modify the request
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+
+	l := BeginHTTPClientSpan(req.Context(), r)
+	defer l.End()
+	var timings clientTimings
+	if l.IsReporting() {
+		ctx := context.WithValue(r.Context(), httpClientSpanKey, l)
+		r = r.WithContext(httptrace.WithClientTrace(ctx, timings.clientTrace()))
+	}
+	resp, err := base.RoundTrip(r)
+	l.AddHTTPResponse(resp, err)
+	l.AddEndArgs(timings.kvs()...)
+	return resp, err
+}
+
+var defaultHTTPClient struct {
+	sync.Mutex
+	original http.RoundTripper
+	wrapper  *HTTPTransport
+}
+
+// InstrumentDefaultHTTPClient wraps the Transport of http.DefaultClient with
+// an HTTPTransport, so the requests made with http.DefaultClient, or the
+// helpers using it, e.g., http.Get, are traced if their contexts are bound to
+// a span. It's a no-op if it's instrumented already. It can be reverted by
+// UninstrumentDefaultHTTPClient.
+func InstrumentDefaultHTTPClient() {
+	defaultHTTPClient.Lock()
+	defer defaultHTTPClient.Unlock()
+	if defaultHTTPClient.wrapper != nil && http.DefaultClient.Transport == defaultHTTPClient.wrapper {
+		return
+	}
+	defaultHTTPClient.original = http.DefaultClient.Transport
+	defaultHTTPClient.wrapper = &HTTPTransport{Base: http.DefaultClient.Transport}
+	http.DefaultClient.Transport = defaultHTTPClient.wrapper
+}
+
+// UninstrumentDefaultHTTPClient restores the Transport of http.DefaultClient
+// replaced by InstrumentDefaultHTTPClient. It's a no-op if it's not
+// instrumented, or the Transport is replaced again by the application.
+func UninstrumentDefaultHTTPClient() {
+	defaultHTTPClient.Lock()
+	defer defaultHTTPClient.Unlock()
+	if defaultHTTPClient.wrapper == nil || http.DefaultClient.Transport != defaultHTTPClient.wrapper {
+		return
+	}
+	http.DefaultClient.Transport = defaultHTTPClient.original
+	defaultHTTPClient.original = nil
+	defaultHTTPClient.wrapper = nil
+}
@@ -0,0 +1,245 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstrumentDefaultHTTPClient(t *testing.T) {
+	var xtraces []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		xtraces = append(xtraces, r.Header.Get(ao.HTTPHeaderName))
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	defer svr.Close()
+
+	get := func(ctx context.Context) {
+		req, err := http.NewRequest("GET", svr.URL, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+		require.NoError(t, err)
+		resp.Body.Close()
+		// the request is not modified
+		assert.Empty(t, req.Header.Get(ao.HTTPHeaderName))
+	}
+
+	r := reporter.SetTestReporter()
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("default-client"))
+
+	// not instrumented
+	get(ctx)
+	assert.Empty(t, xtraces[0])
+
+	// safe to be called more than once
+	original := http.DefaultClient.Transport
+	ao.InstrumentDefaultHTTPClient()
+	ao.InstrumentDefaultHTTPClient()
+	assert.IsType(t, &ao.HTTPTransport{}, http.DefaultClient.Transport)
+	assert.Equal(t, original, http.DefaultClient.Transport.(*ao.HTTPTransport).Base)
+
+	get(ctx)
+	assert.True(t, reporter.ValidMetadata(xtraces[1]))
+	assert.Equal(t, ao.MetadataString(ctx)[2:42], xtraces[1][2:42])
+	// not traced without a span in the context
+	get(context.Background())
+	assert.Empty(t, xtraces[2])
+
+	ao.UninstrumentDefaultHTTPClient()
+	ao.UninstrumentDefaultHTTPClient()
+	assert.Equal(t, original, http.DefaultClient.Transport)
+	get(ctx)
+	assert.Empty(t, xtraces[3])
+	ao.EndTrace(ctx)
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"default-client", "entry"}: {},
+		{"http.Client", "entry"}: {Edges: g.Edges{{"default-client", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, svr.URL, n.Map["RemoteURL"])
+		}},
+		{"http.Client", "exit"}: {Edges: g.Edges{{"http.Client", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, http.StatusTeapot, n.Map["RemoteStatus"])
+		}},
+		{"default-client", "exit"}: {Edges: g.Edges{{"http.Client", "exit"}, {"default-client", "entry"}}},
+	})
+}
+
+func TestHTTPTransportSkipsInternalRequests(t *testing.T) {
+	var xtraces []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		xtraces = append(xtraces, r.Header.Get(ao.HTTPHeaderName))
+	}))
+	defer svr.Close()
+
+	// instrument both the default client and transport transparently, so
+	// the agent's own client would be traced without the marker.
+	ao.InstrumentDefaultHTTPClient()
+	defer ao.UninstrumentDefaultHTTPClient()
+	original := http.DefaultTransport
+	http.DefaultTransport = &ao.HTTPTransport{Base: original}
+	defer func() { http.DefaultTransport = original }()
+
+	r := reporter.SetTestReporter()
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("internal-client"))
+	req, err := http.NewRequest("GET", svr.URL, nil)
+	require.NoError(t, err)
+	resp, err := utils.NewHTTPClient(time.Second).Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	resp.Body.Close()
+	ao.EndTrace(ctx)
+
+	require.Len(t, xtraces, 1)
+	assert.Empty(t, xtraces[0])
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"internal-client", "entry"}: {},
+		{"internal-client", "exit"}:  {Edges: g.Edges{{"internal-client", "entry"}}},
+	})
+}
+
+func TestHTTPTransportTimings(t *testing.T) {
+	svr := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer svr.Close()
+	// a host name rather than an IP address, so there is a DNS lookup
+	u, err := url.Parse(svr.URL)
+	require.NoError(t, err)
+	u.Host = "localhost:" + u.Port()
+
+	base := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	client := &http.Client{Transport: &ao.HTTPTransport{Base: base}}
+
+	r := reporter.SetTestReporter()
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("timings"))
+	get := func(name string) {
+		l, lctx := ao.BeginSpan(ctx, name)
+		req, err := http.NewRequest("GET", u.String()+"/"+name, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req.WithContext(lctx))
+		require.NoError(t, err)
+		resp.Body.Close()
+		l.End()
+	}
+	// a fresh connection and then the connection reused
+	get("fresh")
+	get("reused")
+	ao.EndTrace(ctx)
+
+	var reused int
+	r.Close(10)
+	g.AssertGraph(t, r.EventBufs, 10, g.AssertNodeKVMap{
+		{"timings", "entry", "", ""}: {},
+		{"fresh", "entry", "", ""}:   {Edges: g.Edges{{"timings", "entry"}}},
+		{"http.Client", "entry", "RemoteURL", u.String() + "/fresh"}: {
+			Edges: g.Edges{{"fresh", "entry"}}},
+		{"http.Client", "entry", "RemoteURL", u.String() + "/reused"}: {
+			Edges: g.Edges{{"reused", "entry"}}},
+		{"http.Client", "exit", "", ""}: {Count: 2, Edges: g.Edges{{"http.Client", "entry"}}, Callback: func(n g.Node) {
+			if n.Map["ConnReused"] == true {
+				reused++
+				assert.NotContains(t, n.Map, "DNSDuration")
+				assert.NotContains(t, n.Map, "ConnectDuration")
+				assert.NotContains(t, n.Map, "TLSDuration")
+				return
+			}
+			assert.Equal(t, false, n.Map["ConnReused"])
+			for _, k := range []string{"DNSDuration", "ConnectDuration", "TLSDuration"} {
+				assert.IsType(t, int64(0), n.Map[k], k)
+				assert.True(t, n.Map[k].(int64) >= 0, k)
+			}
+		}},
+		{"fresh", "exit", "", ""}:   {Edges: g.Edges{{"http.Client", "exit"}, {"fresh", "entry"}}},
+		{"reused", "entry", "", ""}: {Edges: g.Edges{{"timings", "entry"}}},
+		{"reused", "exit", "", ""}:  {Edges: g.Edges{{"http.Client", "exit"}, {"reused", "entry"}}},
+		{"timings", "exit", "", ""}: {Edges: g.Edges{{"fresh", "exit"}, {"reused", "exit"}, {"timings", "entry"}}},
+	})
+	assert.Equal(t, 1, reused)
+}
+
+// retryingTransport retries a request until it gets a status code lower than
+// 500 or it has been tried max times.
+type retryingTransport struct {
+	Base http.RoundTripper
+	max  int
+}
+
+func (t *retryingTransport) RoundTrip(req *http.Request) (resp *http.Response, err error) {
+	for i := 0; i < t.max; i++ {
+		if resp, err = t.Base.RoundTrip(req); err == nil && resp.StatusCode < 500 {
+			return resp, nil
+		}
+		if resp != nil && i < t.max-1 {
+			resp.Body.Close()
+		}
+	}
+	return resp, err
+}
+
+func TestHTTPAttemptTransport(t *testing.T) {
+	var xtraces []string
+	svr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		xtraces = append(xtraces, r.Header.Get(ao.HTTPHeaderName))
+		if len(xtraces) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer svr.Close()
+
+	client := &http.Client{Transport: &ao.HTTPTransport{
+		Base: &retryingTransport{Base: &ao.HTTPAttemptTransport{}, max: 3},
+	}}
+	r := reporter.SetTestReporter()
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("retries"))
+	req, err := http.NewRequest("GET", svr.URL, nil)
+	require.NoError(t, err)
+	resp, err := client.Do(req.WithContext(ctx))
+	require.NoError(t, err)
+	resp.Body.Close()
+	ao.EndTrace(ctx)
+
+	// each attempt propagates its own trace context
+	require.Len(t, xtraces, 3)
+	assert.NotEqual(t, xtraces[0], xtraces[1])
+	assert.NotEqual(t, xtraces[1], xtraces[2])
+
+	var attempts []int
+	r.Close(10)
+	g.AssertGraph(t, r.EventBufs, 10, g.AssertNodeKVMap{
+		{"retries", "entry", "", ""}: {},
+		{"http.Client", "entry", "", ""}: {Edges: g.Edges{{"retries", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, svr.URL, n.Map["RemoteURL"])
+		}},
+		{"http.Client.attempt", "entry", "", ""}: {Count: 3, Edges: g.Edges{{"http.Client", "entry"}}, Callback: func(n g.Node) {
+			attempts = append(attempts, n.Map["Attempt"].(int))
+			assert.Contains(t, xtraces, n.Map["X-Trace"])
+		}},
+		{"http.Client.attempt", "exit", "AttemptOutcome", ao.AttemptOutcomeFailure}: {Count: 2,
+			Edges: g.Edges{{"http.Client.attempt", "entry"}}, Callback: func(n g.Node) {
+				assert.Equal(t, http.StatusServiceUnavailable, n.Map["RemoteStatus"])
+			}},
+		{"http.Client.attempt", "exit", "AttemptOutcome", ao.AttemptOutcomeSuccess}: {
+			Edges: g.Edges{{"http.Client.attempt", "entry"}}, Callback: func(n g.Node) {
+				assert.Equal(t, http.StatusOK, n.Map["RemoteStatus"])
+			}},
+		{"http.Client", "exit", "", ""}: {Edges: g.Edges{{"http.Client.attempt", "exit"},
+			{"http.Client.attempt", "exit"}, {"http.Client.attempt", "exit"}, {"http.Client", "entry"}},
+			Callback: func(n g.Node) {
+				assert.Equal(t, http.StatusOK, n.Map["RemoteStatus"])
+			}},
+		{"retries", "exit", "", ""}: {Edges: g.Edges{{"http.Client", "exit"}, {"retries", "entry"}}},
+	})
+	assert.ElementsMatch(t, []int{1, 2, 3}, attempts)
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// The KVs of the phases of an HTTP client request, reported by the exit event
+// of the HTTPTransport span. The durations are in microseconds and only the
+// phases which happened are reported, e.g., there is no DNS lookup, connect
+// or TLS handshake if an idle connection is reused.
+const (
+	keyDNSDuration     = "DNSDuration"
+	keyConnectDuration = "ConnectDuration"
+	keyTLSDuration     = "TLSDuration"
+	keyConnReused      = "ConnReused"
+)
+
+// clientTimings records the timings of the phases of an HTTP client request
+// through an httptrace.ClientTrace. The hooks may be called from the
+// goroutines of the transport, e.g., when racing the connections to the
+// addresses of a host, hence the lock.
+type clientTimings struct {
+	lock                             sync.Mutex
+	dnsStart, connectStart, tlsStart time.Time
+	dns, connect, tls                time.Duration
+	gotConn, reused                  bool
+}
+
+// clientTrace returns the httptrace.ClientTrace which records the timings.
+func (ct *clientTimings) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			ct.lock.Lock()
+			defer ct.lock.Unlock()
+			ct.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			ct.lock.Lock()
+			defer ct.lock.Unlock()
+			if !ct.dnsStart.IsZero() {
+				ct.dns = time.Since(ct.dnsStart)
+			}
+		},
+		ConnectStart: func(network, addr string) {
+			ct.lock.Lock()
+			defer ct.lock.Unlock()
+			// the first of the connections which may be raced
+			if ct.connectStart.IsZero() {
+				ct.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			ct.lock.Lock()
+			defer ct.lock.Unlock()
+			// the first connection established
+			if err == nil && ct.connect == 0 && !ct.connectStart.IsZero() {
+				ct.connect = time.Since(ct.connectStart)
+			}
+		},
+		TLSHandshakeStart: func() {
+			ct.lock.Lock()
+			defer ct.lock.Unlock()
+			ct.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			ct.lock.Lock()
+			defer ct.lock.Unlock()
+			if !ct.tlsStart.IsZero() {
+				ct.tls = time.Since(ct.tlsStart)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			ct.lock.Lock()
+			defer ct.lock.Unlock()
+			ct.gotConn = true
+			ct.reused = info.Reused
+		},
+	}
+}
+
+// kvs returns the KVs of the timings of the phases which happened.
+func (ct *clientTimings) kvs() []interface{} {
+	ct.lock.Lock()
+	defer ct.lock.Unlock()
+
+	var kvs []interface{}
+	if !ct.dnsStart.IsZero() {
+		kvs = append(kvs, keyDNSDuration, int64(roundDuration(ct.dns)/time.Microsecond))
+	}
+	if !ct.connectStart.IsZero() {
+		kvs = append(kvs, keyConnectDuration, int64(roundDuration(ct.connect)/time.Microsecond))
+	}
+	if !ct.tlsStart.IsZero() {
+		kvs = append(kvs, keyTLSDuration, int64(roundDuration(ct.tls)/time.Microsecond))
+	}
+	if ct.gotConn {
+		kvs = append(kvs, keyConnReused, ct.reused)
+	}
+	return kvs
+}
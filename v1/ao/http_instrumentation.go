@@ -13,6 +13,9 @@ import (
 	"runtime/debug"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 )
 
 // HTTPHeaderName is a constant for the HTTP header used by AppOptics ("X-Trace") to propagate
@@ -57,6 +60,10 @@ func HTTPHandler(handler func(http.ResponseWriter, *http.Request), opts ...SpanO
 		}()
 		// Call original HTTP handler
 		handler(w, r)
+		// the headers of an empty response are sent after the handler returns
+		if rw, ok := w.(*HTTPResponseWriter); ok {
+			rw.captureHeaders()
+		}
 	}
 }
 
@@ -81,6 +88,11 @@ func TraceFromHTTPRequestResponse(spanName string, w http.ResponseWriter, r *htt
 		isNewContext = true
 	}
 
+	// read or generate the request ID and propagate it to the handler
+	if requestID := requestIDFromHTTPRequest(r); requestID != "" {
+		r = r.WithContext(WithRequestID(r.Context(), requestID))
+	}
+
 	t := traceFromHTTPRequest(spanName, r, isNewContext, opts...)
 
 	// Associate the trace with http.Request to expose it to the handler
@@ -97,12 +109,16 @@ type HTTPResponseWriter struct {
 	t           Trace
 	StatusCode  int
 	WroteHeader bool
+	// if the response headers have been captured
+	capturedHeaders bool
 }
 
 func (w *HTTPResponseWriter) Write(p []byte) (n int, err error) {
 	if !w.WroteHeader {
 		w.WriteHeader(w.StatusCode)
 	}
+	// the headers are sent by the first Write of an implicit 200 response
+	w.captureHeaders()
 	return w.Writer.Write(p)
 }
 
@@ -120,10 +136,87 @@ func (w *HTTPResponseWriter) WriteHeader(status int) {
 		}
 		w.Header().Set(HTTPHeaderName, w.t.ExitMetadata()) // replace downstream MD with ours
 	}
+	w.captureHeaders()
 	w.WroteHeader = true
 	w.Writer.WriteHeader(status)
 }
 
+// the maximum length of a captured header value, the longer ones are truncated
+const maxCapturedHeaderLen = 256
+
+// the request headers which are never captured, even if they are listed in
+// the configuration, as they carry credentials
+var sensitiveRequestHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+}
+
+// the response headers which are never captured, even if they are listed in
+// the configuration, as they carry credentials
+var sensitiveResponseHeaders = map[string]bool{
+	"Set-Cookie": true,
+	"Cookie":     true,
+}
+
+// capturedHeaders returns the headers listed in names as a map from the KV
+// keys, which are the canonical header names with the prefix, to the values.
+func capturedHeaders(h http.Header, names []string, prefix string) map[string]string {
+	kvs := make(map[string]string)
+	for _, name := range names {
+		name = http.CanonicalHeaderKey(name)
+		values, ok := h[name]
+		if !ok {
+			continue
+		}
+		kvs[prefix+name] = truncateHeaderValue(strings.Join(values, ", "))
+	}
+	return kvs
+}
+
+// truncateHeaderValue truncates the value to maxCapturedHeaderLen bytes at most,
+// without splitting a UTF-8 encoded character.
+func truncateHeaderValue(val string) string {
+	if len(val) <= maxCapturedHeaderLen {
+		return val
+	}
+	i := maxCapturedHeaderLen
+	for i > 0 && !utf8.RuneStart(val[i]) {
+		i--
+	}
+	return val[:i]
+}
+
+// captureHeaders reports the response headers allowed by the configuration as
+// KVs of the exit event. The headers are captured once, when they are sent.
+func (w *HTTPResponseWriter) captureHeaders() {
+	if w.capturedHeaders || !w.t.IsReporting() {
+		return
+	}
+	w.capturedHeaders = true
+	names := allowedHeaderNames(config.GetResponseHeaders(), sensitiveResponseHeaders)
+	for k, v := range capturedHeaders(w.Header(), names, keyResponseHeaderPrefix) {
+		w.t.AddEndArgs(k, v)
+	}
+}
+
+// requestHeaderNames returns the request headers allowed by the configuration,
+// excluding the sensitive ones.
+func requestHeaderNames() []string {
+	return allowedHeaderNames(config.GetRequestHeaders(), sensitiveRequestHeaders)
+}
+
+// allowedHeaderNames returns the header names excluding the sensitive ones.
+func allowedHeaderNames(names []string, sensitive map[string]bool) []string {
+	var allowed []string
+	for _, name := range names {
+		if !sensitive[http.CanonicalHeaderKey(name)] {
+			allowed = append(allowed, name)
+		}
+	}
+	return allowed
+}
+
 // newResponseWriter observes the HTTP Status code of an HTTP response, returning a
 // wrapped http.ResponseWriter and a pointer to an int containing the status.
 func newResponseWriter(writer http.ResponseWriter, t Trace) *HTTPResponseWriter {
@@ -145,8 +238,12 @@ func traceFromHTTPRequest(spanName string, r *http.Request, isNewContext bool, o
 		f(so)
 	}
 
-	// start trace, passing in metadata header
-	t := NewTraceFromIDForURL(spanName, r.Header.Get(HTTPHeaderName), r.URL.EscapedPath(), func() KVMap {
+	// start trace, passing in metadata header and the sampling hint, if any
+	hint := samplingHintFromContext(r.Context())
+	tenant := TenantFromContext(r.Context())
+	// the sampling priority of the upstream service, if any
+	priority := priorityFromTraceState(r.Header.Get(TraceStateHeaderName))
+	t := newTrace(spanName, r.Header.Get(HTTPHeaderName), r.URL.EscapedPath(), hint, tenant, TraceOriginHTTP, func() KVMap {
 		kvs := KVMap{
 			keyMethod:      r.Method,
 			keyHTTPHost:    r.Host,
@@ -155,6 +252,28 @@ func traceFromHTTPRequest(spanName string, r *http.Request, isNewContext bool, o
 			keyQueryString: r.URL.RawQuery,
 		}
 
+		if ip := clientIPFromHTTPRequest(r); ip != "" {
+			kvs[keyClientIP] = ip
+		}
+
+		if id := RequestID(r.Context()); id != "" {
+			kvs[keyRequestID] = id
+		}
+
+		if priority != PriorityUnset {
+			kvs[keySamplingPriority] = priority.String()
+		}
+
+		for k := range kvs {
+			if config.IsHTTPKVDisabled(k) {
+				delete(kvs, k)
+			}
+		}
+
+		for k, v := range capturedHeaders(r.Header, requestHeaderNames(), keyRequestHeaderPrefix) {
+			kvs[k] = v
+		}
+
 		if so.WithBackTrace {
 			kvs[KeyBackTrace] = string(debug.Stack())
 		}
@@ -176,7 +295,19 @@ func traceFromHTTPRequest(spanName string, r *http.Request, isNewContext bool, o
 	if !isNewContext {
 		t.SetStartTime(time.Time{})
 	}
+	// inherit the sampling priority of the upstream service, if any
+	if priority != PriorityUnset {
+		inheritTracePriority(t, priority)
+	}
+	// the trace is cancelled if the client gives up the request
+	if at, ok := t.(*aoTrace); ok {
+		at.ctxErr = r.Context().Err
+	}
 	// update incoming metadata in request headers for any downstream readers
 	r.Header.Set(HTTPHeaderName, t.MetadataString())
+	// the inbound request ID is left untouched
+	if id := RequestID(r.Context()); id != "" && r.Header.Get(RequestIDHeaderName) == "" {
+		r.Header.Set(RequestIDHeaderName, id)
+	}
 	return t
 }
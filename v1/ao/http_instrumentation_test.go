@@ -15,6 +15,7 @@ import (
 	"sync"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"os"
 
@@ -117,6 +118,62 @@ func TestHTTPHandler404(t *testing.T) {
 	})
 }
 
+func TestHTTPHandlerAsyncSpan(t *testing.T) {
+	r := reporter.SetTestReporter()
+	start, done := make(chan struct{}), make(chan struct{})
+	httpTest(func(w http.ResponseWriter, r *http.Request) {
+		l, _ := ao.BeginSpan(r.Context(), "async")
+		go func() {
+			<-start
+			l.End()
+			close(done)
+		}()
+	})
+	// the child span is ended after the handler returns
+	close(start)
+	<-done
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}},
+		{"async", "entry"}:            {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+		{"async", "exit"}:             {Edges: g.Edges{{"async", "entry"}}},
+		// the late child doesn't join the exit of its parent
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+	})
+}
+
+func TestHTTPHandlerAsyncSpanNotCancelled(t *testing.T) {
+	r := reporter.SetTestReporter()
+	done := make(chan struct{})
+	svr := httptest.NewServer(http.HandlerFunc(ao.HTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		l, ctx := ao.BeginSpan(r.Context(), "async")
+		go func() {
+			// the server cancels the request context once the handler returns
+			<-ctx.Done()
+			l.End()
+			close(done)
+		}()
+	})))
+	defer svr.Close()
+	resp, err := http.Get(svr.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	<-done
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}},
+		{"async", "entry"}:            {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+		{"async", "exit"}: {Edges: g.Edges{{"async", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "SpanStatus")
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "SpanStatus")
+		}},
+	})
+}
+
 func TestHTTPHandler200(t *testing.T) {
 	os.Setenv("APPOPTICS_PREPEND_DOMAIN", "false")
 	config.Load()
@@ -148,6 +205,222 @@ func TestHTTPHandler200(t *testing.T) {
 	})
 }
 
+func TestHTTPHandlerResponseHeaders(t *testing.T) {
+	os.Setenv("APPOPTICS_RESPONSE_HEADERS", "cache-status, X-Served-By, X-Long, Set-Cookie")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_RESPONSE_HEADERS")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter() // set up test reporter
+	httpTest(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Status", "HIT")
+		w.Header().Add("X-Served-By", "cache-1")
+		w.Header().Add("X-Served-By", "cache-2")
+		w.Header().Set("X-Long", "a"+strings.Repeat("é", 500))
+		w.Header().Set("Set-Cookie", "session=secret")
+		// the headers are captured for an implicit 200 response
+		w.Write([]byte("ok"))
+	})
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "Response-Header-Cache-Status")
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "HIT", n.Map["Response-Header-Cache-Status"])
+			assert.Equal(t, "cache-1, cache-2", n.Map["Response-Header-X-Served-By"])
+			// truncated on a character boundary
+			long := n.Map["Response-Header-X-Long"].(string)
+			assert.Len(t, long, 255)
+			assert.True(t, utf8.ValidString(long))
+			// only the headers listed are captured
+			assert.NotContains(t, n.Map, "Response-Header-"+ao.HTTPHeaderName)
+			// the cookies are never captured
+			assert.NotContains(t, n.Map, "Response-Header-Set-Cookie")
+		}},
+	})
+}
+
+func TestHTTPHandlerRequestHeaders(t *testing.T) {
+	os.Setenv("APPOPTICS_REQUEST_HEADERS", "x-request-id,Referer,Authorization,Cookie,Proxy-Authorization")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_REQUEST_HEADERS")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter() // set up test reporter
+	httpTestWithEndpointWithHeaders(handler200, "http://test.com/hello", map[string]string{
+		"X-Request-ID":        "abc123",
+		"Referer":             "http://test.com/" + strings.Repeat("a", 1000),
+		"Authorization":       "Bearer secret",
+		"Cookie":              "session=secret",
+		"Proxy-Authorization": "Basic secret",
+		"User-Agent":          "test",
+	})
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
+			assert.Equal(t, "abc123", n.Map["Request-Header-X-Request-Id"])
+			assert.Len(t, n.Map["Request-Header-Referer"], 256)
+			// the headers not listed are not captured
+			assert.NotContains(t, n.Map, "Request-Header-User-Agent")
+			// the sensitive headers are never captured
+			assert.NotContains(t, n.Map, "Request-Header-Authorization")
+			assert.NotContains(t, n.Map, "Request-Header-Cookie")
+			assert.NotContains(t, n.Map, "Request-Header-Proxy-Authorization")
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "Request-Header-X-Request-Id")
+		}},
+	})
+}
+
+func TestHTTPHandlerRequestID(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	var requestID string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requestID = ao.RequestID(r.Context())
+		w.WriteHeader(200)
+	}
+	httpTestWithEndpointWithHeaders(handler, "http://test.com/hello",
+		map[string]string{ao.RequestIDHeaderName: "req-abc123"})
+	assert.Equal(t, "req-abc123", requestID)
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
+			assert.Equal(t, "req-abc123", n.Map["Request-ID"])
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+	})
+
+	// the request ID is injected into the outbound requests
+	req, _ := http.NewRequest("GET", "http://test.com/downstream", nil)
+	ao.BeginHTTPClientSpan(ao.WithRequestID(context.Background(), requestID), req).End()
+	assert.Equal(t, "req-abc123", req.Header.Get(ao.RequestIDHeaderName))
+
+	// but it doesn't override the one set by the application
+	req.Header.Set(ao.RequestIDHeaderName, "req-xyz")
+	ao.BeginHTTPClientSpan(ao.WithRequestID(context.Background(), requestID), req).End()
+	assert.Equal(t, "req-xyz", req.Header.Get(ao.RequestIDHeaderName))
+}
+
+func TestHTTPHandlerInboundRequestID(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	var requestID, header string
+	h := http.HandlerFunc(ao.HTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		requestID = ao.RequestID(r.Context())
+		header = r.Header.Get(ao.RequestIDHeaderName)
+	}))
+
+	// the inbound request ID is reused rather than the one in the context
+	req := httptest.NewRequest("GET", "http://test.com/hello", nil)
+	req.Header.Set(ao.RequestIDHeaderName, "req-abc123")
+	req = req.WithContext(ao.WithRequestID(req.Context(), "outer"))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "req-abc123", requestID)
+	assert.Equal(t, "req-abc123", header)
+
+	// the one in the context is propagated without an inbound one
+	req = httptest.NewRequest("GET", "http://test.com/hello", nil)
+	req = req.WithContext(ao.WithRequestID(req.Context(), "outer"))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "outer", requestID)
+	assert.Equal(t, "outer", header)
+
+	r.Close(4)
+}
+
+func TestHTTPHandlerNewRequestID(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+	var requestID string
+	httpTest(func(w http.ResponseWriter, r *http.Request) {
+		requestID = ao.RequestID(r.Context())
+		w.WriteHeader(200)
+	})
+	assert.Len(t, requestID, 32)
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
+			assert.Equal(t, requestID, n.Map["Request-ID"])
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+	})
+}
+
+func TestHTTPHandlerRequestIDDisabled(t *testing.T) {
+	os.Setenv("APPOPTICS_REQUEST_ID", "false")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_REQUEST_ID")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter() // set up test reporter
+	var requestID string
+	httpTestWithEndpointWithHeaders(func(w http.ResponseWriter, r *http.Request) {
+		requestID = ao.RequestID(r.Context())
+		w.WriteHeader(200)
+	}, "http://test.com/hello", map[string]string{ao.RequestIDHeaderName: "req-abc123"})
+	assert.Empty(t, requestID)
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "Request-ID")
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+	})
+
+	// the request ID is not injected into the outbound requests
+	req, _ := http.NewRequest("GET", "http://test.com/downstream", nil)
+	ao.BeginHTTPClientSpan(ao.WithRequestID(context.Background(), "req-abc123"), req).End()
+	assert.Empty(t, req.Header.Get(ao.RequestIDHeaderName))
+	assert.Empty(t, ao.RequestID(nil))
+}
+
+func testHTTPHandlerClientIP(t *testing.T, expected string) {
+	r := reporter.SetTestReporter() // set up test reporter
+	h := http.HandlerFunc(ao.HTTPHandler(handler200))
+	req, _ := http.NewRequest("GET", "http://test.com/hello", nil)
+	req.RemoteAddr = "10.0.0.1:51234"
+	req.Header.Set(ao.ForwardedForHeaderName, "203.0.113.7, 10.0.0.2")
+	req.Header.Set(ao.RealIPHeaderName, "198.51.100.3")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
+			assert.Equal(t, expected, n.Map["ClientIP"])
+			assert.Equal(t, "10.0.0.1:51234", n.Map["Remote-Host"])
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+	})
+}
+
+func TestHTTPHandlerClientIP(t *testing.T) {
+	// the forwarding headers are not trusted by default
+	testHTTPHandlerClientIP(t, "10.0.0.1")
+
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	os.Setenv("APPOPTICS_TRUST_FORWARDED_FOR", "true")
+	os.Setenv("APPOPTICS_TRUSTED_PROXIES", "10.0.0.0/8")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_TRUST_FORWARDED_FOR")
+		os.Unsetenv("APPOPTICS_TRUSTED_PROXIES")
+		config.Load()
+	}()
+	testHTTPHandlerClientIP(t, "203.0.113.7")
+}
+
 func TestHTTPHandlerNoTrace(t *testing.T) {
 	r := reporter.SetTestReporter(reporter.TestReporterDisableTracing())
 	httpTest(handler404)
@@ -156,6 +429,40 @@ func TestHTTPHandlerNoTrace(t *testing.T) {
 	assert.Len(t, r.EventBufs, 0)
 }
 
+func TestHTTPClientSpanUnsampled(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_PROPAGATE_UNSAMPLED")
+		config.Load()
+	}()
+	r := reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+
+	for _, propagate := range []bool{true, false} {
+		os.Setenv("APPOPTICS_PROPAGATE_UNSAMPLED", strconv.FormatBool(propagate))
+		config.Load()
+
+		ctx := ao.NewContext(context.Background(), ao.NewTrace("unsampled"))
+		require.False(t, ao.IsSampled(ctx))
+
+		req, err := http.NewRequest("GET", "http://test.com/unsampled", nil)
+		require.NoError(t, err)
+		l := ao.BeginHTTPClientSpan(ctx, req)
+		l.End()
+
+		md := req.Header.Get(ao.HTTPHeaderName)
+		if propagate {
+			assert.True(t, reporter.ValidMetadata(md), md)
+			assert.True(t, strings.HasSuffix(md, "00"), md)
+			assert.Equal(t, ao.MetadataString(ctx)[2:42], md[2:42])
+		} else {
+			assert.Empty(t, md)
+		}
+	}
+
+	// unsampled, shouldn't report anything
+	r.Close(0)
+	assert.Len(t, r.EventBufs, 0)
+}
+
 var httpSpanSleep time.Duration
 
 func TestHTTPSpan(t *testing.T) {
@@ -203,6 +510,135 @@ func TestHTTPSpan(t *testing.T) {
 	assert.InDelta(t, (54*time.Millisecond + nullDuration).Seconds(), m.Duration.Seconds(), (10 * time.Millisecond).Seconds())
 }
 
+func TestHTTPSpanDurationPrecision(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_DURATION_PRECISION")
+		config.Load()
+		httpSpanSleep = 0
+	}()
+	httpSpanSleep = 3*time.Millisecond + 300*time.Microsecond
+
+	for _, tc := range []struct {
+		precision string
+		unit      time.Duration
+	}{
+		{"us", time.Microsecond},
+		{"ms", time.Millisecond},
+	} {
+		os.Setenv("APPOPTICS_DURATION_PRECISION", tc.precision)
+		config.Load()
+		r := reporter.SetTestReporter(reporter.TestReporterDisableDefaultSetting(false))
+		httpTest(handlerDelay200)
+		r.Close(2)
+
+		require.Len(t, r.SpanMessages, 1)
+		m, ok := r.SpanMessages[0].(*reporter.HTTPSpanMessage)
+		require.True(t, ok)
+		assert.NotZero(t, m.Duration, tc.precision)
+		assert.Zero(t, m.Duration%tc.unit, "%s: %v", tc.precision, m.Duration)
+	}
+}
+
+func TestHTTPErrorStatusCodes(t *testing.T) {
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	os.Setenv("APPOPTICS_KEEP_ERRORS", "true")
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_KEEP_ERRORS")
+		os.Unsetenv("APPOPTICS_ERROR_STATUS_CODES")
+		config.Load()
+	}()
+
+	for _, tc := range []struct {
+		codes   string
+		isError bool
+	}{
+		{"", false},
+		{"400-499,500-599", true},
+		{"404", true},
+		{"500-599", false},
+	} {
+		os.Setenv("APPOPTICS_ERROR_STATUS_CODES", tc.codes)
+		config.Load()
+		// not sampled, so the trace is reported only if it's kept as an error
+		r := reporter.SetTestReporter(reporter.TestReporterSampleRate(0))
+		httpTest(handler404)
+		if tc.isError {
+			r.Close(3)
+			assert.Len(t, r.EventBufs, 2, tc.codes)
+		} else {
+			r.Close(1)
+			assert.Empty(t, r.EventBufs, tc.codes)
+		}
+
+		require.Len(t, r.SpanMessages, 1)
+		m, ok := r.SpanMessages[0].(*reporter.HTTPSpanMessage)
+		require.True(t, ok)
+		assert.Equal(t, 404, m.Status)
+		assert.Equal(t, tc.isError, m.HasError, tc.codes)
+	}
+}
+
+func TestHTTPDisabledKVs(t *testing.T) {
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	os.Setenv("APPOPTICS_DISABLED_HTTP_KVS", "url,Query-String,Status")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_DISABLED_HTTP_KVS")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	httpTest(handler404)
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "URL")
+			assert.NotContains(t, n.Map, "Query-String")
+			assert.Equal(t, "test.com", n.Map["HTTP-Host"])
+			assert.Equal(t, "GET", n.Map["Method"])
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "Status")
+			assert.Equal(t, "handler404", n.Map["Action"])
+		}},
+	})
+
+	// the status is still used for the metrics
+	require.Len(t, r.SpanMessages, 1)
+	m, ok := r.SpanMessages[0].(*reporter.HTTPSpanMessage)
+	require.True(t, ok)
+	assert.Equal(t, 404, m.Status)
+	assert.Equal(t, "/hello", m.Path)
+}
+
+func TestHTTPDisabledStatusKeepErrors(t *testing.T) {
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	os.Setenv("APPOPTICS_KEEP_ERRORS", "true")
+	os.Setenv("APPOPTICS_ERROR_STATUS_CODES", "404")
+	os.Setenv("APPOPTICS_DISABLED_HTTP_KVS", "Status")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_KEEP_ERRORS")
+		os.Unsetenv("APPOPTICS_ERROR_STATUS_CODES")
+		os.Unsetenv("APPOPTICS_DISABLED_HTTP_KVS")
+		config.Load()
+	}()
+
+	// not sampled, the trace is kept by its status even if it's not reported
+	r := reporter.SetTestReporter(reporter.TestReporterSampleRate(0))
+	httpTest(handler404)
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Edges: g.Edges{}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "Status")
+		}},
+	})
+}
+
 func TestSingleHTTPSpan(t *testing.T) {
 	r := reporter.SetTestReporter(reporter.TestReporterDisableDefaultSetting(false)) // set up test reporter
 	httpTest(handlerDoubleWrapped)
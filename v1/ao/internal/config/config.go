@@ -13,14 +13,21 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 	"github.com/pkg/errors"
@@ -36,6 +43,100 @@ const (
 	maxConfigFileSize = 1024 * 1024
 	// the default collector url
 	defaultSSLCollector = "collector.appoptics.com:443"
+	// the default UDP collector address, a local agent
+	defaultUDPCollector = "127.0.0.1:7831"
+	// MaxCapturedHeaders is the maximum number of HTTP headers captured as KVs
+	MaxCapturedHeaders = 20
+	// MaxMetricTags is the maximum number of the global metric tags
+	MaxMetricTags = 20
+	// DefaultMaxHeaderSize is the default maximum size in bytes of a header
+	// which the trace context is extracted from
+	DefaultMaxHeaderSize = 1024
+	// DefaultAdaptiveSamplingBudget is the default maximum number of outlier
+	// traces kept per second by the adaptive sampling
+	DefaultAdaptiveSamplingBudget = 10
+	// DefaultMetricsNamespace is the default namespace of the names of the
+	// metrics exported to Prometheus and StatsD
+	DefaultMetricsNamespace = "appoptics"
+	// DefaultBaggageMaxBytes is the default maximum total size in bytes of
+	// the baggage items propagated downstream
+	DefaultBaggageMaxBytes = 8192
+	// DefaultBaggageMaxItems is the default maximum number of the baggage
+	// items propagated downstream
+	DefaultBaggageMaxItems = 180
+	// DefaultRemoteConfigInterval is the default interval in seconds of
+	// polling the remote config source
+	DefaultRemoteConfigInterval = 60
+	// DefaultRemoteConfigTTL is the default time in seconds the config fetched
+	// from the remote config source is applied for without being refreshed
+	DefaultRemoteConfigTTL = 300
+)
+
+// DefaultHistogramBuckets are the default upper bounds in seconds of the
+// Prometheus histogram buckets, which are the same as the Prometheus client's.
+var DefaultHistogramBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// The formats of the tags of the StatsD metrics
+const (
+	// StatsDTagsDogStatsD appends the tags as |#name:value,name:value
+	StatsDTagsDogStatsD = "dogstatsd"
+	// StatsDTagsInfluxDB appends the tags to the metric name as
+	// ,name=value,name=value
+	StatsDTagsInfluxDB = "influxdb"
+	// StatsDTagsNone drops the tags, for the plain StatsD servers
+	StatsDTagsNone = "none"
+)
+
+// The handlings of the events whose timestamps are skewed further than the
+// maximum timestamp skew
+const (
+	// SkewedEventsReject drops the events
+	SkewedEventsReject = "reject"
+	// SkewedEventsClamp moves the timestamps of the events to the nearest
+	// bound of the maximum timestamp skew
+	SkewedEventsClamp = "clamp"
+)
+
+// The precisions which the durations reported are rounded to
+const (
+	DurationPrecisionNanosecond  = "ns"
+	DurationPrecisionMicrosecond = "us"
+	DurationPrecisionMillisecond = "ms"
+)
+
+// The granularities of the transaction names of the gRPC server spans
+const (
+	// GRPCTransactionNamingService names the transactions by the gRPC
+	// service, e.g., helloworld.Greeter
+	GRPCTransactionNamingService = "service"
+	// GRPCTransactionNamingMethod names the transactions by the gRPC
+	// service and method, e.g., helloworld.Greeter/SayHello
+	GRPCTransactionNamingMethod = "method"
+)
+
+// The validation strictness of the inbound headers carrying the trace context
+const (
+	// HeaderValidationStrict rejects the malformed headers, so a new trace is
+	// started as if no context is propagated
+	HeaderValidationStrict = "strict"
+	// HeaderValidationLenient repairs the common issues of the headers, e.g.,
+	// extra whitespace or lower case, before parsing them
+	HeaderValidationLenient = "lenient"
+)
+
+// The origins of the traces, i.e., the entry points which start them
+const (
+	// TraceOriginHTTP is the origin of the traces started by the HTTP handlers
+	TraceOriginHTTP = "http"
+	// TraceOriginGRPC is the origin of the traces started by the gRPC server
+	// interceptors
+	TraceOriginGRPC = "grpc"
+	// TraceOriginJob is the origin of the traces started by the cron or
+	// scheduled jobs
+	TraceOriginJob = "job"
+	// TraceOriginManual is the origin of the traces started by the application
+	// with the tracing API directly
+	TraceOriginManual = "manual"
 )
 
 // The environment variables
@@ -43,6 +144,7 @@ const (
 	envAppOpticsCollector           = "APPOPTICS_COLLECTOR"
 	envAppOpticsServiceKey          = "APPOPTICS_SERVICE_KEY"
 	envAppOpticsTrustedPath         = "APPOPTICS_TRUSTEDPATH"
+	envAppOpticsTrustedCert         = "APPOPTICS_TRUSTED_CERT"
 	envAppOpticsCollectorUDP        = "APPOPTICS_COLLECTOR_UDP"
 	envAppOpticsReporter            = "APPOPTICS_REPORTER"
 	envAppOpticsTracingMode         = "APPOPTICS_TRACING_MODE"
@@ -54,7 +156,9 @@ const (
 	envAppOpticsEventsFlushInterval = "APPOPTICS_EVENTS_FLUSH_INTERVAL"
 	envAppOpticsEventsBatchSize     = "APPOPTICS_EVENTS_BATCHSIZE"
 	envAppOpticsDisabled            = "APPOPTICS_DISABLED"
+	envAppOpticsFailClosed          = "APPOPTICS_FAIL_CLOSED"
 	EnvAppOpticsConfigFile          = "APPOPTICS_CONFIG_FILE"
+	EnvAppOpticsProfile             = "APPOPTICS_PROFILE"
 )
 
 // Errors
@@ -62,6 +166,8 @@ var (
 	ErrUnsupportedFormat = errors.New("unsupported format")
 	ErrFileTooLarge      = errors.New("file size exceeds limit")
 	ErrInvalidServiceKey = errors.New("invalid service key")
+	ErrUnknownProfile    = errors.New("unknown config profile")
+	ErrConflictingCert   = errors.New("TrustedPath and TrustedCert are mutually exclusive")
 )
 
 // Config is the struct to define the agent configuration. The configuration
@@ -79,10 +185,20 @@ type Config struct {
 	// The file path of the cert file for gRPC connection
 	TrustedPath string `yaml:"TrustedPath,omitempty" env:"APPOPTICS_TRUSTEDPATH"`
 
+	// The PEM encoded cert for gRPC connection, as an alternative to the
+	// TrustedPath when the cert file can't be mounted. Only one of them can be
+	// set, the TrustedCert is used if both are.
+	TrustedCert string `yaml:"TrustedCert,omitempty" env:"APPOPTICS_TRUSTED_CERT"`
+
 	// The host and port of the UDP collector
 	CollectorUDP string `yaml:"CollectorUDP,omitempty" env:"APPOPTICS_COLLECTOR_UDP"`
 
-	// The reporter type, ssl or udp
+	// The size in bytes of the send buffer (SO_SNDBUF) of the UDP reporter's
+	// socket. The OS default is used if it's 0.
+	UDPSendBuffer int `yaml:"UDPSendBuffer,omitempty" env:"APPOPTICS_UDP_SEND_BUFFER"`
+
+	// The reporter type, ssl, udp or pretty. The pretty reporter prints the
+	// traces to stdout as trees instead of sending them, for debugging only.
 	ReporterType string `yaml:"ReporterType,omitempty" env:"APPOPTICS_REPORTER" default:"ssl"`
 
 	Sampling *SamplingConfig `yaml:"Sampling,omitempty"`
@@ -109,6 +225,325 @@ type Config struct {
 
 	// The default log level. It should follow the level defined in log.DefaultLevel
 	DebugLevel string `yaml:"DebugLevel,omitempty" env:"APPOPTICS_DEBUG_LEVEL" default:"warn"`
+
+	// The Apdex threshold T in milliseconds. The Apdex score is not computed
+	// if it's 0.
+	ApdexThreshold int `yaml:"ApdexThreshold,omitempty" env:"APPOPTICS_APDEX_THRESHOLD"`
+
+	// Whether the context of an unsampled request is still propagated to the
+	// downstream services (with the sampled flag unset), so that they can make
+	// their own sampling decisions and keep the trace ID consistent.
+	PropagateUnsampled bool `yaml:"PropagateUnsampled" env:"APPOPTICS_PROPAGATE_UNSAMPLED" default:"true"`
+
+	// Whether to report a diagnostic span summarizing the events dropped by
+	// the reporter, e.g., due to a full queue or failures of sending.
+	ReportDroppedEvents bool `yaml:"ReportDroppedEvents,omitempty" env:"APPOPTICS_REPORT_DROPPED_EVENTS"`
+
+	// The sample rates used within the time windows of every day. The first
+	// window matched is used, and the default sample rate is used outside of
+	// them. The transactions matched by the TransactionSettings with a
+	// SampleRate are not affected.
+	SamplingWindows []SamplingWindow `yaml:"SamplingWindows,omitempty"`
+	// the sampling windows parsed by validate
+	samplingClocks []clockWindow
+	// The IANA timezone name, e.g., America/Vancouver, of the sampling windows.
+	// The local timezone is used if it's empty.
+	SamplingTimezone string `yaml:"SamplingTimezone,omitempty" env:"APPOPTICS_SAMPLING_TIMEZONE"`
+
+	// The factor, in (0, 1], by which the probability of reporting a span of
+	// a sampled trace is multiplied per level of its depth, e.g., with 0.5 the
+	// children of the root span are reported at 50%, the grandchildren at 25%
+	// and so on. A span not reported drops its whole subtree. The default 1
+	// reports all the spans.
+	SpanDepthDecay float64 `yaml:"SpanDepthDecay,omitempty" env:"APPOPTICS_SPAN_DEPTH_DECAY" default:"1"`
+
+	// The minimum duration of a child span in microseconds. The child spans
+	// finished in less time, without any events or children of their own, are
+	// folded into their parents instead of being reported. The root span is
+	// always reported. No span is folded if it's 0.
+	MinSpanDuration int `yaml:"MinSpanDuration,omitempty" env:"APPOPTICS_MIN_SPAN_DURATION"`
+
+	// Whether the consecutive sibling spans of the same name, e.g., the
+	// per-item lookups of a loop, are merged into a single span, with the
+	// number of the spans and the sum of their durations as KVs. Only the
+	// spans without any events or children of their own are merged.
+	MergeSiblingSpans bool `yaml:"MergeSiblingSpans,omitempty" env:"APPOPTICS_MERGE_SIBLING_SPANS"`
+
+	// Whether the queue time recorded by the application is aggregated into
+	// the TransactionQueueTime metric, besides being reported as a KV.
+	QueueTimeMetric bool `yaml:"QueueTimeMetric" env:"APPOPTICS_QUEUE_TIME_METRIC" default:"true"`
+
+	// Whether the runtime characteristics of the process, i.e., the Go version
+	// and GOMAXPROCS, are reported as KVs of the root spans.
+	RuntimeKVs bool `yaml:"RuntimeKVs" env:"APPOPTICS_RUNTIME_KVS" default:"true"`
+
+	// Whether the agent fails closed on initialization errors, e.g., an invalid
+	// service key or configuration file. The application can then get the
+	// error via ao.InitError and refuse to start. Otherwise (fail-open) the
+	// error is only logged and the agent no-ops.
+	FailClosed bool `yaml:"FailClosed,omitempty" env:"APPOPTICS_FAIL_CLOSED"`
+	// the error of the last loading of the configuration, if any
+	loadErr error
+
+	// Whether the reporter defers connecting to the collector and starting its
+	// goroutines until the first trace is started, to save the connection of a
+	// service which rarely or never traces. The first traces are not sampled
+	// as the sampling settings are not retrieved from the collector yet.
+	LazyStart bool `yaml:"LazyStart,omitempty" env:"APPOPTICS_LAZY_START"`
+
+	// Whether the events of the new traces which are not sampled are buffered
+	// and sent anyway if an error is reported in the trace, regardless of the
+	// sampling decision. The buffered events are dropped when the trace ends
+	// without any error.
+	KeepErrors bool `yaml:"KeepErrors,omitempty" env:"APPOPTICS_KEEP_ERRORS"`
+	// The maximum number of the traces kept per second for each error class
+	// by KeepErrors, so a spike of an error class keeps a sample of its traces
+	// instead of all of them. It's not limited if it's not positive.
+	KeepErrorsRate float64 `yaml:"KeepErrorsRate,omitempty" env:"APPOPTICS_KEEP_ERRORS_RATE"`
+
+	// Whether the new traces which are not sampled are buffered and sent
+	// anyway if their durations are outliers, i.e., above the running p95 of
+	// their transactions, so more of the slow tail is sampled. The outliers
+	// kept are limited by the AdaptiveSamplingBudget.
+	AdaptiveSampling bool `yaml:"AdaptiveSampling,omitempty" env:"APPOPTICS_ADAPTIVE_SAMPLING"`
+	// The maximum number of outlier traces kept per second by the adaptive
+	// sampling. The DefaultAdaptiveSamplingBudget is used if it's not positive.
+	AdaptiveSamplingBudget float64 `yaml:"AdaptiveSamplingBudget,omitempty" env:"APPOPTICS_ADAPTIVE_SAMPLING_BUDGET"`
+
+	// The maximum size in bytes of a header which the trace context, the
+	// tracestate or a baggage item is extracted from. A larger header is
+	// ignored as if it's not propagated. The DefaultMaxHeaderSize is used if
+	// it's not positive.
+	MaxHeaderSize int `yaml:"MaxHeaderSize,omitempty" env:"APPOPTICS_MAX_HEADER_SIZE"`
+
+	// Whether the client IP reported by the server spans is taken from the
+	// X-Forwarded-For or X-Real-IP headers set by a proxy. It should only be
+	// enabled if the service is behind a trusted proxy, as the headers can be
+	// forged by the clients otherwise.
+	TrustForwardedFor bool `yaml:"TrustForwardedFor,omitempty" env:"APPOPTICS_TRUST_FORWARDED_FOR"`
+	// The IP addresses or CIDRs of the trusted proxies, e.g., 10.0.0.0/8. The
+	// X-Forwarded-For header is walked from right to left, skipping them, and
+	// the first address of an untrusted host is the client IP.
+	TrustedProxies []string `yaml:"TrustedProxies,omitempty" env:"APPOPTICS_TRUSTED_PROXIES"`
+	// the trusted proxies parsed by validate
+	trustedProxyNets []*net.IPNet
+
+	// The service keys of the tenants which the traces can be reported for
+	// instead of the ServiceKey, keyed by the tenant names, e.g.,
+	// "tenantA=token:service-a,tenantB=token:service-b". A trace is reported
+	// for a tenant if it's started with the tenant name, e.g., by
+	// ao.WithTenant, or with the ServiceKey if the tenant is not listed. The
+	// metrics are always reported with the ServiceKey.
+	TenantServiceKeys TenantServiceKeys `yaml:"TenantServiceKeys,omitempty" env:"APPOPTICS_TENANT_SERVICE_KEYS"`
+
+	// Whether the trailing slashes are removed from the transaction names, so
+	// e.g., /orders and /orders/ are reported as the same transaction.
+	TrimTrailingSlash bool `yaml:"TrimTrailingSlash,omitempty" env:"APPOPTICS_TRIM_TRAILING_SLASH"`
+	// Whether the transaction names are converted to lower case.
+	LowercaseTransactionName bool `yaml:"LowercaseTransactionName,omitempty" env:"APPOPTICS_LOWERCASE_TRANSACTION_NAME"`
+
+	// Whether the event timestamps are read from the wall clock. By default,
+	// only the first event of a trace reads the wall clock and the later ones
+	// are offset from it by the monotonic clock, so the durations are correct
+	// even if the wall clock is stepped, e.g., by NTP, during the trace.
+	WallClockTimestamps bool `yaml:"WallClockTimestamps,omitempty" env:"APPOPTICS_WALL_CLOCK_TIMESTAMPS"`
+
+	// Whether the metrics are not reported, regardless of the feature flags
+	// provided by the collector.
+	DisableMetrics bool `yaml:"DisableMetrics,omitempty" env:"APPOPTICS_DISABLE_METRICS"`
+	// Whether the feature flags provided by the collector, e.g., to disable the
+	// metrics or change the histogram precision, are ignored, in which case
+	// only the local configuration is used.
+	IgnoreRemoteFlags bool `yaml:"IgnoreRemoteFlags,omitempty" env:"APPOPTICS_IGNORE_REMOTE_FLAGS"`
+
+	// The names of the request headers reported as KVs of the HTTP spans. Only
+	// the headers listed are captured, and the ones carrying credentials, e.g.,
+	// Authorization and Cookie, are never captured.
+	RequestHeaders []string `yaml:"RequestHeaders,omitempty" env:"APPOPTICS_REQUEST_HEADERS"`
+
+	// The names of the response headers reported as KVs of the HTTP spans.
+	// Only the headers listed are captured, as the others may contain PII, and
+	// the cookies, i.e., Set-Cookie and Cookie, are never captured.
+	ResponseHeaders []string `yaml:"ResponseHeaders,omitempty" env:"APPOPTICS_RESPONSE_HEADERS"`
+
+	// The upper bounds in seconds of the Prometheus histogram buckets which
+	// the transaction response times are converted into. The default buckets
+	// are used if it's empty.
+	HistogramBuckets []float64 `yaml:"HistogramBuckets,omitempty" env:"APPOPTICS_HISTOGRAM_BUCKETS"`
+
+	// The sample rates of the query spans by the SQL statement type, e.g.,
+	// SELECT or INSERT, from 0 to 1000000. A query span of a sampled trace is
+	// kept at the rate of its statement type, the statement types not listed
+	// are always kept.
+	SQLSampleRates map[string]int `yaml:"SQLSampleRates,omitempty" env:"APPOPTICS_SQL_SAMPLE_RATES"`
+
+	// The tags added to all the metrics, e.g., the environment and region. The
+	// tags of a metric take precedence over them on conflicts.
+	MetricTags map[string]string `yaml:"MetricTags,omitempty" env:"APPOPTICS_METRIC_TAGS"`
+
+	// The names of the KVs whose values are redacted before being sent, e.g.,
+	// password. The names are case-insensitive.
+	RedactedKeys []string `yaml:"RedactedKeys,omitempty" env:"APPOPTICS_REDACTED_KEYS"`
+	// The regular expression of the names of the KVs to be redacted, e.g.,
+	// (?i)secret|token
+	RedactedKeyRegex string `yaml:"RedactedKeyRegex,omitempty" env:"APPOPTICS_REDACTED_KEY_REGEX"`
+
+	// The address (host:port) of the StatsD server which the metrics are sent
+	// to at the end of each metrics cycle, e.g., 127.0.0.1:8125. The metrics
+	// are not sent to a StatsD server if it's empty.
+	StatsDAddr string `yaml:"StatsDAddr,omitempty" env:"APPOPTICS_STATSD_ADDR"`
+	// The format of the tags of the StatsD metrics: dogstatsd (the default),
+	// influxdb or none.
+	StatsDTagFormat string `yaml:"StatsDTagFormat,omitempty" env:"APPOPTICS_STATSD_TAG_FORMAT"`
+	// Send the metrics to the StatsD server instead of the collector.
+	StatsDOnly bool `yaml:"StatsDOnly,omitempty" env:"APPOPTICS_STATSD_ONLY"`
+
+	// The namespace prefixed to the names of the metrics exported to
+	// Prometheus and StatsD, e.g., appoptics_agent, to avoid collisions with
+	// the metrics of the application. DefaultMetricsNamespace is used if it's
+	// empty.
+	MetricsNamespace string `yaml:"MetricsNamespace,omitempty" env:"APPOPTICS_METRICS_NAMESPACE"`
+
+	// The maximum skew in seconds of the timestamps of the events from the
+	// current time of the agent, e.g., the timestamps provided by a host with
+	// a broken clock. The events skewed further are handled as configured by
+	// SkewedEvents. The timestamps are not checked if it's 0.
+	MaxTimestampSkew int `yaml:"MaxTimestampSkew,omitempty" env:"APPOPTICS_MAX_TIMESTAMP_SKEW"`
+	// How the events with skewed timestamps are handled: reject (the default)
+	// or clamp.
+	SkewedEvents string `yaml:"SkewedEvents,omitempty" env:"APPOPTICS_SKEWED_EVENTS"`
+
+	// The path of the Unix socket the agent listens on for the commands to
+	// change the sample rate and tracing mode at runtime, e.g.,
+	// /var/run/appoptics.sock. The socket is only accessible by the user the
+	// application runs as. No socket is created if it's empty.
+	ControlSocket string `yaml:"ControlSocket,omitempty" env:"APPOPTICS_CONTROL_SOCKET"`
+
+	// The names of the spans which are never created, e.g., the keepalive
+	// pings of a third-party library. A name is either matched exactly or as
+	// a glob pattern of path.Match, e.g., redis-ping or grpc.health.*. The
+	// children of a blocked span are dropped too. The root spans are not
+	// blocked, see TransactionSettings for filtering the transactions.
+	BlockedSpans []string `yaml:"BlockedSpans,omitempty" env:"APPOPTICS_BLOCKED_SPANS"`
+
+	// The names of the config items, e.g., Proxy or ReporterProperties.Proxy,
+	// and the KVs whose values are secrets. They are masked wherever the
+	// config is rendered, e.g., the accepted config items logged and the
+	// config hash, and the KVs are redacted as RedactedKeys. The names are
+	// case-insensitive. The service key is always masked.
+	SecretFields []string `yaml:"SecretFields,omitempty" env:"APPOPTICS_SECRET_FIELDS"`
+
+	// The maximum number of distinct transaction names reported in the
+	// metrics of a report cycle. The names beyond it are aggregated under the
+	// "other" transaction, so the cardinality of the metrics is bounded even if
+	// the names are not normalized well. The smaller one of it and the limit
+	// provided by the collector takes effect, and only the latter if it's not
+	// positive.
+	MaxTransactions int `yaml:"MaxTransactions,omitempty" env:"APPOPTICS_MAX_TRANSACTIONS"`
+
+	// The granularity of the transaction names of the gRPC server spans:
+	// service or method. The transactions are named by the server name and
+	// the method name, e.g., greeter.SayHello, if it's empty.
+	GRPCTransactionNaming string `yaml:"GRPCTransactionNaming,omitempty" env:"APPOPTICS_GRPC_TRANSACTION_NAMING"`
+
+	// The precision which the durations are rounded to before they're
+	// reported, e.g., of the HTTP spans, the merged spans, the phases of the
+	// HTTP client requests and the jobs: ns, us or ms. It's ns, the highest
+	// precision, if it's empty. It doesn't affect the event timestamps.
+	DurationPrecision string `yaml:"DurationPrecision,omitempty" env:"APPOPTICS_DURATION_PRECISION"`
+
+	// The sample rates of the new traces by their origin: http, grpc, job or
+	// manual, from 0 to 1000000. A rate replaces the default sample rate of
+	// the traces of its origin, the origins not listed use the default one.
+	// The transactions matched by the TransactionSettings with a SampleRate
+	// are not affected.
+	OriginSampleRates map[string]int `yaml:"OriginSampleRates,omitempty" env:"APPOPTICS_ORIGIN_SAMPLE_RATES"`
+
+	// The time window in seconds of the unsampled traces captured in memory,
+	// which are sent retroactively once the local sample rate is raised or an
+	// error is reported, e.g., to get the first moments of an incident. The
+	// unsampled trace reporting the error is sent as well if it's kept by
+	// KeepErrors. The memory used is bounded. It's disabled if it's 0.
+	RetroactiveCaptureWindow int `yaml:"RetroactiveCaptureWindow,omitempty" env:"APPOPTICS_RETROACTIVE_CAPTURE_WINDOW"`
+
+	// The validation strictness of the inbound headers carrying the trace
+	// context, e.g., X-Trace: strict or lenient. It's lenient if it's empty.
+	HeaderValidation string `yaml:"HeaderValidation,omitempty" env:"APPOPTICS_HEADER_VALIDATION"`
+
+	// The HTTP status codes, or the ranges of them, e.g., 404 or 400-499, which
+	// mark the HTTP and gRPC server spans as errors. It affects the error
+	// metrics and the traces kept by KeepErrors. It's 500-599 if it's empty.
+	ErrorStatusCodes []string `yaml:"ErrorStatusCodes,omitempty" env:"APPOPTICS_ERROR_STATUS_CODES"`
+	// the error status codes parsed by validate
+	errorStatusRanges []statusCodeRange
+
+	// The layout of the timestamps printed by the pretty reporter, in the
+	// format of the Go time package, e.g., 2006-01-02 15:04:05.000. It's
+	// RFC3339 with nanoseconds if it's empty. The events sent to the collector
+	// are not affected.
+	PrettyTimeFormat string `yaml:"PrettyTimeFormat,omitempty" env:"APPOPTICS_PRETTY_TIME_FORMAT"`
+
+	// The time zone of the timestamps printed by the pretty reporter, as an
+	// IANA name, e.g., UTC or America/New_York. It's the local time zone if
+	// it's empty.
+	PrettyTimeZone string `yaml:"PrettyTimeZone,omitempty" env:"APPOPTICS_PRETTY_TIME_ZONE"`
+
+	// The version of the service, e.g., 1.2.3 or a commit hash, which is
+	// reported as the service.version resource attribute.
+	ServiceVersion string `yaml:"ServiceVersion,omitempty" env:"APPOPTICS_SERVICE_VERSION"`
+
+	// The KVs auto-collected by the HTTP instrumentation which are not added
+	// to the HTTP spans, e.g., URL or Query-String. The keys are matched case
+	// insensitively. A disabled Status is still used for the metrics.
+	DisabledHTTPKVs []string `yaml:"DisabledHTTPKVs,omitempty" env:"APPOPTICS_DISABLED_HTTP_KVS"`
+
+	// The maximum total size in bytes of the keys and values of the baggage
+	// items propagated downstream, including the key prefixes added by the
+	// propagation format, if any, e.g., ot-baggage-. The largest items are
+	// dropped first. It's 8192 if it's 0.
+	BaggageMaxBytes int `yaml:"BaggageMaxBytes,omitempty" env:"APPOPTICS_BAGGAGE_MAX_BYTES"`
+
+	// The maximum number of the baggage items propagated downstream. It's 180
+	// if it's 0.
+	BaggageMaxItems int `yaml:"BaggageMaxItems,omitempty" env:"APPOPTICS_BAGGAGE_MAX_ITEMS"`
+
+	// Whether the root spans report the cold_start KV, which is true for the
+	// first trace started by the process and false for the others, e.g., to
+	// tell the cold starts of the serverless functions.
+	ReportColdStart bool `yaml:"ReportColdStart,omitempty" env:"APPOPTICS_REPORT_COLD_START"`
+
+	// Whether the identical errors, by the error class and message, reported
+	// within a trace are collapsed into a single error event with their count
+	// as the ErrorCount KV, e.g., the errors of a retry loop.
+	DedupErrors bool `yaml:"DedupErrors,omitempty" env:"APPOPTICS_DEDUP_ERRORS"`
+
+	// Whether the config file is watched and reloaded when it's changed, e.g.,
+	// to change the sampling settings without a restart.
+	WatchConfigFile bool `yaml:"WatchConfigFile,omitempty" env:"APPOPTICS_WATCH_CONFIG_FILE"`
+
+	// The HTTP(S) endpoint of a remote config source, which is polled for the
+	// sample rate, tracing mode and transaction filters. They override the
+	// local ones until the config fetched expires.
+	RemoteConfigURL string `yaml:"RemoteConfigURL,omitempty" env:"APPOPTICS_REMOTE_CONFIG_URL"`
+
+	// The interval in seconds of polling the remote config source. The default
+	// is used if it's not positive.
+	RemoteConfigInterval int `yaml:"RemoteConfigInterval,omitempty" env:"APPOPTICS_REMOTE_CONFIG_INTERVAL"`
+
+	// The time in seconds the config fetched is applied for if it can't be
+	// refreshed, e.g., the endpoint is unreachable, after which the local
+	// config is restored. The default is used if it's not positive.
+	RemoteConfigTTL int `yaml:"RemoteConfigTTL,omitempty" env:"APPOPTICS_REMOTE_CONFIG_TTL"`
+
+	// The header sent to the remote config source for authentication, in the
+	// form of "Name: value", e.g., "Authorization: Bearer <token>". Its value is
+	// always masked.
+	RemoteConfigHeader string `yaml:"RemoteConfigHeader,omitempty" env:"APPOPTICS_REMOTE_CONFIG_HEADER"`
+
+	// Whether the X-Request-ID header of the inbound HTTP requests is read, or
+	// generated if it's absent, reported as a KV and propagated to the outbound
+	// HTTP requests.
+	RequestID bool `yaml:"RequestID" env:"APPOPTICS_REQUEST_ID" default:"true"`
 }
 
 // SamplingConfig defines the configuration options for the sampling decision
@@ -150,6 +585,27 @@ type TransactionFilter struct {
 	RegEx      string      `yaml:"RegEx,omitempty"`
 	Extensions []string    `yaml:"Extensions,omitempty"`
 	Tracing    TracingMode `yaml:"Tracing"`
+	// SampleRate overrides the global sample rate for the transactions matched,
+	// which is optional and can't be set with the disabled tracing mode. The
+	// SamplingWindows and OriginSampleRates don't apply to the transactions
+	// matched if it's set.
+	SampleRate *int `yaml:"SampleRate,omitempty"`
+}
+
+// matches checks if the name is matched by the filter's regular expression or
+// extensions. An invalid regular expression matches nothing.
+func (f *TransactionFilter) matches(name string) bool {
+	if f.RegEx != "" {
+		ok, err := regexp.MatchString(f.RegEx, name)
+		return err == nil && ok
+	}
+	ext := strings.TrimLeft(filepath.Ext(name), ".")
+	for _, e := range f.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
 }
 
 // TransactionFilter unmarshal errors
@@ -157,6 +613,11 @@ var (
 	ErrTFInvalidType     = errors.New("invalid Type")
 	ErrTFInvalidTracing  = errors.New("invalid Tracing")
 	ErrTFInvalidRegExExt = errors.New("must set either RegEx or Extensions, but not both")
+	// ErrTFInvalidSampleRate is returned if the SampleRate is out of range
+	ErrTFInvalidSampleRate = errors.New("invalid SampleRate")
+	// ErrTFDisabledSampleRate is returned if the SampleRate is set with the
+	// disabled tracing mode, which contradicts each other.
+	ErrTFDisabledSampleRate = errors.New("SampleRate can't be set with the disabled Tracing")
 )
 
 // UnmarshalYAML is the customized unmarshal method for TransactionFilter
@@ -167,25 +628,45 @@ func (f *TransactionFilter) UnmarshalYAML(unmarshal func(interface{}) error) err
 		RegEx      string      `yaml:"RegEx,omitempty"`
 		Extensions []string    `yaml:"Extensions,omitempty"`
 		Tracing    TracingMode `yaml:"Tracing"`
+		SampleRate *int        `yaml:"SampleRate,omitempty"`
 	}{}
 
 	if err := unmarshal(&aux); err != nil {
 		return errors.Wrap(err, "failed to unmarshal TransactionFilter")
 	}
+	return f.assign(TransactionFilter(aux))
+}
+
+// assign validates the filter unmarshaled from the config file and assigns it
+// to f if it's valid.
+func (f *TransactionFilter) assign(aux TransactionFilter) error {
 	if aux.Type != URL {
 		return ErrTFInvalidType
 	}
+	// the transactions are traced at the sample rate, if it's the only one set
+	if aux.Tracing == "" && aux.SampleRate != nil {
+		aux.Tracing = EnabledTracingMode
+	}
 	if aux.Tracing != EnabledTracingMode && aux.Tracing != DisabledTracingMode {
 		return ErrTFInvalidTracing
 	}
 	if (aux.RegEx == "") == (aux.Extensions == nil) {
 		return ErrTFInvalidRegExExt
 	}
+	if aux.SampleRate != nil {
+		if !IsValidSampleRate(*aux.SampleRate) {
+			return ErrTFInvalidSampleRate
+		}
+		if aux.Tracing == DisabledTracingMode {
+			return ErrTFDisabledSampleRate
+		}
+	}
 
 	f.Type = aux.Type
 	f.RegEx = aux.RegEx
 	f.Extensions = aux.Extensions
 	f.Tracing = aux.Tracing
+	f.SampleRate = aux.SampleRate
 	return nil
 }
 
@@ -201,20 +682,31 @@ func (s *SamplingConfig) UnmarshalYAML(unmarshal func(interface{}) error) error
 		TracingMode TracingMode `yaml:"TracingMode"`
 		SampleRate  int         `yaml:"SampleRate"`
 	}{
-		TracingMode: "Invalid",
-		SampleRate:  -1,
+		TracingMode: unsetTracingMode,
+		SampleRate:  unsetSampleRate,
 	}
 	if err := unmarshal(&aux); err != nil {
 		return errors.Wrap(err, "failed to unmarshal SamplingConfig")
 	}
+	s.assign(aux.TracingMode, aux.SampleRate)
+	return nil
+}
 
-	if aux.TracingMode != "Invalid" {
-		s.SetTracingMode(aux.TracingMode)
+// the values of the sampling config items not set in the config file
+const (
+	unsetTracingMode TracingMode = "Invalid"
+	unsetSampleRate              = -1
+)
+
+// assign sets the tracing mode and the sample rate unmarshaled from the config
+// file, unless they're not set in the file.
+func (s *SamplingConfig) assign(mode TracingMode, rate int) {
+	if mode != unsetTracingMode {
+		s.SetTracingMode(mode)
 	}
-	if aux.SampleRate != -1 {
-		s.SetSampleRate(aux.SampleRate)
+	if rate != unsetSampleRate {
+		s.SetSampleRate(rate)
 	}
-	return nil
 }
 
 // ResetTracingMode resets the tracing mode to its default value and clear the flag.
@@ -256,6 +748,35 @@ func (s *SamplingConfig) SetSampleRate(rate int) {
 	s.sampleRateConfigured = true
 }
 
+// validateReporterCollector warns of the collector settings which don't match
+// the reporter type, as the collector of the other reporter type is ignored,
+// which is easily overlooked.
+func (c *Config) validateReporterCollector() {
+	switch c.ReporterType {
+	case "udp":
+		if c.CollectorUDP != "" {
+			return
+		}
+		if c.Collector != defaultSSLCollector {
+			log.Warningf("The udp reporter is used but only the ssl collector is set (%s: %q), "+
+				"which is ignored. Set %s for the udp reporter or %s=ssl. Falling back to %s.",
+				envAppOpticsCollector, c.Collector, envAppOpticsCollectorUDP, envAppOpticsReporter,
+				defaultUDPCollector)
+		} else {
+			log.Warningf("The udp reporter is used but %s is not set. Falling back to %s.",
+				envAppOpticsCollectorUDP, defaultUDPCollector)
+		}
+		c.CollectorUDP = defaultUDPCollector
+	case "ssl":
+		if c.CollectorUDP != "" && c.Collector == defaultSSLCollector {
+			log.Warningf("The ssl reporter is used but only the udp collector is set (%s: %q), "+
+				"which is ignored. Set %s for the ssl reporter or %s=udp. Falling back to %s.",
+				envAppOpticsCollectorUDP, c.CollectorUDP, envAppOpticsCollector, envAppOpticsReporter,
+				defaultSSLCollector)
+		}
+	}
+}
+
 // Get the value of the `default` tag of a field in the struct.
 func getFieldDefaultValue(i interface{}, fieldName string) string {
 	iv := reflect.Indirect(reflect.ValueOf(i))
@@ -289,6 +810,31 @@ func WithServiceKey(key string) Option {
 	}
 }
 
+// WithFailClosed defines a Config option for the fail mode on initialization
+// errors.
+func WithFailClosed(failClosed bool) Option {
+	return func(c *Config) {
+		c.FailClosed = failClosed
+	}
+}
+
+// WithSamplingWindows defines a Config option for the sampling windows and
+// their timezone.
+func WithSamplingWindows(timezone string, windows ...SamplingWindow) Option {
+	return func(c *Config) {
+		c.SamplingTimezone = timezone
+		c.SamplingWindows = windows
+	}
+}
+
+// WithOriginSampleRates defines a Config option for the sample rates of the
+// new traces by their origin.
+func WithOriginSampleRates(rates map[string]int) Option {
+	return func(c *Config) {
+		c.OriginSampleRates = rates
+	}
+}
+
 // NewConfig initializes a Config object and override default values with options
 // provided as arguments. It may print errors if there are invalid values in the
 // configuration file or the environment variables.
@@ -299,7 +845,10 @@ func NewConfig(opts ...Option) *Config {
 	c := newConfig()
 	if err := c.Load(opts...); err != nil {
 		log.Error(errors.Wrap(err, "Failed to initialize configuration"))
+		// keep the fail mode to surface the error
+		failClosed := c.FailClosed
 		c.reset()
+		c.FailClosed = failClosed
 	}
 	return c
 }
@@ -321,11 +870,26 @@ func (c *Config) validate() error {
 		c.TrustedPath = getFieldDefaultValue(c, "TrustedPath")
 	}
 
+	// the conflict is returned after all the items are validated, so the
+	// config is still usable in the fail-open mode
+	var certErr error
+	if c.TrustedCert = strings.TrimSpace(c.TrustedCert); c.TrustedCert != "" {
+		if ok := IsValidPEM(c.TrustedCert); !ok {
+			log.Warning(InvalidEnv("TrustedCert", c.TrustedCert))
+			c.TrustedCert = getFieldDefaultValue(c, "TrustedCert")
+		} else if c.TrustedPath != "" {
+			log.Warningf("%v, the TrustedCert is used.", ErrConflictingCert)
+			c.TrustedPath = getFieldDefaultValue(c, "TrustedPath")
+			certErr = ErrConflictingCert
+		}
+	}
+
 	c.ReporterType = strings.ToLower(strings.TrimSpace(c.ReporterType))
 	if ok := IsValidReporterType(c.ReporterType); !ok {
 		log.Warning(InvalidEnv("ReporterType", c.ReporterType))
 		c.ReporterType = getFieldDefaultValue(c, "ReporterType")
 	}
+	c.validateReporterCollector()
 
 	c.Sampling.validate()
 
@@ -339,7 +903,144 @@ func (c *Config) validate() error {
 		c.DebugLevel = getFieldDefaultValue(c, "DebugLevel")
 	}
 
-	return c.ReporterProperties.validate()
+	if c.ApdexThreshold < 0 {
+		log.Warning(InvalidEnv("ApdexThreshold", strconv.Itoa(c.ApdexThreshold)))
+		c.ApdexThreshold = 0
+	}
+
+	c.SamplingWindows, c.samplingClocks = validSamplingWindows(c.SamplingWindows)
+	if _, err := loadLocation(c.SamplingTimezone); err != nil {
+		log.Warning(InvalidEnv("SamplingTimezone", c.SamplingTimezone))
+		c.SamplingTimezone = getFieldDefaultValue(c, "SamplingTimezone")
+	}
+
+	if c.SpanDepthDecay <= 0 || c.SpanDepthDecay > 1 {
+		log.Warning(InvalidEnv("SpanDepthDecay", strconv.FormatFloat(c.SpanDepthDecay, 'f', -1, 64)))
+		c.SpanDepthDecay = 1
+	}
+
+	if c.UDPSendBuffer < 0 {
+		log.Warning(InvalidEnv("UDPSendBuffer", strconv.Itoa(c.UDPSendBuffer)))
+		c.UDPSendBuffer = 0
+	}
+
+	if c.MinSpanDuration < 0 {
+		log.Warning(InvalidEnv("MinSpanDuration", strconv.Itoa(c.MinSpanDuration)))
+		c.MinSpanDuration = 0
+	}
+
+	if c.BaggageMaxBytes < 0 {
+		log.Warning(InvalidEnv("BaggageMaxBytes", strconv.Itoa(c.BaggageMaxBytes)))
+		c.BaggageMaxBytes = 0
+	}
+
+	if c.BaggageMaxItems < 0 {
+		log.Warning(InvalidEnv("BaggageMaxItems", strconv.Itoa(c.BaggageMaxItems)))
+		c.BaggageMaxItems = 0
+	}
+
+	if c.RetroactiveCaptureWindow < 0 {
+		log.Warning(InvalidEnv("RetroactiveCaptureWindow", strconv.Itoa(c.RetroactiveCaptureWindow)))
+		c.RetroactiveCaptureWindow = 0
+	}
+
+	c.RequestHeaders = ToHeaderNames(c.RequestHeaders)
+	c.ResponseHeaders = ToHeaderNames(c.ResponseHeaders)
+	c.HistogramBuckets = ToHistogramBuckets(c.HistogramBuckets)
+	c.SQLSampleRates = validSQLSampleRates(c.SQLSampleRates)
+	c.OriginSampleRates = validOriginSampleRates(c.OriginSampleRates)
+	c.ErrorStatusCodes, c.errorStatusRanges = validStatusCodeRanges(c.ErrorStatusCodes)
+	c.MetricTags = validMetricTags(c.MetricTags)
+	c.TenantServiceKeys = validTenantServiceKeys(c.TenantServiceKeys)
+	c.BlockedSpans = validBlockedSpans(c.BlockedSpans)
+	c.DisabledHTTPKVs = validHTTPKVs(c.DisabledHTTPKVs)
+	c.SecretFields = validSecretFields(c.SecretFields)
+	c.TrustedProxies, c.trustedProxyNets = validTrustedProxies(c.TrustedProxies)
+	if _, err := compileRegex(c.RedactedKeyRegex); err != nil {
+		log.Warning(InvalidEnv("RedactedKeyRegex", c.RedactedKeyRegex))
+		c.RedactedKeyRegex = ""
+	}
+
+	if c.StatsDAddr != "" {
+		if _, _, err := net.SplitHostPort(c.StatsDAddr); err != nil {
+			log.Warning(InvalidEnv("StatsDAddr", c.StatsDAddr))
+			c.StatsDAddr = ""
+		}
+	}
+	c.StatsDTagFormat = strings.ToLower(strings.TrimSpace(c.StatsDTagFormat))
+	switch c.StatsDTagFormat {
+	case "", StatsDTagsDogStatsD, StatsDTagsInfluxDB, StatsDTagsNone:
+	default:
+		log.Warning(InvalidEnv("StatsDTagFormat", c.StatsDTagFormat))
+		c.StatsDTagFormat = ""
+	}
+	if c.StatsDOnly && c.StatsDAddr == "" {
+		log.Warning("StatsDOnly is ignored as StatsDAddr is not set.")
+		c.StatsDOnly = false
+	}
+	c.MetricsNamespace = strings.TrimSpace(c.MetricsNamespace)
+	if c.MetricsNamespace != "" && !IsValidMetricsNamespace(c.MetricsNamespace) {
+		log.Warning(InvalidEnv("MetricsNamespace", c.MetricsNamespace))
+		c.MetricsNamespace = ""
+	}
+
+	if c.MaxTimestampSkew < 0 {
+		log.Warning(InvalidEnv("MaxTimestampSkew", strconv.Itoa(c.MaxTimestampSkew)))
+		c.MaxTimestampSkew = 0
+	}
+	c.SkewedEvents = strings.ToLower(strings.TrimSpace(c.SkewedEvents))
+	switch c.SkewedEvents {
+	case "", SkewedEventsReject, SkewedEventsClamp:
+	default:
+		log.Warning(InvalidEnv("SkewedEvents", c.SkewedEvents))
+		c.SkewedEvents = ""
+	}
+
+	c.GRPCTransactionNaming = strings.ToLower(strings.TrimSpace(c.GRPCTransactionNaming))
+	switch c.GRPCTransactionNaming {
+	case "", GRPCTransactionNamingService, GRPCTransactionNamingMethod:
+	default:
+		log.Warning(InvalidEnv("GRPCTransactionNaming", c.GRPCTransactionNaming))
+		c.GRPCTransactionNaming = ""
+	}
+
+	c.HeaderValidation = strings.ToLower(strings.TrimSpace(c.HeaderValidation))
+	switch c.HeaderValidation {
+	case "", HeaderValidationStrict, HeaderValidationLenient:
+	default:
+		log.Warning(InvalidEnv("HeaderValidation", c.HeaderValidation))
+		c.HeaderValidation = ""
+	}
+
+	c.DurationPrecision = strings.ToLower(strings.TrimSpace(c.DurationPrecision))
+	switch c.DurationPrecision {
+	case "", DurationPrecisionNanosecond, DurationPrecisionMicrosecond, DurationPrecisionMillisecond:
+	default:
+		log.Warning(InvalidEnv("DurationPrecision", c.DurationPrecision))
+		c.DurationPrecision = ""
+	}
+
+	c.PrettyTimeZone = strings.TrimSpace(c.PrettyTimeZone)
+	if _, err := time.LoadLocation(c.PrettyTimeZone); err != nil {
+		log.Warning(InvalidEnv("PrettyTimeZone", c.PrettyTimeZone))
+		c.PrettyTimeZone = ""
+	}
+
+	c.RemoteConfigURL = strings.TrimSpace(c.RemoteConfigURL)
+	if c.RemoteConfigURL != "" && !IsValidHTTPURL(c.RemoteConfigURL) {
+		log.Warning(InvalidEnv("RemoteConfigURL", c.RemoteConfigURL))
+		c.RemoteConfigURL = ""
+	}
+	c.RemoteConfigHeader = strings.TrimSpace(c.RemoteConfigHeader)
+	if c.RemoteConfigHeader != "" && !strings.Contains(c.RemoteConfigHeader, ":") {
+		log.Warning(InvalidEnv("RemoteConfigHeader", maskedValue))
+		c.RemoteConfigHeader = ""
+	}
+
+	if err := c.ReporterProperties.validate(); err != nil {
+		return err
+	}
+	return certErr
 }
 
 // Load reads configuration from config file and environment variables.
@@ -349,7 +1050,14 @@ func (c *Config) Load(opts ...Option) error {
 
 	c.reset()
 
+	c.loadErr = c.load(opts...)
+	return c.loadErr
+}
+
+func (c *Config) load(opts ...Option) error {
 	if err := c.loadConfigFile(); err != nil {
+		// the fail mode may still be set by the env variables or options
+		c.loadFailClosed(opts...)
 		return errors.Wrap(err, "Load")
 	}
 	c.loadEnvs()
@@ -366,9 +1074,102 @@ func (c *Config) Load(opts ...Option) error {
 	return nil
 }
 
+// loadFailClosed loads the fail mode from the env variable and options, which
+// is used when the config file is invalid.
+func (c *Config) loadFailClosed(opts ...Option) {
+	if v, err := toBool(os.Getenv(envAppOpticsFailClosed)); err == nil {
+		c.FailClosed = v
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+}
+
 func (c *Config) printDelta() {
 	base := newConfig().reset()
-	log.Warningf("Accepted config items: \n%s", getDelta(base, c, "").sanitize())
+	log.Warningf("Accepted config items: \n%s", getDelta(base, c, "").sanitize(c.SecretFields))
+}
+
+// GetConfigHash returns the hex encoded SHA-256 hash of the effective config,
+// which consists of the items changed from the defaults, with the service key
+// masked. The processes started with the same config have the same hash.
+func (c *Config) GetConfigHash() string {
+	c.RLock()
+	defer c.RUnlock()
+	delta := getDelta(newConfig().reset(), c, "").sanitize(c.SecretFields)
+	sum := sha256.Sum256([]byte(delta.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// Reload reloads the configuration from the config file and environment
+// variables at runtime, and returns the config items changed by the reload, in
+// which the default values are the values before reloading. The configuration
+// is loaded and validated aside, and replaces the current one only if it's
+// valid, so the readers of the config never see a partially loaded one, and
+// the current one is kept if the reload fails.
+func (c *Config) Reload(opts ...Option) (Delta, error) {
+	loaded := newConfig().reset()
+	if err := loaded.load(opts...); err != nil {
+		return Delta{}, err
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	before := getDelta(newConfig().reset(), c, "")
+	c.replace(loaded)
+	c.loadErr = nil
+	after := getDelta(newConfig().reset(), c, "")
+
+	return *diffDelta(before, after).sanitize(c.SecretFields), nil
+}
+
+// replace replaces the config items with those of the other config, which is
+// not shared. The caller must hold the lock.
+func (c *Config) replace(other *Config) {
+	cVal := reflect.ValueOf(c).Elem()
+	otherVal := reflect.ValueOf(other).Elem()
+	for i := 0; i < cVal.NumField(); i++ {
+		if field := cVal.Field(i); !cVal.Type().Field(i).Anonymous && field.CanSet() {
+			field.Set(otherVal.Field(i))
+		}
+	}
+	c.samplingClocks = other.samplingClocks
+	c.trustedProxyNets = other.trustedProxyNets
+	c.errorStatusRanges = other.errorStatusRanges
+}
+
+// diffDelta returns the items changed from one delta to another, both of which
+// are against the default config.
+func diffDelta(before, after *Delta) *Delta {
+	old := make(map[string]DeltaItem)
+	for _, item := range before.items() {
+		old[item.key] = item
+	}
+
+	delta := &Delta{}
+	for _, item := range after.items() {
+		prev, ok := old[item.key]
+		delete(old, item.key)
+		if !ok {
+			delta.add(item)
+		} else if prev.value != item.value {
+			item.defaultVal = prev.value
+			delta.add(item)
+		}
+	}
+	// the items changed back to the default values
+	for _, item := range before.items() {
+		if _, ok := old[item.key]; ok {
+			delta.add(DeltaItem{
+				key:        item.key,
+				env:        item.env,
+				value:      item.defaultVal,
+				defaultVal: item.value,
+			})
+		}
+	}
+	return delta
 }
 
 // DeltaItem defines a delta item  of two Config objects
@@ -392,17 +1193,40 @@ func (d *Delta) items() []DeltaItem {
 	return d.delta
 }
 
-func (d *Delta) sanitize() *Delta {
+// sanitize masks the values of the service key and the secret fields.
+func (d *Delta) sanitize(secretFields []string) *Delta {
 	for idx := range d.delta {
 		// mask the sensitive service key
 		if d.delta[idx].key == "ServiceKey" {
 			d.delta[idx].value = MaskServiceKey(d.delta[idx].value)
+		} else if d.delta[idx].key == "RemoteConfigHeader" ||
+			isSecretField(d.delta[idx].key, secretFields) {
+			d.delta[idx].value = maskedValue
 		}
 	}
 	return d
 }
 
-func (d *Delta) String() string {
+// Keys returns the keys of the changed config items, e.g., Sampling.SampleRate
+func (d Delta) Keys() []string {
+	var keys []string
+	for _, item := range d.delta {
+		keys = append(keys, item.key)
+	}
+	return keys
+}
+
+// Changed checks if the config item of the key is changed.
+func (d Delta) Changed(key string) bool {
+	for _, item := range d.delta {
+		if item.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func (d Delta) String() string {
 	var s []string
 	for _, item := range d.delta {
 		s = append(s, fmt.Sprintf(" - %s (%s) = %s (default: %s)",
@@ -454,8 +1278,8 @@ func getDelta(base, changed interface{}, keyPrefix string) *Delta {
 				kv := DeltaItem{
 					key:        keyName,
 					env:        typeFieldChanged.Tag.Get("env"),
-					value:      fmt.Sprintf("%+v", fieldChanged.Interface()),
-					defaultVal: fmt.Sprintf("%+v", fieldBase.Interface()),
+					value:      formatValue(fieldChanged),
+					defaultVal: formatValue(fieldBase),
 				}
 				delta.add(kv)
 			}
@@ -464,6 +1288,22 @@ func getDelta(base, changed interface{}, keyPrefix string) *Delta {
 	return delta
 }
 
+// formatValue formats the value of a config item in the way of %+v, except that
+// the keys of a map are always sorted, so the delta and the config hash are
+// stable. fmt only sorts the keys since Go 1.12. A map which formats itself,
+// e.g., TenantServiceKeys which masks the keys, is formatted as is.
+func formatValue(v reflect.Value) string {
+	if _, ok := v.Interface().(fmt.Stringer); ok || v.Kind() != reflect.Map {
+		return fmt.Sprintf("%+v", v.Interface())
+	}
+	entries := make([]string, 0, v.Len())
+	for _, k := range v.MapKeys() {
+		entries = append(entries, fmt.Sprintf("%+v:%+v", k.Interface(), v.MapIndex(k).Interface()))
+	}
+	sort.Strings(entries)
+	return "map[" + strings.Join(entries, " ") + "]"
+}
+
 func newConfig() *Config {
 	return &Config{
 		Sampling:           &SamplingConfig{},
@@ -627,6 +1467,17 @@ func (c *Config) loadYaml(path string) error {
 	if err != nil {
 		return errors.Wrap(err, fmt.Sprintf("loadYaml: %s", path))
 	}
+	var items map[string]interface{}
+	if err = yaml.Unmarshal(data, &items); err == nil {
+		keys := make([]string, 0, len(items))
+		for k := range items {
+			keys = append(keys, k)
+		}
+		warnUnknownItems(path, keys)
+	}
+	if err = c.loadProfile(data); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("loadYaml: %s", path))
+	}
 
 	if c.Sampling == nil {
 		c.Sampling = origSampling
@@ -638,6 +1489,37 @@ func (c *Config) loadYaml(path string) error {
 	return nil
 }
 
+// configProfiles is the Profiles section of the config file, which contains
+// the config items of each profile, e.g., prod or staging.
+type configProfiles struct {
+	Profiles map[string]yaml.MapSlice `yaml:"Profiles"`
+}
+
+// loadProfile merges the config items of the profile selected by the env
+// variable APPOPTICS_PROFILE over the ones at the top level of the config
+// file. The profiles are ignored if no profile is selected.
+func (c *Config) loadProfile(data []byte) error {
+	name := strings.TrimSpace(os.Getenv(EnvAppOpticsProfile))
+	if name == "" {
+		return nil
+	}
+	var cp configProfiles
+	if err := yaml.Unmarshal(data, &cp); err != nil {
+		return err
+	}
+	profile, ok := cp.Profiles[name]
+	if !ok {
+		return errors.Wrap(ErrUnknownProfile, name)
+	}
+	log.Warningf("Loading config profile: %s", name)
+
+	out, err := yaml.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(out, &c)
+}
+
 func (c *Config) checkFileSize(path string) error {
 	file, err := os.Stat(path)
 	if err != nil {
@@ -650,6 +1532,18 @@ func (c *Config) checkFileSize(path string) error {
 	return nil
 }
 
+// GetConfigFile returns the absolute path of the config file, or an empty
+// string if there is no config file.
+func GetConfigFile() string {
+	return (&Config{}).getConfigPath()
+}
+
+// CheckConfigFile loads the config file into a new config object to check if
+// it's valid, without changing the current configuration.
+func CheckConfigFile() error {
+	return newConfig().reset().loadConfigFile()
+}
+
 // loadConfigFile loads configuration from the config file.
 func (c *Config) loadConfigFile() error {
 	path := c.getConfigPath()
@@ -667,6 +1561,9 @@ func (c *Config) loadConfigFile() error {
 	case ".yml", ".yaml":
 		log.Warningf("Loading config file: %s", path)
 		return c.loadYaml(path)
+	case ".json":
+		log.Warningf("Loading config file: %s", path)
+		return c.loadJSON(path)
 	default:
 		return errors.Wrap(ErrUnsupportedFormat, path)
 	}
@@ -686,6 +1583,13 @@ func (c *Config) GetServiceKey() string {
 	return c.ServiceKey
 }
 
+// GetTrustedCert returns the PEM encoded cert
+func (c *Config) GetTrustedCert() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.TrustedCert
+}
+
 // GetTrustedPath returns the file path of the cert file
 func (c *Config) GetTrustedPath() string {
 	c.RLock()
@@ -721,6 +1625,39 @@ func (c *Config) GetSampleRate() int {
 	return c.Sampling.SampleRate
 }
 
+// SetSampleRate changes the local sample rate at runtime, until the
+// configuration is reloaded
+func (c *Config) SetSampleRate(rate int) error {
+	if !IsValidSampleRate(rate) {
+		return errors.Errorf("invalid sample rate: %d", rate)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.Sampling.SetSampleRate(rate)
+	return nil
+}
+
+// SetTracingMode changes the local tracing mode at runtime, until the
+// configuration is reloaded
+func (c *Config) SetTracingMode(mode TracingMode) error {
+	mode = NormalizeTracingMode(TracingMode(strings.ToLower(strings.TrimSpace(string(mode)))))
+	if !IsValidTracingMode(mode) {
+		return errors.Errorf("invalid tracing mode: %s", mode)
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.Sampling.SetTracingMode(mode)
+	return nil
+}
+
+// SetSampling replaces the local sampling config at runtime, e.g., to restore a
+// copy returned by GetSampling, until the configuration is reloaded
+func (c *Config) SetSampling(s SamplingConfig) {
+	c.Lock()
+	defer c.Unlock()
+	*c.Sampling = s
+}
+
 // SamplingConfigured returns if tracing mode or sampling rate is configured
 func (c *Config) SamplingConfigured() bool {
 	c.RLock()
@@ -728,6 +1665,14 @@ func (c *Config) SamplingConfigured() bool {
 	return c.Sampling.Configured()
 }
 
+// GetSampling returns a copy of the local sampling config, which is consistent
+// even if the config is being changed or reloaded concurrently.
+func (c *Config) GetSampling() SamplingConfig {
+	c.RLock()
+	defer c.RUnlock()
+	return *c.Sampling
+}
+
 // GetPrependDomain returns the prepend domain config
 func (c *Config) GetPrependDomain() bool {
 	c.RLock()
@@ -784,3 +1729,590 @@ func (c *Config) GetTransactionFiltering() []TransactionFilter {
 	defer c.RUnlock()
 	return c.TransactionSettings
 }
+
+// TransactionSamplingConfigured returns if the transaction name is covered by
+// an explicit transaction filter, rather than falling back to the global
+// tracing mode and sample rate.
+func (c *Config) TransactionSamplingConfigured(name string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	for _, f := range c.TransactionSettings {
+		if f.matches(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetSamplingWindows returns the sampling windows config
+func (c *Config) GetSamplingWindows() []SamplingWindow {
+	c.RLock()
+	defer c.RUnlock()
+	return c.SamplingWindows
+}
+
+// GetSamplingWindowRate returns the sample rate of the first sampling window
+// which contains the time provided, in the timezone of the sampling windows.
+// It returns false if none of them contains the time.
+func (c *Config) GetSamplingWindowRate(t time.Time) (int, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	if len(c.samplingClocks) == 0 {
+		return 0, false
+	}
+	if loc, err := loadLocation(c.SamplingTimezone); err == nil {
+		t = t.In(loc)
+	}
+	for _, w := range c.samplingClocks {
+		if w.contains(t) {
+			return w.rate, true
+		}
+	}
+	return 0, false
+}
+
+// GetSamplingLocation returns the location of the sampling windows
+func (c *Config) GetSamplingLocation() *time.Location {
+	c.RLock()
+	defer c.RUnlock()
+	loc, err := loadLocation(c.SamplingTimezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// GetFailClosed returns if the agent fails closed on initialization errors
+func (c *Config) GetFailClosed() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.FailClosed
+}
+
+// GetLazyStart returns if the reporter is started on the first trace
+func (c *Config) GetLazyStart() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.LazyStart
+}
+
+// GetKeepErrors returns if the unsampled traces with errors are kept
+func (c *Config) GetKeepErrors() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.KeepErrors
+}
+
+// GetKeepErrorsRate returns the maximum number of the traces kept per second
+// for each error class, or 0 if it's not limited
+func (c *Config) GetKeepErrorsRate() float64 {
+	c.RLock()
+	defer c.RUnlock()
+	if c.KeepErrorsRate <= 0 {
+		return 0
+	}
+	return c.KeepErrorsRate
+}
+
+// GetAdaptiveSampling returns if the latency outliers are kept
+func (c *Config) GetAdaptiveSampling() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.AdaptiveSampling
+}
+
+// GetAdaptiveSamplingBudget returns the maximum number of outlier traces kept
+// per second
+func (c *Config) GetAdaptiveSamplingBudget() float64 {
+	c.RLock()
+	defer c.RUnlock()
+	if c.AdaptiveSamplingBudget <= 0 {
+		return DefaultAdaptiveSamplingBudget
+	}
+	return c.AdaptiveSamplingBudget
+}
+
+// GetLoadError returns the error of the last loading of the configuration. The
+// global config is created by the package initialization and NewConfig falls
+// back to the default values on errors, so the error is kept for ao.InitError.
+func (c *Config) GetLoadError() error {
+	c.RLock()
+	defer c.RUnlock()
+	return c.loadErr
+}
+
+// GetSpanDepthDecay returns the decay factor of the span reporting probability
+// per level of depth
+func (c *Config) GetSpanDepthDecay() float64 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.SpanDepthDecay
+}
+
+// GetRuntimeKVs returns if the Go version and GOMAXPROCS are reported by the
+// root spans
+func (c *Config) GetRuntimeKVs() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.RuntimeKVs
+}
+
+// GetQueueTimeMetric returns if the queue time is aggregated into a metric
+func (c *Config) GetQueueTimeMetric() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.QueueTimeMetric
+}
+
+// GetRequestHeaders returns the names of the request headers to be captured
+func (c *Config) GetRequestHeaders() []string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.RequestHeaders
+}
+
+// GetResponseHeaders returns the names of the response headers to be captured
+func (c *Config) GetResponseHeaders() []string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ResponseHeaders
+}
+
+// GetHistogramBuckets returns the upper bounds in seconds of the Prometheus
+// histogram buckets
+func (c *Config) GetHistogramBuckets() []float64 {
+	c.RLock()
+	defer c.RUnlock()
+	if len(c.HistogramBuckets) == 0 {
+		return DefaultHistogramBuckets
+	}
+	return c.HistogramBuckets
+}
+
+// GetSQLSampleRates returns the sample rates of the query spans by the SQL
+// statement type. The map returned must not be modified.
+func (c *Config) GetSQLSampleRates() map[string]int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.SQLSampleRates
+}
+
+// SQLSampleRate returns the sample rate of the SQL statement type in the
+// sample rates returned by GetSQLSampleRates, and false if it's not listed.
+func SQLSampleRate(rates map[string]int, stmtType string) (int, bool) {
+	// the keys are upper-cased by the validation
+	rate, ok := rates[strings.ToUpper(stmtType)]
+	if !ok || !IsValidSampleRate(rate) {
+		return MaxSampleRate, false
+	}
+	return rate, true
+}
+
+// GetMetricTags returns the tags added to all the metrics
+func (c *Config) GetMetricTags() map[string]string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.MetricTags
+}
+
+// IsRedactedKey checks if the value of the KV is redacted by its name
+func (c *Config) IsRedactedKey(key string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	for _, name := range c.RedactedKeys {
+		if strings.EqualFold(name, key) {
+			return true
+		}
+	}
+	if isSecretField(key, c.SecretFields) {
+		return true
+	}
+	if c.RedactedKeyRegex == "" {
+		return false
+	}
+	re, err := compileRegex(c.RedactedKeyRegex)
+	return err == nil && re.MatchString(key)
+}
+
+// GetApdexThreshold returns the Apdex threshold T in milliseconds
+func (c *Config) GetApdexThreshold() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ApdexThreshold
+}
+
+// GetPropagateUnsampled returns if the context of unsampled requests should
+// be propagated to the downstream services.
+func (c *Config) GetPropagateUnsampled() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.PropagateUnsampled
+}
+
+// GetReportDroppedEvents returns if the dropped events should be reported
+func (c *Config) GetReportDroppedEvents() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ReportDroppedEvents
+}
+
+// GetMaxHeaderSize returns the maximum size in bytes of a propagation header
+func (c *Config) GetMaxHeaderSize() int {
+	c.RLock()
+	defer c.RUnlock()
+	if c.MaxHeaderSize <= 0 {
+		return DefaultMaxHeaderSize
+	}
+	return c.MaxHeaderSize
+}
+
+// GetTrustForwardedFor returns if the client IP is taken from the forwarding
+// headers
+func (c *Config) GetTrustForwardedFor() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.TrustForwardedFor
+}
+
+// IsTrustedProxy returns if the IP address is of a trusted proxy
+func (c *Config) IsTrustedProxy(ip net.IP) bool {
+	c.RLock()
+	defer c.RUnlock()
+	for _, n := range c.trustedProxyNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTenantServiceKey returns the service key of the tenant, and false if the
+// tenant is not configured.
+func (c *Config) GetTenantServiceKey(tenant string) (string, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	key, ok := c.TenantServiceKeys[tenant]
+	return key, ok
+}
+
+// GetTenantServiceKeys returns a copy of the service keys of the tenants
+func (c *Config) GetTenantServiceKeys() map[string]string {
+	c.RLock()
+	defer c.RUnlock()
+	keys := make(map[string]string, len(c.TenantServiceKeys))
+	for tenant, key := range c.TenantServiceKeys {
+		keys[tenant] = key
+	}
+	return keys
+}
+
+// GetTrimTrailingSlash returns if the trailing slashes are removed from the
+// transaction names
+func (c *Config) GetTrimTrailingSlash() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.TrimTrailingSlash
+}
+
+// GetLowercaseTransactionName returns if the transaction names are converted
+// to lower case
+func (c *Config) GetLowercaseTransactionName() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.LowercaseTransactionName
+}
+
+// GetWallClockTimestamps returns if the event timestamps are read from the
+// wall clock instead of the monotonic clock
+func (c *Config) GetWallClockTimestamps() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.WallClockTimestamps
+}
+
+// GetMinSpanDuration returns the minimum duration of the child spans reported
+func (c *Config) GetMinSpanDuration() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return time.Duration(c.MinSpanDuration) * time.Microsecond
+}
+
+// GetDisableMetrics returns if the metrics are disabled locally
+func (c *Config) GetDisableMetrics() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.DisableMetrics
+}
+
+// GetIgnoreRemoteFlags returns if the feature flags provided by the collector
+// are ignored
+func (c *Config) GetIgnoreRemoteFlags() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.IgnoreRemoteFlags
+}
+
+// GetMergeSiblingSpans returns if the consecutive sibling spans of the same
+// name are merged
+func (c *Config) GetMergeSiblingSpans() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.MergeSiblingSpans
+}
+
+// GetUDPSendBuffer returns the send buffer size of the UDP reporter's socket
+func (c *Config) GetUDPSendBuffer() int {
+	c.RLock()
+	defer c.RUnlock()
+	return c.UDPSendBuffer
+}
+
+// GetStatsDAddr returns the address of the StatsD server, or an empty string
+// if the metrics are not sent to a StatsD server.
+func (c *Config) GetStatsDAddr() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.StatsDAddr
+}
+
+// GetStatsDTagFormat returns the format of the tags of the StatsD metrics
+func (c *Config) GetStatsDTagFormat() string {
+	c.RLock()
+	defer c.RUnlock()
+	if c.StatsDTagFormat == "" {
+		return StatsDTagsDogStatsD
+	}
+	return c.StatsDTagFormat
+}
+
+// GetStatsDOnly returns if the metrics are sent to the StatsD server instead
+// of the collector
+func (c *Config) GetStatsDOnly() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.StatsDOnly
+}
+
+// GetMetricsNamespace returns the namespace of the names of the metrics
+// exported to Prometheus and StatsD
+func (c *Config) GetMetricsNamespace() string {
+	c.RLock()
+	defer c.RUnlock()
+	if c.MetricsNamespace == "" {
+		return DefaultMetricsNamespace
+	}
+	return c.MetricsNamespace
+}
+
+// GetMaxTimestampSkew returns the maximum skew of the event timestamps from
+// the current time, or 0 if the timestamps are not checked
+func (c *Config) GetMaxTimestampSkew() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return time.Duration(c.MaxTimestampSkew) * time.Second
+}
+
+// GetSkewedEvents returns how the events with skewed timestamps are handled
+func (c *Config) GetSkewedEvents() string {
+	c.RLock()
+	defer c.RUnlock()
+	if c.SkewedEvents == "" {
+		return SkewedEventsReject
+	}
+	return c.SkewedEvents
+}
+
+// GetControlSocket returns the path of the control socket
+func (c *Config) GetControlSocket() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ControlSocket
+}
+
+// IsBlockedSpan checks if the span named name is blocked from being created
+func (c *Config) IsBlockedSpan(name string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	for _, pattern := range c.BlockedSpans {
+		if pattern == name {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// GetMaxTransactions returns the maximum number of distinct transaction names
+// reported in the metrics of a report cycle, or 0 if it's not limited locally
+func (c *Config) GetMaxTransactions() int {
+	c.RLock()
+	defer c.RUnlock()
+	if c.MaxTransactions <= 0 {
+		return 0
+	}
+	return c.MaxTransactions
+}
+
+// GetGRPCTransactionNaming returns the granularity of the transaction names of
+// the gRPC server spans
+func (c *Config) GetGRPCTransactionNaming() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.GRPCTransactionNaming
+}
+
+// GetDurationPrecision returns the precision which the durations reported are
+// rounded to
+func (c *Config) GetDurationPrecision() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	switch c.DurationPrecision {
+	case DurationPrecisionMicrosecond:
+		return time.Microsecond
+	case DurationPrecisionMillisecond:
+		return time.Millisecond
+	default:
+		return time.Nanosecond
+	}
+}
+
+// GetOriginSampleRate returns the sample rate of the new traces of the origin,
+// and false if it's not configured.
+func (c *Config) GetOriginSampleRate(origin string) (int, bool) {
+	c.RLock()
+	defer c.RUnlock()
+	rate, ok := c.OriginSampleRates[origin]
+	return rate, ok
+}
+
+// GetRetroactiveCaptureWindow returns the time window of the unsampled traces
+// captured for retroactive sending, or 0 if it's disabled.
+func (c *Config) GetRetroactiveCaptureWindow() time.Duration {
+	c.RLock()
+	defer c.RUnlock()
+	return time.Duration(c.RetroactiveCaptureWindow) * time.Second
+}
+
+// GetHeaderValidation returns the validation strictness of the inbound headers
+// carrying the trace context
+func (c *Config) GetHeaderValidation() string {
+	c.RLock()
+	defer c.RUnlock()
+	if c.HeaderValidation == "" {
+		return HeaderValidationLenient
+	}
+	return c.HeaderValidation
+}
+
+// IsErrorStatusCode returns if the HTTP status code marks a span as an error.
+func (c *Config) IsErrorStatusCode(code int) bool {
+	c.RLock()
+	defer c.RUnlock()
+	if len(c.errorStatusRanges) == 0 {
+		return code >= 500 && code <= 599
+	}
+	for _, r := range c.errorStatusRanges {
+		if code >= r.lo && code <= r.hi {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPrettyTimeFormat returns the layout and the time zone of the timestamps
+// printed by the pretty reporter.
+func (c *Config) GetPrettyTimeFormat() (string, *time.Location) {
+	c.RLock()
+	defer c.RUnlock()
+	layout := c.PrettyTimeFormat
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+	loc, err := loadLocation(c.PrettyTimeZone)
+	if err != nil {
+		return layout, time.Local
+	}
+	return layout, loc
+}
+
+// GetServiceVersion returns the version of the service
+func (c *Config) GetServiceVersion() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ServiceVersion
+}
+
+// IsHTTPKVDisabled checks if the KV auto-collected by the HTTP instrumentation
+// is disabled.
+func (c *Config) IsHTTPKVDisabled(key string) bool {
+	c.RLock()
+	defer c.RUnlock()
+	for _, k := range c.DisabledHTTPKVs {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetBaggageLimits returns the maximum total size in bytes and the maximum
+// number of the baggage items propagated downstream.
+func (c *Config) GetBaggageLimits() (maxBytes, maxItems int) {
+	c.RLock()
+	defer c.RUnlock()
+	maxBytes, maxItems = c.BaggageMaxBytes, c.BaggageMaxItems
+	if maxBytes == 0 {
+		maxBytes = DefaultBaggageMaxBytes
+	}
+	if maxItems == 0 {
+		maxItems = DefaultBaggageMaxItems
+	}
+	return maxBytes, maxItems
+}
+
+// GetReportColdStart returns if the root spans report the cold_start KV
+func (c *Config) GetReportColdStart() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.ReportColdStart
+}
+
+// GetDedupErrors returns if the identical errors within a trace are collapsed
+func (c *Config) GetDedupErrors() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.DedupErrors
+}
+
+// GetWatchConfigFile returns if the config file is reloaded when it's changed
+func (c *Config) GetWatchConfigFile() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.WatchConfigFile
+}
+
+// GetRemoteConfig returns the endpoint of the remote config source, the header
+// to authenticate with, and the polling interval and TTL of the config fetched.
+// The url is empty if there is no remote config source.
+func (c *Config) GetRemoteConfig() (url, header string, interval, ttl time.Duration) {
+	c.RLock()
+	defer c.RUnlock()
+	intervalSec, ttlSec := c.RemoteConfigInterval, c.RemoteConfigTTL
+	if intervalSec <= 0 {
+		intervalSec = DefaultRemoteConfigInterval
+	}
+	if ttlSec <= 0 {
+		ttlSec = DefaultRemoteConfigTTL
+	}
+	return c.RemoteConfigURL, c.RemoteConfigHeader,
+		time.Duration(intervalSec) * time.Second, time.Duration(ttlSec) * time.Second
+}
+
+// GetRequestID returns if the X-Request-ID header is propagated and reported
+func (c *Config) GetRequestID() bool {
+	c.RLock()
+	defer c.RUnlock()
+	return c.RequestID
+}
@@ -9,12 +9,17 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	aolog "github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v2"
 )
 
@@ -98,7 +103,143 @@ func TestPrintDelta(t *testing.T) {
 		` - Collector (APPOPTICS_COLLECTOR) = test.com:443 (default: collector.appoptics.com:443)
  - PrependDomain (APPOPTICS_PREPEND_DOMAIN) = true (default: false)
  - ReporterProperties.EventFlushInterval (APPOPTICS_EVENTS_FLUSH_INTERVAL) = 100 (default: 2)`,
-		getDelta(newConfig().reset(), changed, "").sanitize().String())
+		getDelta(newConfig().reset(), changed, "").sanitize(nil).String())
+}
+
+func TestConfigHash(t *testing.T) {
+	key1 := "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go"
+	key2 := "ae38000000000000000000000000000000000000000000000000000000009217:go"
+	c1 := newConfig().reset()
+	c1.ServiceKey = key1
+	c2 := newConfig().reset()
+	c2.ServiceKey = key1
+
+	hash := c1.GetConfigHash()
+	assert.Len(t, hash, 64)
+	assert.Equal(t, hash, c1.GetConfigHash())
+	assert.Equal(t, hash, c2.GetConfigHash())
+
+	c2.Collector = "test.com:443"
+	assert.NotEqual(t, hash, c2.GetConfigHash())
+
+	// the hash is of the redacted config
+	c3 := newConfig().reset()
+	c3.ServiceKey = key2
+	assert.Equal(t, MaskServiceKey(key1), MaskServiceKey(key2))
+	assert.Equal(t, hash, c3.GetConfigHash())
+
+	// the map items are hashed with the keys sorted
+	c2 = newConfig().reset()
+	c2.ServiceKey = key1
+	c2.MetricTags = map[string]string{"b": "2", "a": "1", "c": "3"}
+	assert.Equal(t, "map[a:1 b:2 c:3]", formatValue(reflect.ValueOf(c2.MetricTags)))
+	assert.Equal(t, c2.GetConfigHash(), c2.GetConfigHash())
+
+	// the service keys of the tenants are still masked
+	keys := TenantServiceKeys{"b": key1, "a": key1}
+	assert.Equal(t, keys.String(), formatValue(reflect.ValueOf(keys)))
+}
+
+func TestTrustedCert(t *testing.T) {
+	cert, err := ioutil.ReadFile("../reporter/localhost.crt")
+	require.NoError(t, err)
+
+	ClearEnvs()
+	os.Setenv(envAppOpticsServiceKey, "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	os.Setenv(envAppOpticsTrustedCert, string(cert))
+	defer func() {
+		os.Unsetenv(envAppOpticsServiceKey)
+		os.Unsetenv(envAppOpticsTrustedCert)
+		os.Unsetenv(envAppOpticsTrustedPath)
+	}()
+
+	c := newConfig()
+	require.NoError(t, c.Load())
+	assert.Equal(t, strings.TrimSpace(string(cert)), c.GetTrustedCert())
+	assert.Empty(t, c.GetTrustedPath())
+
+	// the invalid PEM is rejected
+	os.Setenv(envAppOpticsTrustedCert, "-----BEGIN CERTIFICATE-----\ninvalid\n-----END CERTIFICATE-----")
+	c = newConfig()
+	require.NoError(t, c.Load())
+	assert.Empty(t, c.GetTrustedCert())
+
+	// it's mutually exclusive with the TrustedPath
+	os.Setenv(envAppOpticsTrustedCert, string(cert))
+	os.Setenv(envAppOpticsTrustedPath, "test.crt")
+	os.Setenv("APPOPTICS_DIAL_TIMEOUT", "0")
+	defer os.Unsetenv("APPOPTICS_DIAL_TIMEOUT")
+	c = newConfig()
+	assert.Equal(t, ErrConflictingCert, errors.Cause(c.Load()))
+	// but the TrustedCert is used and the rest of the config is validated
+	assert.Equal(t, strings.TrimSpace(string(cert)), c.GetTrustedCert())
+	assert.Empty(t, c.GetTrustedPath())
+	assert.Equal(t, int64(10), c.ReporterProperties.DialTimeout)
+}
+
+func TestSecretFields(t *testing.T) {
+	var buf utils.SafeBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	os.Setenv("APPOPTICS_COLLECTOR", "secret.test.com:443")
+	os.Setenv("APPOPTICS_EVENTS_FLUSH_INTERVAL", "100")
+	os.Setenv("APPOPTICS_SECRET_FIELDS", "collector, ReporterProperties.EventFlushInterval,db_password")
+	Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_COLLECTOR")
+		os.Unsetenv("APPOPTICS_EVENTS_FLUSH_INTERVAL")
+		os.Unsetenv("APPOPTICS_SECRET_FIELDS")
+		Load()
+	}()
+
+	// masked in the accepted config items logged
+	assert.NotContains(t, buf.String(), "secret.test.com")
+	assert.NotContains(t, buf.String(), "= 100 (default: 2)")
+	assert.Contains(t, buf.String(), " - Collector (APPOPTICS_COLLECTOR) = **** (default: collector.appoptics.com:443)")
+	assert.Contains(t, buf.String(),
+		" - ReporterProperties.EventFlushInterval (APPOPTICS_EVENTS_FLUSH_INTERVAL) = **** (default: 2)")
+	assert.Contains(t, buf.String(), "ae38********************************************************9217:go")
+
+	// masked in the config hash
+	hash := GetConfigHash()
+	conf.Lock()
+	conf.Collector = "other.test.com:443"
+	conf.Unlock()
+	assert.Equal(t, hash, GetConfigHash())
+
+	// the KVs are redacted
+	assert.True(t, IsRedactedKey("DB_Password"))
+	assert.True(t, IsRedactedKey("collector"))
+	assert.False(t, IsRedactedKey("db_user"))
+}
+
+func TestTenantServiceKeys(t *testing.T) {
+	key1 := "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go"
+	key2 := "bf49315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:Tenant B"
+	os.Setenv("APPOPTICS_SERVICE_KEY", key1)
+	os.Setenv("APPOPTICS_TENANT_SERVICE_KEYS", "a="+key1+", b = "+key2+",c=invalid")
+	c := NewConfig()
+	os.Unsetenv("APPOPTICS_SERVICE_KEY")
+	os.Unsetenv("APPOPTICS_TENANT_SERVICE_KEYS")
+
+	key, ok := c.GetTenantServiceKey("a")
+	assert.True(t, ok)
+	assert.Equal(t, key1, key)
+	// normalized as the service key
+	key, ok = c.GetTenantServiceKey("b")
+	assert.True(t, ok)
+	assert.Equal(t, ToServiceKey(key2), key)
+	// the invalid ones are dropped
+	_, ok = c.GetTenantServiceKey("c")
+	assert.False(t, ok)
+	assert.Len(t, c.GetTenantServiceKeys(), 2)
+
+	// the keys are masked when printed
+	assert.Equal(t, "a="+MaskServiceKey(key1)+",b="+MaskServiceKey(ToServiceKey(key2)),
+		c.TenantServiceKeys.String())
 }
 
 func TestConfigInit(t *testing.T) {
@@ -138,9 +279,21 @@ func TestConfigInit(t *testing.T) {
 			RedirectMax:             20,
 			RetryLogThreshold:       10,
 			MaxRetries:              20,
+			DialTimeout:             10,
+			KeepAlive:               30,
+			EventConnections:        1,
+			SendTimeout:             10,
+			SendTimeoutRetries:      -1,
+			Compression:             "none",
+			CompressionThreshold:    1024,
 		},
-		Disabled:   false,
-		DebugLevel: "warn",
+		Disabled:           false,
+		DebugLevel:         "warn",
+		PropagateUnsampled: true,
+		QueueTimeMetric:    true,
+		RuntimeKVs:         true,
+		RequestID:          true,
+		SpanDepthDecay:     1,
 	}
 	assert.Equal(t, *c, defaultC)
 }
@@ -179,6 +332,10 @@ func TestEnvsLoading(t *testing.T) {
 		"APPOPTICS_EVENTS_FLUSH_INTERVAL=4",
 		"APPOPTICS_EVENTS_BATCHSIZE=4000",
 		"APPOPTICS_DISABLED=true",
+		"APPOPTICS_APDEX_THRESHOLD=500",
+		"APPOPTICS_PROPAGATE_UNSAMPLED=false",
+		"APPOPTICS_DIAL_TIMEOUT=5",
+		"APPOPTICS_TCP_KEEPALIVE=60",
 	}
 	SetEnvs(envs)
 
@@ -210,9 +367,22 @@ func TestEnvsLoading(t *testing.T) {
 			RedirectMax:             20,
 			RetryLogThreshold:       10,
 			MaxRetries:              20,
+			DialTimeout:             5,
+			KeepAlive:               60,
+			EventConnections:        1,
+			SendTimeout:             10,
+			SendTimeoutRetries:      -1,
+			Compression:             "none",
+			CompressionThreshold:    1024,
 		},
-		Disabled:   true,
-		DebugLevel: "warn",
+		Disabled:           true,
+		DebugLevel:         "warn",
+		ApdexThreshold:     500,
+		PropagateUnsampled: false,
+		QueueTimeMetric:    true,
+		RuntimeKVs:         true,
+		RequestID:          true,
+		SpanDepthDecay:     1,
 	}
 
 	c := NewConfig()
@@ -249,13 +419,25 @@ func TestYamlConfig(t *testing.T) {
 			RedirectMax:             20,
 			RetryLogThreshold:       10,
 			MaxRetries:              20,
+			DialTimeout:             10,
+			KeepAlive:               30,
+			EventConnections:        1,
+			SendTimeout:             10,
+			SendTimeoutRetries:      -1,
+			Compression:             "none",
+			CompressionThreshold:    1024,
 		},
 		TransactionSettings: []TransactionFilter{
-			{"url", `\s+\d+\s+`, nil, "disabled"},
-			{"url", "", []string{".jpg"}, "disabled"},
+			{"url", `\s+\d+\s+`, nil, "disabled", nil},
+			{"url", "", []string{".jpg"}, "disabled", nil},
 		},
-		Disabled:   true,
-		DebugLevel: "info",
+		Disabled:           true,
+		DebugLevel:         "info",
+		PropagateUnsampled: true,
+		QueueTimeMetric:    true,
+		RuntimeKVs:         true,
+		RequestID:          true,
+		SpanDepthDecay:     1,
 	}
 
 	out, err := yaml.Marshal(yamlConfig)
@@ -320,13 +502,25 @@ func TestYamlConfig(t *testing.T) {
 			RedirectMax:             20,
 			RetryLogThreshold:       10,
 			MaxRetries:              20,
+			DialTimeout:             10,
+			KeepAlive:               30,
+			EventConnections:        1,
+			SendTimeout:             10,
+			SendTimeoutRetries:      -1,
+			Compression:             "none",
+			CompressionThreshold:    1024,
 		},
 		TransactionSettings: []TransactionFilter{
-			{"url", `\s+\d+\s+`, nil, "disabled"},
-			{"url", "", []string{".jpg"}, "disabled"},
+			{"url", `\s+\d+\s+`, nil, "disabled", nil},
+			{"url", "", []string{".jpg"}, "disabled", nil},
 		},
-		Disabled:   true,
-		DebugLevel: "info",
+		Disabled:           true,
+		DebugLevel:         "info",
+		PropagateUnsampled: true,
+		QueueTimeMetric:    true,
+		RuntimeKVs:         true,
+		RequestID:          true,
+		SpanDepthDecay:     1,
 	}
 
 	c = NewConfig()
@@ -335,6 +529,75 @@ func TestYamlConfig(t *testing.T) {
 	os.Unsetenv("APPOPTICS_CONFIG_FILE")
 }
 
+func TestConfigProfiles(t *testing.T) {
+	file := `
+ServiceKey: ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go
+HostAlias: base
+Sampling:
+  TracingMode: enabled
+  SampleRate: 1000
+ReporterProperties:
+  EventFlushInterval: 4
+Profiles:
+  prod:
+    HostAlias: prod
+    Sampling:
+      SampleRate: 10000
+  staging:
+    HostAlias: staging
+    ReporterProperties:
+      EventFlushInterval: 1
+  dev:
+    Sampling:
+      SampleRate: 100000000
+`
+	dir, err := ioutil.TempDir("", "appoptics")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "appoptics-profiles.yaml")
+	assert.Nil(t, ioutil.WriteFile(path, []byte(file), 0644))
+	defer ClearEnvs()
+
+	load := func(profile string) *Config {
+		ClearEnvs()
+		os.Setenv(EnvAppOpticsConfigFile, path)
+		if profile != "" {
+			os.Setenv(EnvAppOpticsProfile, profile)
+		}
+		return NewConfig()
+	}
+
+	// the profiles are ignored if none is selected
+	c := load("")
+	assert.NoError(t, c.GetLoadError())
+	assert.Equal(t, "base", c.HostAlias)
+	assert.Equal(t, 1000, c.Sampling.SampleRate)
+	assert.Equal(t, int64(4), c.ReporterProperties.EventFlushInterval)
+
+	// the profile is merged over the top level items
+	c = load("prod")
+	assert.NoError(t, c.GetLoadError())
+	assert.Equal(t, "prod", c.HostAlias)
+	assert.Equal(t, EnabledTracingMode, c.Sampling.TracingMode)
+	assert.Equal(t, 10000, c.Sampling.SampleRate)
+	assert.Equal(t, int64(4), c.ReporterProperties.EventFlushInterval)
+
+	c = load("staging")
+	assert.NoError(t, c.GetLoadError())
+	assert.Equal(t, "staging", c.HostAlias)
+	assert.Equal(t, 1000, c.Sampling.SampleRate)
+	assert.Equal(t, int64(1), c.ReporterProperties.EventFlushInterval)
+
+	// the merged config is validated
+	c = load("dev")
+	assert.NoError(t, c.GetLoadError())
+	assert.Equal(t, "base", c.HostAlias)
+	assert.Equal(t, MaxSampleRate, c.Sampling.SampleRate)
+
+	c = load("test")
+	assert.Equal(t, ErrUnknownProfile, errors.Cause(c.GetLoadError()))
+}
+
 func TestSamplingConfigValidate(t *testing.T) {
 	s := &SamplingConfig{
 		TracingMode:           "invalid",
@@ -364,8 +627,8 @@ func TestInvalidConfigFile(t *testing.T) {
 
 	ClearEnvs()
 	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
-	os.Setenv("APPOPTICS_CONFIG_FILE", "/tmp/appoptics-config.json")
-	_ = ioutil.WriteFile("/tmp/appoptics-config.json", []byte("hello"), 0644)
+	os.Setenv("APPOPTICS_CONFIG_FILE", "/tmp/appoptics-config.toml")
+	_ = ioutil.WriteFile("/tmp/appoptics-config.toml", []byte("hello"), 0644)
 
 	_ = NewConfig()
 	assert.Contains(t, buf.String(), ErrUnsupportedFormat.Error())
@@ -379,6 +642,40 @@ func TestInvalidConfigFile(t *testing.T) {
 	assert.Contains(t, buf.String(), "no such file or directory")
 }
 
+func TestFailClosed(t *testing.T) {
+	validKey := "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go"
+	defer ClearEnvs()
+
+	// fail-open by default
+	ClearEnvs()
+	os.Setenv("APPOPTICS_SERVICE_KEY", validKey)
+	c := NewConfig()
+	assert.False(t, c.GetFailClosed())
+	assert.NoError(t, c.GetLoadError())
+
+	// the fail mode is kept on an invalid service key
+	ClearEnvs()
+	os.Setenv("APPOPTICS_SERVICE_KEY", "invalid")
+	os.Setenv("APPOPTICS_FAIL_CLOSED", "true")
+	c = NewConfig()
+	assert.True(t, c.GetFailClosed())
+	assert.Equal(t, ErrInvalidServiceKey, errors.Cause(c.GetLoadError()))
+
+	// and on an invalid config file
+	ClearEnvs()
+	os.Setenv("APPOPTICS_SERVICE_KEY", validKey)
+	os.Setenv("APPOPTICS_CONFIG_FILE", "/tmp/file-not-exist.yaml")
+	c = NewConfig(WithFailClosed(true))
+	assert.True(t, c.GetFailClosed())
+	assert.Error(t, c.GetLoadError())
+
+	ClearEnvs()
+	os.Setenv("APPOPTICS_SERVICE_KEY", "invalid")
+	c = NewConfig()
+	assert.False(t, c.GetFailClosed())
+	assert.Error(t, c.GetLoadError())
+}
+
 func TestInvalidConfig(t *testing.T) {
 	var buf utils.SafeBuffer
 	var writers []io.Writer
@@ -420,9 +717,22 @@ func TestInvalidConfig(t *testing.T) {
 			RedirectMax:             20,
 			RetryLogThreshold:       10,
 			MaxRetries:              20,
+			DialTimeout:             0,
+			KeepAlive:               7200,
+			EventConnections:        1,
+			SendTimeout:             10,
+			SendTimeoutRetries:      -1,
+			Compression:             "zstd",
+			CompressionThreshold:    -1,
 		},
-		Disabled:   true,
-		DebugLevel: "info",
+		Disabled:           true,
+		DebugLevel:         "info",
+		PropagateUnsampled: true,
+		QueueTimeMetric:    true,
+		RuntimeKVs:         true,
+		RequestID:          true,
+		SpanDepthDecay:     1,
+		PrettyTimeZone:     "Mars/Olympus_Mons",
 	}
 
 	assert.Nil(t, invalid.validate())
@@ -434,6 +744,82 @@ func TestInvalidConfig(t *testing.T) {
 	assert.Contains(t, buf.String(), "invalid env, discarded - ReporterType:", buf.String())
 
 	assert.Equal(t, "alias", invalid.HostAlias)
+
+	assert.Equal(t, int64(10), invalid.ReporterProperties.DialTimeout)
+	assert.Contains(t, buf.String(), "invalid env, discarded - DialTimeout:", buf.String())
+
+	assert.Equal(t, int64(keepAliveMax), invalid.ReporterProperties.KeepAlive)
+	assert.Contains(t, buf.String(), "KeepAlive 7200 is too large", buf.String())
+
+	assert.Equal(t, CompressionNone, invalid.ReporterProperties.Compression)
+	assert.Contains(t, buf.String(), "invalid env, discarded - Compression:", buf.String())
+	assert.Equal(t, int64(1024), invalid.ReporterProperties.CompressionThreshold)
+	assert.Contains(t, buf.String(), "invalid env, discarded - CompressionThreshold:", buf.String())
+
+	assert.Equal(t, "", invalid.PrettyTimeZone)
+	assert.Contains(t, buf.String(), "invalid env, discarded - PrettyTimeZone:", buf.String())
+}
+
+func TestValidateReporterCollector(t *testing.T) {
+	var buf utils.SafeBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	testCases := []struct {
+		reporter, collector, collectorUDP string
+		expectedUDP                       string
+		warning                           string
+	}{
+		// udp reporter without a udp collector
+		{"udp", "custom.test.com:443", "", defaultUDPCollector, "only the ssl collector is set"},
+		{"udp", defaultSSLCollector, "", defaultUDPCollector, "APPOPTICS_COLLECTOR_UDP is not set"},
+		// ssl reporter without an ssl collector
+		{"ssl", defaultSSLCollector, "udp.test.com", "udp.test.com", "only the udp collector is set"},
+		// the matching ones
+		{"udp", defaultSSLCollector, "udp.test.com", "udp.test.com", ""},
+		{"udp", "custom.test.com:443", "udp.test.com", "udp.test.com", ""},
+		{"ssl", "custom.test.com:443", "", "", ""},
+		{"ssl", "custom.test.com:443", "udp.test.com", "udp.test.com", ""},
+		{"ssl", defaultSSLCollector, "", "", ""},
+		{"none", defaultSSLCollector, "", "", ""},
+	}
+	for i, tc := range testCases {
+		buf.Reset()
+		c := &Config{ReporterType: tc.reporter, Collector: tc.collector, CollectorUDP: tc.collectorUDP}
+		c.validateReporterCollector()
+		assert.Equal(t, tc.collector, c.Collector, "case #%d", i)
+		assert.Equal(t, tc.expectedUDP, c.CollectorUDP, "case #%d", i)
+		if tc.warning == "" {
+			assert.Empty(t, buf.String(), "case #%d", i)
+		} else {
+			assert.Contains(t, buf.String(), tc.warning, "case #%d", i)
+		}
+	}
+}
+
+func TestMetricsNamespace(t *testing.T) {
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_METRICS_NAMESPACE")
+		Load()
+	}()
+
+	testCases := []struct {
+		namespace, expected string
+	}{
+		{"", DefaultMetricsNamespace},
+		{"appoptics_agent", "appoptics_agent"},
+		{" _agent1 ", "_agent1"},
+		{"appoptics-agent", DefaultMetricsNamespace},
+		{"1agent", DefaultMetricsNamespace},
+		{"agent.go", DefaultMetricsNamespace},
+	}
+	for _, tc := range testCases {
+		os.Setenv("APPOPTICS_METRICS_NAMESPACE", tc.namespace)
+		Load()
+		assert.Equal(t, tc.expected, GetMetricsNamespace(), tc.namespace)
+	}
 }
 
 // TestConfigDefaultValues is to verify the default values defined in struct Config
@@ -459,13 +845,18 @@ func TestTransactionFilter_UnmarshalYAML(t *testing.T) {
 		filter TransactionFilter
 		err    error
 	}{
-		{TransactionFilter{"invalid", `\s+\d+\s+`, nil, "disabled"}, ErrTFInvalidType},
-		{TransactionFilter{"url", `\s+\d+\s+`, nil, "enabled"}, nil},
-		{TransactionFilter{"url", `\s+\d+\s+`, nil, "disabled"}, nil},
-		{TransactionFilter{"url", "", []string{".jpg"}, "disabled"}, nil},
-		{TransactionFilter{"url", `\s+\d+\s+`, []string{".jpg"}, "disabled"}, ErrTFInvalidRegExExt},
-		{TransactionFilter{"url", `\s+\d+\s+`, nil, "disabled"}, nil},
-		{TransactionFilter{"url", `\s+\d+\s+`, nil, "invalid"}, ErrTFInvalidTracing},
+		{TransactionFilter{"invalid", `\s+\d+\s+`, nil, "disabled", nil}, ErrTFInvalidType},
+		{TransactionFilter{"url", `\s+\d+\s+`, nil, "enabled", nil}, nil},
+		{TransactionFilter{"url", `\s+\d+\s+`, nil, "disabled", nil}, nil},
+		{TransactionFilter{"url", "", []string{".jpg"}, "disabled", nil}, nil},
+		{TransactionFilter{"url", `\s+\d+\s+`, []string{".jpg"}, "disabled", nil}, ErrTFInvalidRegExExt},
+		{TransactionFilter{"url", `\s+\d+\s+`, nil, "disabled", nil}, nil},
+		{TransactionFilter{"url", `\s+\d+\s+`, nil, "invalid", nil}, ErrTFInvalidTracing},
+		{TransactionFilter{"url", `\s+\d+\s+`, nil, "enabled", intPtr(10000)}, nil},
+		{TransactionFilter{"url", "", []string{".jpg"}, "enabled", intPtr(0)}, nil},
+		{TransactionFilter{"url", `\s+\d+\s+`, nil, "enabled", intPtr(-1)}, ErrTFInvalidSampleRate},
+		{TransactionFilter{"url", `\s+\d+\s+`, nil, "enabled", intPtr(1000001)}, ErrTFInvalidSampleRate},
+		{TransactionFilter{"url", `\s+\d+\s+`, nil, "disabled", intPtr(10000)}, ErrTFDisabledSampleRate},
 	}
 
 	for idx, testCase := range testCases {
@@ -480,3 +871,189 @@ func TestTransactionFilter_UnmarshalYAML(t *testing.T) {
 		}
 	}
 }
+
+func TestTransactionFilterSampleRate(t *testing.T) {
+	// the tracing mode is enabled if only the sample rate is set
+	var filter TransactionFilter
+	err := yaml.Unmarshal([]byte("Type: url\nRegEx: ^/health$\nSampleRate: 10000\n"), &filter)
+	assert.NoError(t, err)
+	assert.Equal(t, TransactionFilter{"url", "^/health$", nil, "enabled", intPtr(10000)}, filter)
+
+	filter = TransactionFilter{}
+	err = yaml.Unmarshal([]byte("Type: url\nRegEx: ^/health$\n"), &filter)
+	assert.Equal(t, ErrTFInvalidTracing, err)
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestTransactionSamplingConfigured(t *testing.T) {
+	c := &Config{
+		TransactionSettings: []TransactionFilter{
+			{Type: URL, RegEx: `^/api/v\d+/health$`, Tracing: DisabledTracingMode},
+			{Type: URL, Extensions: []string{"png", "jpg"}, Tracing: DisabledTracingMode},
+			{Type: URL, RegEx: `[invalid`, Tracing: EnabledTracingMode},
+		},
+	}
+
+	assert.True(t, c.TransactionSamplingConfigured("/api/v1/health"))
+	assert.True(t, c.TransactionSamplingConfigured("/static/logo.png"))
+	assert.False(t, c.TransactionSamplingConfigured("/api/v1/users"))
+	assert.False(t, c.TransactionSamplingConfigured("/static/app.js"))
+	assert.False(t, c.TransactionSamplingConfigured("[invalid"))
+
+	assert.False(t, (&Config{}).TransactionSamplingConfigured("/api/v1/health"))
+}
+
+func TestSetSampling(t *testing.T) {
+	defer Load()
+	Load()
+	assert.False(t, SamplingConfigured())
+
+	assert.NoError(t, SetSampleRate(5000))
+	assert.Equal(t, 5000, GetSampleRate())
+	assert.True(t, SamplingConfigured())
+	assert.Error(t, SetSampleRate(MaxSampleRate+1))
+	assert.Error(t, SetSampleRate(-1))
+	assert.Equal(t, 5000, GetSampleRate())
+
+	assert.NoError(t, SetTracingMode(" Never "))
+	assert.Equal(t, DisabledTracingMode, GetTracingMode())
+	assert.NoError(t, SetTracingMode("enabled"))
+	assert.Equal(t, EnabledTracingMode, GetTracingMode())
+	assert.Error(t, SetTracingMode("sometimes"))
+	assert.Equal(t, EnabledTracingMode, GetTracingMode())
+
+	// reset by reloading the configuration
+	Load()
+	assert.False(t, SamplingConfigured())
+}
+
+func TestReload(t *testing.T) {
+	defer ClearEnvs()
+	ClearEnvs()
+	os.Setenv(envAppOpticsServiceKey, "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	c := NewConfig()
+	require.NoError(t, c.GetLoadError())
+
+	delta, err := c.Reload()
+	assert.NoError(t, err)
+	assert.Empty(t, delta.Keys())
+
+	os.Setenv("APPOPTICS_SAMPLE_RATE", "1000")
+	os.Setenv("APPOPTICS_HOSTNAME_ALIAS", "alias")
+	delta, err = c.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Sampling.SampleRate", "HostAlias"}, delta.Keys())
+	assert.True(t, delta.Changed("Sampling.SampleRate"))
+	assert.False(t, delta.Changed("Sampling.TracingMode"))
+	assert.Equal(t, 1000, c.GetSampleRate())
+	assert.True(t, c.SamplingConfigured())
+
+	os.Unsetenv("APPOPTICS_HOSTNAME_ALIAS")
+	os.Setenv("APPOPTICS_SAMPLE_RATE", "2000")
+	delta, err = c.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t,
+		` - Sampling.SampleRate (APPOPTICS_SAMPLE_RATE) = 2000 (default: 1000)
+ - HostAlias (APPOPTICS_HOSTNAME_ALIAS) =  (default: alias)`, delta.String())
+	assert.Equal(t, "", c.GetHostAlias())
+
+	// the current config is kept if the reload fails
+	os.Setenv("APPOPTICS_SAMPLE_RATE", "3000")
+	os.Setenv(envAppOpticsServiceKey, "invalid")
+	delta, err = c.Reload()
+	assert.Error(t, err)
+	assert.Empty(t, delta.Keys())
+	assert.Equal(t, 2000, c.GetSampleRate())
+	assert.Equal(t, "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go", c.GetServiceKey())
+	assert.NoError(t, c.GetLoadError())
+
+	// and the delta of the next reload is against it
+	os.Setenv(envAppOpticsServiceKey, "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	delta, err = c.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, ` - Sampling.SampleRate (APPOPTICS_SAMPLE_RATE) = 3000 (default: 2000)`, delta.String())
+}
+
+func TestReloadConcurrent(t *testing.T) {
+	defer ClearEnvs()
+	ClearEnvs()
+	os.Setenv(envAppOpticsServiceKey, "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	c := NewConfig()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				s := c.GetSampling()
+				if s.TracingMode == DisabledTracingMode {
+					assert.Equal(t, 1000, s.SampleRate)
+				} else {
+					assert.Equal(t, MaxSampleRate, s.SampleRate)
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			os.Setenv("APPOPTICS_TRACING_MODE", "disabled")
+			os.Setenv("APPOPTICS_SAMPLE_RATE", "1000")
+		} else {
+			os.Unsetenv("APPOPTICS_TRACING_MODE")
+			os.Unsetenv("APPOPTICS_SAMPLE_RATE")
+		}
+		_, err := c.Reload()
+		assert.NoError(t, err)
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestRemoteConfig(t *testing.T) {
+	var buf utils.SafeBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer ClearEnvs()
+
+	ClearEnvs()
+	os.Setenv(envAppOpticsServiceKey, "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	c := NewConfig()
+	url, header, interval, ttl := c.GetRemoteConfig()
+	assert.Equal(t, "", url)
+	assert.Equal(t, "", header)
+	assert.Equal(t, DefaultRemoteConfigInterval*time.Second, interval)
+	assert.Equal(t, DefaultRemoteConfigTTL*time.Second, ttl)
+
+	os.Setenv("APPOPTICS_REMOTE_CONFIG_URL", "https://config.example.com/sampling")
+	os.Setenv("APPOPTICS_REMOTE_CONFIG_INTERVAL", "10")
+	os.Setenv("APPOPTICS_REMOTE_CONFIG_TTL", "30")
+	os.Setenv("APPOPTICS_REMOTE_CONFIG_HEADER", "Authorization: Bearer secret-token")
+	c = NewConfig()
+	url, header, interval, ttl = c.GetRemoteConfig()
+	assert.Equal(t, "https://config.example.com/sampling", url)
+	assert.Equal(t, "Authorization: Bearer secret-token", header)
+	assert.Equal(t, 10*time.Second, interval)
+	assert.Equal(t, 30*time.Second, ttl)
+	// the header is never logged
+	assert.Contains(t, buf.String(), "RemoteConfigHeader (APPOPTICS_REMOTE_CONFIG_HEADER) = ****")
+	assert.NotContains(t, buf.String(), "secret-token")
+
+	os.Setenv("APPOPTICS_REMOTE_CONFIG_URL", "config.example.com")
+	os.Setenv("APPOPTICS_REMOTE_CONFIG_HEADER", "secret-token")
+	c = NewConfig()
+	url, header, _, _ = c.GetRemoteConfig()
+	assert.Equal(t, "", url)
+	assert.Equal(t, "", header)
+	assert.NotContains(t, buf.String(), "secret-token")
+}
@@ -60,6 +60,23 @@ func loadEnvsInternal(c interface{}) {
 	}
 }
 
+// checkMapValue checks if the value of a key=value pair can be converted to
+// the kind. An empty value is invalid as it's not converted to the zero value
+// like an environment variable.
+func checkMapValue(s string, kind reflect.Kind) error {
+	s = strings.TrimSpace(s)
+	var err error
+	switch kind {
+	case reflect.Int, reflect.Int64:
+		_, err = strconv.ParseInt(s, 10, 64)
+	case reflect.Float64:
+		_, err = strconv.ParseFloat(s, 64)
+	case reflect.Bool:
+		_, err = toBool(s)
+	}
+	return err
+}
+
 // stringToValue converts a string to a value of the type specified by typ.
 func stringToValue(s string, typ reflect.Type) reflect.Value {
 	s = strings.TrimSpace(s)
@@ -85,6 +102,14 @@ func stringToValue(s string, typ reflect.Type) reflect.Value {
 		if err != nil {
 			log.Warningf("Ignore invalid int64 value: %s", s)
 		}
+	case reflect.Float64:
+		if s == "" {
+			s = "0"
+		}
+		val, err = strconv.ParseFloat(s, 64)
+		if err != nil {
+			log.Warningf("Ignore invalid float64 value: %s", s)
+		}
 	case reflect.String:
 		val = s
 	case reflect.Bool:
@@ -98,9 +123,37 @@ func stringToValue(s string, typ reflect.Type) reflect.Value {
 	case reflect.Slice:
 		if s == "" {
 			return reflect.Zero(typ)
+		} else if kind := typ.Elem().Kind(); kind == reflect.String || kind == reflect.Float64 {
+			// a comma-separated list, e.g., "Cache-Status, X-Served-By"
+			items := reflect.MakeSlice(typ, 0, 0)
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = reflect.Append(items, stringToValue(item, typ.Elem()))
+				}
+			}
+			return items
 		} else {
 			panic(fmt.Sprintf("Slice with non-empty value is not supported"))
 		}
+	case reflect.Map:
+		if s == "" {
+			return reflect.Zero(typ)
+		}
+		// a comma-separated list of key=value pairs, e.g., "SELECT=100000, INSERT=1000000"
+		items := reflect.MakeMap(typ)
+		for _, item := range strings.Split(s, ",") {
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+				log.Warningf("Ignore invalid key=value pair: %s", item)
+				continue
+			}
+			if err := checkMapValue(kv[1], typ.Elem().Kind()); err != nil {
+				log.Warningf("Ignore invalid key=value pair: %s", item)
+				continue
+			}
+			items.SetMapIndex(stringToValue(kv[0], typ.Key()), stringToValue(kv[1], typ.Elem()))
+		}
+		return items
 
 	default:
 		panic(fmt.Sprintf("Unsupported kind: %v, val: %s", kind, s))
@@ -70,4 +70,22 @@ func TestStringToValue(t *testing.T) {
 
 	assert.Equal(t, stringToValue("hello", typNewStr).Interface(), NewStr("hello"))
 	assert.Equal(t, stringToValue("hello", typNewStr).Type(), reflect.TypeOf(NewStr("hello")))
+
+	typFloat64 := reflect.TypeOf(float64(1))
+	assert.Equal(t, stringToValue("0.5", typFloat64).Interface(), 0.5)
+	assert.Equal(t, stringToValue("a", typFloat64).Interface(), float64(0))
+
+	typStrings := reflect.TypeOf([]string{})
+	assert.Equal(t, stringToValue("a, b,,c ", typStrings).Interface(), []string{"a", "b", "c"})
+
+	typFloats := reflect.TypeOf([]float64{})
+	assert.Equal(t, stringToValue("0.1, 1,2.5", typFloats).Interface(), []float64{0.1, 1, 2.5})
+
+	typMap := reflect.TypeOf(map[string]int{})
+	assert.Equal(t, stringToValue("SELECT=100000, insert = 0,bad", typMap).Interface(),
+		map[string]int{"SELECT": 100000, "insert": 0})
+	assert.Equal(t, stringToValue("SELECT=abc,INSERT=10,UPDATE=", typMap).Interface(),
+		map[string]int{"INSERT": 10})
+	assert.Equal(t, stringToValue("job=abc", typMap).Interface(), map[string]int{})
+	assert.Nil(t, stringToValue("", typStrings).Interface())
 }
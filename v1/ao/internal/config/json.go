@@ -0,0 +1,131 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/pkg/errors"
+)
+
+// loadJSON loads the config file in JSON, which has the same schema as the
+// YAML one, i.e., the keys are the names in the yaml tags of the config items.
+func (c *Config) loadJSON(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "loadJSON")
+	}
+
+	// A pointer field may be assigned with nil in unmarshal, so just keep the
+	// old default value and re-assign it later.
+	origSampling := c.Sampling
+	origReporterProperties := c.ReporterProperties
+
+	var items map[string]json.RawMessage
+	if err = json.Unmarshal(data, &items); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("loadJSON: %s", path))
+	}
+	keys := make([]string, 0, len(items))
+	for k := range items {
+		keys = append(keys, k)
+	}
+	warnUnknownItems(path, keys)
+
+	// The config struct is modified in place so we won't tolerate any error
+	if err = json.Unmarshal(data, c); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("loadJSON: %s", path))
+	}
+	if err = c.loadJSONProfile(items["Profiles"]); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("loadJSON: %s", path))
+	}
+
+	if c.Sampling == nil {
+		c.Sampling = origSampling
+	}
+	if c.ReporterProperties == nil {
+		c.ReporterProperties = origReporterProperties
+	}
+
+	return nil
+}
+
+// loadJSONProfile merges the config items of the profile selected by the env
+// variable APPOPTICS_PROFILE, see loadProfile.
+func (c *Config) loadJSONProfile(data json.RawMessage) error {
+	name := strings.TrimSpace(os.Getenv(EnvAppOpticsProfile))
+	if name == "" {
+		return nil
+	}
+	var profiles map[string]json.RawMessage
+	if len(data) != 0 {
+		if err := json.Unmarshal(data, &profiles); err != nil {
+			return err
+		}
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		return errors.Wrap(ErrUnknownProfile, name)
+	}
+	log.Warningf("Loading config profile: %s", name)
+	return json.Unmarshal(profile, c)
+}
+
+// UnmarshalJSON is the customized unmarshal method for TransactionFilter
+func (f *TransactionFilter) UnmarshalJSON(data []byte) error {
+	initStruct(f)
+	// the type without the customized unmarshal method
+	type transactionFilter TransactionFilter
+	var aux transactionFilter
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return errors.Wrap(err, "failed to unmarshal TransactionFilter")
+	}
+	return f.assign(TransactionFilter(aux))
+}
+
+// UnmarshalJSON is the customized unmarshal method for SamplingConfig
+func (s *SamplingConfig) UnmarshalJSON(data []byte) error {
+	initStruct(s)
+	var aux = struct {
+		TracingMode TracingMode
+		SampleRate  int
+	}{
+		TracingMode: unsetTracingMode,
+		SampleRate:  unsetSampleRate,
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return errors.Wrap(err, "failed to unmarshal SamplingConfig")
+	}
+	s.assign(aux.TracingMode, aux.SampleRate)
+	return nil
+}
+
+// warnUnknownItems logs the top level keys of the config file which are not
+// config items, which are discarded.
+func warnUnknownItems(path string, keys []string) {
+	known := map[string]bool{"Profiles": true}
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name != "" && name != "-" {
+			known[name] = true
+		}
+	}
+
+	var unknown []string
+	for _, k := range keys {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		log.Warningf("Unknown config items in %s, discarded: %s", path, strings.Join(unknown, ", "))
+	}
+}
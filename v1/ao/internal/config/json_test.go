@@ -0,0 +1,107 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSONConfig(t *testing.T) {
+	file := `{
+  "ServiceKey": "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go",
+  "HostAlias": "json-alias",
+  "Sampling": {"TracingMode": "disabled"},
+  "ReporterProperties": {"EventFlushInterval": 6},
+  "TransactionSettings": [
+    {"Type": "url", "RegEx": "\\s+\\d+\\s+", "Tracing": "disabled"},
+    {"Type": "url", "Extensions": [".jpg"], "Tracing": "disabled"}
+  ],
+  "Unknown": 1,
+  "Profiles": {
+    "prod": {"HostAlias": "prod", "Sampling": {"TracingMode": "enabled", "SampleRate": 10000}}
+  }
+}`
+	dir, err := ioutil.TempDir("", "appoptics")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "appoptics-config.json")
+	require.Nil(t, ioutil.WriteFile(path, []byte(file), 0644))
+	defer ClearEnvs()
+
+	var buf utils.SafeBuffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	ClearEnvs()
+	os.Setenv(EnvAppOpticsConfigFile, path)
+	c := NewConfig()
+	assert.NoError(t, c.GetLoadError())
+	assert.Equal(t, "json-alias", c.HostAlias)
+	assert.Equal(t, DisabledTracingMode, c.Sampling.TracingMode)
+	assert.True(t, c.Sampling.tracingModeConfigured)
+	assert.Equal(t, MaxSampleRate, c.Sampling.SampleRate)
+	assert.False(t, c.Sampling.sampleRateConfigured)
+	assert.Equal(t, int64(6), c.ReporterProperties.EventFlushInterval)
+	assert.Equal(t, NewConfig().ReporterProperties.EventFlushBatchSize,
+		c.ReporterProperties.EventFlushBatchSize)
+	assert.Equal(t, []TransactionFilter{
+		{"url", `\s+\d+\s+`, nil, "disabled", nil},
+		{"url", "", []string{".jpg"}, "disabled", nil},
+	}, c.TransactionSettings)
+	assert.Contains(t, buf.String(), "discarded: Unknown")
+
+	os.Setenv(EnvAppOpticsProfile, "prod")
+	c = NewConfig()
+	assert.NoError(t, c.GetLoadError())
+	assert.Equal(t, "prod", c.HostAlias)
+	assert.Equal(t, EnabledTracingMode, c.Sampling.TracingMode)
+	assert.Equal(t, 10000, c.Sampling.SampleRate)
+
+	os.Setenv(EnvAppOpticsProfile, "test")
+	c = NewConfig()
+	assert.Equal(t, ErrUnknownProfile, errors.Cause(c.GetLoadError()))
+}
+
+func TestTransactionFilter_UnmarshalJSON(t *testing.T) {
+	var testCases = []struct {
+		filter TransactionFilter
+		err    error
+	}{
+		{TransactionFilter{"invalid", `\s+\d+\s+`, nil, "disabled", nil}, ErrTFInvalidType},
+		{TransactionFilter{"url", `\s+\d+\s+`, nil, "enabled", nil}, nil},
+		{TransactionFilter{"url", "", []string{".jpg"}, "disabled", nil}, nil},
+		{TransactionFilter{"url", `\s+\d+\s+`, []string{".jpg"}, "disabled", nil}, ErrTFInvalidRegExExt},
+		{TransactionFilter{"url", "", nil, "disabled", nil}, ErrTFInvalidRegExExt},
+		{TransactionFilter{"url", `\s+\d+\s+`, nil, "invalid", nil}, ErrTFInvalidTracing},
+		{TransactionFilter{"url", `\s+\d+\s+`, nil, "enabled", intPtr(10000)}, nil},
+		{TransactionFilter{"url", `\s+\d+\s+`, nil, "enabled", intPtr(-1)}, ErrTFInvalidSampleRate},
+		{TransactionFilter{"url", `\s+\d+\s+`, nil, "disabled", intPtr(10000)}, ErrTFDisabledSampleRate},
+	}
+
+	for idx, testCase := range testCases {
+		bytes, err := json.Marshal(testCase.filter)
+		assert.Nil(t, err, fmt.Sprintf("Case #%d", idx))
+
+		var filter TransactionFilter
+		err = json.Unmarshal(bytes, &filter)
+		assert.Equal(t, testCase.err, err, fmt.Sprintf("Case #%d", idx))
+		if err == nil {
+			assert.Equal(t, testCase.filter, filter, fmt.Sprintf("Case #%d", idx))
+		}
+	}
+
+	var c Config
+	err := json.Unmarshal([]byte(`{"TransactionSettings": [{"Type": "url", "Tracing": "enabled"}]}`), &c)
+	assert.Equal(t, ErrTFInvalidRegExExt, err)
+}
@@ -3,7 +3,28 @@
 package config
 
 import (
+	"strconv"
+	"strings"
 	"sync/atomic"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+const (
+	// the upper bound of the dial timeout in seconds
+	dialTimeoutMax = 60
+	// the upper bound of the TCP keepalive period in seconds
+	keepAliveMax = 3600
+	// the upper bound of the number of the connections sending events
+	eventConnectionsMax = 8
+	// the upper bound of the send timeout in seconds
+	sendTimeoutMax = 60
+)
+
+// The compression algorithms of the messages sent to the collector
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
 )
 
 // ReporterOptions defines the options of a reporter. The fields of it
@@ -41,6 +62,36 @@ type ReporterOptions struct {
 
 	// The maximum retries
 	MaxRetries int `yaml:"MaxRetries,omitempty" default:"20"`
+
+	// The timeout in seconds of establishing a connection to the collector
+	DialTimeout int64 `yaml:"DialTimeout,omitempty" env:"APPOPTICS_DIAL_TIMEOUT" default:"10"`
+
+	// The TCP keepalive period in seconds of the connection to the collector.
+	// The keepalive is disabled if it's 0.
+	KeepAlive int64 `yaml:"KeepAlive,omitempty" env:"APPOPTICS_TCP_KEEPALIVE" default:"30"`
+
+	// The number of the connections to the collector which the event batches
+	// are sent over in a round-robin manner, skipping the busy ones, so that a
+	// slow batch doesn't hold back the others under high flush rates.
+	EventConnections int64 `yaml:"EventConnections,omitempty" env:"APPOPTICS_EVENT_CONNECTIONS" default:"1"`
+
+	// The timeout in seconds of sending a message to the collector and
+	// receiving its response, so a collector which accepts the connection
+	// but responds slowly doesn't hold back the queue for too long.
+	SendTimeout int64 `yaml:"SendTimeout,omitempty" env:"APPOPTICS_SEND_TIMEOUT" default:"10"`
+
+	// The number of retries of a message after its send times out, before the
+	// message is dropped. The timed out sends are retried like the other
+	// failures if it's negative.
+	SendTimeoutRetries int64 `yaml:"SendTimeoutRetries,omitempty" env:"APPOPTICS_SEND_TIMEOUT_RETRIES" default:"-1"`
+
+	// The compression of the batches sent to the collector: none or gzip.
+	Compression string `yaml:"Compression,omitempty" env:"APPOPTICS_COMPRESSION" default:"none"`
+
+	// The minimum size in bytes of a batch to be compressed. The smaller
+	// batches are sent uncompressed as compressing them costs more CPU than
+	// it saves and may even inflate them.
+	CompressionThreshold int64 `yaml:"CompressionThreshold,omitempty" env:"APPOPTICS_COMPRESSION_THRESHOLD" default:"1024"`
 }
 
 // SetEventFlushInterval sets the event flush interval to i
@@ -65,7 +116,93 @@ func (r *ReporterOptions) GetEventFlushBatchSize() int64 {
 	return atomic.LoadInt64(&r.EventFlushBatchSize)
 }
 
+// GetDialTimeout returns the dial timeout in seconds
+func (r *ReporterOptions) GetDialTimeout() int64 {
+	return atomic.LoadInt64(&r.DialTimeout)
+}
+
+// GetKeepAlive returns the TCP keepalive period in seconds
+func (r *ReporterOptions) GetKeepAlive() int64 {
+	return atomic.LoadInt64(&r.KeepAlive)
+}
+
+// GetEventConnections returns the number of the connections sending events
+func (r *ReporterOptions) GetEventConnections() int64 {
+	return atomic.LoadInt64(&r.EventConnections)
+}
+
+// GetSendTimeout returns the send timeout in seconds
+func (r *ReporterOptions) GetSendTimeout() int64 {
+	return atomic.LoadInt64(&r.SendTimeout)
+}
+
+// GetSendTimeoutRetries returns the number of retries after a send times out
+func (r *ReporterOptions) GetSendTimeoutRetries() int64 {
+	return atomic.LoadInt64(&r.SendTimeoutRetries)
+}
+
+// GetCompression returns the compression of the batches sent to the collector.
+// It's set on loading the config only, hence not accessed atomically.
+func (r *ReporterOptions) GetCompression() string {
+	return r.Compression
+}
+
+// GetCompressionThreshold returns the minimum size in bytes of a batch to be
+// compressed
+func (r *ReporterOptions) GetCompressionThreshold() int64 {
+	return atomic.LoadInt64(&r.CompressionThreshold)
+}
+
 func (r *ReporterOptions) validate() error {
-	// TODO
+	if r.DialTimeout <= 0 {
+		log.Warning(InvalidEnv("DialTimeout", strconv.FormatInt(r.DialTimeout, 10)))
+		r.DialTimeout, _ = strconv.ParseInt(getFieldDefaultValue(r, "DialTimeout"), 10, 64)
+	} else if r.DialTimeout > dialTimeoutMax {
+		log.Warningf("DialTimeout %d is too large, use %d instead.", r.DialTimeout, dialTimeoutMax)
+		r.DialTimeout = dialTimeoutMax
+	}
+
+	if r.KeepAlive < 0 {
+		log.Warning(InvalidEnv("KeepAlive", strconv.FormatInt(r.KeepAlive, 10)))
+		r.KeepAlive, _ = strconv.ParseInt(getFieldDefaultValue(r, "KeepAlive"), 10, 64)
+	} else if r.KeepAlive > keepAliveMax {
+		log.Warningf("KeepAlive %d is too large, use %d instead.", r.KeepAlive, keepAliveMax)
+		r.KeepAlive = keepAliveMax
+	}
+
+	if r.EventConnections <= 0 {
+		log.Warning(InvalidEnv("EventConnections", strconv.FormatInt(r.EventConnections, 10)))
+		r.EventConnections, _ = strconv.ParseInt(getFieldDefaultValue(r, "EventConnections"), 10, 64)
+	} else if r.EventConnections > eventConnectionsMax {
+		log.Warningf("EventConnections %d is too large, use %d instead.", r.EventConnections, eventConnectionsMax)
+		r.EventConnections = eventConnectionsMax
+	}
+
+	if r.SendTimeout <= 0 {
+		log.Warning(InvalidEnv("SendTimeout", strconv.FormatInt(r.SendTimeout, 10)))
+		r.SendTimeout, _ = strconv.ParseInt(getFieldDefaultValue(r, "SendTimeout"), 10, 64)
+	} else if r.SendTimeout > sendTimeoutMax {
+		log.Warningf("SendTimeout %d is too large, use %d instead.", r.SendTimeout, sendTimeoutMax)
+		r.SendTimeout = sendTimeoutMax
+	}
+
+	if r.SendTimeoutRetries < -1 {
+		log.Warning(InvalidEnv("SendTimeoutRetries", strconv.FormatInt(r.SendTimeoutRetries, 10)))
+		r.SendTimeoutRetries, _ = strconv.ParseInt(getFieldDefaultValue(r, "SendTimeoutRetries"), 10, 64)
+	}
+
+	switch r.Compression = strings.ToLower(strings.TrimSpace(r.Compression)); r.Compression {
+	case CompressionNone, CompressionGzip:
+	case "":
+		r.Compression = CompressionNone
+	default:
+		log.Warning(InvalidEnv("Compression", r.Compression))
+		r.Compression = getFieldDefaultValue(r, "Compression")
+	}
+
+	if r.CompressionThreshold < 0 {
+		log.Warning(InvalidEnv("CompressionThreshold", strconv.FormatInt(r.CompressionThreshold, 10)))
+		r.CompressionThreshold, _ = strconv.ParseInt(getFieldDefaultValue(r, "CompressionThreshold"), 10, 64)
+	}
 	return nil
 }
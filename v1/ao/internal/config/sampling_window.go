@@ -0,0 +1,134 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/pkg/errors"
+)
+
+// SamplingWindow defines the sample rate used within a time window of every
+// day, e.g., a higher sample rate during the maintenance window.
+type SamplingWindow struct {
+	// The start and end time of the window in the 24-hour format "HH:MM". The
+	// start time is inclusive and the end time is exclusive. The window spans
+	// midnight if the end time is earlier than the start time.
+	Start string `yaml:"Start"`
+	End   string `yaml:"End"`
+	// The sample rate within the window, from 0 to 1000000.
+	SampleRate int `yaml:"SampleRate"`
+}
+
+// SamplingWindow validation errors
+var (
+	ErrSWInvalidTime       = errors.New("invalid time, it should be in the format of HH:MM")
+	ErrSWInvalidSampleRate = errors.New("invalid SampleRate")
+)
+
+// parseClock converts the time of day in "HH:MM" format into the minutes
+// since midnight.
+func parseClock(s string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return 0, ErrSWInvalidTime
+	}
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, ErrSWInvalidTime
+	}
+	min, err := strconv.Atoi(parts[1])
+	if err != nil || min < 0 || min > 59 {
+		return 0, ErrSWInvalidTime
+	}
+	return hour*60 + min, nil
+}
+
+// clockWindow is a sampling window with the start and end time parsed into the
+// minutes since midnight.
+type clockWindow struct {
+	start, end int
+	rate       int
+}
+
+// parse parses the window and checks if it's valid.
+func (w SamplingWindow) parse() (clockWindow, error) {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return clockWindow{}, errors.Wrap(err, fmt.Sprintf("Start \"%s\"", w.Start))
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return clockWindow{}, errors.Wrap(err, fmt.Sprintf("End \"%s\"", w.End))
+	}
+	if !IsValidSampleRate(w.SampleRate) {
+		return clockWindow{}, ErrSWInvalidSampleRate
+	}
+	return clockWindow{start: start, end: end, rate: w.SampleRate}, nil
+}
+
+// Contains checks if the time of day of t is within the window. The caller
+// needs to convert t to the desired timezone first. A window with the same
+// start and end time contains the whole day.
+func (w SamplingWindow) Contains(t time.Time) bool {
+	cw, err := w.parse()
+	if err != nil {
+		return false
+	}
+	return cw.contains(t)
+}
+
+func (w clockWindow) contains(t time.Time) bool {
+	now := t.Hour()*60 + t.Minute()
+	switch {
+	case w.start < w.end:
+		return now >= w.start && now < w.end
+	case w.start > w.end: // spans midnight
+		return now >= w.start || now < w.end
+	default:
+		return true
+	}
+}
+
+// validSamplingWindows returns the valid sampling windows and the parsed ones,
+// the invalid ones are dropped with a warning.
+func validSamplingWindows(windows []SamplingWindow) ([]SamplingWindow, []clockWindow) {
+	var valid []SamplingWindow
+	var parsed []clockWindow
+	for _, w := range windows {
+		cw, err := w.parse()
+		if err != nil {
+			log.Warningf("Ignore the invalid sampling window %+v: %v", w, err)
+			continue
+		}
+		valid = append(valid, w)
+		parsed = append(parsed, cw)
+	}
+	return valid, parsed
+}
+
+// the locations loaded, keyed by the timezone names
+var locations sync.Map
+
+// loadLocation returns the location of the timezone name, which is cached as
+// time.LoadLocation reads the timezone database. The local timezone is
+// returned for an empty name.
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
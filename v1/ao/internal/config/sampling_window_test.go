@@ -0,0 +1,80 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSamplingWindowContains(t *testing.T) {
+	at := func(clock string) time.Time {
+		tm, err := time.Parse("15:04", clock)
+		require.NoError(t, err)
+		return tm
+	}
+	testCases := []struct {
+		window   SamplingWindow
+		clock    string
+		contains bool
+	}{
+		{SamplingWindow{"01:00", "03:30", 1000000}, "01:00", true},
+		{SamplingWindow{"01:00", "03:30", 1000000}, "03:29", true},
+		{SamplingWindow{"01:00", "03:30", 1000000}, "03:30", false},
+		{SamplingWindow{"01:00", "03:30", 1000000}, "00:59", false},
+		{SamplingWindow{"22:00", "02:00", 1000000}, "23:15", true},
+		{SamplingWindow{"22:00", "02:00", 1000000}, "01:59", true},
+		{SamplingWindow{"22:00", "02:00", 1000000}, "12:00", false},
+		{SamplingWindow{"00:00", "00:00", 1000000}, "12:00", true},
+		{SamplingWindow{"bad", "02:00", 1000000}, "01:00", false},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.contains, tc.window.Contains(at(tc.clock)), "%+v %s", tc.window, tc.clock)
+	}
+}
+
+func TestSamplingWindowsConfig(t *testing.T) {
+	yaml := `
+ServiceKey: ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189218:go
+SamplingTimezone: America/Vancouver
+SamplingWindows:
+  - Start: "01:00"
+    End: "03:00"
+    SampleRate: 1000000
+  - Start: "25:00"
+    End: "03:00"
+    SampleRate: 1000000
+  - Start: "04:00"
+    End: "05:00"
+    SampleRate: 2000000
+`
+	require.NoError(t, ioutil.WriteFile("/tmp/appoptics-windows.yaml", []byte(yaml), 0644))
+	defer os.Remove("/tmp/appoptics-windows.yaml")
+
+	ClearEnvs()
+	os.Setenv(EnvAppOpticsConfigFile, "/tmp/appoptics-windows.yaml")
+	defer ClearEnvs()
+
+	c := NewConfig()
+	// the invalid windows are dropped
+	assert.Equal(t, []SamplingWindow{{"01:00", "03:00", 1000000}}, c.GetSamplingWindows())
+	assert.Equal(t, "America/Vancouver", c.GetSamplingLocation().String())
+
+	// the windows are matched in their timezone
+	loc := c.GetSamplingLocation()
+	rate, ok := c.GetSamplingWindowRate(time.Date(2019, 6, 1, 2, 0, 0, 0, loc).UTC())
+	assert.True(t, ok)
+	assert.Equal(t, 1000000, rate)
+	_, ok = c.GetSamplingWindowRate(time.Date(2019, 6, 1, 4, 30, 0, 0, loc))
+	assert.False(t, ok)
+
+	os.Setenv("APPOPTICS_SAMPLING_TIMEZONE", "Invalid/Timezone")
+	c = NewConfig()
+	assert.Equal(t, "", c.SamplingTimezone)
+	assert.Equal(t, time.Local, c.GetSamplingLocation())
+}
@@ -3,11 +3,21 @@
 package config
 
 import (
+	"crypto/x509"
 	"fmt"
+	"math"
+	"net"
+	"net/textproto"
+	"net/url"
+	"path"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"unicode/utf8"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 )
 
 // InvalidEnv returns a string indicating invalid environment variables
@@ -80,16 +90,30 @@ func IsValidHost(host string) bool {
 	return host != ""
 }
 
+// IsValidHTTPURL checks if the string is an absolute HTTP or HTTPS URL
+func IsValidHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 // IsValidFile checks if the string represents a valid file.
 func IsValidFile(file string) bool {
 	// TODO
 	return true
 }
 
+// IsValidPEM checks if the string contains at least one PEM encoded cert.
+func IsValidPEM(cert string) bool {
+	return x509.NewCertPool().AppendCertsFromPEM([]byte(cert))
+}
+
 // IsValidReporterType checks if the reporter type is valid.
 func IsValidReporterType(t string) bool {
 	t = strings.ToLower(strings.TrimSpace(t))
-	return t == "ssl" || t == "udp"
+	return t == "ssl" || t == "udp" || t == "pretty"
 }
 
 // IsValidTracingMode checks if the mode is valid
@@ -117,6 +141,16 @@ func NormalizeTracingMode(m TracingMode) TracingMode {
 	return mode
 }
 
+// the pattern of a valid metrics namespace, which is a valid Prometheus metric
+// name without the colons
+var metricsNamespaceRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// IsValidMetricsNamespace checks if the namespace of the exported metrics is
+// valid
+func IsValidMetricsNamespace(ns string) bool {
+	return metricsNamespaceRegex.MatchString(ns)
+}
+
 // IsValidHostnameAlias checks if the alias is valid
 func IsValidHostnameAlias(a string) bool {
 	return true
@@ -149,3 +183,304 @@ func MaskServiceKey(validKey string) string {
 
 	return tk + sep + s[1]
 }
+
+// ToHeaderNames canonicalizes the HTTP header names and removes the empty and
+// duplicate ones. Only the first MaxCapturedHeaders names are kept to limit the
+// number of KVs.
+func ToHeaderNames(names []string) []string {
+	var headers []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		if len(headers) == MaxCapturedHeaders {
+			log.Warningf("Too many headers to capture, only the first %d are kept: %v",
+				MaxCapturedHeaders, headers)
+			break
+		}
+		seen[name] = true
+		headers = append(headers, name)
+	}
+	return headers
+}
+
+// ToHistogramBuckets sorts the histogram bucket bounds in ascending order and
+// removes the non-positive, infinite and duplicate ones. The +Inf bucket is
+// always implied so it's not needed.
+func ToHistogramBuckets(bounds []float64) []float64 {
+	var buckets []float64
+	for _, b := range bounds {
+		if b <= 0 || math.IsInf(b, 0) || math.IsNaN(b) {
+			log.Warningf("Ignore the invalid histogram bucket: %v", b)
+			continue
+		}
+		buckets = append(buckets, b)
+	}
+	sort.Float64s(buckets)
+
+	var uniq []float64
+	for i, b := range buckets {
+		if i == 0 || b != buckets[i-1] {
+			uniq = append(uniq, b)
+		}
+	}
+	return uniq
+}
+
+// validSQLSampleRates returns the SQL sample rates with the statement types in
+// upper case, the invalid sample rates are dropped with a warning.
+func validSQLSampleRates(rates map[string]int) map[string]int {
+	if len(rates) == 0 {
+		return nil
+	}
+	valid := make(map[string]int)
+	for typ, rate := range rates {
+		if !IsValidSampleRate(rate) {
+			log.Warningf("Ignore the invalid sample rate of SQL statement %s: %d", typ, rate)
+			continue
+		}
+		valid[strings.ToUpper(strings.TrimSpace(typ))] = rate
+	}
+	return valid
+}
+
+// validOriginSampleRates returns the sample rates of the trace origins with the
+// origins in lower case, the unknown origins and invalid sample rates are
+// dropped with a warning.
+func validOriginSampleRates(rates map[string]int) map[string]int {
+	if len(rates) == 0 {
+		return nil
+	}
+	valid := make(map[string]int)
+	for origin, rate := range rates {
+		o := strings.ToLower(strings.TrimSpace(origin))
+		switch o {
+		case TraceOriginHTTP, TraceOriginGRPC, TraceOriginJob, TraceOriginManual:
+		default:
+			log.Warningf("Ignore the sample rate of the unknown trace origin %s", origin)
+			continue
+		}
+		if !IsValidSampleRate(rate) {
+			log.Warningf("Ignore the invalid sample rate of trace origin %s: %d", origin, rate)
+			continue
+		}
+		valid[o] = rate
+	}
+	return valid
+}
+
+// statusCodeRange is the inclusive bounds of a range of HTTP status codes.
+type statusCodeRange struct {
+	lo, hi int
+}
+
+// parseStatusCodeRange parses an HTTP status code, e.g., 404, or a range of
+// them, e.g., 400-499, into the inclusive bounds of the range.
+func parseStatusCodeRange(s string) (statusCodeRange, error) {
+	bounds := strings.SplitN(s, "-", 2)
+	lo, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return statusCodeRange{}, err
+	}
+	hi := lo
+	if len(bounds) == 2 {
+		if hi, err = strconv.Atoi(strings.TrimSpace(bounds[1])); err != nil {
+			return statusCodeRange{}, err
+		}
+	}
+	if lo < 100 || hi > 599 || lo > hi {
+		return statusCodeRange{}, fmt.Errorf("invalid status code range: %s", s)
+	}
+	return statusCodeRange{lo: lo, hi: hi}, nil
+}
+
+// validStatusCodeRanges returns the valid HTTP status codes and ranges along
+// with the parsed ones, the invalid ones are dropped with a warning.
+func validStatusCodeRanges(ranges []string) ([]string, []statusCodeRange) {
+	var valid []string
+	var parsed []statusCodeRange
+	for _, r := range ranges {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		sr, err := parseStatusCodeRange(r)
+		if err != nil {
+			log.Warningf("Ignore the invalid error status code: %s", r)
+			continue
+		}
+		valid = append(valid, r)
+		parsed = append(parsed, sr)
+	}
+	return valid, parsed
+}
+
+// validMetricTags returns the global metric tags with the empty names dropped.
+// Only the first MaxMetricTags tags, in the order of the names, are kept to
+// limit the cardinality of the metrics.
+func validMetricTags(tags map[string]string) map[string]string {
+	if len(tags) == 0 {
+		return nil
+	}
+	var names []string
+	for name := range tags {
+		if strings.TrimSpace(name) != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if len(names) > MaxMetricTags {
+		log.Warningf("Too many metric tags, only the first %d are kept: %v",
+			MaxMetricTags, names[:MaxMetricTags])
+		names = names[:MaxMetricTags]
+	}
+
+	valid := make(map[string]string)
+	for _, name := range names {
+		valid[strings.TrimSpace(name)] = strings.TrimSpace(tags[name])
+	}
+	return valid
+}
+
+// TenantServiceKeys are the service keys keyed by the tenant names. The keys
+// are masked when it's printed.
+type TenantServiceKeys map[string]string
+
+func (keys TenantServiceKeys) String() string {
+	var tenants []string
+	for tenant := range keys {
+		tenants = append(tenants, tenant)
+	}
+	sort.Strings(tenants)
+	var s []string
+	for _, tenant := range tenants {
+		s = append(s, tenant+"="+MaskServiceKey(keys[tenant]))
+	}
+	return strings.Join(s, ",")
+}
+
+// validTenantServiceKeys returns the service keys of the tenants, normalized
+// as the ServiceKey. The tenants with invalid service keys are dropped.
+func validTenantServiceKeys(keys TenantServiceKeys) TenantServiceKeys {
+	if len(keys) == 0 {
+		return nil
+	}
+	valid := make(TenantServiceKeys)
+	for tenant, key := range keys {
+		tenant = strings.TrimSpace(tenant)
+		key = ToServiceKey(strings.TrimSpace(key))
+		if tenant == "" || !IsValidServiceKey(key) {
+			log.Warningf("Ignore the invalid service key of tenant %s: %s", tenant, MaskServiceKey(key))
+			continue
+		}
+		valid[tenant] = key
+	}
+	return valid
+}
+
+// validTrustedProxies returns the valid IP addresses and CIDRs of the trusted
+// proxies and their parsed networks, the invalid ones are dropped with a
+// warning. An IP address is a network of its own.
+func validTrustedProxies(proxies []string) ([]string, []*net.IPNet) {
+	var valid []string
+	var nets []*net.IPNet
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				log.Warning(InvalidEnv("TrustedProxies", p))
+				continue
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			n = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+		valid = append(valid, p)
+		nets = append(nets, n)
+	}
+	return valid, nets
+}
+
+// validBlockedSpans returns the names of the blocked spans with the empty
+// names and invalid glob patterns dropped.
+func validBlockedSpans(names []string) []string {
+	var valid []string
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, err := path.Match(name, ""); err != nil {
+			log.Warning(InvalidEnv("BlockedSpans", name))
+			continue
+		}
+		valid = append(valid, name)
+	}
+	return valid
+}
+
+// validHTTPKVs returns the keys of the HTTP KVs with the empty ones dropped.
+func validHTTPKVs(keys []string) []string {
+	var valid []string
+	for _, key := range keys {
+		if key = strings.TrimSpace(key); key != "" {
+			valid = append(valid, key)
+		}
+	}
+	return valid
+}
+
+// the value rendered in place of the value of a secret field
+const maskedValue = "****"
+
+// validSecretFields returns the names of the secret fields with the empty
+// names dropped.
+func validSecretFields(names []string) []string {
+	var valid []string
+	for _, name := range names {
+		if name = strings.TrimSpace(name); name != "" {
+			valid = append(valid, name)
+		}
+	}
+	return valid
+}
+
+// isSecretField checks if the config item or KV named key is one of the
+// secret fields. The name of a nested config item, e.g.,
+// ReporterProperties.Proxy, is matched by either its full name or the name of
+// the field.
+func isSecretField(key string, secretFields []string) bool {
+	field := key[strings.LastIndex(key, ".")+1:]
+	for _, name := range secretFields {
+		if strings.EqualFold(name, key) || strings.EqualFold(name, field) {
+			return true
+		}
+	}
+	return false
+}
+
+// the regular expressions compiled, keyed by the expressions
+var regexes sync.Map
+
+// compileRegex returns the compiled regular expression, which is cached as
+// it's matched against every KV.
+func compileRegex(expr string) (*regexp.Regexp, error) {
+	if re, ok := regexes.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	regexes.Store(expr, re)
+	return re, nil
+}
@@ -4,6 +4,8 @@ package config
 
 import (
 	"fmt"
+	"math"
+	"net"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -68,6 +70,7 @@ func TestIsValidReporterType(t *testing.T) {
 	assert.Equal(t, true, IsValidReporterType("udp"))
 	assert.Equal(t, true, IsValidReporterType("ssl"))
 	assert.Equal(t, true, IsValidReporterType("Udp"))
+	assert.Equal(t, true, IsValidReporterType("pretty"))
 	assert.Equal(t, false, IsValidReporterType("xxx"))
 	assert.Equal(t, false, IsValidReporterType(""))
 	assert.Equal(t, false, IsValidReporterType("udpabc"))
@@ -81,6 +84,95 @@ func TestConverters(t *testing.T) {
 	assert.Equal(t, DisabledTracingMode, NormalizeTracingMode("NEVER"))
 }
 
+func TestToHeaderNames(t *testing.T) {
+	assert.Equal(t, []string{"Cache-Status", "X-Served-By"},
+		ToHeaderNames([]string{"cache-status", " X-SERVED-BY", "", "Cache-Status"}))
+	assert.Nil(t, ToHeaderNames(nil))
+
+	var names []string
+	for i := 0; i < MaxCapturedHeaders+5; i++ {
+		names = append(names, fmt.Sprintf("X-Header-%d", i))
+	}
+	assert.Len(t, ToHeaderNames(names), MaxCapturedHeaders)
+}
+
+func TestToHistogramBuckets(t *testing.T) {
+	assert.Equal(t, []float64{0.1, 0.5, 1},
+		ToHistogramBuckets([]float64{1, 0.5, -1, 0, 0.1, math.Inf(1), 0.5}))
+	assert.Nil(t, ToHistogramBuckets(nil))
+}
+
+func TestValidSQLSampleRates(t *testing.T) {
+	assert.Equal(t, map[string]int{"SELECT": 100000, "INSERT": 0},
+		validSQLSampleRates(map[string]int{"select": 100000, "INSERT": 0, "DELETE": -1}))
+	assert.Nil(t, validSQLSampleRates(nil))
+}
+
+func TestSQLSampleRate(t *testing.T) {
+	rates := validSQLSampleRates(map[string]int{"select": 100000, "INSERT": 0})
+	rate, ok := SQLSampleRate(rates, "Select")
+	assert.True(t, ok)
+	assert.Equal(t, 100000, rate)
+	rate, ok = SQLSampleRate(rates, "INSERT")
+	assert.True(t, ok)
+	assert.Equal(t, 0, rate)
+	rate, ok = SQLSampleRate(rates, "UPDATE")
+	assert.False(t, ok)
+	assert.Equal(t, MaxSampleRate, rate)
+	_, ok = SQLSampleRate(nil, "SELECT")
+	assert.False(t, ok)
+}
+
+func TestValidStatusCodeRanges(t *testing.T) {
+	valid, parsed := validStatusCodeRanges([]string{" 404", "", "500-599", "600", "499-400", "4xx"})
+	assert.Equal(t, []string{"404", "500-599"}, valid)
+	assert.Equal(t, []statusCodeRange{{404, 404}, {500, 599}}, parsed)
+	valid, parsed = validStatusCodeRanges(nil)
+	assert.Nil(t, valid)
+	assert.Nil(t, parsed)
+}
+
+func TestValidOriginSampleRates(t *testing.T) {
+	assert.Equal(t, map[string]int{"http": 100000, "job": 0},
+		validOriginSampleRates(map[string]int{" HTTP": 100000, "job": 0, "grpc": -1, "queue": 10}))
+	assert.Nil(t, validOriginSampleRates(nil))
+}
+
+func TestValidMetricTags(t *testing.T) {
+	assert.Equal(t, map[string]string{"env": "prod", "region": "us-east-1"},
+		validMetricTags(map[string]string{" env": "prod ", "region": "us-east-1", "": "x"}))
+	assert.Nil(t, validMetricTags(nil))
+
+	tags := make(map[string]string)
+	for i := 0; i < MaxMetricTags+5; i++ {
+		tags[fmt.Sprintf("tag%02d", i)] = "v"
+	}
+	valid := validMetricTags(tags)
+	assert.Len(t, valid, MaxMetricTags)
+	assert.Contains(t, valid, "tag00")
+}
+
+func TestValidBlockedSpans(t *testing.T) {
+	assert.Equal(t, []string{"redis-ping", "grpc.health.*"},
+		validBlockedSpans([]string{" redis-ping", "", "grpc.health.*", "bad["}))
+	assert.Nil(t, validBlockedSpans(nil))
+}
+
+func TestValidTrustedProxies(t *testing.T) {
+	valid, nets := validTrustedProxies([]string{" 10.0.0.0/8", "", "192.0.2.1", "2001:db8::1", "bogus"})
+	assert.Equal(t, []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::1"}, valid)
+	assert.Len(t, nets, 3)
+	assert.True(t, nets[0].Contains(net.ParseIP("10.1.2.3")))
+	assert.True(t, nets[1].Contains(net.ParseIP("192.0.2.1")))
+	assert.False(t, nets[1].Contains(net.ParseIP("192.0.2.2")))
+	assert.True(t, nets[2].Contains(net.ParseIP("2001:db8::1")))
+}
+
+func TestValidHTTPKVs(t *testing.T) {
+	assert.Equal(t, []string{"URL", "Query-String"}, validHTTPKVs([]string{" URL", "", "Query-String "}))
+	assert.Nil(t, validHTTPKVs(nil))
+}
+
 func withDemoKey(sn string) string {
 	return "demo_service_key:" + sn
 }
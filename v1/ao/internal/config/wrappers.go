@@ -13,6 +13,9 @@ var GetServiceKey = conf.GetServiceKey
 // GetTrustedPath is a wrapper to the method of the global config
 var GetTrustedPath = conf.GetTrustedPath
 
+// GetTrustedCert is a wrapper to the method of the global config
+var GetTrustedCert = conf.GetTrustedCert
+
 // GetReporterType is a wrapper to the method of the global config
 var GetReporterType = conf.GetReporterType
 
@@ -22,9 +25,24 @@ var GetTracingMode = conf.GetTracingMode
 // GetSampleRate is a wrapper to the method of the global config
 var GetSampleRate = conf.GetSampleRate
 
+// SetSampleRate is a wrapper to the method of the global config
+var SetSampleRate = conf.SetSampleRate
+
+// SetTracingMode is a wrapper to the method of the global config
+var SetTracingMode = conf.SetTracingMode
+
+// GetSampling is a wrapper to the method of the global config
+var GetSampling = conf.GetSampling
+
+// SetSampling is a wrapper to the method of the global config
+var SetSampling = conf.SetSampling
+
 // SamplingConfigured is a wrapper to the method of the global config
 var SamplingConfigured = conf.SamplingConfigured
 
+// TransactionSamplingConfigured is a wrapper to the method of the global config
+var TransactionSamplingConfigured = conf.TransactionSamplingConfigured
+
 // GetCollectorUDP is a wrapper to the method of the global config
 var GetCollectorUDP = conf.GetCollectorUDP
 
@@ -52,5 +70,188 @@ var DebugLevel = conf.GetDebugLevel
 // GetTransactionFiltering is a wrapper to the method of the global config
 var GetTransactionFiltering = conf.GetTransactionFiltering
 
+// GetSamplingWindows is a wrapper to the method of the global config
+var GetSamplingWindows = conf.GetSamplingWindows
+
+// GetSamplingWindowRate is a wrapper to the method of the global config
+var GetSamplingWindowRate = conf.GetSamplingWindowRate
+
+// GetSamplingLocation is a wrapper to the method of the global config
+var GetSamplingLocation = conf.GetSamplingLocation
+
+// GetFailClosed is a wrapper to the method of the global config
+var GetFailClosed = conf.GetFailClosed
+
+// GetLazyStart is a wrapper to the method of the global config
+var GetLazyStart = conf.GetLazyStart
+
+// GetKeepErrors is a wrapper to the method of the global config
+var GetKeepErrors = conf.GetKeepErrors
+
+// GetKeepErrorsRate is a wrapper to the method of the global config
+var GetKeepErrorsRate = conf.GetKeepErrorsRate
+
+// GetAdaptiveSampling is a wrapper to the method of the global config
+var GetAdaptiveSampling = conf.GetAdaptiveSampling
+
+// GetAdaptiveSamplingBudget is a wrapper to the method of the global config
+var GetAdaptiveSamplingBudget = conf.GetAdaptiveSamplingBudget
+
+// GetLoadError is a wrapper to the method of the global config
+var GetLoadError = conf.GetLoadError
+
+// GetSpanDepthDecay is a wrapper to the method of the global config
+var GetSpanDepthDecay = conf.GetSpanDepthDecay
+
+// GetRuntimeKVs is a wrapper to the method of the global config
+var GetRuntimeKVs = conf.GetRuntimeKVs
+
+// GetQueueTimeMetric is a wrapper to the method of the global config
+var GetQueueTimeMetric = conf.GetQueueTimeMetric
+
+// GetRequestHeaders is a wrapper to the method of the global config
+var GetRequestHeaders = conf.GetRequestHeaders
+
+// GetResponseHeaders is a wrapper to the method of the global config
+var GetResponseHeaders = conf.GetResponseHeaders
+
+// GetHistogramBuckets is a wrapper to the method of the global config
+var GetHistogramBuckets = conf.GetHistogramBuckets
+
+// GetSQLSampleRates is a wrapper to the method of the global config
+var GetSQLSampleRates = conf.GetSQLSampleRates
+
+// GetMetricTags is a wrapper to the method of the global config
+var GetMetricTags = conf.GetMetricTags
+
+// IsRedactedKey is a wrapper to the method of the global config
+var IsRedactedKey = conf.IsRedactedKey
+
+// GetApdexThreshold is a wrapper to the method of the global config
+var GetApdexThreshold = conf.GetApdexThreshold
+
+// GetPropagateUnsampled is a wrapper to the method of the global config
+var GetPropagateUnsampled = conf.GetPropagateUnsampled
+
+// GetReportDroppedEvents is a wrapper to the method of the global config
+var GetReportDroppedEvents = conf.GetReportDroppedEvents
+
+// GetMaxHeaderSize is a wrapper to the method of the global config
+var GetMaxHeaderSize = conf.GetMaxHeaderSize
+
+// GetConfigHash is a wrapper to the method of the global config
+var GetConfigHash = conf.GetConfigHash
+
+// GetTrustForwardedFor is a wrapper to the method of the global config
+var GetTrustForwardedFor = conf.GetTrustForwardedFor
+
+// IsTrustedProxy is a wrapper to the method of the global config
+var IsTrustedProxy = conf.IsTrustedProxy
+
+// GetTenantServiceKey is a wrapper to the method of the global config
+var GetTenantServiceKey = conf.GetTenantServiceKey
+
+// GetTenantServiceKeys is a wrapper to the method of the global config
+var GetTenantServiceKeys = conf.GetTenantServiceKeys
+
+// GetTrimTrailingSlash is a wrapper to the method of the global config
+var GetTrimTrailingSlash = conf.GetTrimTrailingSlash
+
+// GetLowercaseTransactionName is a wrapper to the method of the global config
+var GetLowercaseTransactionName = conf.GetLowercaseTransactionName
+
+// GetWallClockTimestamps is a wrapper to the method of the global config
+var GetWallClockTimestamps = conf.GetWallClockTimestamps
+
+// GetMinSpanDuration is a wrapper to the method of the global config
+var GetMinSpanDuration = conf.GetMinSpanDuration
+
+// GetDisableMetrics is a wrapper to the method of the global config
+var GetDisableMetrics = conf.GetDisableMetrics
+
+// GetIgnoreRemoteFlags is a wrapper to the method of the global config
+var GetIgnoreRemoteFlags = conf.GetIgnoreRemoteFlags
+
+// GetMergeSiblingSpans is a wrapper to the method of the global config
+var GetMergeSiblingSpans = conf.GetMergeSiblingSpans
+
+// GetUDPSendBuffer is a wrapper to the method of the global config
+var GetUDPSendBuffer = conf.GetUDPSendBuffer
+
+// GetStatsDAddr is a wrapper to the method of the global config
+var GetStatsDAddr = conf.GetStatsDAddr
+
+// GetStatsDTagFormat is a wrapper to the method of the global config
+var GetStatsDTagFormat = conf.GetStatsDTagFormat
+
+// GetStatsDOnly is a wrapper to the method of the global config
+var GetStatsDOnly = conf.GetStatsDOnly
+
+// GetMetricsNamespace is a wrapper to the method of the global config
+var GetMetricsNamespace = conf.GetMetricsNamespace
+
+// GetMaxTimestampSkew is a wrapper to the method of the global config
+var GetMaxTimestampSkew = conf.GetMaxTimestampSkew
+
+// GetSkewedEvents is a wrapper to the method of the global config
+var GetSkewedEvents = conf.GetSkewedEvents
+
+// GetControlSocket is a wrapper to the method of the global config
+var GetControlSocket = conf.GetControlSocket
+
+// IsBlockedSpan is a wrapper to the method of the global config
+var IsBlockedSpan = conf.IsBlockedSpan
+
+// GetMaxTransactions is a wrapper to the method of the global config
+var GetMaxTransactions = conf.GetMaxTransactions
+
+// GetGRPCTransactionNaming is a wrapper to the method of the global config
+var GetGRPCTransactionNaming = conf.GetGRPCTransactionNaming
+
+// GetDurationPrecision is a wrapper to the method of the global config
+var GetDurationPrecision = conf.GetDurationPrecision
+
+// GetOriginSampleRate is a wrapper to the method of the global config
+var GetOriginSampleRate = conf.GetOriginSampleRate
+
+// GetRetroactiveCaptureWindow is a wrapper to the method of the global config
+var GetRetroactiveCaptureWindow = conf.GetRetroactiveCaptureWindow
+
+// GetHeaderValidation is a wrapper to the method of the global config
+var GetHeaderValidation = conf.GetHeaderValidation
+
+// IsErrorStatusCode is a wrapper to the method of the global config
+var IsErrorStatusCode = conf.IsErrorStatusCode
+
+// GetPrettyTimeFormat is a wrapper to the method of the global config
+var GetPrettyTimeFormat = conf.GetPrettyTimeFormat
+
+// GetServiceVersion is a wrapper to the method of the global config
+var GetServiceVersion = conf.GetServiceVersion
+
+// IsHTTPKVDisabled is a wrapper to the method of the global config
+var IsHTTPKVDisabled = conf.IsHTTPKVDisabled
+
+// GetBaggageLimits is a wrapper to the method of the global config
+var GetBaggageLimits = conf.GetBaggageLimits
+
+// GetReportColdStart is a wrapper to the method of the global config
+var GetReportColdStart = conf.GetReportColdStart
+
+// GetDedupErrors is a wrapper to the method of the global config
+var GetDedupErrors = conf.GetDedupErrors
+
+// GetWatchConfigFile is a wrapper to the method of the global config
+var GetWatchConfigFile = conf.GetWatchConfigFile
+
+// GetRemoteConfig is a wrapper to the method of the global config
+var GetRemoteConfig = conf.GetRemoteConfig
+
+// GetRequestID is a wrapper to the method of the global config
+var GetRequestID = conf.GetRequestID
+
+// ReloadConfig is a wrapper to the method of the global config
+var ReloadConfig = conf.Reload
+
 // Load reads the customized configurations
 var Load = conf.Load
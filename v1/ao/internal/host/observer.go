@@ -4,7 +4,6 @@ package host
 
 import (
 	"io/ioutil"
-	"net/http"
 	"os"
 	"regexp"
 	"strings"
@@ -148,7 +147,7 @@ func getPid() int {
 func getAWSMeta(url string) (meta string) {
 	// Fetch it from the specified URL if the cache is uninitialized or no
 	// cache at all.
-	client := http.Client{Timeout: time.Second}
+	client := utils.NewHTTPClient(time.Second)
 	resp, err := client.Get(url)
 	if err != nil {
 		log.Debugf("Failed to get AWS metadata from %s", url)
@@ -0,0 +1,115 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/hdrhist"
+)
+
+const (
+	// the number of durations recorded by a latency window before it's rotated
+	adaptiveWindowSize = 1000
+	// the minimum number of durations recorded before the outliers are kept
+	adaptiveMinSamples = 20
+	// the percentile above which a duration is an outlier
+	adaptivePercentile = 95
+	// the maximum number of transactions tracked, the others share the
+	// latency window of the OtherTransactionName.
+	adaptiveMaxTransactions = 200
+)
+
+// latencyWindow tracks the running latency distribution of a transaction. The
+// durations are recorded in the current histogram, which replaces the previous
+// one once it's full, so the distribution follows the changes of the latencies.
+type latencyWindow struct {
+	prev *hdrhist.Hist
+	cur  *hdrhist.Hist
+}
+
+func newLatencyHist() *hdrhist.Hist {
+	return hdrhist.WithConfig(hdrhist.Config{
+		LowestDiscernible: 1,
+		HighestTrackable:  3600000000,
+		SigFigs:           2,
+	})
+}
+
+// isOutlier returns if the duration (in microseconds) is above the running p95.
+func (w *latencyWindow) isOutlier(us int64) bool {
+	h := w.prev
+	if h == nil {
+		h = w.cur
+	}
+	if h.TotalCount() < adaptiveMinSamples {
+		return false
+	}
+	return us > h.PercentileVal(adaptivePercentile).Value
+}
+
+func (w *latencyWindow) record(us int64) {
+	if w.cur.TotalCount() >= adaptiveWindowSize {
+		w.prev, w.cur = w.cur, newLatencyHist()
+	}
+	w.cur.Record(us)
+}
+
+// adaptiveSampler decides whether an unsampled trace is kept as a latency
+// outlier of its transaction. The outliers kept are limited by a token bucket
+// refilled at the configured budget.
+type adaptiveSampler struct {
+	sync.Mutex
+	windows map[string]*latencyWindow
+	bucket  *tokenBucket
+}
+
+func newAdaptiveSampler() *adaptiveSampler {
+	budget := config.GetAdaptiveSamplingBudget()
+	return &adaptiveSampler{
+		windows: make(map[string]*latencyWindow),
+		bucket: &tokenBucket{
+			ratePerSec: budget,
+			capacity:   budget,
+			available:  budget,
+			last:       time.Now(),
+		},
+	}
+}
+
+// the adaptive sampler of all the unsampled traces
+var globalAdaptiveSampler = newAdaptiveSampler()
+
+// keep records the duration of a trace of the transaction and returns if the
+// trace is an outlier which is kept within the budget.
+func (s *adaptiveSampler) keep(txn string, d time.Duration) bool {
+	us := int64(d / time.Microsecond)
+	if us < 1 {
+		us = 1
+	}
+
+	s.Lock()
+	w, ok := s.windows[txn]
+	if !ok {
+		if len(s.windows) >= adaptiveMaxTransactions {
+			txn = OtherTransactionName
+			w, ok = s.windows[txn]
+		}
+		if !ok {
+			w = &latencyWindow{cur: newLatencyHist()}
+			s.windows[txn] = w
+		}
+	}
+	outlier := w.isOutlier(us)
+	w.record(us)
+	s.Unlock()
+
+	if !outlier {
+		return false
+	}
+	budget := config.GetAdaptiveSamplingBudget()
+	s.bucket.setRateCap(budget, budget)
+	return s.bucket.consume(1)
+}
@@ -0,0 +1,124 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"math/rand"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setAdaptiveSampling(t *testing.T, budget string) func() {
+	require.NoError(t, os.Setenv("APPOPTICS_ADAPTIVE_SAMPLING", "true"))
+	require.NoError(t, os.Setenv("APPOPTICS_ADAPTIVE_SAMPLING_BUDGET", budget))
+	config.Load()
+	return func() {
+		os.Unsetenv("APPOPTICS_ADAPTIVE_SAMPLING")
+		os.Unsetenv("APPOPTICS_ADAPTIVE_SAMPLING_BUDGET")
+		config.Load()
+	}
+}
+
+// feed records the latencies, which are mostly around 10ms with 2.5% of them
+// around 200ms, and returns the numbers of the fast and slow traces kept.
+func feed(s *adaptiveSampler, n int) (fast, slow int) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		if i%40 == 0 {
+			d := 200*time.Millisecond + time.Duration(rnd.Intn(20))*time.Millisecond
+			if s.keep("txn", d) {
+				slow++
+			}
+		} else {
+			d := 5*time.Millisecond + time.Duration(rnd.Intn(10))*time.Millisecond
+			if s.keep("txn", d) {
+				fast++
+			}
+		}
+	}
+	return fast, slow
+}
+
+func TestAdaptiveSamplerKeepsOutliers(t *testing.T) {
+	defer setAdaptiveSampling(t, "100000")()
+	s := newAdaptiveSampler()
+
+	fast, slow := feed(s, 10000)
+	// 9750 fast and 250 slow traces
+	assert.True(t, slow > 225, "slow kept: %d", slow)
+	assert.True(t, fast < 100, "fast kept: %d", fast)
+	assert.True(t, float64(slow)/250 > 10*float64(fast)/9750)
+}
+
+func TestAdaptiveSamplerBudget(t *testing.T) {
+	defer setAdaptiveSampling(t, "5")()
+	s := newAdaptiveSampler()
+
+	fast, slow := feed(s, 10000)
+	assert.True(t, fast+slow <= 6, "kept: %d", fast+slow)
+	assert.True(t, fast+slow > 0)
+}
+
+func TestAdaptiveSamplerMaxTransactions(t *testing.T) {
+	defer setAdaptiveSampling(t, "100")()
+	s := newAdaptiveSampler()
+
+	for i := 0; i < adaptiveMaxTransactions+10; i++ {
+		s.keep(string(rune('a'+i%26))+string(rune(i)), time.Millisecond)
+	}
+	assert.Len(t, s.windows, adaptiveMaxTransactions+1)
+	assert.Contains(t, s.windows, OtherTransactionName)
+}
+
+func TestAdaptiveSamplingTraceKept(t *testing.T) {
+	defer setAdaptiveSampling(t, "100")()
+	globalAdaptiveSampler = newAdaptiveSampler()
+	defer func() { globalAdaptiveSampler = newAdaptiveSampler() }()
+	for i := 0; i < adaptiveMinSamples; i++ {
+		globalAdaptiveSampler.keep("adaptive", time.Microsecond)
+	}
+	r := SetTestReporter(TestReporterSampleRate(0))
+
+	ctx, ok := NewContext("adaptive", "", true, nil)
+	require.True(t, ok)
+	md := ctx.MetadataString()
+	assert.Equal(t, "00", md[len(md)-2:])
+	assert.NoError(t, ctx.ReportEvent(LabelInfo, "adaptive", "K", "V"))
+	time.Sleep(5 * time.Millisecond)
+	assert.Empty(t, r.EventBufs)
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "adaptive", "TransactionName", "adaptive"))
+
+	r.Close(3)
+	g.AssertGraph(t, r.EventBufs, 3, g.AssertNodeMap{
+		{"adaptive", "entry"}: {},
+		{"adaptive", "info"}:  {Edges: g.Edges{{"adaptive", "entry"}}},
+		{"adaptive", "exit"}:  {Edges: g.Edges{{"adaptive", "info"}}},
+	})
+	assert.Zero(t, atomic.LoadInt64(&traceBufferBytes))
+}
+
+func TestAdaptiveSamplingTraceDropped(t *testing.T) {
+	defer setAdaptiveSampling(t, "100")()
+	globalAdaptiveSampler = newAdaptiveSampler()
+	defer func() { globalAdaptiveSampler = newAdaptiveSampler() }()
+	for i := 0; i < adaptiveMinSamples; i++ {
+		globalAdaptiveSampler.keep("adaptive", time.Minute)
+	}
+	r := SetTestReporter(TestReporterSampleRate(0))
+
+	ctx, ok := NewContext("adaptive", "", true, nil)
+	require.True(t, ok)
+	assert.NoError(t, ctx.ReportEvent(LabelInfo, "adaptive", "K", "V"))
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "adaptive", "TransactionName", "adaptive"))
+
+	r.Close(0)
+	assert.Empty(t, r.EventBufs)
+	assert.Zero(t, atomic.LoadInt64(&traceBufferBytes))
+}
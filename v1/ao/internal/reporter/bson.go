@@ -2,7 +2,11 @@
 
 package reporter
 
-import "math"
+import (
+	"bytes"
+	"math"
+	"unicode/utf8"
+)
 
 type bsonBuffer struct {
 	buf []byte
@@ -94,10 +98,31 @@ func bsonAppendFinishObject(b *bsonBuffer, start int) {
 
 func (bbuf *bsonBuffer) addElemName(kind byte, name string) {
 	bbuf.addBytes(kind)
-	bbuf.addBytes([]byte(name)...)
+	bbuf.addBytes([]byte(toValidUTF8(name))...)
 	bbuf.addBytes(0)
 }
 
+// toValidUTF8 replaces each invalid UTF-8 byte of s with the replacement
+// character, as the collector rejects the whole batch if any of the strings,
+// e.g., a span name or a KV, is not valid UTF-8.
+func toValidUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	var b bytes.Buffer
+	b.Grow(len(s))
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b.WriteRune(utf8.RuneError)
+		} else {
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String()
+}
+
 // Marshaling of base types.
 
 func (bbuf *bsonBuffer) addBinary(v []byte) {
@@ -108,6 +133,7 @@ func (bbuf *bsonBuffer) addBinary(v []byte) {
 }
 
 func (bbuf *bsonBuffer) addStr(v string) {
+	v = toValidUTF8(v)
 	bbuf.addInt32(int32(len(v) + 1))
 	bbuf.addCStr(v)
 }
@@ -0,0 +1,33 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+const (
+	// the max number of cache names in a metrics report cycle, the others are
+	// aggregated as OtherTransactionName.
+	metricsCacheNamesMax = 50
+
+	cacheHitMetricName  = "CacheHitCount"
+	cacheMissMetricName = "CacheMissCount"
+)
+
+// the cache names recorded in the current metrics report cycle
+var mCacheNames = NewTransMap(metricsCacheNamesMax)
+
+// RecordCacheAccess aggregates a hit or miss of the cache into the metrics
+// CacheHitCount or CacheMissCount, tagged by the cache name.
+func RecordCacheAccess(cacheName string, hit bool) {
+	if cacheName == "" {
+		cacheName = UnknownTransactionName
+	} else if !mCacheNames.IsWithinLimit(cacheName) {
+		cacheName = OtherTransactionName
+	}
+	name := cacheMissMetricName
+	if hit {
+		name = cacheHitMetricName
+	}
+
+	metricsHTTPMeasurements.lock.Lock()
+	defer metricsHTTPMeasurements.lock.Unlock()
+	recordMeasurement(metricsHTTPMeasurements, name, &map[string]string{"CacheName": cacheName}, 0, 1, false)
+}
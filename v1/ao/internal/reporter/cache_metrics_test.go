@@ -0,0 +1,44 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordCacheAccess(t *testing.T) {
+	metricsHTTPMeasurements.lock.Lock()
+	metricsHTTPMeasurements.measurements = make(map[string]*Measurement)
+	metricsHTTPMeasurements.lock.Unlock()
+	mCacheNames.Reset()
+	defer mCacheNames.Reset()
+
+	RecordCacheAccess("users", true)
+	RecordCacheAccess("users", true)
+	RecordCacheAccess("users", false)
+	RecordCacheAccess("orders", false)
+	// the cache names are bounded
+	for i := 0; i < metricsCacheNamesMax; i++ {
+		RecordCacheAccess("cache"+strconv.Itoa(i), true)
+	}
+
+	metricsHTTPMeasurements.lock.Lock()
+	defer metricsHTTPMeasurements.lock.Unlock()
+	count := func(name, cacheName string) int {
+		m, ok := metricsHTTPMeasurements.measurements[name+"&false&CacheName:"+cacheName+"&"]
+		require.True(t, ok, "%s %s", name, cacheName)
+		assert.False(t, m.ReportSum)
+		return m.Count
+	}
+	assert.Equal(t, 2, count("CacheHitCount", "users"))
+	assert.Equal(t, 1, count("CacheMissCount", "users"))
+	assert.Equal(t, 1, count("CacheMissCount", "orders"))
+	assert.Equal(t, 1, count("CacheHitCount", "cache0"))
+	assert.Equal(t, 2, count("CacheHitCount", OtherTransactionName))
+	assert.NotContains(t, metricsHTTPMeasurements.measurements,
+		"CacheHitCount&false&CacheName:cache"+strconv.Itoa(metricsCacheNamesMax-1)+"&")
+}
@@ -0,0 +1,54 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+// clock provides the wall time displayed as the event timestamps and the
+// monotonic time the durations between the events are measured with. It's
+// replaced by a fake one in tests.
+type clock interface {
+	// Now returns the wall time.
+	Now() time.Time
+	// Monotonic returns the time elapsed since an arbitrary but fixed point,
+	// which never goes backward.
+	Monotonic() time.Duration
+}
+
+type systemClock struct {
+	start time.Time
+}
+
+func (c systemClock) Now() time.Time { return time.Now() }
+
+// Monotonic relies on the monotonic clock reading carried by time.Time, which
+// is used by time.Since as long as start is obtained from time.Now.
+func (c systemClock) Monotonic() time.Duration { return time.Since(c.start) }
+
+var eventClock clock = systemClock{start: time.Now()}
+
+// setClockStart records the start of a trace, which the timestamps of its
+// events are derived from.
+func (t *transactionContext) setClockStart() {
+	t.wallStart = eventClock.Now()
+	t.monoStart = eventClock.Monotonic()
+}
+
+// timestamp returns the timestamp of an event of the trace. It's the wall time
+// of the trace start plus the monotonic time elapsed since then, so the events
+// are never timestamped earlier than their predecessors, even if the wall
+// clock is stepped backward during the trace.
+func (t *transactionContext) timestamp() time.Time {
+	if t == nil || t.wallStart.IsZero() || config.GetWallClockTimestamps() {
+		return eventClock.Now()
+	}
+	elapsed := eventClock.Monotonic() - t.monoStart
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	return t.wallStart.Add(elapsed)
+}
@@ -0,0 +1,89 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type fakeClock struct {
+	wall time.Time
+	mono time.Duration
+}
+
+func (c *fakeClock) Now() time.Time           { return c.wall }
+func (c *fakeClock) Monotonic() time.Duration { return c.mono }
+
+// advance moves the monotonic clock forward, and the wall clock by the step
+// provided, which may be backward.
+func (c *fakeClock) advance(d, step time.Duration) {
+	c.mono += d
+	c.wall = c.wall.Add(step)
+}
+
+func setFakeClock() (*fakeClock, func()) {
+	c := &fakeClock{wall: time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)}
+	eventClock = c
+	return c, func() { eventClock = systemClock{start: time.Now()} }
+}
+
+// reportSteppedTrace reports a trace during which the wall clock is stepped
+// backward by an hour, and returns the timestamps of its events.
+func reportSteppedTrace(t *testing.T) []int64 {
+	c, restore := setFakeClock()
+	defer restore()
+	r := SetTestReporter()
+
+	ctx, ok := NewContext("clock", "", true, nil)
+	require.True(t, ok)
+	c.advance(5*time.Millisecond, -time.Hour)
+	assert.NoError(t, ctx.ReportEvent(LabelInfo, "clock"))
+	c.advance(5*time.Millisecond, 5*time.Millisecond)
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "clock"))
+	r.Close(3)
+
+	var timestamps []int64
+	for _, buf := range r.EventBufs {
+		m := bson.M{}
+		require.NoError(t, bson.Unmarshal(buf, m))
+		timestamps = append(timestamps, m["Timestamp_u"].(int64))
+	}
+	return timestamps
+}
+
+func TestMonotonicTimestamps(t *testing.T) {
+	ts := reportSteppedTrace(t)
+	require.Len(t, ts, 3)
+	start := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC).UnixNano() / 1000
+	assert.Equal(t, []int64{start, start + 5000, start + 10000}, ts)
+}
+
+func TestWallClockTimestamps(t *testing.T) {
+	require.NoError(t, os.Setenv("APPOPTICS_WALL_CLOCK_TIMESTAMPS", "true"))
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_WALL_CLOCK_TIMESTAMPS")
+		config.Load()
+	}()
+
+	ts := reportSteppedTrace(t)
+	require.Len(t, ts, 3)
+	assert.True(t, ts[1] < ts[0])
+}
+
+func TestSystemClockMonotonic(t *testing.T) {
+	c := systemClock{start: time.Now()}
+	prev := c.Monotonic()
+	for i := 0; i < 1000; i++ {
+		cur := c.Monotonic()
+		assert.True(t, cur >= prev)
+		prev = cur
+	}
+}
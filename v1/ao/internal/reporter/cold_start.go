@@ -0,0 +1,17 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import "sync/atomic"
+
+// the KV reported by the root spans if the cold starts are reported
+const coldStartKey = "cold_start"
+
+// it's 1 once a trace has been started by the process
+var traceStarted int32
+
+// isColdStart returns if the trace is the first one started by the process.
+// Only one of the traces started concurrently gets true.
+func isColdStart() bool {
+	return atomic.LoadInt32(&traceStarted) == 0 && atomic.CompareAndSwapInt32(&traceStarted, 0, 1)
+}
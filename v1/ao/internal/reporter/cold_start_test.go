@@ -0,0 +1,71 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestColdStart(t *testing.T) {
+	require.NoError(t, os.Setenv("APPOPTICS_REPORT_COLD_START", "true"))
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_REPORT_COLD_START")
+		config.Load()
+	}()
+	atomic.StoreInt32(&traceStarted, 0)
+	r := SetTestReporter()
+
+	// the traces started concurrently race to be the first one
+	const n = 10
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, ok := NewContext("cold", "", true, nil)
+			assert.True(t, ok)
+			// a child span is not a root span
+			assert.NoError(t, ctx.Copy().ReportEvent(LabelEntry, "child"))
+		}()
+	}
+	wg.Wait()
+
+	r.Close(2 * n)
+	var cold, warm int
+	for _, buf := range r.EventBufs {
+		m := bson.M{}
+		require.NoError(t, bson.Unmarshal(buf, m))
+		if m["Layer"] == "child" {
+			assert.NotContains(t, m, coldStartKey)
+			continue
+		}
+		if m[coldStartKey] == true {
+			cold++
+		} else if m[coldStartKey] == false {
+			warm++
+		}
+	}
+	assert.Equal(t, 1, cold)
+	assert.Equal(t, n-1, warm)
+}
+
+func TestColdStartDisabled(t *testing.T) {
+	atomic.StoreInt32(&traceStarted, 0)
+	r := SetTestReporter()
+	_, ok := NewContext("cold", "", true, nil)
+	require.True(t, ok)
+	r.Close(1)
+
+	m := bson.M{}
+	require.NoError(t, bson.Unmarshal(r.EventBufs[0], m))
+	assert.NotContains(t, m, coldStartKey)
+}
@@ -0,0 +1,50 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/collector"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip" // registers the gzip compressor
+)
+
+// gzipClient compresses the messages of the RPC calls which post them to the
+// collector with gzip.
+type gzipClient struct {
+	collector.TraceCollectorClient
+}
+
+func (c gzipClient) PostEvents(ctx context.Context, in *collector.MessageRequest,
+	opts ...grpc.CallOption) (*collector.MessageResult, error) {
+	return c.TraceCollectorClient.PostEvents(ctx, in, append(opts, grpc.UseCompressor(gzip.Name))...)
+}
+
+func (c gzipClient) PostMetrics(ctx context.Context, in *collector.MessageRequest,
+	opts ...grpc.CallOption) (*collector.MessageResult, error) {
+	return c.TraceCollectorClient.PostMetrics(ctx, in, append(opts, grpc.UseCompressor(gzip.Name))...)
+}
+
+func (c gzipClient) PostStatus(ctx context.Context, in *collector.MessageRequest,
+	opts ...grpc.CallOption) (*collector.MessageResult, error) {
+	return c.TraceCollectorClient.PostStatus(ctx, in, append(opts, grpc.UseCompressor(gzip.Name))...)
+}
+
+// clientFor returns the client to invoke the RPC method with, which compresses
+// the messages of the method if the compression is enabled and the messages
+// are not smaller than the compression threshold.
+func (c *grpcConnection) clientFor(m Method) collector.TraceCollectorClient {
+	if c.compression != config.CompressionGzip {
+		return c.client
+	}
+	size := 0
+	for _, msg := range m.Message() {
+		size += len(msg)
+	}
+	if size == 0 || size < c.compressionThreshold {
+		return c.client
+	}
+	return gzipClient{c.client}
+}
@@ -0,0 +1,56 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	pb "github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/collector"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+)
+
+func TestCompressionThreshold(t *testing.T) {
+	c, err := newGrpcConnection("events channel", "test-addr", WithDialer(&NoopDialer{}),
+		WithCompression(config.CompressionGzip, 100))
+	require.NoError(t, err)
+
+	// the compressor of each call, or an empty string if it's sent raw
+	var compressors []string
+	record := func(args mock.Arguments) {
+		compressor := ""
+		for _, opt := range args[2:] {
+			if o, ok := opt.(grpc.CompressorCallOption); ok {
+				compressor = o.CompressorType
+			}
+		}
+		compressors = append(compressors, compressor)
+	}
+	client := &mocks.TraceCollectorClient{}
+	// the header and trailer options are always appended
+	client.On("PostEvents", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(record).
+		Return(&pb.MessageResult{Result: pb.ResultCode_OK}, nil)
+	client.On("PostEvents", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(record).
+		Return(&pb.MessageResult{Result: pb.ResultCode_OK}, nil)
+	c.client = client
+
+	exit := make(chan struct{})
+	small := bytes.Repeat([]byte("x"), 40)
+	// below the threshold
+	require.NoError(t, c.InvokeRPC(exit, newPostEventsMethod("key", [][]byte{small})))
+	// the size of the batch, rather than a message, is compared
+	require.NoError(t, c.InvokeRPC(exit, newPostEventsMethod("key", [][]byte{small, small, small})))
+	require.NoError(t, c.InvokeRPC(exit, newPostEventsMethod("key", [][]byte{bytes.Repeat([]byte("x"), 100)})))
+	assert.Equal(t, []string{"", "gzip", "gzip"}, compressors)
+
+	// nothing is compressed if the compression is disabled
+	compressors = nil
+	c.compression = config.CompressionNone
+	require.NoError(t, c.InvokeRPC(exit, newPostEventsMethod("key", [][]byte{small, small, small})))
+	assert.Equal(t, []string{""}, compressors)
+}
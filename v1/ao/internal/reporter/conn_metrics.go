@@ -0,0 +1,37 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import "sync/atomic"
+
+// the numbers of the server connections accepted and closed (flushed on each
+// metrics report cycle)
+var connsNew, connsClosed int64
+
+// the numbers of the server connections currently active and idle
+var connsActive, connsIdle int64
+
+// RecordConnNew counts a server connection accepted.
+func RecordConnNew() {
+	atomic.AddInt64(&connsNew, 1)
+}
+
+// RecordConnClosed counts a server connection closed.
+func RecordConnClosed() {
+	atomic.AddInt64(&connsClosed, 1)
+}
+
+// AddActiveConns adds delta to the number of active server connections
+func AddActiveConns(delta int64) {
+	atomic.AddInt64(&connsActive, delta)
+}
+
+// AddIdleConns adds delta to the number of idle server connections
+func AddIdleConns(delta int64) {
+	atomic.AddInt64(&connsIdle, delta)
+}
+
+// ActiveAndIdleConns returns the number of active and idle server connections
+func ActiveAndIdleConns() (active, idle int64) {
+	return atomic.LoadInt64(&connsActive), atomic.LoadInt64(&connsIdle)
+}
@@ -6,10 +6,15 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
 )
 
 const (
@@ -51,6 +56,14 @@ type transactionContext struct {
 	name string
 	// if the trace/transaction is enabled (defined by per-URL transaction filtering)
 	enabled bool
+	// buffers the events of an unsampled trace which may still be kept, if any
+	buffer *traceBuffer
+	// the tenant whose service key the trace is reported with, if any
+	tenant string
+	// the wall and monotonic clock readings when the trace started in this
+	// process, the event timestamps are derived from
+	wallStart time.Time
+	monoStart time.Duration
 	sync.RWMutex
 }
 
@@ -64,6 +77,30 @@ func ValidMetadata(mdstr string) bool {
 	return true
 }
 
+// MetadataFields is the decoded representation of a metadata string.
+type MetadataFields struct {
+	Version uint8  // the X-Trace version
+	TaskID  string // the hex encoded task ID
+	OpID    string // the hex encoded op ID
+	Flags   uint8  // the flags, e.g., XTR_FLAGS_SAMPLED
+}
+
+// ParseMetadata decodes a metadata string. It returns an error describing why
+// the metadata string is invalid.
+func ParseMetadata(mdstr string) (MetadataFields, error) {
+	md := &oboeMetadata{}
+	md.Init()
+	if err := md.FromString(mdstr); err != nil {
+		return MetadataFields{}, err
+	}
+	return MetadataFields{
+		Version: md.version,
+		TaskID:  strings.ToUpper(hex.EncodeToString(md.ids.taskID)),
+		OpID:    md.opString(),
+		Flags:   md.flags,
+	}, nil
+}
+
 func (md *oboeMetadata) Init() {
 	if md == nil {
 		return
@@ -83,17 +120,15 @@ func (md *oboeMetadata) SetRandom() error {
 	if md == nil {
 		return errors.New("md.SetRandom: nil md")
 	}
-	_, err := randReader.Read(md.ids.taskID)
-	if err != nil {
+	g := getIDGenerator()
+	if err := g.GenerateTraceID(md.ids.taskID); err != nil {
 		return err
 	}
-	_, err = randReader.Read(md.ids.opID)
-	return err
+	return g.GenerateSpanID(md.ids.opID)
 }
 
 func (md *oboeMetadata) SetRandomOpID() error {
-	_, err := randReader.Read(md.ids.opID)
-	return err
+	return getIDGenerator().GenerateSpanID(md.ids.opID)
 }
 
 func (ids *oboeIDs) setOpID(opID []byte) {
@@ -304,6 +339,7 @@ func NewNullContext() Context { return &nullContext{} }
 // newContext allocates a context with random metadata (for a new trace).
 func newContext(sampled bool) Context {
 	ctx := &oboeContext{txCtx: &transactionContext{enabled: true}}
+	ctx.txCtx.setClockStart()
 	ctx.metadata.Init()
 	if err := ctx.metadata.SetRandom(); err != nil {
 		log.Infof("AppOptics rand.Read error: %v", err)
@@ -315,6 +351,7 @@ func newContext(sampled bool) Context {
 
 func newContextFromMetadataString(mdstr string) (*oboeContext, error) {
 	ctx := &oboeContext{txCtx: &transactionContext{enabled: true}}
+	ctx.txCtx.setClockStart()
 	ctx.metadata.Init()
 	err := ctx.metadata.FromString(mdstr)
 	return ctx, err
@@ -330,9 +367,51 @@ func NewContext(layer, mdStr string, reportEntry bool, cb func() map[string]inte
 // Setting reportEntry will report an entry event before this function returns, calling cb if provided
 // for additional KV pairs.
 func NewContextForURL(layer, mdStr string, reportEntry bool, url string, cb func() map[string]interface{}) (ctx Context, ok bool) {
+	return NewContextWithHint(layer, mdStr, reportEntry, url, SamplingHintNone, cb)
+}
+
+// NewContextWithHint is the same as NewContextForURL, except that the sampling
+// decision of a new trace is made by the hint provided, if any. A valid inbound
+// context in mdStr always takes precedence over the hint.
+func NewContextWithHint(layer, mdStr string, reportEntry bool, url string, hint SamplingHint,
+	cb func() map[string]interface{}) (ctx Context, ok bool) {
+	return NewContextForTenant(layer, mdStr, reportEntry, url, hint, "", cb)
+}
+
+// NewContextForTenant is the same as NewContextWithHint, except that the
+// events of the trace are reported with the service key of the tenant, if
+// it's not empty. The default service key is used if the tenant is not
+// configured.
+func NewContextForTenant(layer, mdStr string, reportEntry bool, url string, hint SamplingHint, tenant string,
+	cb func() map[string]interface{}) (ctx Context, ok bool) {
+	return NewContextForOrigin(layer, mdStr, reportEntry, url, hint, tenant, "", cb)
+}
+
+// NewContextForOrigin is the same as NewContextForTenant, except that a new
+// trace is sampled at the sample rate of its origin, e.g., http or job, if
+// it's configured.
+func NewContextForOrigin(layer, mdStr string, reportEntry bool, url string, hint SamplingHint, tenant, origin string,
+	cb func() map[string]interface{}) (ctx Context, ok bool) {
+	if tenant != "" {
+		if _, has := config.GetTenantServiceKey(tenant); !has {
+			warnUnknownTenant(tenant)
+			tenant = ""
+		}
+	}
+	startReporter()
 	traced := false
 	addCtxEdge := false
 
+	if OversizedHeader("X-Trace", mdStr) {
+		mdStr = ""
+	}
+	if v, err := ValidateHeader("X-Trace", mdStr); err != nil {
+		log.Debugf("passed in x-trace is rejected: %v", err)
+		mdStr = ""
+	} else {
+		mdStr = v
+	}
+
 	if mdStr != "" {
 		var err error
 		if ctx, err = newContextFromMetadataString(mdStr); err != nil {
@@ -348,7 +427,7 @@ func NewContextForURL(layer, mdStr string, reportEntry bool, url string, cb func
 				return ctx, false
 			}
 
-			_, flags, _ := mergeURLSetting(setting, url)
+			_, flags, _, _ := mergeURLSetting(setting, url)
 			ctx.SetEnabled(flags.Enabled())
 			return ctx, true
 		}
@@ -357,8 +436,21 @@ func NewContextForURL(layer, mdStr string, reportEntry bool, url string, cb func
 	if !traced {
 		ctx = newContext(true)
 	}
+	if c, isOboe := ctx.(*oboeContext); isOboe {
+		c.txCtx.tenant = tenant
+	}
 
-	ok, rate, source, enabled := shouldTraceRequestWithURL(layer, traced, url)
+	// decided before the sampling, so an unsampled first trace is still the
+	// cold start
+	coldStart := reportEntry && isColdStart()
+	ok, rate, source, enabled := shouldTraceRequestForOrigin(layer, traced, url, hint, origin)
+	if !ok && !traced && enabled && needTraceBuffer() {
+		if c, isOboe := ctx.(*oboeContext); isOboe {
+			// sample it internally and decide whether to keep it later
+			c.txCtx.buffer = newTraceBuffer()
+			ok = true
+		}
+	}
 	if ok {
 		if reportEntry {
 			var kvs map[string]interface{}
@@ -370,6 +462,13 @@ func NewContextForURL(layer, mdStr string, reportEntry bool, url string, cb func
 			}
 			kvs["SampleRate"] = rate
 			kvs["SampleSource"] = source
+			if config.GetRuntimeKVs() {
+				kvs["Go.Version"] = utils.GoVersion()
+				kvs["Go.GOMAXPROCS"] = runtime.GOMAXPROCS(0)
+			}
+			if config.GetReportColdStart() {
+				kvs[coldStartKey] = coldStart
+			}
 			if _, ok = ctx.(*oboeContext); !ok {
 				return &nullContext{}, false
 			}
@@ -385,6 +484,41 @@ func NewContextForURL(layer, mdStr string, reportEntry bool, url string, cb func
 	return ctx, true
 }
 
+// getTenant returns the tenant whose service key the trace is reported with,
+// or an empty string for the default service key.
+func (ctx *oboeContext) getTenant() string {
+	if ctx == nil || ctx.txCtx == nil {
+		return ""
+	}
+	return ctx.txCtx.tenant
+}
+
+// the minimum interval between two warnings of the unknown tenants
+const unknownTenantWarnInterval = time.Minute
+
+// the time in nanoseconds of the last warning of the unknown tenants
+var lastUnknownTenantWarn int64
+
+// warnUnknownTenant logs a warning of the unknown tenant at most once per
+// minute, as the tenant names may come from the requests.
+func warnUnknownTenant(tenant string) {
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&lastUnknownTenantWarn)
+	if now-last >= int64(unknownTenantWarnInterval) &&
+		atomic.CompareAndSwapInt64(&lastUnknownTenantWarn, last, now) {
+		log.Warningf("Reporting the trace of the unknown tenant %s with the default service key, "+
+			"check APPOPTICS_TENANT_SERVICE_KEYS.", tenant)
+	}
+}
+
+// traceBuffer returns the buffer of the trace if it may still be kept.
+func (ctx *oboeContext) traceBuffer() *traceBuffer {
+	if ctx == nil || ctx.txCtx == nil {
+		return nil
+	}
+	return ctx.txCtx.buffer
+}
+
 func (ctx *oboeContext) Copy() Context {
 	md := oboeMetadata{}
 	md.Init()
@@ -473,6 +607,7 @@ func (ctx *oboeContext) reportEvent(label Label, layer string, addCtxEdge bool,
 
 // report an event using KVs from variadic args
 func (ctx *oboeContext) report(e *event, addCtxEdge bool, args ...interface{}) error {
+	args = processEvent(e, args)
 	for i := 0; i+1 < len(args); i += 2 {
 		if err := e.AddKV(args[i], args[i+1]); err != nil {
 			return err
@@ -481,11 +616,46 @@ func (ctx *oboeContext) report(e *event, addCtxEdge bool, args ...interface{}) e
 	if addCtxEdge {
 		e.AddEdge(ctx)
 	}
+	if b := ctx.traceBuffer(); b != nil {
+		switch e.label {
+		case LabelExit:
+			b.setTransactionName(args...)
+			b.setStatus(args...)
+		case LabelError:
+			b.setErrorClass(args...)
+		}
+	}
 	// report event
-	return e.Report(ctx)
+	if err := e.Report(ctx); err != nil {
+		return err
+	}
+	sinkEvent(ctx, e, args)
+	if e.label == LabelError && retroCaptureEnabled() {
+		// send the context of the last moments before the error, including the
+		// trace itself if it's still buffered and kept by KeepErrors. The
+		// captured traces are sent in the background to keep the reporting
+		// path cheap.
+		if b := ctx.traceBuffer(); b != nil && config.GetKeepErrors() {
+			if err := b.keepPending(ctx, globalReporter); err != nil {
+				return err
+			}
+		}
+		globalRetroCapture.flushInBackground(globalReporter)
+	}
+	return nil
 }
 
-func (ctx *oboeContext) MetadataString() string { return ctx.metadata.String() }
+// MetadataString returns the metadata string to propagate. A trace pending in
+// its trace buffer is propagated as not sampled, as it's not sampled by the
+// sampling decision.
+func (ctx *oboeContext) MetadataString() string {
+	if b := ctx.traceBuffer(); b != nil && b.pending() {
+		md := ctx.metadata
+		md.flags &^= XTR_FLAGS_SAMPLED
+		return md.String()
+	}
+	return ctx.metadata.String()
+}
 
 // String returns a hex string representation
 func (md *oboeMetadata) String() string {
@@ -0,0 +1,59 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// the KVs of the deploy marker, which is sent as a status message like the
+// init message. The time of the deploy is the timestamp of the message.
+const (
+	deployMarkerKey      = "__DeployMarker"
+	deployVersionKey     = "DeployVersion"
+	deployDescriptionKey = "DeployDescription"
+	deployMarkerLabel    = "single"
+	deployMarkerLayer    = "go"
+)
+
+// syncStatusSender is implemented by the reporters which can send a status
+// message immediately instead of queueing it.
+type syncStatusSender interface {
+	SendStatus(ctx context.Context, c *oboeContext, e *event) error
+}
+
+// SendDeployMarker sends a marker of the deploy of the version, with its
+// description, for the backend to annotate the timeline. It blocks until the
+// marker is sent or the context is canceled, if the reporter supports it,
+// otherwise the marker is queued as the other status messages.
+func SendDeployMarker(ctx context.Context, version, description string) error {
+	if Closed() {
+		return errors.Wrap(ErrReporterIsClosed, "failed to send the deploy marker")
+	}
+	c, ok := newContext(true).(*oboeContext)
+	if !ok {
+		return errors.New("failed to create the deploy marker")
+	}
+	e, err := c.newEvent(deployMarkerLabel, deployMarkerLayer)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the deploy marker")
+	}
+	_ = e.AddKV(deployMarkerKey, 1)
+	_ = e.AddKV(deployVersionKey, version)
+	if description != "" {
+		_ = e.AddKV(deployDescriptionKey, description)
+	}
+
+	r := globalReporter
+	if tee, ok := r.(*teeReporter); ok {
+		r = tee.reporter
+	}
+	if s, ok := r.(syncStatusSender); ok {
+		err = s.SendStatus(ctx, c, e)
+	} else {
+		err = r.reportStatus(c, e)
+	}
+	return errors.Wrap(err, "failed to send the deploy marker")
+}
@@ -0,0 +1,61 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/host"
+	pb "github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/collector"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/mocks"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestSendDeployMarker(t *testing.T) {
+	mc, err := newGrpcConnection("metrics channel", "test-addr", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+
+	var sent [][]byte
+	client := &mocks.TraceCollectorClient{}
+	client.On("PostStatus", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			sent = append(sent, args.Get(1).(*pb.MessageRequest).Messages...)
+		}).
+		Return(&pb.MessageResult{Result: pb.ResultCode_OK}, nil)
+	mc.client = client
+
+	r := &grpcReporter{
+		metricConnection: mc,
+		serviceKey:       serviceKey,
+		done:             make(chan struct{}),
+	}
+	oldReporter := globalReporter
+	globalReporter = r
+	defer func() { globalReporter = oldReporter }()
+
+	// the marker is sent immediately, bypassing the status message queue
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	before := time.Now()
+	require.NoError(t, SendDeployMarker(ctx, "v1.2.3", "fix the checkout latency"))
+	client.AssertNumberOfCalls(t, "PostStatus", 1)
+	require.Len(t, sent, 1)
+
+	m := bson.M{}
+	require.NoError(t, bson.Unmarshal(sent[0], &m))
+	assert.Equal(t, 1, m["__DeployMarker"])
+	assert.Equal(t, "v1.2.3", m["DeployVersion"])
+	assert.Equal(t, "fix the checkout latency", m["DeployDescription"])
+	assert.Equal(t, "single", m["Label"])
+	assert.Equal(t, host.Hostname(), m["Hostname"])
+	assert.True(t, m["Timestamp_u"].(int64) >= before.UnixNano()/1000)
+
+	close(r.done)
+	assert.Equal(t, ErrReporterIsClosed, errors.Cause(SendDeployMarker(ctx, "v1.2.4", "")))
+}
@@ -0,0 +1,129 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Diagnostics is a snapshot of the internal state of the reporter.
+type Diagnostics struct {
+	// the type of the reporter: ssl, udp, test, lazy (not started yet) or none
+	Reporter string
+	Ready    bool
+	Closed   bool
+
+	// the number of messages waiting in the queues and the capacity of the
+	// event queue
+	EventQueueDepth    int
+	EventQueueCapacity int
+	SpanQueueDepth     int
+	StatusQueueDepth   int
+	// the events dropped since the last diagnostic report
+	DroppedEvents int64
+
+	// the number of reconnections to the collector
+	Reconnects int64
+	// the last error returned by the collector, if any
+	LastError     string
+	LastErrorTime time.Time
+
+	// incremented on each update of the settings
+	SettingsVersion int64
+	// the time the default settings are updated and the sample rate of them
+	SettingsUpdated time.Time
+	SampleRate      int
+}
+
+// diagnoser is implemented by the reporters which expose their internal state.
+type diagnoser interface {
+	diagnostics(d *Diagnostics)
+}
+
+// GetDiagnostics returns a snapshot of the internal state of the reporter. It
+// is safe for concurrent use.
+func GetDiagnostics() Diagnostics {
+	r := activeReporter()
+
+	d := Diagnostics{Closed: r.Closed()}
+	switch r.(type) {
+	case *grpcReporter:
+		d.Reporter = "ssl"
+	case *udpReporter:
+		d.Reporter = "udp"
+	case *TestReporter:
+		d.Reporter = "test"
+	case *lazyReporter:
+		d.Reporter = "lazy"
+	default:
+		d.Reporter = "none"
+	}
+	if dr, ok := r.(diagnoser); ok {
+		dr.diagnostics(&d)
+	}
+
+	d.SettingsVersion = atomic.LoadInt64(&globalSettingsCfg.version)
+	if s, ok := getSetting(""); ok {
+		d.SettingsUpdated = s.timestamp
+		d.SampleRate = s.value
+	}
+	return d
+}
+
+// errorRecord keeps the last error and the time it occurred. It is safe for
+// concurrent use.
+type errorRecord struct {
+	err  error
+	at   time.Time
+	lock sync.Mutex
+}
+
+func (r *errorRecord) set(err error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.err = err
+	r.at = time.Now()
+}
+
+func (r *errorRecord) get() (error, time.Time) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.err, r.at
+}
+
+// activeReporter returns the reporter which sends the events, which may be
+// wrapped by the global reporter.
+func activeReporter() reporter {
+	r := globalReporter
+	if tee, ok := r.(*teeReporter); ok {
+		r = tee.reporter
+	}
+	if lr, ok := r.(*lazyReporter); ok && lr.started() != nil {
+		r = lr.started()
+	}
+	return r
+}
+
+func (r *grpcReporter) diagnostics(d *Diagnostics) {
+	d.Ready = r.isReady()
+	d.EventQueueDepth = len(r.eventMessages)
+	d.EventQueueCapacity = cap(r.eventMessages)
+	d.SpanQueueDepth = len(r.spanMessages)
+	d.StatusQueueDepth = len(r.statusMessages)
+	if r.drops != nil {
+		d.DroppedEvents = atomic.LoadInt64(&r.drops.queueFull) + atomic.LoadInt64(&r.drops.sendFailed)
+	}
+
+	for _, c := range []*grpcConnection{r.eventConnection, r.metricConnection} {
+		if c == nil {
+			continue
+		}
+		d.Reconnects += atomic.LoadInt64(&c.reconnects)
+		if err, at := c.lastErr.get(); err != nil && at.After(d.LastErrorTime) {
+			d.LastError = err.Error()
+			d.LastErrorTime = at
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"testing"
+
+	pb "github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/collector"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetDiagnostics(t *testing.T) {
+	c, err := newGrpcConnection("events channel", "localhost:4567", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+	mc, err := newGrpcConnection("metrics channel", "localhost:4567", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+	r := &grpcReporter{
+		eventConnection:  c,
+		metricConnection: mc,
+		eventMessages:    make(chan []byte, 10),
+		spanMessages:     make(chan SpanMessage, 10),
+		statusMessages:   make(chan []byte, 10),
+		done:             make(chan struct{}),
+		drops:            newEventDrops(dropsReportIntervalDefault),
+	}
+	r.setReady(true)
+
+	oldReporter := globalReporter
+	globalReporter = r
+	defer func() { globalReporter = oldReporter }()
+
+	d := GetDiagnostics()
+	assert.Equal(t, "ssl", d.Reporter)
+	assert.True(t, d.Ready)
+	assert.False(t, d.Closed)
+	assert.Equal(t, 0, d.EventQueueDepth)
+	assert.Equal(t, 10, d.EventQueueCapacity)
+	assert.Empty(t, d.LastError)
+
+	// simulate the queued messages, dropped events and a collector error
+	for i := 0; i < 3; i++ {
+		r.eventMessages <- []byte("event")
+	}
+	r.statusMessages <- []byte("status")
+	r.drops.add(dropReasonQueueFull, 2)
+
+	m := &mocks.Method{}
+	m.On("String").Return("mock")
+	m.On("Call", mock.Anything, mock.Anything).Return(nil)
+	m.On("Message").Return(nil)
+	m.On("MessageLen").Return(int64(0))
+	m.On("CallSummary").Return("try later")
+	m.On("ResultCode", mock.Anything, mock.Anything).Return(pb.ResultCode_TRY_LATER, nil)
+	m.On("RetryOnErr", mock.Anything, mock.Anything).Return(false)
+	assert.Equal(t, errNoRetryOnErr, c.InvokeRPC(r.done, m))
+
+	c.setActive(false)
+	c.reconnect()
+
+	version := GetDiagnostics().SettingsVersion
+	updateSetting(int32(TYPE_DEFAULT), "",
+		[]byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		500000, 120, argsToMap(1000000, 1000000, -1, -1))
+	defer resetSettings()
+
+	d = GetDiagnostics()
+	assert.Equal(t, 3, d.EventQueueDepth)
+	assert.Equal(t, 0, d.SpanQueueDepth)
+	assert.Equal(t, 1, d.StatusQueueDepth)
+	assert.EqualValues(t, 2, d.DroppedEvents)
+	assert.Equal(t, "try later", d.LastError)
+	assert.False(t, d.LastErrorTime.IsZero())
+	assert.EqualValues(t, 1, d.Reconnects)
+	assert.Equal(t, version+1, d.SettingsVersion)
+	assert.Equal(t, 500000, d.SampleRate)
+	assert.False(t, d.SettingsUpdated.IsZero())
+
+	close(r.done)
+	d = GetDiagnostics()
+	assert.True(t, d.Closed)
+}
@@ -0,0 +1,96 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// the reasons why the events are dropped by the reporter
+const (
+	dropReasonQueueFull  = "QueueFull"
+	dropReasonSendFailed = "SendFailed"
+)
+
+const (
+	// the layer name of the diagnostic span
+	diagnosticsLayer = "AppOptics.Diagnostics"
+	// the minimum interval between two diagnostic spans of dropped events
+	dropsReportIntervalDefault = time.Minute
+)
+
+// eventDrops counts the events dropped by the reporter since the last report.
+// All the fields are accessed atomically.
+type eventDrops struct {
+	queueFull  int64
+	sendFailed int64
+	// the last time the drops are reported, in Unix nanoseconds
+	lastReport int64
+	// the minimum interval between two reports
+	interval time.Duration
+}
+
+func newEventDrops(interval time.Duration) *eventDrops {
+	return &eventDrops{interval: interval}
+}
+
+// add counts n events dropped for the reason
+func (d *eventDrops) add(reason string, n int64) {
+	switch reason {
+	case dropReasonQueueFull:
+		atomic.AddInt64(&d.queueFull, n)
+	case dropReasonSendFailed:
+		atomic.AddInt64(&d.sendFailed, n)
+	}
+}
+
+// flush returns the numbers of dropped events by reason and resets them. It
+// returns false if there is nothing dropped, or the minimum interval has not
+// passed since the last report.
+func (d *eventDrops) flush(now time.Time) (map[string]int64, bool) {
+	last := atomic.LoadInt64(&d.lastReport)
+	if now.Sub(time.Unix(0, last)) < d.interval {
+		return nil, false
+	}
+	if atomic.LoadInt64(&d.queueFull) == 0 && atomic.LoadInt64(&d.sendFailed) == 0 {
+		return nil, false
+	}
+	// someone else is flushing it
+	if !atomic.CompareAndSwapInt64(&d.lastReport, last, now.UnixNano()) {
+		return nil, false
+	}
+	return map[string]int64{
+		dropReasonQueueFull:  atomic.SwapInt64(&d.queueFull, 0),
+		dropReasonSendFailed: atomic.SwapInt64(&d.sendFailed, 0),
+	}, true
+}
+
+// report sends a diagnostic span summarizing the events dropped since the
+// last report. It does nothing if there is nothing to report.
+func (d *eventDrops) report(now time.Time) error {
+	counts, ok := d.flush(now)
+	if !ok {
+		return nil
+	}
+
+	ctx, ok := newContext(true).(*oboeContext)
+	if !ok {
+		return errors.New("failed to create the diagnostic context")
+	}
+	var total int64
+	var kvs []interface{}
+	for reason, n := range counts {
+		total += n
+		kvs = append(kvs, "DroppedEvents."+reason, n)
+	}
+	kvs = append(kvs, "DroppedEvents", total)
+
+	if err := ctx.reportEvent(LabelEntry, diagnosticsLayer, false, kvs...); err != nil {
+		return errors.Wrap(err, "failed to report the diagnostic span")
+	}
+	return errors.Wrap(ctx.reportEvent(LabelExit, diagnosticsLayer, true),
+		"failed to report the diagnostic span")
+}
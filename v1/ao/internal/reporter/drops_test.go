@@ -0,0 +1,61 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"testing"
+	"time"
+
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventDropsFlush(t *testing.T) {
+	d := newEventDrops(time.Minute)
+	now := time.Now()
+
+	// nothing dropped
+	_, ok := d.flush(now)
+	assert.False(t, ok)
+
+	d.add(dropReasonQueueFull, 3)
+	d.add(dropReasonSendFailed, 2)
+	d.add("unknown", 1)
+	counts, ok := d.flush(now)
+	assert.True(t, ok)
+	assert.Equal(t, map[string]int64{dropReasonQueueFull: 3, dropReasonSendFailed: 2}, counts)
+
+	// the frequency is bounded by the interval
+	d.add(dropReasonQueueFull, 1)
+	_, ok = d.flush(now.Add(time.Second))
+	assert.False(t, ok)
+	counts, ok = d.flush(now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, map[string]int64{dropReasonQueueFull: 1, dropReasonSendFailed: 0}, counts)
+}
+
+func TestEventDropsReport(t *testing.T) {
+	r := SetTestReporter()
+	d := newEventDrops(time.Minute)
+
+	// nothing to report
+	assert.NoError(t, d.report(time.Now()))
+
+	d.add(dropReasonQueueFull, 10)
+	d.add(dropReasonSendFailed, 5)
+	assert.NoError(t, d.report(time.Now()))
+
+	// too early for the next report
+	d.add(dropReasonQueueFull, 1)
+	assert.NoError(t, d.report(time.Now()))
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{diagnosticsLayer, "entry"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
+			assert.EqualValues(t, 15, n.Map["DroppedEvents"])
+			assert.EqualValues(t, 10, n.Map["DroppedEvents.QueueFull"])
+			assert.EqualValues(t, 5, n.Map["DroppedEvents.SendFailed"])
+		}},
+		{diagnosticsLayer, "exit"}: {Edges: g.Edges{{diagnosticsLayer, "entry"}}},
+	})
+}
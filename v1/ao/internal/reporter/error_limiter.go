@@ -0,0 +1,84 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+const (
+	// the maximum number of error classes rate limited separately, the others
+	// share the rate limit of the OtherTransactionName.
+	errorClassesMax = 200
+
+	suppressedErrorsMetricName = "SuppressedErrors"
+)
+
+// the error classes recorded in the current metrics report cycle
+var mErrorClasses = NewTransMap(errorClassesMax)
+
+// errorLimiter decides whether an unsampled trace is kept by KeepErrors for
+// an error reported in it. The traces kept are limited by a token bucket per
+// error class, so a spike of an error class doesn't crowd out the others.
+type errorLimiter struct {
+	sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newErrorLimiter() *errorLimiter {
+	return &errorLimiter{buckets: make(map[string]*tokenBucket)}
+}
+
+// the error limiter of all the unsampled traces
+var globalErrorLimiter = newErrorLimiter()
+
+// keep returns if the trace with an error of the class is kept within the
+// rate of its class. The errors not kept are counted as suppressed.
+func (l *errorLimiter) keep(class string) bool {
+	rate := config.GetKeepErrorsRate()
+	if rate <= 0 {
+		return true
+	}
+	// allow a burst of at least one trace for a rate below 1
+	capacity := math.Max(rate, 1)
+
+	l.Lock()
+	b, ok := l.buckets[class]
+	if !ok {
+		if len(l.buckets) >= errorClassesMax {
+			class = OtherTransactionName
+			b, ok = l.buckets[class]
+		}
+		if !ok {
+			b = &tokenBucket{available: capacity, last: time.Now()}
+			l.buckets[class] = b
+		}
+	}
+	l.Unlock()
+
+	b.setRateCap(rate, capacity)
+	if b.consume(1) {
+		return true
+	}
+	recordSuppressedError(class)
+	return false
+}
+
+// recordSuppressedError counts an error not kept into the metric
+// SuppressedErrors, tagged by the error class.
+func recordSuppressedError(class string) {
+	if class == "" {
+		class = UnknownTransactionName
+	} else if !mErrorClasses.IsWithinLimit(class) {
+		class = OtherTransactionName
+	}
+
+	metricsHTTPMeasurements.lock.Lock()
+	defer metricsHTTPMeasurements.lock.Unlock()
+	recordMeasurement(metricsHTTPMeasurements, suppressedErrorsMetricName,
+		&map[string]string{"ErrorClass": class}, 1, 1, false)
+}
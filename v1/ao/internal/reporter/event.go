@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
@@ -17,6 +18,12 @@ import (
 type event struct {
 	metadata oboeMetadata
 	bbuf     bsonBuffer
+	label    Label
+	layer    string
+	// if the event is prepared for sending already
+	prepared bool
+	// the timestamp of the event if it's provided by TimestampKey
+	timestamp time.Time
 }
 
 // Label is a required event attribute.
@@ -31,10 +38,15 @@ const (
 	LabelProfileEntry = "profile_entry"
 	LabelProfileExit  = "profile_exit"
 	EdgeKey           = "Edge"
+	// TimestampKey overrides the timestamp of an event with a time.Time value,
+	// e.g., for an event reported after it happened.
+	TimestampKey = "Timestamp_u"
 )
 
 const (
 	eventHeader = "1"
+	// the value reported in place of the value of a redacted KV
+	redactedValue = "****"
 )
 
 // enums used by sampling and tracing settings
@@ -175,6 +187,7 @@ func newEvent(md *oboeMetadata, label Label, layer string) (*event, error) {
 }
 
 func (e *event) addLabelLayer(label Label, layer string) {
+	e.label, e.layer = label, layer
 	e.AddString("Label", string(label))
 	if layer != "" {
 		e.AddString("Layer", layer)
@@ -226,6 +239,11 @@ func (e *event) AddKV(key, value interface{}) error {
 	if !isStr {
 		return fmt.Errorf("key %v (type %T) not a string", k, k)
 	}
+	// redact the value of a sensitive KV, whatever its type is
+	if k != EdgeKey && config.IsRedactedKey(k) {
+		e.AddString(k, redactedValue)
+		return nil
+	}
 	// load value and add KV to event
 	switch v := value.(type) {
 	case string:
@@ -264,6 +282,10 @@ func (e *event) AddKV(key, value interface{}) error {
 		}
 	case sampleSource:
 		e.AddInt(k, int(v))
+	case time.Time:
+		if k == TimestampKey {
+			e.timestamp = v
+		}
 
 	// allow reporting of pointers to basic types as well (for delayed evaluation)
 	case *string:
@@ -328,6 +350,9 @@ func (e *event) AddKV(key, value interface{}) error {
 func (e *event) ReportUsing(c *oboeContext, r reporter, channel reporterChannel) error {
 	if channel == EVENTS {
 		if e.metadata.isSampled() {
+			if b := c.traceBuffer(); b != nil {
+				return b.report(c, e, r)
+			}
 			return r.reportEvent(c, e)
 		}
 	} else if channel == METRICS {
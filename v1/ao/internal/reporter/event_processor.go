@@ -0,0 +1,153 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import "sync/atomic"
+
+// EventProcessor transforms or enriches an event before it's serialized, e.g.,
+// to add a KV derived from the others. It's called for every event reported
+// and must be cheap and safe for concurrent use.
+type EventProcessor func(e *ProcessedEvent)
+
+// the KVs which identify the event in its trace, which can't be changed by the
+// event processors.
+var reservedEventKeys = map[string]bool{
+	"X-Trace":    true,
+	EdgeKey:      true,
+	"Label":      true,
+	"Layer":      true,
+	TimestampKey: true,
+	"Hostname":   true,
+	"PID":        true,
+}
+
+// eventProcessorsHolder wraps the event processors as atomic.Value doesn't
+// store nil.
+type eventProcessorsHolder struct {
+	processors []EventProcessor
+}
+
+var eventProcessors atomic.Value
+
+func init() {
+	SetEventProcessors()
+	SetEventSink(nil)
+}
+
+// SetEventProcessors replaces the event processors, which are called in order
+// for every event. The processors are removed if none is provided.
+func SetEventProcessors(processors ...EventProcessor) {
+	var valid []EventProcessor
+	for _, p := range processors {
+		if p != nil {
+			valid = append(valid, p)
+		}
+	}
+	eventProcessors.Store(eventProcessorsHolder{valid})
+}
+
+// ProcessedEvent is an event passed to the event processors, whose KVs can be
+// read and changed except the reserved ones, e.g., X-Trace and Edge.
+type ProcessedEvent struct {
+	label Label
+	layer string
+	kvs   []interface{}
+}
+
+// Label returns the label of the event, e.g., entry or exit
+func (e *ProcessedEvent) Label() string { return string(e.label) }
+
+// Layer returns the name of the span of the event
+func (e *ProcessedEvent) Layer() string { return e.layer }
+
+// Get returns the value of the KV, and false if the event has no such KV.
+func (e *ProcessedEvent) Get(key string) (interface{}, bool) {
+	for i := 0; i+1 < len(e.kvs); i += 2 {
+		if k, ok := e.kvs[i].(string); ok && k == key {
+			return e.kvs[i+1], true
+		}
+	}
+	return nil, false
+}
+
+// Set adds the KV to the event, or replaces its value if the event has it
+// already. The reserved KVs are not changed.
+func (e *ProcessedEvent) Set(key string, value interface{}) {
+	if reservedEventKeys[key] {
+		return
+	}
+	for i := 0; i+1 < len(e.kvs); i += 2 {
+		if k, ok := e.kvs[i].(string); ok && k == key {
+			e.kvs[i+1] = value
+			return
+		}
+	}
+	e.kvs = append(e.kvs, key, value)
+}
+
+// Delete removes the KV from the event. The reserved KVs are not removed.
+func (e *ProcessedEvent) Delete(key string) {
+	if reservedEventKeys[key] {
+		return
+	}
+	kvs := e.kvs[:0]
+	for i := 0; i+1 < len(e.kvs); i += 2 {
+		if k, ok := e.kvs[i].(string); !ok || k != key {
+			kvs = append(kvs, e.kvs[i], e.kvs[i+1])
+		}
+	}
+	e.kvs = kvs
+}
+
+// processEvent runs the event processors, if any, on the KVs to be added to the
+// event and returns the KVs processed. The args are not modified as they may
+// be shared with the caller.
+func processEvent(e *event, args []interface{}) []interface{} {
+	processors := eventProcessors.Load().(eventProcessorsHolder).processors
+	if len(processors) == 0 {
+		return args
+	}
+	pe := &ProcessedEvent{label: e.label, layer: e.layer, kvs: make([]interface{}, 0, len(args)+2)}
+	for i := 0; i+1 < len(args); i += 2 {
+		pe.kvs = append(pe.kvs, args[i], args[i+1])
+	}
+	for _, p := range processors {
+		p(pe)
+	}
+	return pe.kvs
+}
+
+// EventSink receives a copy of every event sent, after it's processed. It's
+// called synchronously and must not block.
+type EventSink func(e *ProcessedEvent)
+
+// eventSinkHolder wraps the event sink as atomic.Value doesn't store nil.
+type eventSinkHolder struct {
+	sink EventSink
+}
+
+var eventSink atomic.Value
+
+// SetEventSink replaces the event sink, which is removed if sink is nil.
+func SetEventSink(sink EventSink) {
+	eventSink.Store(eventSinkHolder{sink})
+}
+
+// sinkEvent passes a copy of the event sent with the KVs to the event sink, if
+// any. The events of the traces pending in their trace buffers are held by the
+// buffers, and passed only once the traces are kept.
+func sinkEvent(ctx *oboeContext, e *event, args []interface{}) {
+	sink := eventSink.Load().(eventSinkHolder).sink
+	if sink == nil || !e.metadata.isSampled() {
+		return
+	}
+	pe := &ProcessedEvent{label: e.label, layer: e.layer, kvs: make([]interface{}, 0, len(args)+2)}
+	pe.kvs = append(pe.kvs, "X-Trace", e.MetadataString())
+	for i := 0; i+1 < len(args); i += 2 {
+		pe.kvs = append(pe.kvs, args[i], args[i+1])
+	}
+	if b := ctx.traceBuffer(); b != nil && b.deferSink(pe) {
+		return
+	}
+	sink(pe)
+}
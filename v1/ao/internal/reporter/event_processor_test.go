@@ -0,0 +1,36 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessEvent(t *testing.T) {
+	e := &event{label: LabelInfo, layer: "layer"}
+	args := []interface{}{"A", 1, "B", "b", EdgeKey, "edge", "dangling"}
+
+	// no-op without processors
+	assert.Equal(t, args, processEvent(e, args))
+
+	SetEventProcessors(nil, func(pe *ProcessedEvent) {
+		assert.Equal(t, "info", pe.Label())
+		assert.Equal(t, "layer", pe.Layer())
+		v, ok := pe.Get("A")
+		assert.True(t, ok)
+		pe.Set("A", v.(int)+1)
+		pe.Set("C", true)
+		pe.Delete("B")
+		pe.Delete(EdgeKey)
+		pe.Set(TimestampKey, 0)
+		_, ok = pe.Get("B")
+		assert.False(t, ok)
+	})
+	defer SetEventProcessors()
+
+	assert.Equal(t, []interface{}{"A", 2, EdgeKey, "edge", "C", true}, processEvent(e, args))
+	// the args of the caller are not modified
+	assert.Equal(t, []interface{}{"A", 1, "B", "b", EdgeKey, "edge", "dangling"}, args)
+}
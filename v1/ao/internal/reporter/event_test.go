@@ -4,11 +4,16 @@ package reporter
 
 import (
 	"math"
+	"os"
+	"strings"
 	"testing"
+	"unicode/utf8"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
 )
 
 var testLayer = "go_test"
@@ -162,6 +167,75 @@ func TestEvent(t *testing.T) {
 		{"go_test", "exit"}:  {Edges: g.Edges{{"go_test", "entry"}}},
 	})
 }
+func TestEventInvalidUTF8(t *testing.T) {
+	r := SetTestReporter()
+	ctx := newTestContext(t)
+	e, err := ctx.newEvent(LabelEntry, "layer\xff")
+	require.NoError(t, err)
+	assert.NoError(t, e.AddKV("key\xfe", "value\xc3\x28"))
+	assert.NoError(t, e.AddKV("binary", string([]byte{0x00, 0x80, 0xff})))
+	assert.NoError(t, e.AddKV("valid", "h\u00e9llo \u2713"))
+	assert.NoError(t, e.Report(ctx))
+
+	r.Close(1)
+	require.Len(t, r.EventBufs, 1)
+	m := bson.M{}
+	require.NoError(t, bson.Unmarshal(r.EventBufs[0], m))
+	for k, v := range m {
+		assert.True(t, utf8.ValidString(k), k)
+		if s, ok := v.(string); ok {
+			assert.True(t, utf8.ValidString(s), s)
+		}
+	}
+	assert.Equal(t, "layer\uFFFD", m["Layer"])
+	assert.Equal(t, "value\uFFFD(", m["key\uFFFD"])
+	assert.Equal(t, "\x00\uFFFD\uFFFD", m["binary"])
+	assert.Equal(t, "h\u00e9llo \u2713", m["valid"])
+}
+
+func TestEventRedactedKeys(t *testing.T) {
+	os.Setenv("APPOPTICS_REDACTED_KEYS", "password,API-Key")
+	os.Setenv("APPOPTICS_REDACTED_KEY_REGEX", "(?i)secret")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_REDACTED_KEYS")
+		os.Unsetenv("APPOPTICS_REDACTED_KEY_REGEX")
+		config.Load()
+	}()
+
+	r := SetTestReporter()
+	ctx := newTestContext(t)
+	e, err := ctx.newEvent(LabelEntry, testLayer)
+	require.NoError(t, err)
+	password := "hunter2"
+	assert.NoError(t, e.AddKV("password", "hunter2"))
+	assert.NoError(t, e.AddKV("Password2", &password))
+	assert.NoError(t, e.AddKV("api-key", 12345))
+	assert.NoError(t, e.AddKV("ClientSecret", []byte("secret")))
+	assert.NoError(t, e.AddKV("my_secret_flag", true))
+	assert.NoError(t, e.AddKV("user", "alice"))
+	assert.NoError(t, e.Report(ctx))
+
+	r.Close(1)
+	require.Len(t, r.EventBufs, 1)
+	m := bson.M{}
+	require.NoError(t, bson.Unmarshal(r.EventBufs[0], m))
+	assert.Equal(t, "****", m["password"])
+	assert.Equal(t, "****", m["api-key"])
+	assert.Equal(t, "****", m["ClientSecret"])
+	assert.Equal(t, "****", m["my_secret_flag"])
+	// only the names listed, or matching the regex, are redacted
+	assert.Equal(t, "hunter2", m["Password2"])
+	assert.Equal(t, "alice", m["user"])
+}
+
+func TestToValidUTF8(t *testing.T) {
+	assert.Equal(t, "", toValidUTF8(""))
+	assert.Equal(t, "abc", toValidUTF8("abc"))
+	assert.Equal(t, "\uFFFD", toValidUTF8("\uFFFD"))
+	assert.Equal(t, "a\uFFFDb\uFFFD\uFFFD", toValidUTF8("a\xffb\xe2\x82"))
+}
+
 func TestEventNoEdge(t *testing.T) {
 	r := SetTestReporter()
 	ctx := newTestContext(t)
@@ -190,3 +264,98 @@ func TestTracingModeToFlags(t *testing.T) {
 	assert.Equal(t, FLAG_OK, newTracingMode(config.DisabledTracingMode).toFlags())
 	assert.Equal(t, FLAG_OK, tracingMode(100).toFlags())
 }
+
+// newRepresentativeEvent returns an entry event of an HTTP request, which is
+// not prepared yet.
+func newRepresentativeEvent(ctx *oboeContext) (*event, error) {
+	e, err := ctx.newEvent(LabelEntry, testLayer)
+	if err != nil {
+		return nil, err
+	}
+	e.AddString("HTTPMethod", "GET")
+	e.AddString("HTTP-Host", "example.com")
+	e.AddString("URL", "/api/v1/users/123")
+	e.AddString("Query-String", "page=1&size=20")
+	e.AddString("Remote-Host", "127.0.0.1:52310")
+	e.AddInt("SampleRate", 1000000)
+	e.AddInt("SampleSource", 1)
+	e.AddEdge(ctx)
+	return e, nil
+}
+
+// BenchmarkEventEncode measures the encoding of a representative entry event
+// of an HTTP request. The size of the encoded event is set as the bytes
+// processed per operation, so the throughput is reported in MB/s.
+func BenchmarkEventEncode(b *testing.B) {
+	ctx := newContext(true).(*oboeContext)
+	b.ReportAllocs()
+	size := 0
+	for i := 0; i < b.N; i++ {
+		e, err := newRepresentativeEvent(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := prepareEvent(ctx, e); err != nil {
+			b.Fatal(err)
+		}
+		size = len(e.bbuf.GetBuf())
+	}
+	b.SetBytes(int64(size))
+}
+
+// BenchmarkEventEncodeMsgpack is the same as BenchmarkEventEncode, except that
+// the event is transcoded into MessagePack.
+func BenchmarkEventEncodeMsgpack(b *testing.B) {
+	ctx := newContext(true).(*oboeContext)
+	b.ReportAllocs()
+	size := 0
+	for i := 0; i < b.N; i++ {
+		e, err := newRepresentativeEvent(ctx)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := prepareEvent(ctx, e); err != nil {
+			b.Fatal(err)
+		}
+		buf, err := marshalEventMsgpack(e.bbuf.GetBuf())
+		if err != nil {
+			b.Fatal(err)
+		}
+		size = len(buf)
+	}
+	b.SetBytes(int64(size))
+}
+
+func TestEventMsgpackRoundTrip(t *testing.T) {
+	ctx := newContext(true).(*oboeContext)
+	e, err := newRepresentativeEvent(ctx)
+	require.NoError(t, err)
+	e.AddInt64("Duration", 1<<40)
+	e.AddInt("Backtrace-Depth", -200)
+	e.AddFloat64("Ratio", 0.25)
+	e.AddBool("Async", true)
+	e.AddString("Long", strings.Repeat("x", 300))
+	require.NoError(t, prepareEvent(ctx, e))
+
+	buf, err := marshalEventMsgpack(e.bbuf.GetBuf())
+	require.NoError(t, err)
+	decoded, err := unmarshalMsgpack(buf)
+	require.NoError(t, err)
+
+	var m bson.M
+	require.NoError(t, bson.Unmarshal(e.bbuf.GetBuf(), &m))
+	expected := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		// all the integers are decoded as int64
+		if i, ok := v.(int); ok {
+			v = int64(i)
+		}
+		expected[k] = v
+	}
+	assert.Equal(t, expected, decoded)
+	assert.True(t, len(buf) < len(e.bbuf.GetBuf()))
+
+	// truncated data is rejected
+	_, err = unmarshalMsgpack(buf[:len(buf)-1])
+	assert.Error(t, err)
+}
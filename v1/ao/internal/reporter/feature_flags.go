@@ -0,0 +1,69 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"sync/atomic"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// KVs from getSettingsResult arguments toggling the features remotely
+const (
+	kvMetricsEnabled     = "MetricsEnabled"
+	kvHistogramPrecision = "HistogramPrecision"
+)
+
+// the metrics toggle provided by the collector: 1 enabled, 0 disabled and -1
+// if it's never provided
+var remoteMetricsEnabled int32 = -1
+
+// if the histogram precision is configured by APPOPTICS_HISTOGRAM_PRECISION,
+// which takes precedence over the one provided by the collector
+var localHistogramPrecision bool
+
+// updateFeatureFlags applies the feature flags of the settings arguments, if
+// any. The flags not provided are left unchanged.
+func updateFeatureFlags(args map[string][]byte) {
+	if _, ok := args[kvMetricsEnabled]; ok {
+		enabled := parseInt32(args, kvMetricsEnabled, atomic.LoadInt32(&remoteMetricsEnabled))
+		if old := atomic.SwapInt32(&remoteMetricsEnabled, enabled); old != enabled {
+			log.Infof("Metrics are %s by the collector.", enabledString(enabled != 0))
+		}
+	}
+
+	if _, ok := args[kvHistogramPrecision]; ok {
+		if localHistogramPrecision || config.GetIgnoreRemoteFlags() {
+			return
+		}
+		metricsHTTPHistograms.lock.Lock()
+		defer metricsHTTPHistograms.lock.Unlock()
+		p := parseInt32(args, kvHistogramPrecision, int32(metricsHTTPHistograms.precision))
+		if p > 5 {
+			log.Warningf("Invalid histogram precision from the collector: %d", p)
+			return
+		}
+		// it applies to the histograms created since the next report cycle
+		metricsHTTPHistograms.precision = int(p)
+	}
+}
+
+// metricsEnabled returns if the metrics are reported. The local configuration
+// takes precedence over the flag provided by the collector.
+func metricsEnabled() bool {
+	if config.GetDisableMetrics() {
+		return false
+	}
+	if config.GetIgnoreRemoteFlags() {
+		return true
+	}
+	return atomic.LoadInt32(&remoteMetricsEnabled) != 0
+}
+
+func enabledString(enabled bool) string {
+	if enabled {
+		return "enabled"
+	}
+	return "disabled"
+}
@@ -0,0 +1,149 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+	"encoding/binary"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	pb "github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/collector"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newFlagsTestReporter returns a reporter whose metrics messages sent are
+// counted by the returned function.
+func newFlagsTestReporter(t *testing.T) (*grpcReporter, func() int) {
+	ec, err := newGrpcConnection("events channel", "test-addr", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+	mc, err := newGrpcConnection("metrics channel", "test-addr", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+
+	var sent int32
+	client := &mocks.TraceCollectorClient{}
+	client.On("PostMetrics", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) { atomic.AddInt32(&sent, 1) }).
+		Return(&pb.MessageResult{Result: pb.ResultCode_OK}, nil)
+	mc.client = client
+
+	r := &grpcReporter{
+		eventConnection:       ec,
+		metricConnection:      mc,
+		collectMetricInterval: grpcMetricIntervalDefault,
+		spanMessages:          make(chan SpanMessage, 1),
+		cond:                  sync.NewCond(&sync.Mutex{}),
+		done:                  make(chan struct{}),
+	}
+	return r, func() int {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, r.FlushMetrics(ctx))
+		return int(atomic.SwapInt32(&sent, 0))
+	}
+}
+
+// fakeSettings returns a settings response with the feature flags provided.
+func fakeSettings(flags map[string]int) *pb.SettingsResult {
+	args := make(map[string][]byte)
+	for k, v := range flags {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, uint32(v))
+		args[k] = b
+	}
+	return &pb.SettingsResult{
+		Result: pb.ResultCode_OK,
+		Settings: []*pb.OboeSetting{{
+			Type:      pb.OboeSettingType_DEFAULT_SAMPLE_RATE,
+			Flags:     []byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+			Value:     1000000,
+			Arguments: args,
+			Ttl:       120,
+		}},
+	}
+}
+
+func resetFeatureFlags() {
+	atomic.StoreInt32(&remoteMetricsEnabled, -1)
+	metricsHTTPHistograms.lock.Lock()
+	metricsHTTPHistograms.precision = metricsHistPrecisionDefault
+	metricsHTTPHistograms.lock.Unlock()
+	resetSettings()
+}
+
+func TestRemoteMetricsFlag(t *testing.T) {
+	defer resetFeatureFlags()
+	r, flush := newFlagsTestReporter(t)
+	defer close(r.done)
+	span := &HTTPSpanMessage{Transaction: "flags", Status: 200, Method: "GET"}
+
+	assert.Equal(t, 1, flush())
+
+	r.updateSettings(fakeSettings(map[string]int{kvMetricsEnabled: 0}))
+	assert.Equal(t, 0, flush())
+	assert.NoError(t, r.reportSpan(span))
+	assert.Empty(t, r.spanMessages)
+
+	// unchanged if the flag is not provided
+	r.updateSettings(fakeSettings(nil))
+	assert.Equal(t, 0, flush())
+
+	r.updateSettings(fakeSettings(map[string]int{kvMetricsEnabled: 1}))
+	assert.Equal(t, 1, flush())
+	assert.NoError(t, r.reportSpan(span))
+	assert.Len(t, r.spanMessages, 1)
+}
+
+func TestLocalMetricsConfigOverride(t *testing.T) {
+	defer resetFeatureFlags()
+	r, flush := newFlagsTestReporter(t)
+	defer close(r.done)
+
+	// enabled locally regardless of the collector
+	os.Setenv("APPOPTICS_IGNORE_REMOTE_FLAGS", "true")
+	config.Load()
+	r.updateSettings(fakeSettings(map[string]int{kvMetricsEnabled: 0}))
+	assert.Equal(t, 1, flush())
+	os.Unsetenv("APPOPTICS_IGNORE_REMOTE_FLAGS")
+	config.Load()
+	assert.Equal(t, 0, flush())
+
+	// disabled locally regardless of the collector
+	os.Setenv("APPOPTICS_DISABLE_METRICS", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_DISABLE_METRICS")
+		config.Load()
+	}()
+	r.updateSettings(fakeSettings(map[string]int{kvMetricsEnabled: 1}))
+	assert.Equal(t, 0, flush())
+}
+
+func TestRemoteHistogramPrecision(t *testing.T) {
+	defer resetFeatureFlags()
+	r, _ := newFlagsTestReporter(t)
+	defer close(r.done)
+
+	r.updateSettings(fakeSettings(map[string]int{kvHistogramPrecision: 4}))
+	assert.Equal(t, 4, metricsHTTPHistograms.precision)
+
+	// invalid
+	r.updateSettings(fakeSettings(map[string]int{kvHistogramPrecision: 6}))
+	assert.Equal(t, 4, metricsHTTPHistograms.precision)
+
+	os.Setenv("APPOPTICS_IGNORE_REMOTE_FLAGS", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_IGNORE_REMOTE_FLAGS")
+		config.Load()
+	}()
+	r.updateSettings(fakeSettings(map[string]int{kvHistogramPrecision: 1}))
+	assert.Equal(t, 4, metricsHTTPHistograms.precision)
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// the minimum interval between two warnings of the oversized headers
+const oversizedHeaderWarnInterval = time.Minute
+
+// the time in nanoseconds of the last warning of the oversized headers
+var lastOversizedHeaderWarn int64
+
+// OversizedHeader returns if the value of a header carrying the trace context,
+// the tracestate or a baggage item exceeds the configured maximum size. Such a header should be ignored without
+// parsing it, as if no context is propagated. A warning is logged at most once
+// per minute, so a misbehaving client cannot flood the logs.
+func OversizedHeader(name, value string) bool {
+	max := config.GetMaxHeaderSize()
+	if len(value) <= max {
+		return false
+	}
+
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&lastOversizedHeaderWarn)
+	if now-last >= int64(oversizedHeaderWarnInterval) &&
+		atomic.CompareAndSwapInt64(&lastOversizedHeaderWarn, last, now) {
+		log.Warningf("Ignoring the %s header of %d bytes which exceeds the maximum size %d.",
+			name, len(value), max)
+	}
+	return true
+}
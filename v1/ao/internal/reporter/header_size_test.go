@@ -0,0 +1,53 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOversizedHeader(t *testing.T) {
+	atomic.StoreInt64(&lastOversizedHeaderWarn, 0)
+	assert.False(t, OversizedHeader("X-Trace", ""))
+	assert.False(t, OversizedHeader("X-Trace", strings.Repeat("A", config.DefaultMaxHeaderSize)))
+	assert.True(t, OversizedHeader("X-Trace", strings.Repeat("A", config.DefaultMaxHeaderSize+1)))
+
+	// the warning is rate limited
+	warned := atomic.LoadInt64(&lastOversizedHeaderWarn)
+	assert.NotZero(t, warned)
+	assert.True(t, OversizedHeader("X-Trace", strings.Repeat("A", 2*config.DefaultMaxHeaderSize)))
+	assert.Equal(t, warned, atomic.LoadInt64(&lastOversizedHeaderWarn))
+
+	require.NoError(t, os.Setenv("APPOPTICS_MAX_HEADER_SIZE", "60"))
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_MAX_HEADER_SIZE")
+		config.Load()
+	}()
+	assert.False(t, OversizedHeader("X-Trace", strings.Repeat("A", 60)))
+	assert.True(t, OversizedHeader("X-Trace", strings.Repeat("A", 61)))
+}
+
+func TestNewContextOversizedHeader(t *testing.T) {
+	r := SetTestReporter()
+
+	md := "2B7435A9FE510AE4533414D425DADF4E180D2B4E3649E60702469DB05F01"
+	ctx, ok := NewContext("oversized", md+strings.Repeat("0", 4*1024*1024), true, nil)
+	require.True(t, ok)
+	// a new trace is started as if no context is propagated
+	assert.True(t, ctx.IsSampled())
+	assert.NotContains(t, ctx.MetadataString(), "7435A9FE510AE4533414D425DADF4E180D2B4E36")
+
+	r.Close(1)
+	g.AssertGraph(t, r.EventBufs, 1, g.AssertNodeMap{
+		{"oversized", "entry"}: {Edges: g.Edges{}},
+	})
+}
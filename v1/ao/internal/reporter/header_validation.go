@@ -0,0 +1,56 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/pkg/errors"
+)
+
+// the minimum interval between two warnings of the repaired headers
+const repairedHeaderWarnInterval = time.Minute
+
+// the time in nanoseconds of the last warning of the repaired headers
+var lastRepairedHeaderWarn int64
+
+// ErrMalformedHeader is returned by ValidateHeader if the header is malformed
+// and rejected by the strict validation.
+var ErrMalformedHeader = errors.New("malformed header")
+
+// ValidateHeader validates the value of a header carrying the trace context
+// per the validation strictness (APPOPTICS_HEADER_VALIDATION) and returns the
+// value to be parsed. A malformed value, e.g., with extra whitespace, quotes or
+// in lower case, is rejected with ErrMalformedHeader in the strict mode, and
+// repaired in the lenient mode with a warning logged at most once per minute.
+func ValidateHeader(name, value string) (string, error) {
+	repaired := repairHeader(value)
+	if repaired == value {
+		return value, nil
+	}
+	if config.GetHeaderValidation() == config.HeaderValidationStrict {
+		return "", errors.Wrapf(ErrMalformedHeader, "%s: %q", name, value)
+	}
+
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&lastRepairedHeaderWarn)
+	if now-last >= int64(repairedHeaderWarnInterval) &&
+		atomic.CompareAndSwapInt64(&lastRepairedHeaderWarn, last, now) {
+		log.Warningf("Repaired the malformed %s header %q, check the upstream services.", name, value)
+	}
+	return repaired, nil
+}
+
+// repairHeader fixes the common issues of the header value: the surrounding
+// whitespace and quotes, and the lower case hex digits.
+func repairHeader(value string) string {
+	v := strings.TrimSpace(value)
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = strings.TrimSpace(v[1 : len(v)-1])
+	}
+	return strings.ToUpper(v)
+}
@@ -0,0 +1,86 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	validXTrace     = "2B7435A9FE510AE4533414D425DADF4E180D2B4E3649E60702469DB05F01"
+	malformedXTrace = "  \"2b7435a9fe510ae4533414d425dadf4e180d2b4e3649e60702469db05f01\"\t"
+)
+
+func setHeaderValidation(t *testing.T, mode string) func() {
+	require.NoError(t, os.Setenv("APPOPTICS_HEADER_VALIDATION", mode))
+	config.Load()
+	return func() {
+		os.Unsetenv("APPOPTICS_HEADER_VALIDATION")
+		config.Load()
+	}
+}
+
+func TestValidateHeaderLenient(t *testing.T) {
+	assert.Equal(t, config.HeaderValidationLenient, config.GetHeaderValidation())
+	atomic.StoreInt64(&lastRepairedHeaderWarn, 0)
+
+	v, err := ValidateHeader("X-Trace", validXTrace)
+	assert.NoError(t, err)
+	assert.Equal(t, validXTrace, v)
+	assert.Zero(t, atomic.LoadInt64(&lastRepairedHeaderWarn))
+
+	v, err = ValidateHeader("X-Trace", malformedXTrace)
+	assert.NoError(t, err)
+	assert.Equal(t, validXTrace, v)
+
+	// the warning is rate limited
+	warned := atomic.LoadInt64(&lastRepairedHeaderWarn)
+	assert.NotZero(t, warned)
+	v, err = ValidateHeader("X-Trace", " "+validXTrace)
+	assert.NoError(t, err)
+	assert.Equal(t, validXTrace, v)
+	assert.Equal(t, warned, atomic.LoadInt64(&lastRepairedHeaderWarn))
+}
+
+func TestValidateHeaderStrict(t *testing.T) {
+	defer setHeaderValidation(t, "strict")()
+
+	v, err := ValidateHeader("X-Trace", validXTrace)
+	assert.NoError(t, err)
+	assert.Equal(t, validXTrace, v)
+
+	for _, md := range []string{malformedXTrace, " " + validXTrace, strings.ToLower(validXTrace)} {
+		_, err = ValidateHeader("X-Trace", md)
+		assert.Equal(t, ErrMalformedHeader, errors.Cause(err), md)
+	}
+}
+
+func TestNewContextMalformedHeader(t *testing.T) {
+	r := SetTestReporter()
+
+	// the trace is continued from the repaired header
+	ctx, ok := NewContext("malformed", malformedXTrace, true, nil)
+	require.True(t, ok)
+	assert.Contains(t, ctx.MetadataString(), "7435A9FE510AE4533414D425DADF4E180D2B4E36")
+
+	// a new trace is started as if no context is propagated
+	defer setHeaderValidation(t, "strict")()
+	ctx, ok = NewContext("malformed", malformedXTrace, true, nil)
+	require.True(t, ok)
+	assert.True(t, ctx.IsSampled())
+	assert.NotContains(t, ctx.MetadataString(), "7435A9FE510AE4533414D425DADF4E180D2B4E36")
+
+	ctx, ok = NewContext("malformed", validXTrace, true, nil)
+	require.True(t, ok)
+	assert.Contains(t, ctx.MetadataString(), "7435A9FE510AE4533414D425DADF4E180D2B4E36")
+
+	r.Close(3)
+}
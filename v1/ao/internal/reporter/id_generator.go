@@ -0,0 +1,52 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import "sync/atomic"
+
+// IDGenerator generates the IDs of the traces and spans. It must be safe for
+// concurrent use as the IDs are generated from multiple goroutines.
+type IDGenerator interface {
+	// GenerateTraceID fills id with a new trace ID.
+	GenerateTraceID(id []byte) error
+	// GenerateSpanID fills id with a new span ID.
+	GenerateSpanID(id []byte) error
+}
+
+// randIDGenerator is the default ID generator reading from randReader.
+type randIDGenerator struct{}
+
+func (randIDGenerator) GenerateTraceID(id []byte) error {
+	_, err := randReader.Read(id)
+	return err
+}
+
+func (randIDGenerator) GenerateSpanID(id []byte) error {
+	_, err := randReader.Read(id)
+	return err
+}
+
+// idGeneratorHolder wraps the ID generator as atomic.Value requires the values
+// stored to be of the same concrete type.
+type idGeneratorHolder struct {
+	IDGenerator
+}
+
+var idGenerator atomic.Value
+
+func init() {
+	SetIDGenerator(nil)
+}
+
+// SetIDGenerator replaces the ID generator of the traces and spans. The
+// default crypto/rand based generator is restored if g is nil.
+func SetIDGenerator(g IDGenerator) {
+	if g == nil {
+		g = randIDGenerator{}
+	}
+	idGenerator.Store(idGeneratorHolder{g})
+}
+
+func getIDGenerator() IDGenerator {
+	return idGenerator.Load().(idGeneratorHolder).IDGenerator
+}
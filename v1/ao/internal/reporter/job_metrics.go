@@ -0,0 +1,36 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import "time"
+
+const (
+	// the max number of job names in a metrics report cycle, the others are
+	// aggregated as OtherTransactionName.
+	metricsJobNamesMax = 50
+
+	jobDurationMetricName = "JobDuration"
+
+	// the outcomes of a job run, reported as the JobOutcome tag
+	JobOutcomeSuccess = "success"
+	JobOutcomeFailure = "failure"
+)
+
+// the job names recorded in the current metrics report cycle
+var mJobNames = NewTransMap(metricsJobNamesMax)
+
+// RecordJob aggregates a run of the job into the metric JobDuration, whose
+// sum is the duration in microseconds, tagged by the job name and outcome.
+func RecordJob(jobName string, duration time.Duration, outcome string) {
+	if jobName == "" {
+		jobName = UnknownTransactionName
+	} else if !mJobNames.IsWithinLimit(jobName) {
+		jobName = OtherTransactionName
+	}
+
+	metricsHTTPMeasurements.lock.Lock()
+	defer metricsHTTPMeasurements.lock.Unlock()
+	recordMeasurement(metricsHTTPMeasurements, jobDurationMetricName,
+		&map[string]string{"JobName": jobName, "JobOutcome": outcome},
+		float64(duration/time.Microsecond), 1, true)
+}
@@ -0,0 +1,41 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordJob(t *testing.T) {
+	metricsHTTPMeasurements.lock.Lock()
+	metricsHTTPMeasurements.measurements = make(map[string]*Measurement)
+	metricsHTTPMeasurements.lock.Unlock()
+	mJobNames.Reset()
+	defer mJobNames.Reset()
+
+	RecordJob("cleanup", 2*time.Millisecond, JobOutcomeSuccess)
+	RecordJob("cleanup", 3*time.Millisecond, JobOutcomeSuccess)
+	RecordJob("cleanup", time.Millisecond, JobOutcomeFailure)
+	// the job names are bounded
+	for i := 0; i < metricsJobNamesMax; i++ {
+		RecordJob("job"+strconv.Itoa(i), time.Millisecond, JobOutcomeSuccess)
+	}
+
+	metricsHTTPMeasurements.lock.Lock()
+	defer metricsHTTPMeasurements.lock.Unlock()
+	get := func(jobName, outcome string) *Measurement {
+		m, ok := metricsHTTPMeasurements.measurements["JobDuration&true&JobName:"+jobName+"&JobOutcome:"+outcome+"&"]
+		require.True(t, ok, "%s %s", jobName, outcome)
+		return m
+	}
+	m := get("cleanup", JobOutcomeSuccess)
+	assert.Equal(t, 2, m.Count)
+	assert.Equal(t, float64(5000), m.Sum)
+	assert.Equal(t, 1, get("cleanup", JobOutcomeFailure).Count)
+	assert.Equal(t, 1, get(OtherTransactionName, JobOutcomeSuccess).Count)
+}
@@ -0,0 +1,275 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+const (
+	// the maximum number of events buffered for a trace
+	traceBufferMaxEvents = 1000
+	// the maximum size in bytes of the events buffered for all the traces
+	traceBufferMaxBytes = 16 * 1024 * 1024
+)
+
+// the total size of the events buffered by all the trace buffers
+var traceBufferBytes int64
+
+// the states of a trace buffer
+const (
+	traceBufferPending = iota // buffering the events until the trace is kept or ends
+	traceBufferKept           // the trace is kept, the events are sent as usual
+	traceBufferDropped        // the trace is not kept or overflowed, the events are dropped
+)
+
+// traceBuffer holds the events of a trace which is not sampled, so the trace
+// can still be kept if an error is reported in it (APPOPTICS_KEEP_ERRORS), or
+// if it's a latency outlier of its transaction (APPOPTICS_ADAPTIVE_SAMPLING),
+// or captured for retroactive sending (APPOPTICS_RETROACTIVE_CAPTURE_WINDOW).
+// Its trace is sampled internally, but not propagated as sampled to the
+// downstream services while it's pending.
+type traceBuffer struct {
+	sync.Mutex
+	state  int
+	events []*event
+	size   int
+	// the copies of the buffered events for the event sink, if any
+	sinkEvents []*ProcessedEvent
+	// the number of the spans entered but not exited yet
+	depth int
+	// the start time and the transaction name of the trace
+	start time.Time
+	txn   string
+	// the class of the last error reported in the trace
+	errClass string
+	// the HTTP status code reported by the root span, if any
+	status int
+}
+
+// needTraceBuffer returns if the unsampled traces need to be buffered.
+func needTraceBuffer() bool {
+	return config.GetKeepErrors() || config.GetAdaptiveSampling() || retroCaptureEnabled()
+}
+
+func newTraceBuffer() *traceBuffer {
+	return &traceBuffer{start: time.Now()}
+}
+
+// pending returns if the buffer has not decided whether to keep the trace.
+func (b *traceBuffer) pending() bool {
+	b.Lock()
+	defer b.Unlock()
+	return b.state == traceBufferPending
+}
+
+// setTransactionName records the transaction name reported by the KVs of the
+// root span's exit event, if any.
+func (b *traceBuffer) setTransactionName(args ...interface{}) {
+	if v, ok := stringKV("TransactionName", args...); ok {
+		b.Lock()
+		b.txn = v
+		b.Unlock()
+	}
+}
+
+// setStatus records the HTTP status code reported by the KVs of the root
+// span's exit event, if any.
+func (b *traceBuffer) setStatus(args ...interface{}) {
+	for i := 0; i+1 < len(args); i += 2 {
+		if k, ok := args[i].(string); !ok || k != "Status" {
+			continue
+		}
+		var status int
+		switch v := args[i+1].(type) {
+		case int:
+			status = v
+		case *int:
+			status = *v
+		default:
+			continue
+		}
+		b.Lock()
+		b.status = status
+		b.Unlock()
+	}
+}
+
+// SetTraceStatus records the HTTP status code of the trace of the context in
+// its trace buffer, if any, as the exit event may not report it, e.g., when
+// the Status KV is disabled.
+func SetTraceStatus(c Context, status int) {
+	ctx, ok := c.(*oboeContext)
+	if !ok {
+		return
+	}
+	if b := ctx.traceBuffer(); b != nil {
+		b.Lock()
+		b.status = status
+		b.Unlock()
+	}
+}
+
+// setErrorClass records the error class reported by the KVs of an error
+// event, if any.
+func (b *traceBuffer) setErrorClass(args ...interface{}) {
+	if v, ok := stringKV("ErrorClass", args...); ok {
+		b.Lock()
+		b.errClass = v
+		b.Unlock()
+	}
+}
+
+// stringKV returns the string value of the key in the KVs.
+func stringKV(key string, args ...interface{}) (string, bool) {
+	for i := 0; i+1 < len(args); i += 2 {
+		if k, ok := args[i].(string); ok && k == key {
+			if v, ok := args[i+1].(string); ok {
+				return v, true
+			}
+		}
+	}
+	return "", false
+}
+
+// report buffers the event, or reports it with the reporter if the trace has
+// been kept. The buffered events are sent once the trace is kept and dropped
+// once the root span exits otherwise.
+func (b *traceBuffer) report(ctx *oboeContext, e *event, r reporter) error {
+	b.Lock()
+	defer b.Unlock()
+
+	switch b.state {
+	case traceBufferKept:
+		return r.reportEvent(ctx, e)
+	case traceBufferDropped:
+		return nil
+	}
+
+	// Prepare it now to get the timestamp right and the context's op_id updated
+	// for the edges of the following events.
+	if err := prepareEvent(ctx, e); err != nil {
+		return err
+	}
+	e.prepared = true
+
+	size := len(e.bbuf.GetBuf())
+	if len(b.events) >= traceBufferMaxEvents ||
+		atomic.LoadInt64(&traceBufferBytes)+int64(size) > traceBufferMaxBytes {
+		log.Debug("Dropping the unsampled trace as the trace buffer is full.")
+		b.drop()
+		return nil
+	}
+	atomic.AddInt64(&traceBufferBytes, int64(size))
+	b.events = append(b.events, e)
+	b.size += size
+
+	switch e.label {
+	case LabelEntry, LabelProfileEntry:
+		b.depth++
+	case LabelExit, LabelProfileExit:
+		b.depth--
+		if b.depth <= 0 {
+			if config.GetAdaptiveSampling() && b.isOutlier(ctx) {
+				return b.keep(ctx, r)
+			}
+			if b.isErrorStatus() {
+				return b.keep(ctx, r)
+			}
+			if retroCaptureEnabled() {
+				globalRetroCapture.add(ctx, b.events, b.size)
+			}
+			b.drop()
+		}
+	case LabelError:
+		if config.GetKeepErrors() && globalErrorLimiter.keep(b.errClass) {
+			return b.keep(ctx, r)
+		}
+	}
+	return nil
+}
+
+// isErrorStatus returns if the trace, which has just ended, is kept as its
+// HTTP status code marks it as an error (APPOPTICS_ERROR_STATUS_CODES).
+func (b *traceBuffer) isErrorStatus() bool {
+	if !config.GetKeepErrors() || b.status == 0 || !config.IsErrorStatusCode(b.status) {
+		return false
+	}
+	return globalErrorLimiter.keep(strconv.Itoa(b.status))
+}
+
+// isOutlier returns if the trace, which has just ended, is kept by the
+// adaptive sampler as a latency outlier of its transaction.
+func (b *traceBuffer) isOutlier(ctx *oboeContext) bool {
+	txn := b.txn
+	if txn == "" {
+		txn = ctx.GetTransactionName()
+	}
+	return globalAdaptiveSampler.keep(txn, time.Since(b.start))
+}
+
+// keep sends the buffered events and switches the trace to sent as usual.
+func (b *traceBuffer) keep(ctx *oboeContext, r reporter) error {
+	events, sinkEvents := b.events, b.sinkEvents
+	b.release(traceBufferKept)
+
+	var err error
+	for _, e := range events {
+		// the events are prepared already, so the context is left untouched.
+		if reportErr := r.reportEvent(ctx, e); reportErr != nil {
+			err = reportErr
+		}
+	}
+	if sink := eventSink.Load().(eventSinkHolder).sink; sink != nil {
+		for _, pe := range sinkEvents {
+			sink(pe)
+		}
+	}
+	return err
+}
+
+// deferSink holds the copy of the event for the event sink until the trace is
+// kept, or discards it if the trace is dropped. It returns false if the trace
+// has been kept and the event is to be passed to the sink as usual.
+func (b *traceBuffer) deferSink(pe *ProcessedEvent) bool {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case traceBufferPending:
+		b.sinkEvents = append(b.sinkEvents, pe)
+	case traceBufferKept:
+		return false
+	}
+	return true
+}
+
+// keepPending keeps the trace if it's still pending and its error class is
+// within the limit, e.g., to send an unsampled trace which reports an error
+// along with the traces captured retroactively.
+func (b *traceBuffer) keepPending(ctx *oboeContext, r reporter) error {
+	b.Lock()
+	defer b.Unlock()
+	if b.state != traceBufferPending || !globalErrorLimiter.keep(b.errClass) {
+		return nil
+	}
+	return b.keep(ctx, r)
+}
+
+// drop discards the buffered events and all the following events of the trace.
+func (b *traceBuffer) drop() {
+	b.release(traceBufferDropped)
+}
+
+func (b *traceBuffer) release(state int) {
+	atomic.AddInt64(&traceBufferBytes, -int64(b.size))
+	b.events = nil
+	b.sinkEvents = nil
+	b.size = 0
+	b.state = state
+}
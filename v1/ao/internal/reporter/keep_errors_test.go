@@ -0,0 +1,168 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func setKeepErrors(t *testing.T) func() {
+	require.NoError(t, os.Setenv("APPOPTICS_KEEP_ERRORS", "true"))
+	config.Load()
+	return func() {
+		os.Unsetenv("APPOPTICS_KEEP_ERRORS")
+		config.Load()
+	}
+}
+
+func TestKeepErrorsUnsampledTraceWithError(t *testing.T) {
+	defer setKeepErrors(t)()
+	r := SetTestReporter(TestReporterSampleRate(0))
+
+	ctx, ok := NewContext("keepErrors", "", true, nil)
+	require.True(t, ok)
+	assert.True(t, ctx.IsSampled())
+	// still propagated as not sampled as long as it's pending
+	md := ctx.MetadataString()
+	assert.Equal(t, "00", md[len(md)-2:])
+
+	assert.NoError(t, ctx.ReportEvent(LabelInfo, "keepErrors", "K", "V"))
+	assert.Empty(t, r.EventBufs)
+	assert.NoError(t, ctx.ReportEvent(LabelError, "keepErrors", "ErrorClass", "error"))
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "keepErrors"))
+	md = ctx.MetadataString()
+	assert.Equal(t, "01", md[len(md)-2:])
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"keepErrors", "entry"}: {},
+		{"keepErrors", "info"}:  {Edges: g.Edges{{"keepErrors", "entry"}}},
+		{"keepErrors", "error"}: {Edges: g.Edges{{"keepErrors", "info"}}},
+		{"keepErrors", "exit"}:  {Edges: g.Edges{{"keepErrors", "error"}}},
+	})
+	assert.Zero(t, atomic.LoadInt64(&traceBufferBytes))
+}
+
+func TestKeepErrorsUnsampledTraceDropped(t *testing.T) {
+	defer setKeepErrors(t)()
+	r := SetTestReporter(TestReporterSampleRate(0))
+
+	ctx, ok := NewContext("keepErrors", "", true, nil)
+	require.True(t, ok)
+	assert.NoError(t, ctx.ReportEvent(LabelInfo, "keepErrors", "K", "V"))
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "keepErrors"))
+	// the events after the end of the trace are dropped too
+	assert.NoError(t, ctx.ReportEvent(LabelError, "keepErrors", "ErrorClass", "error"))
+
+	r.Close(0)
+	assert.Empty(t, r.EventBufs)
+	assert.Zero(t, atomic.LoadInt64(&traceBufferBytes))
+}
+
+func TestKeepErrorsEventSink(t *testing.T) {
+	defer setKeepErrors(t)()
+	r := SetTestReporter(TestReporterSampleRate(0))
+	var labels []string
+	SetEventSink(func(e *ProcessedEvent) { labels = append(labels, e.Label()) })
+	defer SetEventSink(nil)
+
+	// the events of a dropped trace are not passed to the sink
+	ctx, ok := NewContext("keepErrors", "", true, nil)
+	require.True(t, ok)
+	assert.NoError(t, ctx.ReportEvent(LabelInfo, "keepErrors", "K", "V"))
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "keepErrors"))
+	assert.Empty(t, labels)
+
+	// the buffered events are passed in order once the trace is kept
+	ctx, ok = NewContext("keepErrors", "", true, nil)
+	require.True(t, ok)
+	assert.NoError(t, ctx.ReportEvent(LabelInfo, "keepErrors", "K", "V"))
+	assert.Empty(t, labels)
+	assert.NoError(t, ctx.ReportEvent(LabelError, "keepErrors", "ErrorClass", "error"))
+	assert.Equal(t, []string{"entry", "info", "error"}, labels)
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "keepErrors"))
+	assert.Equal(t, []string{"entry", "info", "error", "exit"}, labels)
+
+	r.Close(4)
+}
+
+func TestKeepErrorsRateLimited(t *testing.T) {
+	defer setKeepErrors(t)()
+	require.NoError(t, os.Setenv("APPOPTICS_KEEP_ERRORS_RATE", "0.1"))
+	config.Load()
+	defer os.Unsetenv("APPOPTICS_KEEP_ERRORS_RATE")
+	globalErrorLimiter = newErrorLimiter()
+	metricsHTTPMeasurements.lock.Lock()
+	metricsHTTPMeasurements.measurements = make(map[string]*Measurement)
+	metricsHTTPMeasurements.lock.Unlock()
+	r := SetTestReporter(TestReporterSampleRate(0))
+
+	report := func(class string) {
+		ctx, ok := NewContext("keepErrors", "", true, nil)
+		require.True(t, ok)
+		assert.NoError(t, ctx.ReportEvent(LabelError, "keepErrors", "ErrorClass", class))
+		assert.NoError(t, ctx.ReportEvent(LabelExit, "keepErrors"))
+	}
+	// flood an error class, only one trace is kept within the rate
+	for i := 0; i < 10; i++ {
+		report("timeout")
+	}
+	// the other classes are not crowded out
+	report("db")
+
+	r.Close(6)
+	assert.Len(t, r.EventBufs, 6)
+	classes := make(map[string]int)
+	for _, buf := range r.EventBufs {
+		m := make(map[string]interface{})
+		require.NoError(t, bson.Unmarshal(buf, m))
+		if m["Label"] == "error" {
+			classes[m["ErrorClass"].(string)]++
+		}
+	}
+	assert.Equal(t, map[string]int{"timeout": 1, "db": 1}, classes)
+
+	metricsHTTPMeasurements.lock.Lock()
+	defer metricsHTTPMeasurements.lock.Unlock()
+	m := metricsHTTPMeasurements.measurements["SuppressedErrors&false&ErrorClass:timeout&"]
+	if assert.NotNil(t, m) {
+		assert.Equal(t, 9, m.Count)
+	}
+	assert.Nil(t, metricsHTTPMeasurements.measurements["SuppressedErrors&false&ErrorClass:db&"])
+}
+
+func TestKeepErrorsBufferBounded(t *testing.T) {
+	defer setKeepErrors(t)()
+	r := SetTestReporter(TestReporterSampleRate(0))
+
+	ctx, ok := NewContext("keepErrors", "", true, nil)
+	require.True(t, ok)
+	for i := 0; i < traceBufferMaxEvents; i++ {
+		assert.NoError(t, ctx.ReportEvent(LabelInfo, "keepErrors"))
+	}
+	assert.NoError(t, ctx.ReportEvent(LabelError, "keepErrors", "ErrorClass", "error"))
+
+	r.Close(0)
+	assert.Empty(t, r.EventBufs)
+	assert.Zero(t, atomic.LoadInt64(&traceBufferBytes))
+}
+
+func TestKeepErrorsDisabled(t *testing.T) {
+	r := SetTestReporter(TestReporterSampleRate(0))
+
+	ctx, ok := NewContext("keepErrors", "", true, nil)
+	require.True(t, ok)
+	assert.False(t, ctx.IsSampled())
+	assert.NoError(t, ctx.ReportEvent(LabelError, "keepErrors", "ErrorClass", "error"))
+
+	r.Close(0)
+	assert.Empty(t, r.EventBufs)
+}
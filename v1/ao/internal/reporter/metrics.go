@@ -13,6 +13,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/hdrhist"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/host"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
@@ -26,6 +27,7 @@ const (
 
 	metricsTagNameLengthMax  = 64  // max number of characters for tag names
 	metricsTagValueLengthMax = 255 // max number of characters for tag values
+	metricsTagsCountMax      = 50  // max number of tags of a metric
 )
 
 // Special transaction names
@@ -45,6 +47,8 @@ type SpanMessage interface {
 type BaseSpanMessage struct {
 	Duration time.Duration // duration of the span (nanoseconds)
 	HasError bool          // boolean flag whether this transaction contains an error or not
+	// the time spent waiting in a queue before the processing started, if any
+	QueueTime time.Duration
 }
 
 // HTTPSpanMessage is used for inbound metrics
@@ -113,6 +117,9 @@ type TransMap struct {
 	// cycle, the new capacity got from the server is stored in nextCap and will only be flushed to currCap
 	// when the Reset() is called.
 	nextCap int32
+	// The local limit which can only lower the capacity, or nil if the map is
+	// not limited locally.
+	localCap func() int
 	// Whether there is an overflow. Overflow means the user tried to store more transaction names
 	// than the capacity defined by settings.
 	// This flag is cleared in every metrics cycle.
@@ -133,6 +140,14 @@ func NewTransMap(cap int32) *TransMap {
 	}
 }
 
+// newLocalTransMap initializes a new TransMap struct whose capacity is further
+// limited by the local setting returned by localCap, if positive.
+func newLocalTransMap(cap int32, localCap func() int) *TransMap {
+	t := NewTransMap(cap)
+	t.localCap = localCap
+	return t
+}
+
 // SetCap sets the capacity of the transaction map
 func (t *TransMap) SetCap(cap int32) {
 	t.mutex.Lock()
@@ -147,6 +162,23 @@ func (t *TransMap) Cap() int32 {
 	return t.currCap
 }
 
+// Limit returns the current capacity lowered by the local limit, if any.
+func (t *TransMap) Limit() int32 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return t.limit()
+}
+
+func (t *TransMap) limit() int32 {
+	limit := t.currCap
+	if t.localCap != nil {
+		if max := t.localCap(); max > 0 && max < int(limit) {
+			limit = int32(max)
+		}
+	}
+	return limit
+}
+
 // ResetTransMap resets the transaction map to a initialized state. The new capacity got from the
 // server will be used in next metrics reporting cycle after reset.
 func (t *TransMap) Reset() {
@@ -165,11 +197,16 @@ func (t *TransMap) IsWithinLimit(name string) bool {
 	defer t.mutex.Unlock()
 
 	if _, ok := t.transactionNames[name]; !ok {
+		limit := t.limit()
 		// only record if we haven't reached the limits yet
-		if int32(len(t.transactionNames)) < t.currCap {
+		if int32(len(t.transactionNames)) < limit {
 			t.transactionNames[name] = struct{}{}
 			return true
 		}
+		if !t.overflow {
+			log.Warningf("The number of transaction names exceeds the limit %d, the others are reported as %q in this cycle.",
+				limit, OtherTransactionName)
+		}
 		t.overflow = true
 		return false
 	}
@@ -186,8 +223,39 @@ func (t *TransMap) Overflow() bool {
 }
 
 // mTransMap is the list of currently stored unique HTTP transaction names
-// (flushed on each metrics report cycle)
-var mTransMap = NewTransMap(metricsTransactionsMaxDefault)
+// (flushed on each metrics report cycle). The local MaxTransactions setting can
+// only lower the limit provided by the collector.
+var mTransMap = newLocalTransMap(metricsTransactionsMaxDefault, config.GetMaxTransactions)
+
+// the number of spans folded into their parents for being shorter than the
+// minimum span duration (flushed on each metrics report cycle)
+var spansFolded int64
+
+// RecordSpanFolded counts a span which is folded into its parent instead of
+// being reported.
+func RecordSpanFolded() {
+	atomic.AddInt64(&spansFolded, 1)
+}
+
+// the number of the traces and spans which have begun but not ended yet. A
+// steadily rising number indicates the spans are leaked, i.e., not ended by
+// the application.
+var activeTraces, activeSpans int64
+
+// AddActiveTraces adds delta to the number of active traces
+func AddActiveTraces(delta int64) {
+	atomic.AddInt64(&activeTraces, delta)
+}
+
+// AddActiveSpans adds delta to the number of active spans
+func AddActiveSpans(delta int64) {
+	atomic.AddInt64(&activeSpans, delta)
+}
+
+// ActiveTracesAndSpans returns the number of active traces and spans
+func ActiveTracesAndSpans() (traces, spans int64) {
+	return atomic.LoadInt64(&activeTraces), atomic.LoadInt64(&activeSpans)
+}
 
 // collection of currently stored measurements (flushed on each metrics report cycle)
 var metricsHTTPMeasurements = &measurements{
@@ -210,6 +278,7 @@ func init() {
 		if p, err := strconv.Atoi(precision); err == nil {
 			if p >= 0 && p <= 5 {
 				metricsHTTPHistograms.precision = p
+				localHistogramPrecision = true
 			} else {
 				log.Errorf("value of %v must be between 0 and 5: %v", pEnv, precision)
 			}
@@ -225,6 +294,7 @@ func init() {
 // return				metrics message in BSON format
 func generateMetricsMessage(metricsFlushInterval int, queueStats *eventQueueStats) []byte {
 	bbuf := NewBsonBuffer()
+	sd := newStatsDBatch()
 
 	appendHostId(bbuf)
 	bsonAppendInt64(bbuf, "Timestamp_u", int64(time.Now().UnixNano()/1000))
@@ -242,6 +312,11 @@ func generateMetricsMessage(metricsFlushInterval int, queueStats *eventQueueStat
 	addMetricsValue(bbuf, &index, "TokenBucketExhaustionCount", rc.limited)
 	addMetricsValue(bbuf, &index, "SampleCount", rc.sampled)
 	addMetricsValue(bbuf, &index, "ThroughTraceCount", rc.through)
+	sd.count("RequestCount", rc.requested, nil)
+	sd.count("TraceCount", rc.traced, nil)
+	sd.count("TokenBucketExhaustionCount", rc.limited, nil)
+	sd.count("SampleCount", rc.sampled, nil)
+	sd.count("ThroughTraceCount", rc.through, nil)
 
 	// Queue states
 	q := queueStats.copyAndReset()
@@ -250,6 +325,33 @@ func generateMetricsMessage(metricsFlushInterval int, queueStats *eventQueueStat
 	addMetricsValue(bbuf, &index, "NumFailed", q.numFailed)
 	addMetricsValue(bbuf, &index, "TotalEvents", q.totalEvents)
 	addMetricsValue(bbuf, &index, "QueueLargest", q.queueLargest)
+	folded := atomic.SwapInt64(&spansFolded, 0)
+	addMetricsValue(bbuf, &index, "SpansFolded", folded)
+	skewed := atomic.SwapInt64(&skewedEvents, 0)
+	addMetricsValue(bbuf, &index, "SkewedEvents", skewed)
+	traces, spans := ActiveTracesAndSpans()
+	addMetricsValue(bbuf, &index, "ActiveTraces", traces)
+	addMetricsValue(bbuf, &index, "ActiveSpans", spans)
+	newConns := atomic.SwapInt64(&connsNew, 0)
+	addMetricsValue(bbuf, &index, "ConnectionsNew", newConns)
+	closedConns := atomic.SwapInt64(&connsClosed, 0)
+	addMetricsValue(bbuf, &index, "ConnectionsClosed", closedConns)
+	activeConns, idleConns := ActiveAndIdleConns()
+	addMetricsValue(bbuf, &index, "ConnectionsActive", activeConns)
+	addMetricsValue(bbuf, &index, "ConnectionsIdle", idleConns)
+	sd.count("NumSent", q.numSent, nil)
+	sd.count("NumOverflowed", q.numOverflowed, nil)
+	sd.count("NumFailed", q.numFailed, nil)
+	sd.count("TotalEvents", q.totalEvents, nil)
+	sd.gauge("QueueLargest", float64(q.queueLargest), nil)
+	sd.count("SpansFolded", folded, nil)
+	sd.count("SkewedEvents", skewed, nil)
+	sd.gauge("ActiveTraces", float64(traces), nil)
+	sd.gauge("ActiveSpans", float64(spans), nil)
+	sd.count("ConnectionsNew", newConns, nil)
+	sd.count("ConnectionsClosed", closedConns, nil)
+	sd.gauge("ConnectionsActive", float64(activeConns), nil)
+	sd.gauge("ConnectionsIdle", float64(idleConns), nil)
 
 	addHostMetrics(bbuf, &index)
 
@@ -272,14 +374,32 @@ func generateMetricsMessage(metricsFlushInterval int, queueStats *eventQueueStat
 	var gc debug.GCStats
 	host.GC(&gc)
 	addMetricsValue(bbuf, &index, "JMX.type=count,name=GCStats.NumGC", gc.NumGC)
+	sd.gauge("runtime.NumGoroutine", float64(runtime.NumGoroutine()), nil)
+	sd.gauge("runtime.MemStats.Alloc", float64(mem.Alloc), nil)
+	sd.gauge("runtime.MemStats.Sys", float64(mem.Sys), nil)
+	sd.gauge("runtime.MemStats.HeapInuse", float64(mem.HeapInuse), nil)
+	sd.gauge("runtime.GCStats.NumGC", float64(gc.NumGC), nil)
 
 	metricsHTTPMeasurements.lock.Lock()
 	for _, m := range metricsHTTPMeasurements.measurements {
 		addMeasurementToBSON(bbuf, &index, m)
+		sd.measurement(m)
 	}
 	metricsHTTPMeasurements.measurements = make(map[string]*Measurement) // clear measurements
 	metricsHTTPMeasurements.lock.Unlock()
 
+	// The histograms are locked until they are cleared so the Apdex scores are
+	// computed from the same responses as the histograms sent below.
+	metricsHTTPHistograms.lock.Lock()
+
+	// Apdex scores, computed from the response time histograms
+	if t := config.GetApdexThreshold(); t > 0 {
+		threshold := time.Duration(t) * time.Millisecond
+		for _, h := range metricsHTTPHistograms.histograms {
+			addApdexToBSON(bbuf, &index, h, threshold)
+		}
+	}
+
 	bsonAppendFinishObject(bbuf, start)
 	// ==========================================
 
@@ -288,11 +408,11 @@ func generateMetricsMessage(metricsFlushInterval int, queueStats *eventQueueStat
 	start = bsonAppendStartArray(bbuf, "histograms")
 	index = 0
 
-	metricsHTTPHistograms.lock.Lock()
-
 	for _, h := range metricsHTTPHistograms.histograms {
 		addHistogramToBSON(bbuf, &index, h)
+		sd.timings("TransactionResponseTime", h.hist, h.tags)
 	}
+	accumulatePromHistograms(metricsHTTPHistograms.histograms)
 	metricsHTTPHistograms.histograms = make(map[string]*histogram) // clear histograms
 
 	metricsHTTPHistograms.lock.Unlock()
@@ -304,6 +424,13 @@ func generateMetricsMessage(metricsFlushInterval int, queueStats *eventQueueStat
 	}
 	// The transaction map is reset in every metrics cycle.
 	mTransMap.Reset()
+	mCacheNames.Reset()
+	mJobNames.Reset()
+	mErrorClasses.Reset()
+
+	if err := sd.send(); err != nil {
+		log.Warningf("Failed to send the metrics to StatsD: %v", err)
+	}
 
 	bsonBufferFinish(bbuf)
 	return bbuf.buf
@@ -382,6 +509,7 @@ func addMetricsValue(bbuf *bsonBuffer, index *int, name string, value interface{
 	default:
 		bsonAppendString(bbuf, "value", "unknown")
 	}
+	addTagsToBSON(bbuf, nil)
 
 	bsonAppendFinishObject(bbuf, start)
 	*index += 1
@@ -450,6 +578,11 @@ func (s *HTTPSpanMessage) processMeasurements(transactionName string) {
 		withErrorTags["Errors"] = "true"
 		recordMeasurement(metricsHTTPMeasurements, name, &withErrorTags, duration, 1, true)
 	}
+
+	if s.QueueTime > 0 && config.GetQueueTimeMetric() {
+		recordMeasurement(metricsHTTPMeasurements, "TransactionQueueTime", &primaryTags,
+			float64(s.QueueTime), 1, true)
+	}
 }
 
 // records a measurement
@@ -552,19 +685,7 @@ func addMeasurementToBSON(bbuf *bsonBuffer, index *int, m *Measurement) {
 		bsonAppendFloat64(bbuf, "sum", m.Sum)
 	}
 
-	if len(m.Tags) > 0 {
-		start := bsonAppendStartObject(bbuf, "tags")
-		for k, v := range m.Tags {
-			if len(k) > metricsTagNameLengthMax {
-				k = k[0:metricsTagNameLengthMax]
-			}
-			if len(v) > metricsTagValueLengthMax {
-				v = v[0:metricsTagValueLengthMax]
-			}
-			bsonAppendString(bbuf, k, v)
-		}
-		bsonAppendFinishObject(bbuf, start)
-	}
+	addTagsToBSON(bbuf, m.Tags)
 
 	bsonAppendFinishObject(bbuf, start)
 	*index += 1
@@ -588,24 +709,98 @@ func addHistogramToBSON(bbuf *bsonBuffer, index *int, h *histogram) {
 	bsonAppendString(bbuf, "value", string(data))
 
 	// append tags
-	if len(h.tags) > 0 {
-		start := bsonAppendStartObject(bbuf, "tags")
-		for k, v := range h.tags {
-			if len(k) > metricsTagNameLengthMax {
-				k = k[0:metricsTagNameLengthMax]
-			}
-			if len(v) > metricsTagValueLengthMax {
-				v = v[0:metricsTagValueLengthMax]
-			}
-			bsonAppendString(bbuf, k, v)
-		}
-		bsonAppendFinishObject(bbuf, start)
+	addTagsToBSON(bbuf, h.tags)
+
+	bsonAppendFinishObject(bbuf, start)
+	*index += 1
+}
+
+// apdex computes the Apdex score of a histogram with the threshold T, which is
+// (satisfied + tolerating/2) / total. A response is satisfied if it's within T,
+// tolerating if it's within 4T and frustrated otherwise.
+// It returns false if there is no response recorded.
+func (h *histogram) apdex(threshold time.Duration) (float64, bool) {
+	total := h.hist.TotalCount()
+	if total == 0 {
+		return 0, false
 	}
+	t := int64(threshold / time.Microsecond)
+	satisfied := h.hist.Val(t).CumCount
+	tolerating := h.hist.Val(4*t).CumCount - satisfied
+	return (float64(satisfied) + float64(tolerating)/2) / float64(total), true
+}
+
+// adds the Apdex score of a histogram to a BSON buffer
+// bbuf			the BSON buffer to append the metric to
+// index		a running integer (0,1,2,...) which is needed for BSON arrays
+// h			histogram of the response time
+// threshold	the Apdex threshold T
+func addApdexToBSON(bbuf *bsonBuffer, index *int, h *histogram, threshold time.Duration) {
+	score, ok := h.apdex(threshold)
+	if !ok {
+		return
+	}
+
+	start := bsonAppendStartObject(bbuf, strconv.Itoa(*index))
+
+	bsonAppendString(bbuf, "name", "Apdex")
+	bsonAppendFloat64(bbuf, "value", score)
+
+	// append tags
+	addTagsToBSON(bbuf, h.tags)
 
 	bsonAppendFinishObject(bbuf, start)
 	*index += 1
 }
 
+// adds the tags of a metric, merged with the global metric tags, to a BSON buffer
+// bbuf		the BSON buffer to append the tags to
+// tags		the tags of the metric, which take precedence over the global ones
+func addTagsToBSON(bbuf *bsonBuffer, tags map[string]string) {
+	tags = mergeMetricTags(tags, config.GetMetricTags())
+	if len(tags) == 0 {
+		return
+	}
+
+	start := bsonAppendStartObject(bbuf, "tags")
+	for k, v := range tags {
+		if len(k) > metricsTagNameLengthMax {
+			k = k[0:metricsTagNameLengthMax]
+		}
+		if len(v) > metricsTagValueLengthMax {
+			v = v[0:metricsTagValueLengthMax]
+		}
+		bsonAppendString(bbuf, k, v)
+	}
+	bsonAppendFinishObject(bbuf, start)
+}
+
+// mergeMetricTags merges the global tags into the tags of a metric, the tags
+// of the metric take precedence on conflicts. The global tags are added in the
+// order of their names until there are metricsTagsCountMax tags.
+func mergeMetricTags(tags map[string]string, global map[string]string) map[string]string {
+	if len(global) == 0 {
+		return tags
+	}
+
+	var names []string
+	for name := range global {
+		if _, ok := tags[name]; !ok && name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	merged := utils.CopyMap(&tags)
+	for _, name := range names {
+		if len(merged) >= metricsTagsCountMax {
+			break
+		}
+		merged[name] = global[name]
+	}
+	return merged
+}
+
 func (s *eventQueueStats) setQueueLargest(count int64) {
 	newVal := count
 
@@ -6,6 +6,7 @@ package reporter
 
 import (
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
@@ -66,4 +67,48 @@ func addHostMetrics(bbuf *bsonBuffer, index *int) {
 			}
 		}
 	}
+
+	// container memory, the host memory above is misleading in a container
+	if usage, limit, ok := cgroupMemory(cgroupRoot); ok {
+		addMetricsValue(bbuf, index, "ContainerMemoryUsage", usage)
+		addMetricsValue(bbuf, index, "ContainerMemoryLimit", limit)
+	}
+}
+
+// the mount point of the cgroup filesystem, which is replaceable for testing
+var cgroupRoot = "/sys/fs/cgroup"
+
+// a memory limit larger than this is considered unlimited, as cgroup v1 reports
+// the unlimited memory as the max int64 rounded down to the page size.
+const cgroupUnlimitedMemory = int64(1) << 62
+
+// cgroupMemory returns the memory usage and limit in bytes of the cgroup of
+// the process, i.e., the container, under the cgroup filesystem root. Both
+// cgroup v2 (memory.current and memory.max) and v1 (memory/memory.usage_in_bytes
+// and memory/memory.limit_in_bytes) are supported. It returns false if the
+// memory is not limited, e.g., not running in a container.
+func cgroupMemory(root string) (usage int64, limit int64, ok bool) {
+	files := [][2]string{
+		{"memory.current", "memory.max"},                                 // v2
+		{"memory/memory.usage_in_bytes", "memory/memory.limit_in_bytes"}, // v1
+	}
+	for _, f := range files {
+		limitStr := utils.GetStrByKeyword(filepath.Join(root, f[1]), "")
+		if limitStr == "" {
+			continue
+		}
+		// "max" of cgroup v2 means unlimited
+		var err error
+		limit, err = strconv.ParseInt(strings.TrimSpace(limitStr), 10, 64)
+		if err != nil || limit <= 0 || limit >= cgroupUnlimitedMemory {
+			return 0, 0, false
+		}
+		usage, err = strconv.ParseInt(strings.TrimSpace(
+			utils.GetStrByKeyword(filepath.Join(root, f[0]), "")), 10, 64)
+		if err != nil {
+			return 0, 0, false
+		}
+		return usage, limit, true
+	}
+	return 0, 0, false
 }
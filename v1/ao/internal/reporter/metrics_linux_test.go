@@ -5,12 +5,16 @@
 package reporter
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"testing"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestAppendUname(t *testing.T) {
@@ -32,3 +36,60 @@ func TestAppendUname(t *testing.T) {
 	assert.Equal(t, sysname, m["UnameSysName"])
 	assert.Equal(t, release, m["UnameVersion"])
 }
+
+func TestCgroupMemory(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	write := func(name, content string) {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	// bare metal
+	_, _, ok := cgroupMemory(root)
+	assert.False(t, ok)
+
+	// cgroup v1, unlimited
+	write("memory/memory.limit_in_bytes", "9223372036854771712\n")
+	write("memory/memory.usage_in_bytes", "104857600\n")
+	_, _, ok = cgroupMemory(root)
+	assert.False(t, ok)
+
+	// cgroup v1, limited
+	write("memory/memory.limit_in_bytes", "536870912\n")
+	usage, limit, ok := cgroupMemory(root)
+	assert.True(t, ok)
+	assert.Equal(t, int64(104857600), usage)
+	assert.Equal(t, int64(536870912), limit)
+
+	// cgroup v2, unlimited
+	write("memory.max", "max\n")
+	write("memory.current", "209715200\n")
+	_, _, ok = cgroupMemory(root)
+	assert.False(t, ok)
+
+	// cgroup v2, limited
+	write("memory.max", "1073741824\n")
+	usage, limit, ok = cgroupMemory(root)
+	assert.True(t, ok)
+	assert.Equal(t, int64(209715200), usage)
+	assert.Equal(t, int64(1073741824), limit)
+
+	// reported as metrics
+	cgroupRoot = root
+	defer func() { cgroupRoot = "/sys/fs/cgroup" }()
+	index := 0
+	bbuf := NewBsonBuffer()
+	addHostMetrics(bbuf, &index)
+	bsonBufferFinish(bbuf)
+	metrics := make(map[string]interface{})
+	for _, v := range bsonToMap(bbuf) {
+		m := v.(map[string]interface{})
+		metrics[m["name"].(string)] = m["value"]
+	}
+	assert.Equal(t, int64(209715200), metrics["ContainerMemoryUsage"])
+	assert.Equal(t, int64(1073741824), metrics["ContainerMemoryLimit"])
+}
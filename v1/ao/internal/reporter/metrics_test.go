@@ -4,6 +4,7 @@ package reporter
 
 import (
 	"bytes"
+	"fmt"
 	"log"
 	"math"
 	"net"
@@ -13,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/hdrhist"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/host"
 	"github.com/stretchr/testify/assert"
@@ -193,6 +195,61 @@ func TestTransMap(t *testing.T) {
 	assert.False(t, m.Overflow())
 }
 
+func TestMaxTransactions(t *testing.T) {
+	os.Setenv("APPOPTICS_MAX_TRANSACTIONS", "2")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_MAX_TRANSACTIONS")
+		config.Load()
+	}()
+
+	// the local limit only lowers the one provided by the collector
+	m := newLocalTransMap(3, config.GetMaxTransactions)
+	assert.True(t, m.IsWithinLimit("t1"))
+	assert.True(t, m.IsWithinLimit("t2"))
+	assert.False(t, m.IsWithinLimit("t3"))
+	assert.True(t, m.Overflow())
+	assert.Equal(t, int32(2), m.Limit())
+	m = newLocalTransMap(1, config.GetMaxTransactions)
+	assert.True(t, m.IsWithinLimit("t1"))
+	assert.False(t, m.IsWithinLimit("t2"))
+	assert.Equal(t, int32(1), m.Limit())
+
+	// the other maps are not affected
+	m = NewTransMap(3)
+	assert.True(t, m.IsWithinLimit("t1"))
+	assert.True(t, m.IsWithinLimit("t2"))
+	assert.True(t, m.IsWithinLimit("t3"))
+	assert.Equal(t, int32(3), m.Limit())
+
+	metricsHTTPMeasurements.lock.Lock()
+	metricsHTTPMeasurements.measurements = make(map[string]*Measurement)
+	metricsHTTPMeasurements.lock.Unlock()
+	mTransMap.Reset()
+	defer mTransMap.Reset()
+
+	for _, txn := range []string{"t1", "t2", "t3", "t4", "t1"} {
+		(&HTTPSpanMessage{
+			BaseSpanMessage: BaseSpanMessage{Duration: time.Second},
+			Transaction:     txn,
+			Status:          200,
+			Method:          "GET",
+		}).process()
+	}
+
+	metricsHTTPMeasurements.lock.Lock()
+	defer metricsHTTPMeasurements.lock.Unlock()
+	names := make(map[string]int)
+	for _, me := range metricsHTTPMeasurements.measurements {
+		// only the primary measurements keyed by the transaction name
+		if me.Name == "TransactionResponseTime" && len(me.Tags) == 1 {
+			names[me.Tags["TransactionName"]] += me.Count
+		}
+	}
+	assert.Equal(t, map[string]int{"t1": 2, "t2": 1, OtherTransactionName: 2}, names)
+	assert.True(t, mTransMap.Overflow())
+}
+
 func TestRecordMeasurement(t *testing.T) {
 	var me = &measurements{
 		measurements: make(map[string]*Measurement),
@@ -222,6 +279,33 @@ func TestRecordMeasurement(t *testing.T) {
 	assert.True(t, m.ReportSum)
 }
 
+func TestProcessQueueTime(t *testing.T) {
+	metricsHTTPMeasurements.lock.Lock()
+	metricsHTTPMeasurements.measurements = make(map[string]*Measurement)
+	metricsHTTPMeasurements.lock.Unlock()
+
+	(&HTTPSpanMessage{
+		BaseSpanMessage: BaseSpanMessage{Duration: time.Second, QueueTime: 2 * time.Second},
+		Transaction:     "queued",
+		Status:          200,
+		Method:          "GET",
+	}).process()
+	(&HTTPSpanMessage{
+		BaseSpanMessage: BaseSpanMessage{Duration: time.Second},
+		Transaction:     "queued",
+		Status:          200,
+		Method:          "GET",
+	}).process()
+
+	metricsHTTPMeasurements.lock.Lock()
+	m := metricsHTTPMeasurements.measurements["TransactionQueueTime&true&TransactionName:queued&"]
+	metricsHTTPMeasurements.lock.Unlock()
+	if assert.NotNil(t, m) {
+		assert.Equal(t, 1, m.Count)
+		assert.Equal(t, float64(2*time.Second), m.Sum)
+	}
+}
+
 func TestRecordHistogram(t *testing.T) {
 	var hi = &histograms{
 		histograms: make(map[string]*histogram),
@@ -363,6 +447,65 @@ func TestAddHistogramToBSON(t *testing.T) {
 	assert.Equal(t, veryLongTagValueTrimmed, t2[veryLongTagNameTrimmed])
 }
 
+func TestApdex(t *testing.T) {
+	var hi = &histograms{
+		histograms: make(map[string]*histogram),
+		precision:  metricsHistPrecisionDefault,
+	}
+	threshold := 100 * time.Millisecond
+
+	// satisfied
+	recordHistogram(hi, "apdex", 50*time.Millisecond)
+	recordHistogram(hi, "apdex", 100*time.Millisecond)
+	// tolerating
+	recordHistogram(hi, "apdex", 200*time.Millisecond)
+	recordHistogram(hi, "apdex", 400*time.Millisecond)
+	// frustrated
+	recordHistogram(hi, "apdex", 500*time.Millisecond)
+	recordHistogram(hi, "apdex", 2*time.Second)
+
+	h := hi.histograms["apdex"]
+	score, ok := h.apdex(threshold)
+	assert.True(t, ok)
+	assert.Equal(t, 0.5, score)
+
+	for i := 0; i < 6; i++ {
+		recordHistogram(hi, "satisfied", time.Millisecond)
+	}
+	score, ok = hi.histograms["satisfied"].apdex(threshold)
+	assert.True(t, ok)
+	assert.Equal(t, 1.0, score)
+
+	recordHistogram(hi, "frustrated", time.Second)
+	score, ok = hi.histograms["frustrated"].apdex(threshold)
+	assert.True(t, ok)
+	assert.Equal(t, 0.0, score)
+
+	empty := &histogram{
+		hist: hdrhist.WithConfig(hdrhist.Config{
+			LowestDiscernible: 1,
+			HighestTrackable:  3600000000,
+			SigFigs:           metricsHistPrecisionDefault,
+		}),
+	}
+	_, ok = empty.apdex(threshold)
+	assert.False(t, ok)
+
+	index := 0
+	bbuf := NewBsonBuffer()
+	addApdexToBSON(bbuf, &index, empty, threshold)
+	addApdexToBSON(bbuf, &index, h, threshold)
+	bsonBufferFinish(bbuf)
+	assert.Equal(t, 1, index)
+	m := bsonToMap(bbuf)
+
+	assert.NotZero(t, m["0"])
+	m1 := m["0"].(map[string]interface{})
+	assert.Equal(t, "Apdex", m1["name"])
+	assert.Equal(t, 0.5, m1["value"])
+	assert.Equal(t, "apdex", m1["tags"].(map[string]interface{})["TransactionName"])
+}
+
 func TestGenerateMetricsMessage(t *testing.T) {
 	bbuf := &bsonBuffer{
 		buf: generateMetricsMessage(15, &eventQueueStats{}),
@@ -398,6 +541,14 @@ func TestGenerateMetricsMessage(t *testing.T) {
 		{"NumFailed", int64(1)},
 		{"TotalEvents", int64(1)},
 		{"QueueLargest", int64(1)},
+		{"SpansFolded", int64(1)},
+		{"SkewedEvents", int64(1)},
+		{"ActiveTraces", int64(1)},
+		{"ActiveSpans", int64(1)},
+		{"ConnectionsNew", int64(1)},
+		{"ConnectionsClosed", int64(1)},
+		{"ConnectionsActive", int64(1)},
+		{"ConnectionsIdle", int64(1)},
 	}
 	if runtime.GOOS == "linux" {
 		testCases = append(testCases, []testCase{
@@ -443,3 +594,136 @@ func TestGenerateMetricsMessage(t *testing.T) {
 	assert.True(t, m["TransactionNameOverflow"].(bool))
 	mTransMap.Reset()
 }
+
+func TestGlobalMetricTags(t *testing.T) {
+	os.Setenv("APPOPTICS_METRIC_TAGS", "env=prod,region=us-east-1,TransactionName=global")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_METRIC_TAGS")
+		config.Load()
+	}()
+
+	index := 0
+	bbuf := NewBsonBuffer()
+	addMeasurementToBSON(bbuf, &index, &Measurement{
+		Name:  "TransactionResponseTime",
+		Tags:  map[string]string{"TransactionName": "txn", "HttpMethod": "GET"},
+		Count: 1,
+	})
+	addMetricsValue(bbuf, &index, "RequestCount", 1)
+	bsonBufferFinish(bbuf)
+	m := bsonToMap(bbuf)
+
+	// the tags of the metric take precedence on conflicts
+	assert.Equal(t, map[string]interface{}{
+		"TransactionName": "txn",
+		"HttpMethod":      "GET",
+		"env":             "prod",
+		"region":          "us-east-1",
+	}, m["0"].(map[string]interface{})["tags"])
+	assert.Equal(t, map[string]interface{}{
+		"TransactionName": "global",
+		"env":             "prod",
+		"region":          "us-east-1",
+	}, m["1"].(map[string]interface{})["tags"])
+}
+
+func TestMergeMetricTags(t *testing.T) {
+	tags := map[string]string{"TransactionName": "txn"}
+	assert.Equal(t, tags, mergeMetricTags(tags, nil))
+
+	global := make(map[string]string)
+	for i := 0; i < metricsTagsCountMax+5; i++ {
+		global[fmt.Sprintf("tag%02d", i)] = "v"
+	}
+	merged := mergeMetricTags(tags, global)
+	assert.Len(t, merged, metricsTagsCountMax)
+	assert.Equal(t, "txn", merged["TransactionName"])
+	assert.Equal(t, "v", merged["tag00"])
+	assert.NotContains(t, merged, fmt.Sprintf("tag%02d", metricsTagsCountMax-1))
+	// the tags of the metric are not modified
+	assert.Len(t, tags, 1)
+}
+
+func TestSpansFolded(t *testing.T) {
+	RecordSpanFolded()
+	RecordSpanFolded()
+	bbuf := &bsonBuffer{buf: generateMetricsMessage(15, &eventQueueStats{})}
+	m := bsonToMap(bbuf)
+
+	folded := func(m map[string]interface{}) interface{} {
+		for _, mt := range m["measurements"].([]interface{}) {
+			if mt.(map[string]interface{})["name"] == "SpansFolded" {
+				return mt.(map[string]interface{})["value"]
+			}
+		}
+		return nil
+	}
+	assert.Equal(t, int64(2), folded(m))
+
+	// flushed on each report cycle
+	bbuf.buf = generateMetricsMessage(15, &eventQueueStats{})
+	assert.Equal(t, int64(0), folded(bsonToMap(bbuf)))
+}
+
+func TestActiveTracesAndSpans(t *testing.T) {
+	traces, spans := ActiveTracesAndSpans()
+	AddActiveTraces(1)
+	AddActiveSpans(3)
+	AddActiveSpans(-1)
+	defer func() {
+		AddActiveTraces(-1)
+		AddActiveSpans(-2)
+	}()
+
+	active := func() (interface{}, interface{}) {
+		bbuf := &bsonBuffer{buf: generateMetricsMessage(15, &eventQueueStats{})}
+		values := make(map[string]interface{})
+		for _, mt := range bsonToMap(bbuf)["measurements"].([]interface{}) {
+			values[mt.(map[string]interface{})["name"].(string)] = mt.(map[string]interface{})["value"]
+		}
+		return values["ActiveTraces"], values["ActiveSpans"]
+	}
+	gotTraces, gotSpans := active()
+	assert.Equal(t, traces+1, gotTraces)
+	assert.Equal(t, spans+2, gotSpans)
+
+	// the gauges are not reset on each report cycle
+	gotTraces, gotSpans = active()
+	assert.Equal(t, traces+1, gotTraces)
+	assert.Equal(t, spans+2, gotSpans)
+}
+
+func TestConnMetrics(t *testing.T) {
+	RecordConnNew()
+	RecordConnNew()
+	RecordConnClosed()
+	active, idle := ActiveAndIdleConns()
+	AddActiveConns(2)
+	AddIdleConns(1)
+	defer func() {
+		AddActiveConns(-2)
+		AddIdleConns(-1)
+	}()
+
+	values := func() map[string]interface{} {
+		bbuf := &bsonBuffer{buf: generateMetricsMessage(15, &eventQueueStats{})}
+		values := make(map[string]interface{})
+		for _, mt := range bsonToMap(bbuf)["measurements"].([]interface{}) {
+			values[mt.(map[string]interface{})["name"].(string)] = mt.(map[string]interface{})["value"]
+		}
+		return values
+	}
+	m := values()
+	assert.Equal(t, int64(2), m["ConnectionsNew"])
+	assert.Equal(t, int64(1), m["ConnectionsClosed"])
+	assert.Equal(t, active+2, m["ConnectionsActive"])
+	assert.Equal(t, idle+1, m["ConnectionsIdle"])
+
+	// the counters are flushed on each report cycle, but not the gauges
+	m = values()
+	assert.Equal(t, int64(0), m["ConnectionsNew"])
+	assert.Equal(t, int64(0), m["ConnectionsClosed"])
+	assert.Equal(t, active+2, m["ConnectionsActive"])
+	assert.Equal(t, idle+1, m["ConnectionsIdle"])
+}
@@ -20,6 +20,9 @@ import (
 
 // Current settings configuration
 type oboeSettingsCfg struct {
+	// incremented on each update of the settings, it should be accessed
+	// atomically. Keep it the first field for the 64-bit alignment.
+	version  int64
 	settings map[oboeSettingKey]*oboeSettings
 	lock     sync.RWMutex
 	rateCounts
@@ -35,9 +38,12 @@ type oboeSettings struct {
 	value int
 	// The sample source after negotiating with local config
 	source sampleSource
-	ttl    int64
-	layer  string
-	bucket *tokenBucket
+	// the original sample rate and source retrieved from the remote collector
+	originalValue  int
+	originalSource sampleSource
+	ttl            int64
+	layer          string
+	bucket         *tokenBucket
 }
 
 func (s *oboeSettings) hasOverrideFlag() bool {
@@ -126,6 +132,7 @@ func sendInitMessage() {
 		_ = e.AddKV("__Init", 1)
 		_ = e.AddKV("Go.Version", utils.GoVersion())
 		_ = e.AddKV("Go.AppOptics.Version", utils.Version())
+		_ = e.AddKV("Go.AppOptics.ConfigHash", config.GetConfigHash())
 
 		_ = e.ReportStatus(c)
 	}
@@ -185,9 +192,24 @@ func (b *tokenBucket) update(now time.Time) {
 	}
 }
 
-func oboeSampleRequest(layer string, traced bool, url string) (bool, int, sampleSource, bool) {
+// SamplingHint is a per-request sampling decision made by the application. It
+// replaces the sample rate and the token bucket for a new request, but has no
+// effect on requests which are continued from an inbound context, or if
+// tracing is disabled for the layer or the URL.
+type SamplingHint int
+
+const (
+	// SamplingHintNone leaves the decision to the sample rate settings.
+	SamplingHintNone SamplingHint = iota
+	// SamplingHintSample samples the request regardless of the sample rate.
+	SamplingHintSample
+	// SamplingHintDrop does not sample the request.
+	SamplingHintDrop
+)
+
+func oboeSampleRequest(layer string, traced bool, url string, hint SamplingHint, origin string) (bool, int, sampleSource, bool) {
 	if usingTestReporter {
-		if r, ok := globalReporter.(*TestReporter); ok {
+		if r, ok := activeReporter().(*TestReporter); ok {
 			if !r.UseSettings {
 				return r.ShouldTrace, 0, SAMPLE_SOURCE_NONE, true // trace tests
 			}
@@ -203,14 +225,28 @@ func oboeSampleRequest(layer string, traced bool, url string) (bool, int, sample
 	retval := false
 	doRateLimiting := false
 
-	sampleRate, flags, source := mergeURLSetting(setting, url)
+	sampleRate, flags, source, hasURLRate := mergeURLSetting(setting, url)
+	if !hasURLRate {
+		// the sample rates of the URL rules take precedence over the sampling
+		// windows and origins
+		sampleRate, source = mergeWindowSetting(setting, sampleRate, source, time.Now())
+		sampleRate, source = mergeOriginSetting(setting, sampleRate, source, origin)
+	}
 
 	if !traced {
 		// A new request
 		if flags&FLAG_SAMPLE_START != 0 {
-			retval = shouldSample(sampleRate)
-			if retval {
-				doRateLimiting = true
+			switch hint {
+			case SamplingHintSample:
+				// the hint bypasses the token bucket as well
+				retval = true
+			case SamplingHintDrop:
+				retval = false
+			default:
+				retval = shouldSample(sampleRate)
+				if retval {
+					doRateLimiting = true
+				}
 			}
 		}
 	} else {
@@ -274,42 +310,84 @@ func parseInt32(args map[string][]byte, key string, fb int32) int32 {
 //
 // Note: This function modifies the argument in place.
 func mergeLocalSetting(remote *oboeSettings) *oboeSettings {
-	if remote.hasOverrideFlag() && config.SamplingConfigured() {
+	// a consistent copy as the config may be reloaded at the same time
+	local := config.GetSampling()
+	if remote.hasOverrideFlag() && local.Configured() {
 		// Choose the lower sample rate and merge the flags
-		if remote.value > config.GetSampleRate() {
-			remote.value = config.GetSampleRate()
+		if remote.value > local.SampleRate {
+			remote.value = local.SampleRate
 			remote.source = SAMPLE_SOURCE_FILE
 		}
-		remote.flags &= newTracingMode(config.GetTracingMode()).toFlags()
-	} else if config.SamplingConfigured() {
+		remote.flags &= newTracingMode(local.TracingMode).toFlags()
+	} else if local.Configured() {
 		// Use local sample rate and tracing mode config
-		remote.value = config.GetSampleRate()
-		remote.flags = newTracingMode(config.GetTracingMode()).toFlags()
+		remote.value = local.SampleRate
+		remote.flags = newTracingMode(local.TracingMode).toFlags()
 		remote.source = SAMPLE_SOURCE_FILE
 	}
 	return remote
 }
 
 // mergeURLSetting merges the service level setting (merged from remote and local
-// settings) and the per-URL sampling flags, if any.
-func mergeURLSetting(setting *oboeSettings, url string) (int, settingFlag, sampleSource) {
+// settings) and the per-URL sampling flags and sample rate, if any. It returns
+// true as well if the URL matches a URL rule which sets a sample rate.
+func mergeURLSetting(setting *oboeSettings, url string) (int, settingFlag, sampleSource, bool) {
 	if url == "" {
-		return setting.value, setting.flags, setting.source
+		return setting.value, setting.flags, setting.source, false
 	}
 
-	urlTracingMode := urls.getTracingMode(url)
+	urlTracingMode, urlSampleRate := urls.getSetting(url)
 	if urlTracingMode.isUnknown() {
-		return setting.value, setting.flags, setting.source
+		return setting.value, setting.flags, setting.source, false
 	}
 
 	flags := urlTracingMode.toFlags()
 	source := SAMPLE_SOURCE_FILE
+	value := setting.value
+	if urlSampleRate != noURLSampleRate {
+		value = urlSampleRate
+	}
 
 	if setting.hasOverrideFlag() {
 		flags &= setting.originalFlags
+		// the collector caps the sample rate of the URL as well
+		if value > setting.originalValue {
+			value = setting.originalValue
+		}
 	}
 
-	return setting.value, flags, source
+	return value, flags, source, urlSampleRate != noURLSampleRate
+}
+
+// mergeWindowSetting applies the sample rate of the first sampling window which
+// contains the time provided, if any. The lower sample rate is chosen if the
+// remote setting has the override flag.
+func mergeWindowSetting(setting *oboeSettings, rate int, source sampleSource, now time.Time) (int, sampleSource) {
+	windowRate, ok := config.GetSamplingWindowRate(now)
+	if !ok {
+		return rate, source
+	}
+	if setting.hasOverrideFlag() && windowRate > rate {
+		return rate, source
+	}
+	return windowRate, SAMPLE_SOURCE_FILE
+}
+
+// mergeOriginSetting applies the sample rate of the origin of the trace, if
+// it's configured. The collector caps the sample rate of the origin if the
+// remote setting has the override flag.
+func mergeOriginSetting(setting *oboeSettings, rate int, source sampleSource, origin string) (int, sampleSource) {
+	if origin == "" {
+		return rate, source
+	}
+	originRate, ok := config.GetOriginSampleRate(origin)
+	if !ok {
+		return rate, source
+	}
+	if setting.hasOverrideFlag() && originRate > setting.originalValue {
+		originRate = setting.originalValue
+	}
+	return originRate, SAMPLE_SOURCE_FILE
 }
 
 func adjustSampleRate(rate int64) int {
@@ -333,6 +411,8 @@ func updateSetting(sType int32, layer string, flags []byte, value int64, ttl int
 	ns.flags = flagStringToBin(string(flags))
 	ns.originalFlags = ns.flags
 	ns.value = adjustSampleRate(value)
+	ns.originalValue = ns.value
+	ns.originalSource = ns.source
 	ns.ttl = ttl
 	ns.layer = layer
 
@@ -350,6 +430,22 @@ func updateSetting(sType int32, layer string, flags []byte, value int64, ttl int
 	globalSettingsCfg.lock.Lock()
 	globalSettingsCfg.settings[key] = merged
 	globalSettingsCfg.lock.Unlock()
+	atomic.AddInt64(&globalSettingsCfg.version, 1)
+}
+
+// ReapplyLocalSettings merges the local sampling config, which may have been
+// changed at runtime, into the current settings again, so the change applies
+// immediately instead of on the next settings update.
+func ReapplyLocalSettings() {
+	globalSettingsCfg.lock.Lock()
+	for k, s := range globalSettingsCfg.settings {
+		// the settings in use are not modified in place
+		ns := *s
+		ns.flags, ns.value, ns.source = s.originalFlags, s.originalValue, s.originalSource
+		globalSettingsCfg.settings[k] = mergeLocalSetting(&ns)
+	}
+	globalSettingsCfg.lock.Unlock()
+	atomic.AddInt64(&globalSettingsCfg.version, 1)
 }
 
 // Used for tests only
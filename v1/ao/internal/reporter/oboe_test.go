@@ -33,6 +33,8 @@ func assertInitMessage(t *testing.T, bufs [][]byte) {
 		{"go", "single"}: {Edges: g.Edges{}, Callback: func(n g.Node) {
 			assert.Equal(t, 1, n.Map["__Init"])
 			assert.Equal(t, utils.Version(), n.Map["Go.AppOptics.Version"])
+			assert.Equal(t, config.GetConfigHash(), n.Map["Go.AppOptics.ConfigHash"])
+			assert.Len(t, n.Map["Go.AppOptics.ConfigHash"], 64)
 			assert.NotEmpty(t, n.Map["Go.Version"])
 		}},
 	})
@@ -246,7 +248,7 @@ func TestSampleFlags(t *testing.T) {
 		{Type: "url", RegEx: `user\d{3}`, Tracing: config.DisabledTracingMode},
 		{Type: "url", Extensions: []string{".png", ".jpg"}, Tracing: config.DisabledTracingMode},
 	})
-	ok, _, _, _ = shouldTraceRequestWithURL(testLayer, false, "http://test.com/user123")
+	ok, _, _, _ = shouldTraceRequestWithURL(testLayer, false, "http://test.com/user123", SamplingHintNone)
 	assert.False(t, ok)
 
 	resetSettings()
@@ -278,6 +280,45 @@ func TestSampleFlags(t *testing.T) {
 	r.Close(0)
 }
 
+func TestSamplingHint(t *testing.T) {
+	r := SetTestReporter(TestReporterDisableDefaultSetting(true))
+	defer func() {
+		urls.loadConfig(nil)
+		urls.cache.Clear()
+	}()
+
+	// 0% sample rate and an empty token bucket
+	updateSetting(int32(TYPE_DEFAULT), "",
+		[]byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		0, 120, argsToMap(0, 0, -1, -1))
+	ok, _, _, _ := shouldTraceRequestWithURL(testLayer, false, "", SamplingHintNone)
+	assert.False(t, ok)
+	ok, _, _, _ = shouldTraceRequestWithURL(testLayer, false, "", SamplingHintSample)
+	assert.True(t, ok)
+	ok, _, _, _ = shouldTraceRequestWithURL(testLayer, false, "", SamplingHintDrop)
+	assert.False(t, ok)
+	// the hint has no effect on continued requests
+	ok, _, _, _ = shouldTraceRequestWithURL(testLayer, true, "", SamplingHintDrop)
+	assert.True(t, ok)
+
+	// 100% sample rate
+	resetSettings()
+	updateSetting(int32(TYPE_DEFAULT), "",
+		[]byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		1000000, 120, argsToMap(1000000, 1000000, -1, -1))
+	ok, _, _, _ = shouldTraceRequestWithURL(testLayer, false, "", SamplingHintDrop)
+	assert.False(t, ok)
+
+	// the hint cannot enable tracing for a disabled URL
+	urls.loadConfig([]config.TransactionFilter{
+		{Type: "url", RegEx: `user\d{3}`, Tracing: config.DisabledTracingMode},
+	})
+	ok, _, _, _ = shouldTraceRequestWithURL(testLayer, false, "/user123", SamplingHintSample)
+	assert.False(t, ok)
+
+	r.Close(0)
+}
+
 func TestSampleTokenBucket(t *testing.T) {
 	r := SetTestReporter()
 	c := globalSettingsCfg
@@ -510,3 +551,158 @@ func TestAdjustSampleRate(t *testing.T) {
 	assert.Equal(t, 0, adjustSampleRate(-1))
 	assert.Equal(t, maxSamplingRate-1, adjustSampleRate(maxSamplingRate-1))
 }
+
+func TestSamplingWindows(t *testing.T) {
+	_ = os.Unsetenv("APPOPTICS_TRACING_MODE")
+	_ = os.Unsetenv("APPOPTICS_SAMPLE_RATE")
+	config.Load()
+	defer config.Load()
+	r := SetTestReporter(TestReporterSampleRate(0))
+
+	now := time.Now().UTC()
+	clock := func(d time.Duration) string { return now.Add(d).Format("15:04") }
+
+	// the window includes the current time
+	config.Load(config.WithSamplingWindows("UTC",
+		config.SamplingWindow{Start: clock(time.Hour), End: clock(2 * time.Hour), SampleRate: 0},
+		config.SamplingWindow{Start: clock(-time.Hour), End: clock(time.Hour), SampleRate: 1000000},
+	))
+	ok, rate, source, _ := shouldTraceRequest(testLayer, false)
+	assert.True(t, ok)
+	assert.Equal(t, 1000000, rate)
+	assert.Equal(t, SAMPLE_SOURCE_FILE, source)
+
+	// the default sample rate is used outside of the windows
+	config.Load(config.WithSamplingWindows("UTC",
+		config.SamplingWindow{Start: clock(time.Hour), End: clock(2 * time.Hour), SampleRate: 1000000},
+	))
+	ok, rate, source, _ = shouldTraceRequest(testLayer, false)
+	assert.False(t, ok)
+	assert.Equal(t, 0, rate)
+	assert.Equal(t, SAMPLE_SOURCE_DEFAULT, source)
+
+	// the lower rate is chosen if the remote setting has the override flag
+	updateSetting(int32(TYPE_DEFAULT), "",
+		[]byte("OVERRIDE,SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		1000, 120, argsToMap(1000000, 1000000, -1, -1))
+	config.Load(config.WithSamplingWindows("UTC",
+		config.SamplingWindow{Start: clock(-time.Hour), End: clock(time.Hour), SampleRate: 1000000},
+	))
+	_, rate, _, _ = shouldTraceRequest(testLayer, false)
+	assert.Equal(t, 1000, rate)
+
+	r.Close(0)
+}
+
+func TestURLSampleRateWithWindow(t *testing.T) {
+	_ = os.Unsetenv("APPOPTICS_TRACING_MODE")
+	_ = os.Unsetenv("APPOPTICS_SAMPLE_RATE")
+	defer config.Load()
+	r := SetTestReporter(TestReporterSampleRate(0))
+	defer r.Close(0)
+
+	now := time.Now().UTC()
+	clock := func(d time.Duration) string { return now.Add(d).Format("15:04") }
+	config.Load(config.WithSamplingWindows("UTC",
+		config.SamplingWindow{Start: clock(-time.Hour), End: clock(time.Hour), SampleRate: 1000000},
+	))
+	urlRate := 0
+	ReloadURLsConfig([]config.TransactionFilter{
+		{Type: "url", RegEx: `^/health$`, Tracing: config.EnabledTracingMode, SampleRate: &urlRate},
+		{Type: "url", RegEx: `^/checkout$`, Tracing: config.EnabledTracingMode},
+	})
+	defer ReloadURLsConfig(nil)
+
+	// the sample rate of the URL is not overridden by the window
+	ok, rate, source, _ := shouldTraceRequestWithURL(testLayer, false, "/health", SamplingHintNone)
+	assert.False(t, ok)
+	assert.Equal(t, 0, rate)
+	assert.Equal(t, SAMPLE_SOURCE_FILE, source)
+
+	// the window applies to the URLs matched by a rule without a sample rate
+	ok, rate, _, _ = shouldTraceRequestWithURL(testLayer, false, "/checkout", SamplingHintNone)
+	assert.True(t, ok)
+	assert.Equal(t, 1000000, rate)
+
+	// and to the other URLs
+	ok, rate, _, _ = shouldTraceRequestWithURL(testLayer, false, "/users", SamplingHintNone)
+	assert.True(t, ok)
+	assert.Equal(t, 1000000, rate)
+}
+
+func TestOriginSampleRate(t *testing.T) {
+	r := SetTestReporter(TestReporterSampleRate(0))
+	defer r.Close(0)
+	defer config.Load()
+	config.Load(config.WithOriginSampleRates(map[string]int{"job": 1000000}))
+
+	ok, rate, source, _ := shouldTraceRequestForOrigin(testLayer, false, "", SamplingHintNone, "job")
+	assert.True(t, ok)
+	assert.Equal(t, 1000000, rate)
+	assert.Equal(t, SAMPLE_SOURCE_FILE, source)
+
+	// the sample rates of the URL rules take precedence over the origin
+	urlRate := 0
+	ReloadURLsConfig([]config.TransactionFilter{
+		{Type: "url", RegEx: `^/health$`, Tracing: config.EnabledTracingMode, SampleRate: &urlRate},
+		{Type: "url", RegEx: `^/checkout$`, Tracing: config.EnabledTracingMode},
+	})
+	defer ReloadURLsConfig(nil)
+	ok, rate, _, _ = shouldTraceRequestForOrigin(testLayer, false, "/health", SamplingHintNone, "job")
+	assert.False(t, ok)
+	assert.Equal(t, 0, rate)
+	// but not the rules without a sample rate
+	ok, rate, _, _ = shouldTraceRequestForOrigin(testLayer, false, "/checkout", SamplingHintNone, "job")
+	assert.True(t, ok)
+	assert.Equal(t, 1000000, rate)
+
+	// the collector caps the sample rate of the origin with the override flag,
+	// rather than the sample rate merged with the local config
+	updateSetting(int32(TYPE_DEFAULT), "",
+		[]byte("OVERRIDE,SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		1000, 120, argsToMap(1000000, 1000000, -1, -1))
+	os.Setenv("APPOPTICS_SAMPLE_RATE", "10")
+	defer os.Unsetenv("APPOPTICS_SAMPLE_RATE")
+	config.Load(config.WithOriginSampleRates(map[string]int{"job": 1000000}))
+	_, rate, _, _ = shouldTraceRequestForOrigin(testLayer, false, "", SamplingHintNone, "job")
+	assert.Equal(t, 1000, rate)
+}
+
+func TestMergeURLSetting(t *testing.T) {
+	rate := 10000
+	ReloadURLsConfig([]config.TransactionFilter{
+		{Type: "url", RegEx: `^/health$`, Tracing: config.EnabledTracingMode, SampleRate: &rate},
+		{Type: "url", RegEx: `^/checkout`, Tracing: config.EnabledTracingMode},
+	})
+	defer ReloadURLsConfig(nil)
+
+	enabled := FLAG_SAMPLE_START | FLAG_SAMPLE_THROUGH_ALWAYS
+	setting := &oboeSettings{flags: enabled, originalFlags: enabled,
+		value: 500000, originalValue: 500000, source: SAMPLE_SOURCE_DEFAULT}
+
+	// the sample rate of the URL overrides the global one
+	value, flags, source, hasRate := mergeURLSetting(setting, "/health")
+	assert.Equal(t, 10000, value)
+	assert.Equal(t, enabled, flags)
+	assert.Equal(t, SAMPLE_SOURCE_FILE, source)
+	assert.True(t, hasRate)
+
+	value, _, source, hasRate = mergeURLSetting(setting, "/checkout/cart")
+	assert.Equal(t, 500000, value)
+	assert.Equal(t, SAMPLE_SOURCE_FILE, source)
+	assert.False(t, hasRate)
+
+	value, _, source, hasRate = mergeURLSetting(setting, "/users")
+	assert.Equal(t, 500000, value)
+	assert.Equal(t, SAMPLE_SOURCE_DEFAULT, source)
+	assert.False(t, hasRate)
+
+	// the collector caps the sample rate of the URL with the override flag
+	rate = 1000000
+	ReloadURLsConfig([]config.TransactionFilter{
+		{Type: "url", RegEx: `^/checkout$`, Tracing: config.EnabledTracingMode, SampleRate: &rate},
+	})
+	setting.originalFlags |= FLAG_OVERRIDE
+	value, _, _, _ = mergeURLSetting(setting, "/checkout")
+	assert.Equal(t, 500000, value)
+}
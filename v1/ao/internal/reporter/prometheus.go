@@ -0,0 +1,247 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/hdrhist"
+)
+
+// the name of the Prometheus histogram of the transaction response times,
+// without the metrics namespace
+const promTransactionHistogram = "transaction_response_time_seconds"
+
+// the name of the Prometheus metric of the resource attributes, which is not
+// in the metrics namespace per the OpenTelemetry conventions
+const promTargetInfo = "target_info"
+
+// PromBucket is a classic Prometheus histogram bucket, which counts the
+// observations less than or equal to the upper bound.
+type PromBucket struct {
+	UpperBound      float64 // in seconds, +Inf for the last bucket
+	CumulativeCount int64
+}
+
+// PromHistogram is a histogram in the Prometheus bucket layout.
+type PromHistogram struct {
+	Transaction string // empty for the histogram of all the transactions
+	Buckets     []PromBucket
+	Count       int64
+	Sum         float64 // in seconds
+}
+
+// toPromHistogram converts a HDR histogram of durations in microseconds into
+// the Prometheus buckets of the bounds, which are in seconds and in ascending
+// order. A +Inf bucket is always appended so its count equals the total count.
+//
+// The values of a HDR histogram are only as precise as its precision, so an
+// observation close to a bound may be counted in the next bucket. The sum is
+// estimated from the mean for the same reason.
+func toPromHistogram(h *hdrhist.Hist, bounds []float64) PromHistogram {
+	vals := h.AllVals()
+	buckets := make([]PromBucket, 0, len(bounds)+1)
+
+	var cum int64
+	i := 0
+	for _, b := range bounds {
+		for ; i < len(vals) && float64(vals[i].Value) <= b*1e6; i++ {
+			cum = vals[i].CumCount
+		}
+		buckets = append(buckets, PromBucket{UpperBound: b, CumulativeCount: cum})
+	}
+	buckets = append(buckets, PromBucket{UpperBound: math.Inf(1), CumulativeCount: h.TotalCount()})
+
+	return PromHistogram{
+		Buckets: buckets,
+		Count:   h.TotalCount(),
+		Sum:     h.Mean() * float64(h.TotalCount()) / 1e6,
+	}
+}
+
+// the transaction histograms of the past metrics cycles in the Prometheus
+// bucket layout, keyed by the transaction name. They are accumulated before the
+// histograms are reset, so the buckets and counts are monotonic counters as
+// Prometheus requires. The number of transactions is bounded by the limit of
+// a metrics cycle, the others are folded into the OtherTransactionName.
+var promHistograms = struct {
+	sync.Mutex
+	histograms map[string]PromHistogram
+}{histograms: make(map[string]PromHistogram)}
+
+// accumulatePromHistograms adds the histograms of the current metrics cycle to
+// the accumulated ones. It's called with the lock of the histograms held before
+// they are reset.
+func accumulatePromHistograms(histograms map[string]*histogram) {
+	bounds := config.GetHistogramBuckets()
+
+	promHistograms.Lock()
+	defer promHistograms.Unlock()
+	for _, h := range histograms {
+		addPromHistogramTo(promHistograms.histograms, toPromHistogram(h.hist, bounds),
+			h.tags["TransactionName"])
+	}
+}
+
+// promMaxTransactions returns the maximum number of transactions of the
+// Prometheus histograms, which is the limit of the transactions of a metrics
+// cycle.
+func promMaxTransactions() int {
+	return int(mTransMap.Limit())
+}
+
+// addPromHistogramTo adds the histogram of the transaction to the histograms
+// keyed by the transaction names, or to that of the OtherTransactionName if the
+// number of transactions reaches the limit. The histogram of all the
+// transactions, whose name is empty, is not counted.
+func addPromHistogramTo(hs map[string]PromHistogram, ph PromHistogram, txn string) {
+	if _, ok := hs[txn]; !ok && txn != "" {
+		named := len(hs)
+		if _, ok := hs[""]; ok {
+			named--
+		}
+		if named >= promMaxTransactions() {
+			txn = OtherTransactionName
+		}
+	}
+	ph.Transaction = txn
+	hs[txn] = addPromHistograms(hs[txn], ph)
+}
+
+// addPromHistograms adds up two histograms. The accumulated one is dropped if
+// its bounds are different, e.g., the buckets are reconfigured, which is a
+// counter reset to Prometheus.
+func addPromHistograms(acc, h PromHistogram) PromHistogram {
+	if len(acc.Buckets) != len(h.Buckets) {
+		return h
+	}
+	sum := PromHistogram{
+		Transaction: h.Transaction,
+		Buckets:     make([]PromBucket, len(h.Buckets)),
+		Count:       acc.Count + h.Count,
+		Sum:         acc.Sum + h.Sum,
+	}
+	for i, b := range h.Buckets {
+		if acc.Buckets[i].UpperBound != b.UpperBound {
+			return h
+		}
+		sum.Buckets[i] = PromBucket{UpperBound: b.UpperBound,
+			CumulativeCount: acc.Buckets[i].CumulativeCount + b.CumulativeCount}
+	}
+	return sum
+}
+
+// TransactionHistograms returns the transaction response time histograms since
+// the agent started in the Prometheus bucket layout, with the bucket bounds from
+// the configuration. The buckets are cumulative over the le bounds and over
+// time, i.e., they are not reset along with the histograms of the metrics
+// cycles.
+func TransactionHistograms() []PromHistogram {
+	bounds := config.GetHistogramBuckets()
+
+	metricsHTTPHistograms.lock.Lock()
+	defer metricsHTTPHistograms.lock.Unlock()
+	promHistograms.Lock()
+	defer promHistograms.Unlock()
+
+	all := make(map[string]PromHistogram, len(promHistograms.histograms))
+	for txn, ph := range promHistograms.histograms {
+		all[txn] = ph
+	}
+	for _, h := range metricsHTTPHistograms.histograms {
+		addPromHistogramTo(all, toPromHistogram(h.hist, bounds), h.tags["TransactionName"])
+	}
+
+	hs := make([]PromHistogram, 0, len(all))
+	for _, ph := range all {
+		hs = append(hs, ph)
+	}
+	sort.Slice(hs, func(i, j int) bool { return hs[i].Transaction < hs[j].Transaction })
+	return hs
+}
+
+// WritePrometheusHistograms writes the transaction response time histograms
+// in the Prometheus text exposition format.
+func WritePrometheusHistograms(w io.Writer) error {
+	hs := TransactionHistograms()
+	if len(hs) == 0 {
+		return nil
+	}
+
+	name := config.GetMetricsNamespace() + "_" + promTransactionHistogram
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# HELP %s The response time of the transactions.\n", name)
+	fmt.Fprintf(bw, "# TYPE %s histogram\n", name)
+	for _, h := range hs {
+		var labels string
+		if h.Transaction != "" {
+			labels = fmt.Sprintf("transaction=\"%s\",", escapePromLabel(h.Transaction))
+		}
+		for _, b := range h.Buckets {
+			fmt.Fprintf(bw, "%s_bucket{%sle=\"%s\"} %d\n", name,
+				labels, formatPromFloat(b.UpperBound), b.CumulativeCount)
+		}
+		labels = strings.TrimSuffix(labels, ",")
+		if labels != "" {
+			labels = "{" + labels + "}"
+		}
+		fmt.Fprintf(bw, "%s_sum%s %s\n", name, labels, formatPromFloat(h.Sum))
+		fmt.Fprintf(bw, "%s_count%s %d\n", name, labels, h.Count)
+	}
+	return bw.Flush()
+}
+
+// WritePrometheusTargetInfo writes the resource attributes as the labels of the
+// target_info metric in the Prometheus text exposition format, as the
+// OpenTelemetry Prometheus exporters do. The attribute names are sanitized
+// into label names, e.g., service.name to service_name.
+func WritePrometheusTargetInfo(w io.Writer, attrs map[string]string) error {
+	if len(attrs) == 0 {
+		return nil
+	}
+	labels := make([]string, 0, len(attrs))
+	for k, v := range attrs {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", sanitizePromLabelName(k), escapePromLabel(v)))
+	}
+	sort.Strings(labels)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# HELP %s Target metadata\n", promTargetInfo)
+	fmt.Fprintf(bw, "# TYPE %s gauge\n", promTargetInfo)
+	fmt.Fprintf(bw, "%s{%s} 1\n", promTargetInfo, strings.Join(labels, ","))
+	return bw.Flush()
+}
+
+// sanitizePromLabelName replaces the characters not allowed in a Prometheus
+// label name with underscores.
+func sanitizePromLabelName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// formatPromFloat formats a float in the way of the Prometheus text format
+func formatPromFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+// escapePromLabel escapes a label value in the Prometheus text format
+func escapePromLabel(s string) string {
+	return promLabelEscaper.Replace(s)
+}
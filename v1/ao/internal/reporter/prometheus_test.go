@@ -0,0 +1,161 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToPromHistogram(t *testing.T) {
+	var hi = &histograms{
+		histograms: make(map[string]*histogram),
+		precision:  metricsHistPrecisionDefault,
+	}
+	durations := []time.Duration{
+		2 * time.Millisecond, 8 * time.Millisecond, 40 * time.Millisecond,
+		40 * time.Millisecond, 300 * time.Millisecond, 3 * time.Second, 30 * time.Second,
+	}
+	var sum time.Duration
+	for _, d := range durations {
+		recordHistogram(hi, "prom", d)
+		sum += d
+	}
+
+	h := toPromHistogram(hi.histograms["prom"].hist, config.DefaultHistogramBuckets)
+	assert.Equal(t, int64(len(durations)), h.Count)
+	assert.InDelta(t, sum.Seconds(), h.Sum, sum.Seconds()*0.01)
+	assert.Len(t, h.Buckets, len(config.DefaultHistogramBuckets)+1)
+
+	// the buckets are cumulative and the per-bucket counts sum to the count
+	var total, prev int64
+	for _, b := range h.Buckets {
+		assert.True(t, b.CumulativeCount >= prev)
+		total += b.CumulativeCount - prev
+		prev = b.CumulativeCount
+	}
+	assert.Equal(t, h.Count, total)
+
+	last := h.Buckets[len(h.Buckets)-1]
+	assert.True(t, math.IsInf(last.UpperBound, 1))
+	assert.Equal(t, h.Count, last.CumulativeCount)
+
+	counts := map[float64]int64{}
+	for _, b := range h.Buckets {
+		counts[b.UpperBound] = b.CumulativeCount
+	}
+	assert.Equal(t, int64(1), counts[.005])
+	assert.Equal(t, int64(2), counts[.01])
+	assert.Equal(t, int64(4), counts[.05])
+	assert.Equal(t, int64(5), counts[.5])
+	assert.Equal(t, int64(6), counts[5])
+	assert.Equal(t, int64(6), counts[10])
+
+	// custom bounds
+	h = toPromHistogram(hi.histograms["prom"].hist, []float64{1})
+	assert.Equal(t, []PromBucket{{1, 5}, {math.Inf(1), 7}}, h.Buckets)
+}
+
+// resetPromHistograms clears the histograms of the current metrics cycle and
+// the accumulated ones.
+func resetPromHistograms() {
+	metricsHTTPHistograms.lock.Lock()
+	metricsHTTPHistograms.histograms = make(map[string]*histogram)
+	metricsHTTPHistograms.lock.Unlock()
+	promHistograms.Lock()
+	promHistograms.histograms = make(map[string]PromHistogram)
+	promHistograms.Unlock()
+}
+
+func TestWritePrometheusHistograms(t *testing.T) {
+	resetPromHistograms()
+	defer resetPromHistograms()
+
+	var buf bytes.Buffer
+	assert.Nil(t, WritePrometheusHistograms(&buf))
+	assert.Empty(t, buf.String())
+
+	recordHistogram(metricsHTTPHistograms, "", 20*time.Millisecond)
+	recordHistogram(metricsHTTPHistograms, `my "txn"`, 20*time.Millisecond)
+
+	assert.Nil(t, WritePrometheusHistograms(&buf))
+	out := buf.String()
+	assert.Contains(t, out, "# TYPE appoptics_transaction_response_time_seconds histogram\n")
+	assert.Contains(t, out, "appoptics_transaction_response_time_seconds_bucket{le=\"0.01\"} 0\n")
+	assert.Contains(t, out, "appoptics_transaction_response_time_seconds_bucket{le=\"0.025\"} 1\n")
+	assert.Contains(t, out, "appoptics_transaction_response_time_seconds_bucket{le=\"+Inf\"} 1\n")
+	assert.Contains(t, out, "appoptics_transaction_response_time_seconds_count 1\n")
+	assert.Contains(t, out,
+		"appoptics_transaction_response_time_seconds_bucket{transaction=\"my \\\"txn\\\"\",le=\"+Inf\"} 1\n")
+	assert.Contains(t, out,
+		"appoptics_transaction_response_time_seconds_count{transaction=\"my \\\"txn\\\"\"} 1\n")
+}
+
+func TestPromHistogramsAccumulated(t *testing.T) {
+	resetPromHistograms()
+	defer resetPromHistograms()
+
+	recordHistogram(metricsHTTPHistograms, "txn", 20*time.Millisecond)
+	recordHistogram(metricsHTTPHistograms, "txn", 2*time.Second)
+
+	// the histograms are reset by the metrics cycle
+	metricsHTTPHistograms.lock.Lock()
+	accumulatePromHistograms(metricsHTTPHistograms.histograms)
+	metricsHTTPHistograms.histograms = make(map[string]*histogram)
+	metricsHTTPHistograms.lock.Unlock()
+	recordHistogram(metricsHTTPHistograms, "txn", 20*time.Millisecond)
+
+	var buf bytes.Buffer
+	assert.Nil(t, WritePrometheusHistograms(&buf))
+	out := buf.String()
+	for _, line := range []string{
+		"appoptics_transaction_response_time_seconds_bucket{transaction=\"txn\",le=\"0.01\"} 0\n",
+		"appoptics_transaction_response_time_seconds_bucket{transaction=\"txn\",le=\"0.025\"} 2\n",
+		"appoptics_transaction_response_time_seconds_bucket{transaction=\"txn\",le=\"1\"} 2\n",
+		"appoptics_transaction_response_time_seconds_bucket{transaction=\"txn\",le=\"2.5\"} 3\n",
+		"appoptics_transaction_response_time_seconds_bucket{transaction=\"txn\",le=\"+Inf\"} 3\n",
+		"appoptics_transaction_response_time_seconds_count{transaction=\"txn\"} 3\n",
+	} {
+		assert.Contains(t, out, line)
+	}
+
+	// the accumulated histogram is dropped if the buckets are reconfigured
+	h := addPromHistograms(PromHistogram{Buckets: []PromBucket{{1, 1}, {math.Inf(1), 2}}, Count: 2},
+		PromHistogram{Buckets: []PromBucket{{2, 1}, {math.Inf(1), 1}}, Count: 1})
+	assert.Equal(t, int64(1), h.Count)
+}
+
+func TestPromHistogramsBounded(t *testing.T) {
+	os.Setenv("APPOPTICS_MAX_TRANSACTIONS", "2")
+	config.Load()
+	resetPromHistograms()
+	defer func() {
+		os.Unsetenv("APPOPTICS_MAX_TRANSACTIONS")
+		config.Load()
+		resetPromHistograms()
+	}()
+
+	// the transactions of different metrics cycles add up
+	for _, txn := range []string{"", "a", "b", "c", "d"} {
+		recordHistogram(metricsHTTPHistograms, txn, 20*time.Millisecond)
+		metricsHTTPHistograms.lock.Lock()
+		accumulatePromHistograms(metricsHTTPHistograms.histograms)
+		metricsHTTPHistograms.histograms = make(map[string]*histogram)
+		metricsHTTPHistograms.lock.Unlock()
+	}
+	recordHistogram(metricsHTTPHistograms, "e", 20*time.Millisecond)
+
+	hs := TransactionHistograms()
+	var txns []string
+	for _, h := range hs {
+		txns = append(txns, h.Transaction)
+	}
+	assert.Equal(t, []string{"", "a", "b", OtherTransactionName}, txns)
+	assert.Equal(t, int64(3), hs[3].Count)
+}
@@ -9,7 +9,6 @@ import (
 	"errors"
 	"math"
 	"strings"
-	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/host"
@@ -46,6 +45,9 @@ const (
 // currently used reporter
 var globalReporter reporter = &nullReporter{}
 
+// the error of initializing the current reporter, if any
+var initErr error
+
 var (
 	periodicTasksDisabled = false // disable periodic tasks, for testing
 )
@@ -85,14 +87,21 @@ func setGlobalReporter(reporterType string) {
 	if globalReporter != nil {
 		globalReporter.ShutdownNow()
 	}
+	initErr = nil
 
 	switch strings.ToLower(reporterType) {
 	case "ssl":
 		fallthrough // using fallthrough since the SSL reporter (gRPC) is our default reporter
 	default:
-		globalReporter = newGRPCReporter()
+		if config.GetLazyStart() && config.IsValidServiceKey(config.GetServiceKey()) {
+			globalReporter = newLazyReporter(newGRPCReporter)
+		} else {
+			globalReporter = newGRPCReporter()
+		}
 	case "udp":
 		globalReporter = udpNewReporter()
+	case "pretty":
+		globalReporter = prettyNewReporter()
 	case "none":
 		globalReporter = newNullReporter()
 	}
@@ -111,6 +120,50 @@ func Shutdown(ctx context.Context) error {
 	return globalReporter.Shutdown(ctx)
 }
 
+// metricsFlusher is implemented by the reporters which send metrics.
+type metricsFlusher interface {
+	FlushMetrics(ctx context.Context) error
+}
+
+// FlushMetrics sends the pending metrics immediately and blocks until they are
+// sent or the context is canceled. It's a no-op for the reporters which don't
+// send metrics.
+func FlushMetrics(ctx context.Context) error {
+	r := globalReporter
+	if tee, ok := r.(*teeReporter); ok {
+		r = tee.reporter
+	}
+	if f, ok := r.(metricsFlusher); ok {
+		return f.FlushMetrics(ctx)
+	}
+	return nil
+}
+
+// eventsFlusher is implemented by the reporters which batch the events.
+type eventsFlusher interface {
+	FlushEvents(ctx context.Context) error
+}
+
+// FlushEvents sends the events queued immediately and blocks until they are
+// sent or the context is canceled. It's a no-op for the reporters which send
+// the events as they're reported.
+func FlushEvents(ctx context.Context) error {
+	r := globalReporter
+	if tee, ok := r.(*teeReporter); ok {
+		r = tee.reporter
+	}
+	if f, ok := r.(eventsFlusher); ok {
+		return f.FlushEvents(ctx)
+	}
+	return nil
+}
+
+// InitError returns the error of initializing the reporter, e.g., an invalid
+// service key, if any.
+func InitError() error {
+	return initErr
+}
+
 // Closed indicates if the reporter has been shutdown
 func Closed() bool {
 	return globalReporter.Closed()
@@ -133,6 +186,9 @@ func prepareEvent(ctx *oboeContext, e *event) error {
 	if ctx == nil || e == nil {
 		return errors.New("invalid context, event")
 	}
+	if e.prepared {
+		return nil
+	}
 
 	// The context metadata must have the same task_id as the event.
 	if !bytes.Equal(ctx.metadata.ids.taskID, e.metadata.ids.taskID) {
@@ -144,7 +200,15 @@ func prepareEvent(ctx *oboeContext, e *event) error {
 		return errors.New("invalid event, same as context")
 	}
 
-	us := time.Now().UnixNano() / 1000
+	ts := e.timestamp
+	if ts.IsZero() {
+		ts = ctx.txCtx.timestamp()
+	}
+	ts, err := checkTimestampSkew(ts)
+	if err != nil {
+		return err
+	}
+	us := ts.UnixNano() / 1000
 	e.AddInt64("Timestamp_u", us)
 
 	e.AddString("Hostname", host.Hostname())
@@ -157,13 +221,18 @@ func prepareEvent(ctx *oboeContext, e *event) error {
 	return nil
 }
 
-func shouldTraceRequestWithURL(layer string, traced bool, url string) (bool, int, sampleSource, bool) {
-	return oboeSampleRequest(layer, traced, url)
+func shouldTraceRequestWithURL(layer string, traced bool, url string, hint SamplingHint) (bool, int, sampleSource, bool) {
+	return shouldTraceRequestForOrigin(layer, traced, url, hint, "")
+}
+
+func shouldTraceRequestForOrigin(layer string, traced bool, url string, hint SamplingHint,
+	origin string) (bool, int, sampleSource, bool) {
+	return oboeSampleRequest(layer, traced, url, hint, origin)
 }
 
 // Determines if request should be traced, based on sample rate settings.
 func shouldTraceRequest(layer string, traced bool) (bool, int, sampleSource, bool) {
-	return shouldTraceRequestWithURL(layer, traced, "")
+	return shouldTraceRequestWithURL(layer, traced, "", SamplingHintNone)
 }
 
 func argsToMap(capacity, ratePerSec float64, metricsFlushInterval, maxTransactions int) map[string][]byte {
@@ -5,8 +5,10 @@ package reporter
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"io/ioutil"
 	"math"
+	"net"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -68,10 +70,12 @@ ftgwcxyEq5SkiR+6BCwdzAMqADV37TzXDHLjwSrMIrgLV5xZM20Kk6chxI5QAr/f
 	grpcRetryDelayInitial                   = 500              // initial connection/send retry delay in milliseconds
 	grpcRetryDelayMultiplier                = 1.5              // backoff multiplier for unsuccessful retries
 	grpcRetryDelayMax                       = 60               // max connection/send retry delay in seconds
-	grpcCtxTimeout                          = 10 * time.Second // gRPC method invocation timeout in seconds
+	grpcCtxTimeout                          = 10 * time.Second // default gRPC method invocation timeout
 	grpcRedirectMax                         = 20               // max allowed collector redirects
 	grpcRetryLogThreshold                   = 10               // log prints after this number of retries (about 56.7s)
 	grpcMaxRetries                          = 20               // The message will be dropped after this number of retries
+	grpcDialTimeoutDefault                  = 10 * time.Second // timeout of establishing a connection
+	grpcKeepAliveDefault                    = 30 * time.Second // TCP keepalive period of the connection
 )
 
 type reporterChannel int
@@ -84,6 +88,10 @@ const (
 
 // everything needed for a GRPC connection
 type grpcConnection struct {
+	// the number of reconnections, it should be accessed atomically. It's kept
+	// as the first word to be 64-bit aligned on 32-bit platforms.
+	reconnects int64
+
 	name           string                         // connection name
 	client         collector.TraceCollectorClient // GRPC client instance
 	connection     *grpc.ClientConn               // GRPC connection object
@@ -104,9 +112,28 @@ type grpcConnection struct {
 	backoff Backoff
 	Dialer
 
+	// the timeout of establishing a TCP connection to the collector
+	dialTimeout time.Duration
+	// the TCP keepalive period, it's disabled if the value is 0
+	keepAlive time.Duration
+	// the timeout of an RPC call
+	sendTimeout time.Duration
+	// the number of retries of a message after its RPC call times out before
+	// the message is dropped, no limit if it's negative
+	sendTimeoutRetries int
+	// the compression of the messages, and the minimum size in bytes of the
+	// messages of an RPC call to be compressed
+	compression          string
+	compressionThreshold int
+
 	// This channel is closed after flushing the metrics.
 	flushed     chan struct{}
 	flushedOnce sync.Once
+
+	// the last error returned by the RPC calls
+	lastErr errorRecord
+	// the status codes of the fatal RPC errors which have been logged
+	fatalLogged sync.Map
 }
 
 // GrpcConnOpt defines the function type that sets an option of the grpcConnection
@@ -133,6 +160,44 @@ func WithDialer(d Dialer) GrpcConnOpt {
 	}
 }
 
+// WithDialTimeout returns a function that sets the dial timeout
+func WithDialTimeout(timeout time.Duration) GrpcConnOpt {
+	return func(c *grpcConnection) {
+		c.dialTimeout = timeout
+	}
+}
+
+// WithKeepAlive returns a function that sets the TCP keepalive period
+func WithKeepAlive(period time.Duration) GrpcConnOpt {
+	return func(c *grpcConnection) {
+		c.keepAlive = period
+	}
+}
+
+// WithSendTimeout returns a function that sets the timeout of an RPC call
+func WithSendTimeout(timeout time.Duration) GrpcConnOpt {
+	return func(c *grpcConnection) {
+		c.sendTimeout = timeout
+	}
+}
+
+// WithSendTimeoutRetries returns a function that sets the number of retries
+// of a message after its RPC call times out
+func WithSendTimeoutRetries(retries int) GrpcConnOpt {
+	return func(c *grpcConnection) {
+		c.sendTimeoutRetries = retries
+	}
+}
+
+// WithCompression returns a function that sets the compression of the
+// messages which are not smaller than the threshold in bytes
+func WithCompression(compression string, threshold int) GrpcConnOpt {
+	return func(c *grpcConnection) {
+		c.compression = compression
+		c.compressionThreshold = threshold
+	}
+}
+
 // WithBackoff return a function that sets the backoff option
 func WithBackoff(b Backoff) GrpcConnOpt {
 	return func(c *grpcConnection) {
@@ -151,6 +216,10 @@ func newGrpcConnection(name string, target string, opts ...GrpcConnOpt) (*grpcCo
 		insecureSkipVerify: false,
 		backoff:            DefaultBackoff,
 		Dialer:             &DefaultDialer{},
+		dialTimeout:        grpcDialTimeoutDefault,
+		keepAlive:          grpcKeepAliveDefault,
+		sendTimeout:        grpcCtxTimeout,
+		sendTimeoutRetries: -1,
 		flushed:            make(chan struct{}),
 	}
 
@@ -178,17 +247,26 @@ func (c *grpcConnection) Close() {
 }
 
 type grpcReporter struct {
-	eventConnection              *grpcConnection // used for events only
-	metricConnection             *grpcConnection // used for everything else (postMetrics, postStatus, getSettings)
-	collectMetricInterval        int32           // metrics flush interval in seconds
-	getSettingsInterval          int             // settings retrieval interval in seconds
-	settingsTimeoutCheckInterval int             // check interval for timed out settings in seconds
+	eventConnection              *grpcConnection   // used for events only
+	eventConnections             []*grpcConnection // the pool of the event connections, led by eventConnection
+	metricConnection             *grpcConnection   // used for everything else (postMetrics, postStatus, getSettings)
+	collectMetricInterval        int32             // metrics flush interval in seconds
+	getSettingsInterval          int               // settings retrieval interval in seconds
+	settingsTimeoutCheckInterval int               // check interval for timed out settings in seconds
 
 	serviceKey string // service key
 
 	eventMessages  chan []byte      // channel for event messages (sent from agent)
 	spanMessages   chan SpanMessage // channel for span messages (sent from agent)
 	statusMessages chan []byte      // channel for status messages (sent from agent)
+	// the requests of FlushEvents, each of which is closed once the events
+	// queued are sent
+	eventFlushes chan chan struct{}
+
+	// the event queues of the tenants, keyed by the tenant names, which are
+	// rebuilt when the config is reloaded
+	tenantQueues     map[string]*eventQueue
+	tenantQueuesLock sync.RWMutex
 
 	// The reporter is considered ready if there is a valid default setting for sampling.
 	// It should be accessed atomically.
@@ -205,6 +283,82 @@ type grpcReporter struct {
 	// The flag to indicate gracefully stopping the reporter. It should be accessed atomically.
 	// A (default) zero value means shutdown abruptly.
 	gracefully int32
+
+	// the events dropped since the last diagnostic report
+	drops *eventDrops
+
+	// serializes the metrics collection of the periodic task and FlushMetrics
+	collectMetricsLock sync.Mutex
+	// the time of the last metrics collection, protected by collectMetricsLock
+	metricsCollectedAt time.Time
+}
+
+// eventQueue holds the event messages of the traces reported for a tenant.
+type eventQueue struct {
+	serviceKey string
+	messages   chan []byte
+}
+
+// eventBatch is a batch of event messages sent with the service key.
+type eventBatch struct {
+	serviceKey string
+	messages   [][]byte
+	// called once the batch is sent or dropped, if it's not nil
+	sent func()
+}
+
+// newTenantQueues creates the event queues of the tenants configured.
+func newTenantQueues() map[string]*eventQueue {
+	queues := make(map[string]*eventQueue)
+	for tenant, key := range config.GetTenantServiceKeys() {
+		queues[tenant] = &eventQueue{serviceKey: key, messages: make(chan []byte, 10000)}
+	}
+	return queues
+}
+
+// reloadTenantQueues rebuilds the event queues of the tenants from the current
+// config. The queue of a tenant is kept if its service key is unchanged, so
+// none of its events is lost. The queues removed are drained by eventSender.
+func (r *grpcReporter) reloadTenantQueues() {
+	keys := config.GetTenantServiceKeys()
+	r.tenantQueuesLock.Lock()
+	defer r.tenantQueuesLock.Unlock()
+	queues := make(map[string]*eventQueue, len(keys))
+	for tenant, key := range keys {
+		if q, ok := r.tenantQueues[tenant]; ok && q.serviceKey == key {
+			queues[tenant] = q
+		} else {
+			queues[tenant] = &eventQueue{serviceKey: key, messages: make(chan []byte, 10000)}
+		}
+	}
+	r.tenantQueues = queues
+}
+
+// tenantQueue returns the event queue of the tenant, if any.
+func (r *grpcReporter) tenantQueue(tenant string) (*eventQueue, bool) {
+	r.tenantQueuesLock.RLock()
+	defer r.tenantQueuesLock.RUnlock()
+	q, ok := r.tenantQueues[tenant]
+	return q, ok
+}
+
+// activeTenantQueues returns the set of the event queues of the tenants.
+func (r *grpcReporter) activeTenantQueues() map[*eventQueue]bool {
+	r.tenantQueuesLock.RLock()
+	defer r.tenantQueuesLock.RUnlock()
+	active := make(map[*eventQueue]bool, len(r.tenantQueues))
+	for _, q := range r.tenantQueues {
+		active[q] = true
+	}
+	return active
+}
+
+// ReloadTenants rebuilds the event queues of the tenants of the gRPC reporter,
+// if any, from the current config, e.g., after the config is reloaded.
+func ReloadTenants() {
+	if r, ok := activeReporter().(*grpcReporter); ok {
+		r.reloadTenantQueues()
+	}
 }
 
 // gRPC reporter errors
@@ -212,6 +366,9 @@ var (
 	ErrShutdownClosedReporter = errors.New("trying to shutdown a closed reporter")
 	ErrShutdownTimeout        = errors.New("Shutdown timeout")
 	ErrReporterIsClosed       = errors.New("the reporter is closed")
+	ErrFlushMetricsTimeout    = errors.New("FlushMetrics timeout")
+	ErrFlushEventsTimeout     = errors.New("FlushEvents timeout")
+	ErrSendStatusTimeout      = errors.New("SendStatus timeout")
 )
 
 const (
@@ -237,6 +394,7 @@ func newGRPCReporter() reporter {
 
 	if !config.IsValidServiceKey(serviceKey) {
 		log.Error(fullTextInvalidServiceKey)
+		initErr = config.ErrInvalidServiceKey
 		return &nullReporter{}
 	}
 
@@ -250,29 +408,46 @@ func newGRPCReporter() reporter {
 		cert, err := ioutil.ReadFile(certPath)
 		if err != nil {
 			log.Errorf("Error reading cert file %s: %v", certPath, err)
+			initErr = errors.Wrap(err, "reading cert file")
 			return &nullReporter{}
 		}
 		opts = append(opts, WithCert(cert))
+	} else if cert := config.GetTrustedCert(); cert != "" {
+		opts = append(opts, WithCert([]byte(cert)))
 	}
 
 	opts = append(opts, WithSkipVerify(config.GetSkipVerify()))
 
-	// create connection object for events client and metrics client
-	eventConn, err1 := newGrpcConnection("events channel", addr, opts...)
+	if ro := config.ReporterOpts(); ro != nil {
+		opts = append(opts,
+			WithDialTimeout(time.Duration(ro.GetDialTimeout())*time.Second),
+			WithKeepAlive(time.Duration(ro.GetKeepAlive())*time.Second),
+			WithSendTimeout(time.Duration(ro.GetSendTimeout())*time.Second),
+			WithSendTimeoutRetries(int(ro.GetSendTimeoutRetries())),
+			WithCompression(ro.GetCompression(), int(ro.GetCompressionThreshold())))
+	}
+
+	// create connection objects for events clients and metrics client
+	eventConns, err1 := newEventConnections(addr, int(config.ReporterOpts().GetEventConnections()), opts...)
 	if err1 != nil {
 		log.Errorf("Failed to initialize gRPC reporter %v: %v", addr, err1)
+		initErr = err1
 		return &nullReporter{}
 	}
 	metricConn, err2 := newGrpcConnection("metrics channel", addr, opts...)
 	if err2 != nil {
-		eventConn.Close()
+		for _, c := range eventConns {
+			c.Close()
+		}
 		log.Errorf("Failed to initialize gRPC reporter %v: %v", addr, err2)
+		initErr = err2
 		return &nullReporter{}
 	}
 
 	// construct the reporter object which handles two connections
 	r := &grpcReporter{
-		eventConnection:  eventConn,
+		eventConnection:  eventConns[0],
+		eventConnections: eventConns,
 		metricConnection: metricConn,
 
 		collectMetricInterval:        grpcMetricIntervalDefault,
@@ -282,11 +457,15 @@ func newGRPCReporter() reporter {
 		serviceKey: serviceKey,
 
 		eventMessages:  make(chan []byte, 10000),
+		tenantQueues:   newTenantQueues(),
 		spanMessages:   make(chan SpanMessage, 10000),
 		statusMessages: make(chan []byte, 100),
+		eventFlushes:   make(chan chan struct{}),
 
 		cond: sync.NewCond(&sync.Mutex{}),
 		done: make(chan struct{}),
+
+		drops: newEventDrops(dropsReportIntervalDefault),
 	}
 
 	r.start()
@@ -296,6 +475,42 @@ func newGRPCReporter() reporter {
 	return r
 }
 
+// newEventConnections creates the pool of n connections sending events. The
+// connections share the queue stats of the first one, which are reported as
+// the stats of the whole pool.
+func newEventConnections(addr string, n int, opts ...GrpcConnOpt) ([]*grpcConnection, error) {
+	if n < 1 {
+		n = 1
+	}
+	conns := make([]*grpcConnection, 0, n)
+	for i := 0; i < n; i++ {
+		name := "events channel"
+		if i > 0 {
+			name = fmt.Sprintf("events channel %d", i)
+		}
+		c, err := newGrpcConnection(name, addr, opts...)
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, err
+		}
+		if i > 0 {
+			c.queueStats = conns[0].queueStats
+		}
+		conns = append(conns, c)
+	}
+	return conns, nil
+}
+
+// eventConns returns the connections sending events.
+func (r *grpcReporter) eventConns() []*grpcConnection {
+	if len(r.eventConnections) == 0 {
+		return []*grpcConnection{r.eventConnection}
+	}
+	return r.eventConnections
+}
+
 func (r *grpcReporter) isGracefully() bool {
 	return atomic.LoadInt32(&r.gracefully) == 1
 }
@@ -376,9 +591,9 @@ func (r *grpcReporter) Shutdown(ctx context.Context) error {
 func (r *grpcReporter) flushed() chan struct{} {
 	c := make(chan struct{})
 	go func(o chan struct{}) {
-		chs := []chan struct{}{
-			r.eventConnection.getFlushedChan(),
-			r.metricConnection.getFlushedChan(),
+		chs := []chan struct{}{r.metricConnection.getFlushedChan()}
+		for _, c := range r.eventConns() {
+			chs = append(chs, c.getFlushedChan())
 		}
 		for _, ch := range chs {
 			<-ch
@@ -390,7 +605,9 @@ func (r *grpcReporter) flushed() chan struct{} {
 
 // closeConns closes all the gRPC connections of a reporter
 func (r *grpcReporter) closeConns() {
-	r.eventConnection.Close()
+	for _, c := range r.eventConns() {
+		c.Close()
+	}
 	r.metricConnection.Close()
 }
 
@@ -468,7 +685,7 @@ func (c *grpcConnection) connect() error {
 		return nil
 	}
 	// create a new connection object for this client
-	conn, err := c.Dial(*c)
+	conn, err := c.Dial(c)
 	if err != nil {
 		return errors.Wrap(err, "failed to connect to target")
 	}
@@ -486,6 +703,15 @@ func (c *grpcConnection) connect() error {
 	return nil
 }
 
+// getSendTimeout returns the timeout of an RPC call, or the default one if
+// it's not set.
+func (c *grpcConnection) getSendTimeout() time.Duration {
+	if c.sendTimeout <= 0 {
+		return grpcCtxTimeout
+	}
+	return c.sendTimeout
+}
+
 func (c *grpcConnection) isActive() bool {
 	return atomic.LoadInt32(&c.atomicActive) == 1
 }
@@ -499,7 +725,10 @@ func (c *grpcConnection) setActive(active bool) {
 }
 
 func (c *grpcConnection) reconnect() {
-	c.connect()
+	atomic.AddInt64(&c.reconnects, 1)
+	if err := c.connect(); err != nil {
+		c.lastErr.set(err)
+	}
 }
 
 // long-running goroutine that kicks off periodic tasks like collectMetrics() and getSettings()
@@ -579,14 +808,16 @@ func (r *grpcReporter) periodicTasks() {
 				go r.checkSettingsTimeout(settingsTimeoutCheckReady)
 			default:
 			}
-		case <-r.eventConnection.pingTicker.C: // ping on event connection (keep alive)
+		case <-r.eventConnection.pingTicker.C: // ping on event connections (keep alive)
 			// set up ticker for next round
 			r.eventConnection.resetPing()
-			go func() {
-				if r.eventConnection.ping(r.done, r.serviceKey) == errInvalidServiceKey {
-					r.ShutdownNow()
-				}
-			}()
+			for _, c := range r.eventConns() {
+				go func(c *grpcConnection) {
+					if c.ping(r.done, r.serviceKey) == errInvalidServiceKey {
+						r.ShutdownNow()
+					}
+				}(c)
+			}
 		case <-r.metricConnection.pingTicker.C: // ping on metrics connection (keep alive)
 			// set up ticker for next round
 			r.metricConnection.resetPing()
@@ -633,38 +864,72 @@ func (r *grpcReporter) reportEvent(ctx *oboeContext, e *event) error {
 		return err
 	}
 
+	messages := r.eventMessages
+	if tenant := ctx.getTenant(); tenant != "" {
+		q, ok := r.tenantQueue(tenant)
+		if !ok {
+			return errors.Errorf("no event queue for tenant %s", tenant)
+		}
+		messages = q.messages
+	}
+
 	select {
-	case r.eventMessages <- (*e).bbuf.GetBuf():
+	case messages <- (*e).bbuf.GetBuf():
 		atomic.AddInt64(&r.eventConnection.queueStats.totalEvents, int64(1))
 		return nil
 	default:
 		atomic.AddInt64(&r.eventConnection.queueStats.numOverflowed, int64(1))
+		r.drops.add(dropReasonQueueFull, 1)
 		return errors.New("event message queue is full")
 	}
 }
 
+// keyedBucket is the bucket of the events sent with the service key.
+type keyedBucket struct {
+	serviceKey string
+	*BytesBucket
+	// if the tenant of the bucket is removed by reloading the config
+	removed bool
+}
+
 // eventSender is a long-running goroutine that listens on the events message
-// channel, collects all messages on that channel and attempts to send them to
-// the collector using the gRPC method PostEvents()
+// channels, collects all messages on them and attempts to send them to the
+// collector using the gRPC method PostEvents(). The events of each tenant are
+// batched separately and sent with the tenant's service key.
 func (r *grpcReporter) eventSender() {
-	batches := make(chan [][]byte, 10)
+	// the batches are sent over the pooled connections in a round-robin
+	// manner, skipping the busy ones
+	conns := r.eventConns()
+	batches := make([]chan eventBatch, len(conns))
+	for i, c := range conns {
+		batches[i] = make(chan eventBatch, 10)
+		go r.eventBatchSender(c, batches[i])
+	}
+	next := 0
 	defer func() {
-		close(batches)
+		for _, b := range batches {
+			close(b)
+		}
 		log.Info("eventSender goroutine exiting.")
 	}()
 
-	go r.eventBatchSender(batches)
 	opts := config.ReporterOpts()
 
 	// This event bucket is drainable either after it reaches HWM, or the flush
 	// interval has passed.
-	evtBucket := NewBytesBucket(r.eventMessages,
-		WithHWM(int(opts.GetEventFlushBatchSize()*1024)),
-		WithIntervalGetter(opts.GetEventFlushInterval))
+	newBucket := func(serviceKey string, messages chan []byte) keyedBucket {
+		return keyedBucket{serviceKey: serviceKey, BytesBucket: NewBytesBucket(messages,
+			WithHWM(int(opts.GetEventFlushBatchSize()*1024)),
+			WithIntervalGetter(opts.GetEventFlushInterval))}
+	}
+	defaultBucket := newBucket(r.serviceKey, r.eventMessages)
+	tenantBuckets := make(map[*eventQueue]keyedBucket)
 
 	var closing bool
 
 	for {
+		// the flush request received in this round, if any
+		var flushed chan struct{}
 		select {
 		// Check if the agent is required to quit.
 		case <-r.done:
@@ -672,27 +937,64 @@ func (r *grpcReporter) eventSender() {
 				return
 			}
 			closing = true
+		case flushed = <-r.eventFlushes:
 		default:
 		}
+		// the batches pushed for the flush request
+		var flushing sync.WaitGroup
+
+		// the buckets of the tenant queues added by reloading the config are
+		// created, and the ones removed are drained for the last time.
+		active := r.activeTenantQueues()
+		for q := range active {
+			if _, ok := tenantBuckets[q]; !ok {
+				tenantBuckets[q] = newBucket(q.serviceKey, q.messages)
+			}
+		}
+		buckets := []keyedBucket{defaultBucket}
+		for q, b := range tenantBuckets {
+			if !active[q] {
+				b.removed = true
+				delete(tenantBuckets, q)
+			}
+			buckets = append(buckets, b)
+		}
+
+		for _, evtBucket := range buckets {
+			// Pour as much water as we can into the bucket, until it's full or
+			// no more water can be got from the source. It's not blocking here.
+			evtBucket.PourIn()
+
+			// The events can only be pushed into the channel when the bucket
+			// is drainable (either full or timeout) and we've got the token
+			// to push events.
+			//
+			// If the token is holding by eventRetrySender, it usually means the
+			// events sending is too slow (or the events are generated too fast).
+			// We have to wait in this case.
+			//
+			// If the reporter is closing or the tenant is removed, we have the
+			// last chance to send all the queued events.
+			//
+			// All the buckets are drained for a flush request.
+			if evtBucket.Drainable() || evtBucket.removed || closing || flushed != nil {
+				w := evtBucket.Watermark()
+				batch := eventBatch{serviceKey: evtBucket.serviceKey, messages: evtBucket.Drain()}
+				if flushed != nil {
+					flushing.Add(1)
+					batch.sent = flushing.Done
+				}
+				sent := dispatchBatch(batches, next, batch)
+				log.Debugf("Pushed %d bytes to the sender %d.", w, sent)
+				next = (sent + 1) % len(batches)
+			}
+		}
 
-		// Pour as much water as we can into the bucket, until it's full or
-		// no more water can be got from the source. It's not blocking here.
-		evtBucket.PourIn()
-
-		// The events can only be pushed into the channel when the bucket
-		// is drainable (either full or timeout) and we've got the token
-		// to push events.
-		//
-		// If the token is holding by eventRetrySender, it usually means the
-		// events sending is too slow (or the events are generated too fast).
-		// We have to wait in this case.
-		//
-		// If the reporter is closing, we have the last chance to send all
-		// the queued events.
-		if evtBucket.Drainable() || closing {
-			w := evtBucket.Watermark()
-			batches <- evtBucket.Drain()
-			log.Debugf("Pushed %d bytes to the sender.", w)
+		if flushed != nil {
+			go func() {
+				flushing.Wait()
+				close(flushed)
+			}()
 		}
 
 		if closing {
@@ -704,55 +1006,93 @@ func (r *grpcReporter) eventSender() {
 	}
 }
 
-func (r *grpcReporter) eventBatchSender(batches <-chan [][]byte) {
+// dispatchBatch pushes the batch to the first sender from next whose queue is
+// not full, so a slow connection doesn't hold back the batches while the others
+// are free. It waits for the sender next only if all of them are busy. It
+// returns the index of the sender which the batch is pushed to.
+func dispatchBatch(batches []chan eventBatch, next int, batch eventBatch) int {
+	for i := range batches {
+		n := (next + i) % len(batches)
+		select {
+		case batches[n] <- batch:
+			return n
+		default:
+		}
+	}
+	batches[next] <- batch
+	return next
+}
+
+func (r *grpcReporter) eventBatchSender(conn *grpcConnection, batches <-chan eventBatch) {
 	defer func() {
-		r.eventConnection.setFlushed()
+		conn.setFlushed()
 		log.Info("eventBatchSender goroutine exiting.")
 	}()
 
-	var closing bool
-	var messages [][]byte
-
+	done := r.done
 	for {
-		// this will block until a message arrives or the reporter is closed
+		var batch eventBatch
+		// this will block until a batch arrives, or the channel is closed by
+		// eventSender which has pushed all the batches, or the reporter is
+		// closed.
 		select {
-		case b := <-batches:
-			messages = b
-		case messages = <-batches:
-			if len(messages) == 0 {
-				batches = nil
-			}
-		case <-r.done:
-			select {
-			case messages = <-batches:
-			default:
+		case b, ok := <-batches:
+			if !ok {
+				return
 			}
+			batch = b
+		case <-done:
 			if !r.isGracefully() {
 				return
 			}
-			closing = true
+			// keep sending the last batches until the channel is closed
+			done = nil
+			continue
 		}
 
-		if len(messages) != 0 {
-			method := newPostEventsMethod(r.serviceKey, messages)
-			err := r.eventConnection.InvokeRPC(r.done, method)
+		if messages := batch.messages; len(messages) != 0 {
+			method := newPostEventsMethod(batch.serviceKey, messages)
+			err := conn.InvokeRPC(r.done, method)
 
 			switch err {
 			case errInvalidServiceKey:
+				if batch.serviceKey != r.serviceKey {
+					// only the events of the tenant are rejected
+					log.Errorf("eventBatchSender: invalid tenant service key %s",
+						config.MaskServiceKey(batch.serviceKey))
+					r.drops.add(dropReasonSendFailed, int64(len(messages)))
+					break
+				}
 				r.ShutdownNow()
 			case nil:
 				log.Info(method.CallSummary())
+				r.reportDrops()
+			case errFatalRPC:
+				// it's logged by InvokeRPC already
+				r.drops.add(dropReasonSendFailed, int64(len(messages)))
 			default:
 				log.Warningf("eventBatchSender: %s", err)
+				r.drops.add(dropReasonSendFailed, int64(len(messages)))
 			}
 		}
-
-		if closing {
-			return
+		if batch.sent != nil {
+			batch.sent()
 		}
 	}
 }
 
+// reportDrops sends a diagnostic span summarizing the dropped events, if it's
+// enabled. It's called after the events are sent successfully so the span is
+// likely to be delivered.
+func (r *grpcReporter) reportDrops() {
+	if !config.GetReportDroppedEvents() {
+		return
+	}
+	if err := r.drops.report(time.Now()); err != nil {
+		log.Debugf("Failed to report dropped events: %v", err)
+	}
+}
+
 // ================================ Metrics Handling ====================================
 
 // calculates the interval from now until the next time we need to collect metrics
@@ -769,13 +1109,97 @@ func (r *grpcReporter) collectMetricsNextInterval() time.Duration {
 func (r *grpcReporter) collectMetrics(collectReady chan bool) {
 	// notify caller that this routine has terminated (defered to end of routine)
 	defer func() { collectReady <- true }()
+	r.collectAndSendMetrics()
+}
+
+// collectAndSendMetrics generates a new metrics message and sends it to the
+// collector. It's shared by the periodic task, FlushMetrics and the graceful
+// shutdown, and the calls are serialized as generating the message resets the
+// metrics.
+func (r *grpcReporter) collectAndSendMetrics() {
+	r.collectMetricsLock.Lock()
+	defer r.collectMetricsLock.Unlock()
 
 	i := int(atomic.LoadInt32(&r.collectMetricInterval))
+	// The metrics are aggregated since the last collection, which is less than
+	// an interval ago if it's done by FlushMetrics.
+	now := time.Now()
+	if !r.metricsCollectedAt.IsZero() {
+		elapsed := int(now.Sub(r.metricsCollectedAt).Seconds() + 0.5)
+		if elapsed < 1 {
+			elapsed = 1
+		}
+		if elapsed < i {
+			i = elapsed
+		}
+	}
+	r.metricsCollectedAt = now
+
 	// generate a new metrics message
 	message := generateMetricsMessage(i, r.eventConnection.queueStats)
+	// the message is still generated to flush the metrics aggregated
+	if !metricsEnabled() {
+		log.Debug("Metrics are disabled, not sending the metrics message.")
+		return
+	}
+	// the metrics are sent to the StatsD server when the message is generated
+	if config.GetStatsDOnly() {
+		return
+	}
 	r.sendMetrics(message)
 }
 
+// FlushMetrics collects the pending metrics and sends them to the collector
+// immediately, without waiting for the next collection interval. The metrics
+// flush interval reported is the time elapsed since the last collection, so is
+// that of the next periodic collection. It blocks until the metrics are sent or
+// the context is canceled. The sending goes on in the background in the latter
+// case.
+func (r *grpcReporter) FlushMetrics(ctx context.Context) error {
+	if r.Closed() {
+		return ErrReporterIsClosed
+	}
+
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		r.collectAndSendMetrics()
+	}()
+
+	select {
+	case <-sent:
+		return nil
+	case <-ctx.Done():
+		return ErrFlushMetricsTimeout
+	}
+}
+
+// FlushEvents sends the events queued to the collector immediately, without
+// waiting for the batches to fill up or the flush interval. It blocks until
+// the events are sent or the context is canceled. The sending goes on in the
+// background in the latter case.
+func (r *grpcReporter) FlushEvents(ctx context.Context) error {
+	if r.Closed() {
+		return ErrReporterIsClosed
+	}
+
+	flushed := make(chan struct{})
+	select {
+	case r.eventFlushes <- flushed:
+	case <-r.done:
+		return ErrReporterIsClosed
+	case <-ctx.Done():
+		return ErrFlushEventsTimeout
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ErrFlushEventsTimeout
+	}
+}
+
 // listens on the metrics message channel, collects all messages on that channel and
 // attempts to send them to the collector using the GRPC method PostMetrics()
 func (r *grpcReporter) sendMetrics(msg []byte) {
@@ -792,6 +1216,8 @@ func (r *grpcReporter) sendMetrics(msg []byte) {
 		r.ShutdownNow()
 	case nil:
 		log.Info(method.CallSummary())
+	case errFatalRPC:
+		// it's logged by InvokeRPC already
 	default:
 		log.Warningf("sendMetrics: %s", err)
 	}
@@ -838,6 +1264,9 @@ func (r *grpcReporter) updateSettings(settings *collector.SettingsResult) {
 		// update MaxTransactions
 		mt := parseInt32(s.Arguments, kvMaxTransactions, mTransMap.Cap())
 		mTransMap.SetCap(mt)
+
+		// update the features toggled by the collector
+		updateFeatureFlags(s.Arguments)
 	}
 
 	if !r.isReady() && hasDefaultSetting() {
@@ -887,6 +1316,36 @@ func (r *grpcReporter) reportStatus(ctx *oboeContext, e *event) error {
 	}
 }
 
+// SendStatus sends the status message to the collector immediately instead of
+// queueing it, and blocks until it's sent or the context is canceled.
+func (r *grpcReporter) SendStatus(ctx context.Context, c *oboeContext, e *event) error {
+	if r.Closed() {
+		return ErrReporterIsClosed
+	}
+	if err := prepareEvent(c, e); err != nil {
+		return err
+	}
+
+	method := newPostStatusMethod(r.serviceKey, [][]byte{e.bbuf.GetBuf()})
+	sent := make(chan error, 1)
+	go func() {
+		sent <- r.metricConnection.InvokeRPC(r.done, method)
+	}()
+
+	select {
+	case err := <-sent:
+		switch err {
+		case errInvalidServiceKey:
+			r.ShutdownNow()
+		case nil:
+			log.Info(method.CallSummary())
+		}
+		return err
+	case <-ctx.Done():
+		return ErrSendStatusTimeout
+	}
+}
+
 // long-running goroutine that listens on the status message channel, collects all messages
 // on that channel and attempts to send them to the collector using the GRPC method PostStatus()
 func (r *grpcReporter) statusSender() {
@@ -937,6 +1396,9 @@ func (r *grpcReporter) reportSpan(span SpanMessage) error {
 	if r.Closed() {
 		return ErrReporterIsClosed
 	}
+	if !metricsEnabled() {
+		return nil
+	}
 	select {
 	case r.spanMessages <- span:
 		return nil
@@ -1000,6 +1462,10 @@ var (
 	// the operation or loop cannot continue as the reporter is exiting.
 	errReporterExiting = errors.New("reporter is exiting")
 
+	// the RPC calls of the message time out more times than the retries
+	// allowed by the send timeout policy, and the message is dropped.
+	errSendTimeout = errors.New("send timed out")
+
 	// something might be wrong if we run into this error.
 	errShouldNotHappen = errors.New("this should not happen")
 
@@ -1010,13 +1476,48 @@ var (
 	// errConnStale means the connection is broken. This usually happens
 	// when an RPC call is timeout.
 	errConnStale = errors.New("connection is stale")
+
+	// errFatalRPC means the RPC call is rejected for a reason which won't go
+	// away by retrying, e.g., the request is malformed. The message is dropped.
+	errFatalRPC = errors.New("fatal RPC error")
 )
 
+// classifyRPCError checks if an error returned by an RPC call is worth a
+// retry. It returns nil for the retryable errors, e.g., network failures and
+// the unavailability of the collector, or errFatalRPC for the authentication
+// and validation errors, which drops the current message only. The reporter is
+// closed by the collector's INVALID_API_KEY result rather than the status code
+// of a single call, which may come from a proxy in between.
+func classifyRPCError(err error) error {
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.PermissionDenied,
+		codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange,
+		codes.Unimplemented, codes.NotFound, codes.AlreadyExists:
+		return errFatalRPC
+	default:
+		return nil
+	}
+}
+
+// logFatalOnce logs the fatal error of an RPC call as an error the first time
+// the status code is seen by this connection, and as debug messages afterwards.
+func (c *grpcConnection) logFatalOnce(m Method, err error) {
+	if _, logged := c.fatalLogged.LoadOrStore(status.Code(err), true); !logged {
+		log.Errorf("[%s] fatal invocation error, no retry: %v.", m, err)
+	} else {
+		log.Debugf("[%s] fatal invocation error, no retry: %v.", m, err)
+	}
+}
+
 // InvokeRPC makes an RPC call and returns an error if something is broken and
 // cannot be handled by itself, e.g., the collector's response indicates the
 // service key is invalid. It maintains the connection and does the retries
 // automatically and transparently. It may give up after a certain times of
-// retries, so it is a best-effort service only.
+// retries, so it is a best-effort service only. The errors which won't go away
+// by retrying, e.g., authentication or validation errors, are not retried, see
+// classifyRPCError. If the collector returns a Retry-After in the metadata,
+// e.g., when it's overloaded, the next retry waits for that delay, capped at
+// the maximum retry delay, instead of the backoff delay.
 //
 // When an error is returned, it usually means a fatal error and the reporter
 // may be shutdown.
@@ -1029,6 +1530,8 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 	failsNum := 0
 	// Number of retries, including gRPC errors and collector errors
 	retriesNum := 0
+	// Number of RPC calls timed out
+	timeoutsNum := 0
 
 	printRPCMsg(m)
 
@@ -1044,15 +1547,23 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 		}
 
 		var err = errConnStale
+		// The delay before the next retry asked by the collector, if any
+		var retryAfter time.Duration
+		var hasRetryAfter bool
 		// Protect the call to the client object or we could run into problems
 		// if another goroutine is messing with it at the same time, e.g. doing
 		// a redirection.
 		c.lock.RLock()
 		if c.isActive() {
-			ctx, cancel := context.WithTimeout(context.Background(), grpcCtxTimeout)
-			err = m.Call(ctx, c.client)
+			ctx, cancel := context.WithTimeout(context.Background(), c.getSendTimeout())
+			client := &retryAfterClient{TraceCollectorClient: c.clientFor(m)}
+			err = m.Call(ctx, client)
+			retryAfter, hasRetryAfter = client.retryAfter()
 
 			code := status.Code(err)
+			if code == codes.DeadlineExceeded {
+				timeoutsNum++
+			}
 			if code == codes.DeadlineExceeded ||
 				code == codes.Canceled {
 				log.Infof("[%s] Connection becomes stale: %v.", c.name, err)
@@ -1068,6 +1579,11 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 		c.resetPing()
 
 		if err != nil {
+			c.lastErr.set(err)
+			if fatal := classifyRPCError(err); fatal != nil {
+				c.logFatalOnce(m, err)
+				return fatal
+			}
 			// gRPC handles the reconnection automatically.
 			failsNum++
 			if failsNum == grpcRetryLogThreshold {
@@ -1082,7 +1598,11 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 			failsNum = 0
 
 			// server responded, check the result code and perform actions accordingly
-			switch result, _ := m.ResultCode(); result {
+			result, _ := m.ResultCode()
+			if result != collector.ResultCode_OK {
+				c.lastErr.set(errors.New(m.CallSummary()))
+			}
+			switch result {
 			case collector.ResultCode_OK:
 				atomic.AddInt64(&c.queueStats.numSent, m.MessageLen())
 				return nil
@@ -1118,12 +1638,23 @@ func (c *grpcConnection) InvokeRPC(exit chan struct{}, m Method) error {
 			c.reconnect()
 		}
 
+		if c.sendTimeoutRetries >= 0 && timeoutsNum > c.sendTimeoutRetries {
+			log.Warningf("[%s] dropped after %d timed out sends.", m, timeoutsNum)
+			atomic.AddInt64(&c.queueStats.numFailed, m.MessageLen())
+			return errSendTimeout
+		}
+
 		if !m.RetryOnErr() {
 			return errNoRetryOnErr
 		}
 
 		retriesNum++
 		err = c.backoff(retriesNum, func(d time.Duration) {
+			if hasRetryAfter {
+				// the collector knows better when it can take more
+				log.Debugf("[%s] retry after %v as asked by the collector.", m, retryAfter)
+				d = retryAfter
+			}
 			time.Sleep(d)
 		})
 		if err != nil {
@@ -1185,7 +1716,7 @@ func buildBestEffortIdentity() *collector.HostID {
 // Dialer has a method Dial which accepts a grpcConnection object as the
 // argument and returns a ClientConn object.
 type Dialer interface {
-	Dial(grpcConnection) (*grpc.ClientConn, error)
+	Dial(*grpcConnection) (*grpc.ClientConn, error)
 }
 
 // DefaultDialer implements the Dialer interface to provide the default dialing
@@ -1194,7 +1725,7 @@ type DefaultDialer struct{}
 
 // Dial issues the connection to the remote address with attributes provided by
 // the grpcConnection.
-func (d *DefaultDialer) Dial(c grpcConnection) (*grpc.ClientConn, error) {
+func (d *DefaultDialer) Dial(c *grpcConnection) (*grpc.ClientConn, error) {
 	certPool := x509.NewCertPool()
 
 	if ok := certPool.AppendCertsFromPEM(c.certificate); !ok {
@@ -1218,7 +1749,24 @@ func (d *DefaultDialer) Dial(c grpcConnection) (*grpc.ClientConn, error) {
 	}
 	creds := credentials.NewTLS(tlsConfig)
 
-	return grpc.Dial(c.address, grpc.WithTransportCredentials(creds))
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialTCP(ctx, addr, c.dialTimeout, c.keepAlive)
+	}
+	return grpc.Dial(c.address, grpc.WithTransportCredentials(creds),
+		grpc.WithContextDialer(dialer))
+}
+
+// dialTCP establishes a TCP connection to the address. It fails if the
+// connection cannot be established within the timeout, so that a dead collector
+// is detected quickly and the retries kick in. A zero timeout means no timeout
+// other than the one of the context, and a zero keepAlive disables the TCP
+// keepalive.
+func dialTCP(ctx context.Context, addr string, timeout, keepAlive time.Duration) (net.Conn, error) {
+	if keepAlive == 0 {
+		keepAlive = -1 // negative value disables keepalive
+	}
+	d := &net.Dialer{Timeout: timeout, KeepAlive: keepAlive}
+	return d.DialContext(ctx, "tcp", addr)
 }
 
 func printRPCMsg(m Method) {
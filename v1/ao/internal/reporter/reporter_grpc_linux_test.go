@@ -0,0 +1,61 @@
+// +build linux
+
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newUnresponsiveListener returns the address of a listening socket which
+// never accepts connections. As the backlog is zero, the handshakes of the
+// connections beyond the first one are never completed.
+func newUnresponsiveListener(t *testing.T) (string, func()) {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, 0)
+	require.NoError(t, err)
+	require.NoError(t, syscall.Bind(fd, &syscall.SockaddrInet4{Addr: [4]byte{127, 0, 0, 1}}))
+	require.NoError(t, syscall.Listen(fd, 0))
+	sa, err := syscall.Getsockname(fd)
+	require.NoError(t, err)
+	addr := fmt.Sprintf("127.0.0.1:%d", sa.(*syscall.SockaddrInet4).Port)
+	return addr, func() { syscall.Close(fd) }
+}
+
+func TestDialTCPTimeout(t *testing.T) {
+	addr, closeFn := newUnresponsiveListener(t)
+	defer closeFn()
+
+	// fill up the accept queue
+	var conns []net.Conn
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	timeout := 200 * time.Millisecond
+	for i := 0; i < 8; i++ {
+		start := time.Now()
+		conn, err := dialTCP(context.Background(), addr, timeout, grpcKeepAliveDefault)
+		if err != nil {
+			// the dial should fail within the configured window
+			elapsed := time.Since(start)
+			assert.True(t, elapsed >= timeout, elapsed)
+			assert.True(t, elapsed < timeout+time.Second, elapsed)
+			if ne, ok := err.(net.Error); assert.True(t, ok, err) {
+				assert.True(t, ne.Timeout(), err)
+			}
+			return
+		}
+		conns = append(conns, conn)
+	}
+	t.Fatal("the dial should have timed out")
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// lazyReporter defers creating the reporter, i.e., connecting to the collector
+// and starting the goroutines, until the first trace is started. It's safe for
+// concurrent use: the reporter is created exactly once and the concurrent
+// callers wait for it.
+type lazyReporter struct {
+	newReporter func() reporter
+	once        sync.Once
+	r           atomic.Value // the reporter created, of type reporter
+	closed      int32        // shutdown before the reporter is created
+}
+
+func newLazyReporter(newReporter func() reporter) *lazyReporter {
+	log.Warning("AppOptics reporter will be started on the first trace.")
+	return &lazyReporter{newReporter: newReporter}
+}
+
+// start creates the reporter if it's not created yet, and returns it.
+func (lr *lazyReporter) start() reporter {
+	lr.once.Do(func() {
+		if atomic.LoadInt32(&lr.closed) == 1 {
+			lr.r.Store(reporter(&nullReporter{}))
+			return
+		}
+		lr.r.Store(lr.newReporter())
+	})
+	return lr.r.Load().(reporter)
+}
+
+// started returns the reporter created, or nil if it's not created yet.
+func (lr *lazyReporter) started() reporter {
+	if r, ok := lr.r.Load().(reporter); ok {
+		return r
+	}
+	return nil
+}
+
+func (lr *lazyReporter) reportEvent(ctx *oboeContext, e *event) error {
+	return lr.start().reportEvent(ctx, e)
+}
+
+func (lr *lazyReporter) reportStatus(ctx *oboeContext, e *event) error {
+	return lr.start().reportStatus(ctx, e)
+}
+
+func (lr *lazyReporter) reportSpan(span SpanMessage) error {
+	return lr.start().reportSpan(span)
+}
+
+// Shutdown closes the reporter if it's created, otherwise it prevents the
+// reporter from being created.
+func (lr *lazyReporter) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&lr.closed, 1)
+	return lr.start().Shutdown(ctx)
+}
+
+// ShutdownNow closes the reporter immediately.
+func (lr *lazyReporter) ShutdownNow() error {
+	atomic.StoreInt32(&lr.closed, 1)
+	return lr.start().ShutdownNow()
+}
+
+// Closed returns if the reporter is closed. It's not closed before the
+// reporter is created unless it's shutdown.
+func (lr *lazyReporter) Closed() bool {
+	if r := lr.started(); r != nil {
+		return r.Closed()
+	}
+	return atomic.LoadInt32(&lr.closed) == 1
+}
+
+// WaitForReady starts the reporter, as the caller is about to trace, and waits
+// until it becomes ready.
+func (lr *lazyReporter) WaitForReady(ctx context.Context) bool {
+	return lr.start().WaitForReady(ctx)
+}
+
+// FlushMetrics sends the pending metrics of the reporter, if it's created.
+func (lr *lazyReporter) FlushMetrics(ctx context.Context) error {
+	if f, ok := lr.started().(metricsFlusher); ok {
+		return f.FlushMetrics(ctx)
+	}
+	return nil
+}
+
+// SendStatus starts the reporter and sends the status message immediately, if
+// the reporter supports it.
+func (lr *lazyReporter) SendStatus(ctx context.Context, c *oboeContext, e *event) error {
+	r := lr.start()
+	if s, ok := r.(syncStatusSender); ok {
+		return s.SendStatus(ctx, c, e)
+	}
+	return r.reportStatus(c, e)
+}
+
+// startReporter starts the global reporter if it's started lazily. It's called
+// when a trace is started.
+func startReporter() {
+	r := globalReporter
+	if tee, ok := r.(*teeReporter); ok {
+		r = tee.reporter
+	}
+	if lr, ok := r.(*lazyReporter); ok {
+		lr.start()
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLazyReporter(t *testing.T) {
+	oldReporter := globalReporter
+	defer func() { globalReporter = oldReporter }()
+
+	var created int32
+	nr := &nullReporter{}
+	lr := newLazyReporter(func() reporter {
+		atomic.AddInt32(&created, 1)
+		return nr
+	})
+	globalReporter = lr
+
+	// nothing is created until the first trace
+	assert.False(t, Closed())
+	assert.Nil(t, lr.started())
+	assert.NoError(t, FlushMetrics(context.Background()))
+	assert.Equal(t, "lazy", GetDiagnostics().Reporter)
+	assert.Zero(t, atomic.LoadInt32(&created))
+
+	// the reporter is created exactly once by the concurrent traces
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			NewContext("test", "", false, nil)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&created))
+	assert.Equal(t, nr, lr.started())
+	assert.Equal(t, "none", GetDiagnostics().Reporter)
+}
+
+func TestLazyReporterShutdown(t *testing.T) {
+	oldReporter := globalReporter
+	defer func() { globalReporter = oldReporter }()
+
+	var created int32
+	lr := newLazyReporter(func() reporter {
+		atomic.AddInt32(&created, 1)
+		return &nullReporter{}
+	})
+	globalReporter = lr
+
+	// the reporter is never created once it's shutdown
+	assert.NoError(t, Shutdown(context.Background()))
+	assert.True(t, Closed())
+	NewContext("test", "", false, nil)
+	assert.Zero(t, atomic.LoadInt32(&created))
+}
+
+func TestSetLazyReporter(t *testing.T) {
+	os.Setenv("APPOPTICS_LAZY_START", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_LAZY_START")
+		config.Load()
+	}()
+
+	oldReporter := globalReporter
+	defer func() { globalReporter = oldReporter }()
+
+	setGlobalReporter("ssl")
+	require.IsType(t, &lazyReporter{}, globalReporter)
+	lr := globalReporter.(*lazyReporter)
+	// no connection to the collector is made
+	assert.Nil(t, lr.started())
+	assert.NoError(t, lr.ShutdownNow())
+	assert.Nil(t, InitError())
+}
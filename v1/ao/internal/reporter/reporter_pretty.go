@@ -0,0 +1,291 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// the maximum length of a KV value printed, the longer ones are truncated
+	prettyValueLenMax = 64
+	// the maximum number of traces buffered until they finish, the oldest one
+	// is evicted if a new trace exceeds it
+	prettyPendingMax = 100
+)
+
+// the KVs which are not printed as they're present in every event
+var prettyHiddenKeys = map[string]bool{
+	"_V": true, "X-Trace": true, EdgeKey: true, "Layer": true, "Label": true,
+	"Timestamp_u": true, "Hostname": true, "PID": true, "TID": true,
+}
+
+// prettyReporter prints every finished trace to stdout as an indented tree of
+// its spans, with their durations and KVs. The start time of the trace is
+// printed in the configured layout and time zone (APPOPTICS_PRETTY_TIME_FORMAT
+// and APPOPTICS_PRETTY_TIME_ZONE). It's for local debugging only:
+// nothing is sent to AppOptics and no metrics are reported. The traces are
+// sampled by the configured sample rate and tracing mode as usual.
+type prettyReporter struct {
+	out io.Writer
+	// the events of the unfinished traces, keyed by the task ID
+	pending map[string]*recordedTrace
+	// the task IDs of the unfinished traces in the order they're started
+	order []string
+	lock  sync.Mutex
+}
+
+func newPrettyReporter(out io.Writer) reporter {
+	log.Warning("AppOptics pretty reporter is enabled, the traces are printed to stdout " +
+		"and not sent to AppOptics. It's for debugging only.")
+
+	// add default setting, the same as the UDP reporter's, which the local
+	// sampling config is merged into
+	updateSetting(int32(TYPE_DEFAULT), "",
+		[]byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		1000000, 120, argsToMap(16, 8, -1, -1))
+
+	return &prettyReporter{out: out, pending: make(map[string]*recordedTrace)}
+}
+
+func prettyNewReporter() reporter {
+	return newPrettyReporter(os.Stdout)
+}
+
+func (r *prettyReporter) reportEvent(ctx *oboeContext, e *event) error {
+	if err := prepareEvent(ctx, e); err != nil {
+		return err
+	}
+	taskID := string(e.metadata.ids.taskID)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	t, ok := r.pending[taskID]
+	if !ok {
+		if len(r.pending) >= prettyPendingMax {
+			r.evictOldest()
+		}
+		t = &recordedTrace{taskID: taskID}
+		r.pending[taskID] = t
+		r.order = append(r.order, taskID)
+	}
+	t.events = append(t.events, e.bbuf.GetBuf())
+
+	switch e.label {
+	case LabelEntry, LabelProfileEntry:
+		t.depth++
+	case LabelExit, LabelProfileExit:
+		t.depth--
+		if t.depth <= 0 {
+			r.remove(taskID)
+			_, err := io.WriteString(r.out, formatTraceTree(t.events))
+			return err
+		}
+	}
+	return nil
+}
+
+// evictOldest drops the events of the unfinished trace started the earliest,
+// which may never finish, e.g., its exit events are lost.
+func (r *prettyReporter) evictOldest() {
+	taskID := r.order[0]
+	log.Debugf("Pretty reporter: evicted the unfinished trace %X", taskID)
+	r.remove(taskID)
+}
+
+// remove drops the unfinished trace.
+func (r *prettyReporter) remove(taskID string) {
+	delete(r.pending, taskID)
+	for i, id := range r.order {
+		if id == taskID {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func (r *prettyReporter) reportStatus(ctx *oboeContext, e *event) error { return nil }
+func (r *prettyReporter) reportSpan(span SpanMessage) error             { return nil }
+func (r *prettyReporter) Shutdown(ctx context.Context) error            { return nil }
+func (r *prettyReporter) ShutdownNow() error                            { return nil }
+func (r *prettyReporter) Closed() bool                                  { return false }
+func (r *prettyReporter) WaitForReady(ctx context.Context) bool         { return true }
+
+// prettySpan is a span rebuilt from the events of a trace.
+type prettySpan struct {
+	name     string
+	start    int64 // in microseconds
+	end      int64
+	exited   bool
+	kvs      map[string]interface{}
+	notes    []string // the info and error events
+	children []*prettySpan
+}
+
+// formatTraceTree renders the events of a finished trace as a tree. The span
+// of an event is found by its edges, which point to the previous event of the
+// same span, or of the parent span for an entry event.
+func formatTraceTree(events [][]byte) string {
+	var roots []*prettySpan
+	var traceID string
+	var started int64                     // the earliest timestamp of the events in microseconds
+	spans := make(map[string]*prettySpan) // keyed by the op IDs of the events
+
+	for _, buf := range events {
+		m := bson.M{}
+		if err := bson.Unmarshal(buf, m); err != nil {
+			log.Debugf("Pretty reporter: failed to decode event: %v", err)
+			continue
+		}
+		xtrace, _ := m["X-Trace"].(string)
+		if len(xtrace) != oboeMetadataStringLen {
+			continue
+		}
+		if traceID == "" {
+			traceID = xtrace[2:42]
+		}
+		opID := xtrace[42:58]
+		layer, _ := m["Layer"].(string)
+		ts, _ := m["Timestamp_u"].(int64)
+		if started == 0 || (ts != 0 && ts < started) {
+			started = ts
+		}
+		edges := eventEdges(m)
+
+		switch label, _ := m["Label"].(string); label {
+		case LabelEntry, LabelProfileEntry:
+			s := &prettySpan{name: layer, start: ts, kvs: make(map[string]interface{})}
+			if layer == "" {
+				s.name, _ = m["ProfileName"].(string)
+			}
+			addPrettyKVs(s.kvs, m)
+			if parent := findSpan(spans, edges, ""); parent != nil {
+				parent.children = append(parent.children, s)
+			} else {
+				roots = append(roots, s)
+			}
+			spans[opID] = s
+		case LabelExit, LabelProfileExit:
+			if s := findSpan(spans, edges, layer); s != nil {
+				s.end, s.exited = ts, true
+				addPrettyKVs(s.kvs, m)
+				spans[opID] = s
+			}
+		default:
+			if s := findSpan(spans, edges, layer); s != nil {
+				kvs := make(map[string]interface{})
+				addPrettyKVs(kvs, m)
+				s.notes = append(s.notes, strings.TrimSpace(label+" "+formatKVs(kvs)))
+				spans[opID] = s
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Trace %s started at %s\n", traceID, formatTimestamp(started))
+	for _, s := range roots {
+		writeSpan(&b, s, 1)
+	}
+	return b.String()
+}
+
+// formatTimestamp renders the timestamp in microseconds with the layout and in
+// the time zone configured for the pretty reporter.
+func formatTimestamp(us int64) string {
+	layout, loc := config.GetPrettyTimeFormat()
+	return time.Unix(0, us*int64(time.Microsecond)).In(loc).Format(layout)
+}
+
+// eventEdges returns the op IDs of the edges of the event.
+func eventEdges(m bson.M) []string {
+	switch edge := m[EdgeKey].(type) {
+	case string:
+		return []string{edge}
+	case []interface{}:
+		var edges []string
+		for _, e := range edge {
+			if s, ok := e.(string); ok {
+				edges = append(edges, s)
+			}
+		}
+		return edges
+	}
+	return nil
+}
+
+// findSpan returns the span an edge points to. An open span of the layer is
+// preferred if the layer is not empty.
+func findSpan(spans map[string]*prettySpan, edges []string, layer string) *prettySpan {
+	var found *prettySpan
+	for _, edge := range edges {
+		s, ok := spans[edge]
+		if !ok {
+			continue
+		}
+		if layer == "" || (s.name == layer && !s.exited) {
+			return s
+		}
+		if found == nil {
+			found = s
+		}
+	}
+	return found
+}
+
+func addPrettyKVs(kvs map[string]interface{}, m bson.M) {
+	for k, v := range m {
+		if !prettyHiddenKeys[k] {
+			kvs[k] = v
+		}
+	}
+}
+
+// formatKVs renders the KVs sorted by the keys, the long values are truncated.
+func formatKVs(kvs map[string]interface{}) string {
+	keys := make([]string, 0, len(kvs))
+	for k := range kvs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := fmt.Sprint(kvs[k])
+		if len(v) > prettyValueLenMax {
+			v = v[:prettyValueLenMax] + "..."
+		}
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, " ")
+}
+
+func writeSpan(b *bytes.Buffer, s *prettySpan, depth int) {
+	indent := strings.Repeat("  ", depth)
+	duration := "unfinished"
+	if s.exited {
+		duration = (time.Duration(s.end-s.start) * time.Microsecond).String()
+	}
+	line := fmt.Sprintf("%s%s (%s)", indent, s.name, duration)
+	if kvs := formatKVs(s.kvs); kvs != "" {
+		line += " " + kvs
+	}
+	b.WriteString(line + "\n")
+	for _, note := range s.notes {
+		b.WriteString(indent + "  ! " + note + "\n")
+	}
+	for _, c := range s.children {
+		writeSpan(b, c, depth+1)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrettyReporter(t *testing.T) {
+	var out bytes.Buffer
+	oldReporter := globalReporter
+	globalReporter = newPrettyReporter(&out)
+	defer func() { globalReporter = oldReporter }()
+
+	ctx, ok := NewContext("root", "", true, func() map[string]interface{} {
+		return map[string]interface{}{"URL": "/orders"}
+	})
+	require.True(t, ok)
+	child := ctx.Copy()
+	assert.NoError(t, child.ReportEvent(LabelEntry, "child", "Query", "SELECT 1"))
+	grandchild := child.Copy()
+	assert.NoError(t, grandchild.ReportEvent(LabelEntry, "grandchild"))
+	assert.NoError(t, grandchild.ReportEvent(LabelExit, "grandchild"))
+	assert.NoError(t, child.ReportEvent(LabelError, "child", "ErrorClass", "error", "ErrorMsg", "failed"))
+	assert.NoError(t, child.ReportEvent(LabelExit, "child"))
+	// nothing is printed until the trace finishes
+	assert.Empty(t, out.String())
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "root", "Status", 200))
+
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 5, out.String())
+	assert.Regexp(t, regexp.MustCompile(`^Trace [0-9A-F]{40} started at \S+$`), lines[0])
+	assert.Regexp(t, regexp.MustCompile(`^  root \([0-9.]+[µm]?s\) Go.GOMAXPROCS=\d+ Go.Version=\S+ SampleRate=1000000 SampleSource=\d+ Status=200 URL=/orders$`), lines[1])
+	assert.Regexp(t, regexp.MustCompile(`^    child \([0-9.]+[µm]?s\) Query=SELECT 1$`), lines[2])
+	assert.Equal(t, "      ! error ErrorClass=error ErrorMsg=failed", lines[3])
+	assert.Regexp(t, regexp.MustCompile(`^      grandchild \([0-9.]+[µm]?s\)$`), lines[4])
+}
+
+func TestPrettyReporterEviction(t *testing.T) {
+	var out bytes.Buffer
+	r := newPrettyReporter(&out).(*prettyReporter)
+	oldReporter := globalReporter
+	globalReporter = r
+	defer func() { globalReporter = oldReporter }()
+	// not limited by the token bucket
+	updateSetting(int32(TYPE_DEFAULT), "", []byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
+		1000000, 120, argsToMap(1000000, 1000000, -1, -1))
+
+	var ctxs []Context
+	for i := 0; i <= prettyPendingMax; i++ {
+		ctx, ok := NewContext("root", "", true, nil)
+		require.True(t, ok)
+		ctxs = append(ctxs, ctx)
+	}
+	// the oldest unfinished trace is evicted
+	assert.Equal(t, prettyPendingMax, len(r.pending))
+	assert.Equal(t, prettyPendingMax, len(r.order))
+	_, ok := r.pending[string(ctxs[0].(*oboeContext).metadata.ids.taskID)]
+	assert.False(t, ok)
+
+	// the newest one is still printed when it finishes
+	assert.NoError(t, ctxs[prettyPendingMax].ReportEvent(LabelExit, "root"))
+	assert.Contains(t, out.String(), "  root (")
+	assert.Equal(t, prettyPendingMax-1, len(r.pending))
+	assert.Equal(t, prettyPendingMax-1, len(r.order))
+}
+
+func TestPrettyReporterTruncation(t *testing.T) {
+	kvs := map[string]interface{}{"B": strings.Repeat("v", 100), "A": 1}
+	assert.Equal(t, "A=1 B="+strings.Repeat("v", prettyValueLenMax)+"...", formatKVs(kvs))
+}
+
+func TestPrettyReporterTimestamp(t *testing.T) {
+	ts := time.Date(2019, 6, 1, 12, 0, 0, 500000000, time.UTC).UnixNano() / int64(time.Microsecond)
+
+	require.NoError(t, os.Setenv("APPOPTICS_PRETTY_TIME_ZONE", "Asia/Tokyo"))
+	require.NoError(t, os.Setenv("APPOPTICS_PRETTY_TIME_FORMAT", "2006-01-02 15:04:05.000 MST"))
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_PRETTY_TIME_ZONE")
+		os.Unsetenv("APPOPTICS_PRETTY_TIME_FORMAT")
+		config.Load()
+	}()
+	assert.Equal(t, "2019-06-01 21:00:00.500 JST", formatTimestamp(ts))
+
+	// the default layout is used if only the time zone is set
+	os.Unsetenv("APPOPTICS_PRETTY_TIME_FORMAT")
+	require.NoError(t, os.Setenv("APPOPTICS_PRETTY_TIME_ZONE", "America/New_York"))
+	config.Load()
+	assert.Equal(t, "2019-06-01T08:00:00.5-04:00", formatTimestamp(ts))
+
+	// the local time zone is used for an invalid one
+	require.NoError(t, os.Setenv("APPOPTICS_PRETTY_TIME_ZONE", "Mars/Olympus_Mons"))
+	config.Load()
+	assert.Equal(t, time.Unix(0, ts*int64(time.Microsecond)).In(time.Local).Format(time.RFC3339Nano), formatTimestamp(ts))
+}
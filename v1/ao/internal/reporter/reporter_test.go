@@ -10,6 +10,9 @@ import (
 	"net"
 	"os"
 	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -194,6 +197,33 @@ func TestUDPReporter(t *testing.T) {
 	assert.NoError(t, r.reportStatus(ctx, ev2))
 }
 
+func TestUDPSendBuffer(t *testing.T) {
+	addr, err := net.ResolveUDPAddr("udp4", udpAddrDefault)
+	require.NoError(t, err)
+	conn, err := net.DialUDP("udp4", nil, addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var buf utils.SafeBuffer
+	log.SetOutput(&buf)
+	aolog.SetLevel(aolog.INFO)
+	defer func() {
+		log.SetOutput(os.Stderr)
+		aolog.SetLevel(aolog.WARNING)
+	}()
+
+	setUDPSendBuffer(conn, 65536)
+	assert.Contains(t, buf.String(), "UDP send buffer size requested: 65536 bytes")
+	if runtime.GOOS != "linux" {
+		return
+	}
+	// Linux doubles the size requested
+	size, err := udpSendBufferSize(conn)
+	require.NoError(t, err)
+	assert.True(t, size >= 65536, "size: %d", size)
+	assert.Contains(t, buf.String(), fmt.Sprintf("granted: %d bytes", size))
+}
+
 // ========================= GRPC Reporter =============================
 
 func assertSSLMode(t *testing.T) {
@@ -413,9 +443,38 @@ func TestDefaultBackoff(t *testing.T) {
 	assert.NotNil(t, DefaultBackoff(grpcMaxRetries+1, func(d time.Duration) {}))
 }
 
+func TestDialTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+
+	conn, err := dialTCP(context.Background(), ln.Addr().String(), time.Second, 0)
+	require.NoError(t, err)
+	conn.Close()
+
+	conn, err = dialTCP(context.Background(), ln.Addr().String(), time.Second, grpcKeepAliveDefault)
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestGrpcConnectionDialOptions(t *testing.T) {
+	c, err := newGrpcConnection("test", "localhost:4567", WithDialer(&NoopDialer{}),
+		WithDialTimeout(time.Second), WithKeepAlive(0))
+	require.NoError(t, err)
+	assert.Equal(t, time.Second, c.dialTimeout)
+	assert.Equal(t, time.Duration(0), c.keepAlive)
+
+	c, err = newGrpcConnection("test", "localhost:4567", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+	assert.Equal(t, grpcDialTimeoutDefault, c.dialTimeout)
+	assert.Equal(t, grpcKeepAliveDefault, c.keepAlive)
+	assert.Equal(t, grpcCtxTimeout, c.sendTimeout)
+	assert.Equal(t, -1, c.sendTimeoutRetries)
+}
+
 type NoopDialer struct{}
 
-func (d *NoopDialer) Dial(c grpcConnection) (*grpc.ClientConn, error) {
+func (d *NoopDialer) Dial(c *grpcConnection) (*grpc.ClientConn, error) {
 	return nil, nil
 }
 
@@ -570,3 +629,407 @@ func TestInitReporter(t *testing.T) {
 	initReporter()
 	require.IsType(t, &grpcReporter{}, globalReporter)
 }
+
+func TestInvokeRPCRetryClassification(t *testing.T) {
+	retries := 0
+	c, err := newGrpcConnection("events channel", "test-addr", WithDialer(&NoopDialer{}),
+		WithBackoff(func(r int, wait func(d time.Duration)) error {
+			retries = r
+			if r > grpcMaxRetries {
+				return errGiveUpAfterRetries
+			}
+			return nil
+		}))
+	require.NoError(t, err)
+	exit := make(chan struct{})
+
+	newMethod := func(errs ...error) *mocks.Method {
+		m := &mocks.Method{}
+		m.On("String").Return("mock")
+		m.On("Message").Return(nil)
+		m.On("MessageLen").Return(int64(0))
+		m.On("CallSummary").Return("summary")
+		m.On("RetryOnErr", mock.Anything, mock.Anything).Return(true)
+		m.On("ResultCode", mock.Anything, mock.Anything).Return(pb.ResultCode_OK, nil)
+		calls := 0
+		m.On("Call", mock.Anything, mock.Anything).
+			Return(func(ctx context.Context, c pb.TraceCollectorClient) error {
+				calls++
+				if calls <= len(errs) {
+					return errs[calls-1]
+				}
+				return nil
+			})
+		return m
+	}
+
+	// authentication errors are not retried (the HTTP 401 equivalent), but
+	// they don't close the reporter either
+	m := newMethod(status.Error(codes.Unauthenticated, "bad key"))
+	assert.Equal(t, errFatalRPC, c.InvokeRPC(exit, m))
+	m.AssertNumberOfCalls(t, "Call", 1)
+	assert.Equal(t, 0, retries)
+
+	// so are validation errors (the HTTP 400 equivalent)
+	m = newMethod(status.Error(codes.InvalidArgument, "bad payload"))
+	assert.Equal(t, errFatalRPC, c.InvokeRPC(exit, m))
+	m.AssertNumberOfCalls(t, "Call", 1)
+	assert.Equal(t, 0, retries)
+
+	// the unavailability of the collector is retried (the HTTP 503 equivalent)
+	m = newMethod(status.Error(codes.Unavailable, "unavailable"),
+		status.Error(codes.Unavailable, "unavailable"))
+	assert.NoError(t, c.InvokeRPC(exit, m))
+	m.AssertNumberOfCalls(t, "Call", 3)
+	assert.Equal(t, 2, retries)
+}
+
+func TestInvokeRPCSendTimeout(t *testing.T) {
+	c, err := newGrpcConnection("events channel", "test-addr", WithDialer(&NoopDialer{}),
+		WithSendTimeout(20*time.Millisecond), WithSendTimeoutRetries(1),
+		WithBackoff(func(r int, wait func(d time.Duration)) error { return nil }))
+	require.NoError(t, err)
+	exit := make(chan struct{})
+
+	// the collector responds slower than the send timeout for the first slow
+	// calls, then responds immediately
+	newMethod := func(slow int) *mocks.Method {
+		m := &mocks.Method{}
+		m.On("String").Return("mock")
+		m.On("Message").Return(nil)
+		m.On("MessageLen").Return(int64(3))
+		m.On("CallSummary").Return("summary")
+		m.On("RetryOnErr", mock.Anything, mock.Anything).Return(true)
+		m.On("ResultCode", mock.Anything, mock.Anything).Return(pb.ResultCode_OK, nil)
+		calls := 0
+		m.On("Call", mock.Anything, mock.Anything).
+			Return(func(ctx context.Context, c pb.TraceCollectorClient) error {
+				calls++
+				if calls > slow {
+					return nil
+				}
+				select {
+				case <-ctx.Done():
+					return status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+				case <-time.After(time.Second):
+					return nil
+				}
+			})
+		return m
+	}
+
+	// a timed out send is retried
+	m := newMethod(1)
+	assert.NoError(t, c.InvokeRPC(exit, m))
+	m.AssertNumberOfCalls(t, "Call", 2)
+
+	// and dropped once the retries are exhausted, instead of stalling
+	start := time.Now()
+	m = newMethod(10)
+	assert.Equal(t, errSendTimeout, c.InvokeRPC(exit, m))
+	m.AssertNumberOfCalls(t, "Call", 2)
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, int64(3), c.queueStats.copyAndReset().numFailed)
+
+	// the timed out sends are retried like the other failures by default
+	c.sendTimeoutRetries = -1
+	m = newMethod(3)
+	assert.NoError(t, c.InvokeRPC(exit, m))
+	m.AssertNumberOfCalls(t, "Call", 4)
+}
+
+func TestClassifyRPCError(t *testing.T) {
+	assert.Equal(t, errFatalRPC, classifyRPCError(status.Error(codes.PermissionDenied, "")))
+	assert.Equal(t, errFatalRPC, classifyRPCError(status.Error(codes.Unauthenticated, "")))
+	assert.Equal(t, errFatalRPC, classifyRPCError(status.Error(codes.Unimplemented, "")))
+	assert.Nil(t, classifyRPCError(status.Error(codes.ResourceExhausted, "")))
+	assert.Nil(t, classifyRPCError(status.Error(codes.Internal, "")))
+	assert.Nil(t, classifyRPCError(errConnStale))
+}
+
+func TestFlushMetrics(t *testing.T) {
+	ec, err := newGrpcConnection("events channel", "test-addr", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+	mc, err := newGrpcConnection("metrics channel", "test-addr", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+
+	var sent [][]byte
+	client := &mocks.TraceCollectorClient{}
+	client.On("PostMetrics", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			sent = append(sent, args.Get(1).(*pb.MessageRequest).Messages...)
+		}).
+		Return(&pb.MessageResult{Result: pb.ResultCode_OK}, nil)
+	mc.client = client
+
+	r := &grpcReporter{
+		eventConnection:       ec,
+		metricConnection:      mc,
+		collectMetricInterval: grpcMetricIntervalDefault,
+		done:                  make(chan struct{}),
+	}
+
+	// record a metric and flush it without waiting for the next interval
+	(&HTTPSpanMessage{
+		BaseSpanMessage: BaseSpanMessage{Duration: time.Millisecond},
+		Transaction:     "flush-metrics-test",
+		Status:          200,
+		Method:          "GET",
+	}).process()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, r.FlushMetrics(ctx))
+	client.AssertNumberOfCalls(t, "PostMetrics", 1)
+	require.Len(t, sent, 1)
+	assert.Contains(t, string(sent[0]), "flush-metrics-test")
+
+	// the interval reported is scaled to the time elapsed since the last flush
+	require.NoError(t, r.FlushMetrics(ctx))
+	require.Len(t, sent, 2)
+	m := bson.M{}
+	require.NoError(t, bson.Unmarshal(sent[1], m))
+	assert.Equal(t, 1, m["MetricsFlushInterval"])
+
+	close(r.done)
+	assert.Equal(t, ErrReporterIsClosed, r.FlushMetrics(ctx))
+}
+
+func TestFlushEvents(t *testing.T) {
+	ec, err := newGrpcConnection("events channel", "test-addr", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+	mc, err := newGrpcConnection("metrics channel", "test-addr", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+
+	var posted int64
+	client := &mocks.TraceCollectorClient{}
+	client.On("PostEvents", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			atomic.AddInt64(&posted, int64(len(args.Get(1).(*pb.MessageRequest).Messages)))
+		}).
+		Return(&pb.MessageResult{Result: pb.ResultCode_OK}, nil)
+	ec.client = client
+
+	r := &grpcReporter{
+		eventConnection:  ec,
+		eventConnections: []*grpcConnection{ec},
+		metricConnection: mc,
+		serviceKey:       serviceKey,
+		eventMessages:    make(chan []byte, 10),
+		eventFlushes:     make(chan chan struct{}),
+		done:             make(chan struct{}),
+		drops:            newEventDrops(dropsReportIntervalDefault),
+	}
+
+	// the events are not sent until the flush
+	interval := config.ReporterOpts().GetEventFlushInterval()
+	config.ReporterOpts().SetEventFlushInterval(60)
+	defer config.ReporterOpts().SetEventFlushInterval(interval)
+	go r.eventSender()
+	for i := 0; i < 3; i++ {
+		r.eventMessages <- []byte("event")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, r.FlushEvents(ctx))
+	assert.Equal(t, int64(3), atomic.LoadInt64(&posted))
+
+	close(r.done)
+	assert.Equal(t, ErrReporterIsClosed, r.FlushEvents(ctx))
+}
+
+func TestEventConnectionPool(t *testing.T) {
+	conns, err := newEventConnections("test-addr", 3, WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+	require.Len(t, conns, 3)
+	mc, err := newGrpcConnection("metrics channel", "test-addr", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+
+	posted := make([]int64, len(conns))
+	for i, c := range conns {
+		// the pool shares the queue stats
+		assert.True(t, c.queueStats == conns[0].queueStats)
+		i := i
+		client := &mocks.TraceCollectorClient{}
+		client.On("PostEvents", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Run(func(args mock.Arguments) { atomic.AddInt64(&posted[i], 1) }).
+			Return(&pb.MessageResult{Result: pb.ResultCode_OK}, nil)
+		c.client = client
+	}
+
+	r := &grpcReporter{
+		eventConnection:  conns[0],
+		eventConnections: conns,
+		metricConnection: mc,
+		serviceKey:       serviceKey,
+		eventMessages:    make(chan []byte, 10),
+		done:             make(chan struct{}),
+		drops:            newEventDrops(dropsReportIntervalDefault),
+	}
+
+	// every event fills a batch by itself
+	batchSize := config.ReporterOpts().GetEventFlushBatchSize()
+	config.ReporterOpts().SetEventFlushBatchSize(1)
+	defer config.ReporterOpts().SetEventFlushBatchSize(batchSize)
+	for i := 0; i < 6; i++ {
+		r.eventMessages <- make([]byte, 2048)
+	}
+	go r.eventSender()
+
+	// the batches are distributed across the pooled connections
+	assert.Eventually(t, func() bool {
+		for i := range posted {
+			if atomic.LoadInt64(&posted[i]) != 2 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+
+	close(r.done)
+	r.closeConns()
+	for _, c := range conns {
+		assert.Nil(t, c.connection)
+	}
+}
+
+func TestDispatchBatch(t *testing.T) {
+	batches := []chan eventBatch{make(chan eventBatch, 1), make(chan eventBatch, 1), make(chan eventBatch, 1)}
+	assert.Equal(t, 1, dispatchBatch(batches, 1, eventBatch{}))
+	// the busy sender is skipped
+	assert.Equal(t, 2, dispatchBatch(batches, 1, eventBatch{}))
+	assert.Equal(t, 0, dispatchBatch(batches, 1, eventBatch{}))
+
+	// it waits for the sender next if all of them are busy
+	go func() { <-batches[2] }()
+	assert.Equal(t, 2, dispatchBatch(batches, 2, eventBatch{}))
+}
+
+func TestEventTenantRouting(t *testing.T) {
+	tenantKey := "bf49315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:tenant"
+	ec, err := newGrpcConnection("events channel", "test-addr", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+	mc, err := newGrpcConnection("metrics channel", "test-addr", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	posted := make(map[string]int)
+	client := &mocks.TraceCollectorClient{}
+	client.On("PostEvents", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			req := args.Get(1).(*pb.MessageRequest)
+			mu.Lock()
+			posted[req.ApiKey] += len(req.Messages)
+			mu.Unlock()
+		}).
+		Return(&pb.MessageResult{Result: pb.ResultCode_OK}, nil)
+	ec.client = client
+
+	r := &grpcReporter{
+		eventConnection:  ec,
+		metricConnection: mc,
+		serviceKey:       serviceKey,
+		eventMessages:    make(chan []byte, 10),
+		tenantQueues: map[string]*eventQueue{
+			"tenant": {serviceKey: tenantKey, messages: make(chan []byte, 10)},
+		},
+		done:  make(chan struct{}),
+		drops: newEventDrops(dropsReportIntervalDefault),
+	}
+
+	report := func(tenant string, n int) {
+		ctx := newContext(true).(*oboeContext)
+		ctx.txCtx.tenant = tenant
+		for i := 0; i < n; i++ {
+			e, err := ctx.newEvent(LabelInfo, "layer")
+			require.NoError(t, err)
+			require.NoError(t, r.reportEvent(ctx, e))
+		}
+	}
+	report("", 2)
+	report("tenant", 3)
+	// the traces of the unknown tenants are not reported
+	ctx := newContext(true).(*oboeContext)
+	ctx.txCtx.tenant = "unknown"
+	e, err := ctx.newEvent(LabelInfo, "layer")
+	require.NoError(t, err)
+	assert.Error(t, r.reportEvent(ctx, e))
+
+	go r.eventSender()
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return posted[serviceKey] == 2 && posted[tenantKey] == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, posted, 2)
+
+	// the tenant queues are rebuilt when the config is reloaded
+	tenantKey2 := "cf49315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:tenant2"
+	os.Setenv("APPOPTICS_TENANT_SERVICE_KEYS", "tenant2="+tenantKey2)
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_TENANT_SERVICE_KEYS")
+		config.Load()
+	}()
+	r.reloadTenantQueues()
+	report("tenant2", 4)
+	ctx = newContext(true).(*oboeContext)
+	ctx.txCtx.tenant = "tenant"
+	e, err = ctx.newEvent(LabelInfo, "layer")
+	require.NoError(t, err)
+	assert.Error(t, r.reportEvent(ctx, e))
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return posted[tenantKey2] == 4
+	}, 5*time.Second, 10*time.Millisecond)
+
+	close(r.done)
+	r.closeConns()
+}
+
+func TestEventBatchSender(t *testing.T) {
+	ec, err := newGrpcConnection("events channel", "test-addr", WithDialer(&NoopDialer{}))
+	require.NoError(t, err)
+	var posted int64
+	client := &mocks.TraceCollectorClient{}
+	client.On("PostEvents", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			atomic.AddInt64(&posted, int64(len(args.Get(1).(*pb.MessageRequest).Messages)))
+		}).
+		Return(&pb.MessageResult{Result: pb.ResultCode_OK}, nil)
+	ec.client = client
+
+	r := &grpcReporter{
+		eventConnection: ec,
+		serviceKey:      serviceKey,
+		done:            make(chan struct{}),
+		drops:           newEventDrops(dropsReportIntervalDefault),
+	}
+	batches := make(chan eventBatch, 10)
+	exited := make(chan struct{})
+	go func() {
+		r.eventBatchSender(ec, batches)
+		close(exited)
+	}()
+
+	// an empty batch doesn't stop the sender
+	batches <- eventBatch{serviceKey: serviceKey}
+	batches <- eventBatch{serviceKey: serviceKey, messages: [][]byte{{1}}}
+	assert.Eventually(t, func() bool { return atomic.LoadInt64(&posted) == 1 },
+		5*time.Second, 10*time.Millisecond)
+
+	// the last batches are sent until the channel is closed if the reporter is
+	// closed gracefully
+	r.setGracefully(true)
+	close(r.done)
+	batches <- eventBatch{serviceKey: serviceKey, messages: [][]byte{{2}, {3}}}
+	close(batches)
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the sender doesn't exit")
+	}
+	assert.Equal(t, int64(3), atomic.LoadInt64(&posted))
+}
@@ -34,8 +34,10 @@ func udpNewReporter() reporter {
 	}
 	if err != nil {
 		log.Errorf("AppOptics failed to initialize UDP reporter: %v", err)
+		initErr = err
 		return &nullReporter{}
 	}
+	setUDPSendBuffer(conn, config.GetUDPSendBuffer())
 
 	// add default setting
 	updateSetting(int32(TYPE_DEFAULT), "",
@@ -45,6 +47,25 @@ func udpNewReporter() reporter {
 	return &udpReporter{conn: conn}
 }
 
+// setUDPSendBuffer requests the send buffer size of the socket, if it's not 0,
+// and logs the size actually granted by the OS, which may be capped, e.g., by
+// net.core.wmem_max on Linux, or doubled for the bookkeeping overhead.
+func setUDPSendBuffer(conn *net.UDPConn, size int) {
+	if size <= 0 {
+		return
+	}
+	if err := conn.SetWriteBuffer(size); err != nil {
+		log.Warningf("AppOptics failed to set the UDP send buffer size to %d: %v", size, err)
+		return
+	}
+	granted, err := udpSendBufferSize(conn)
+	if err != nil {
+		log.Infof("AppOptics UDP send buffer size requested: %d bytes", size)
+		return
+	}
+	log.Infof("AppOptics UDP send buffer size requested: %d bytes, granted: %d bytes", size, granted)
+}
+
 func (r *udpReporter) report(ctx *oboeContext, e *event) error {
 	if err := prepareEvent(ctx, e); err != nil {
 		// don't continue if preparation failed
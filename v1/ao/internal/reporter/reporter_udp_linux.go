@@ -0,0 +1,27 @@
+// +build linux
+
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"net"
+	"syscall"
+)
+
+// udpSendBufferSize returns the send buffer size (SO_SNDBUF) of the socket
+// granted by the OS, which may differ from the one requested.
+func udpSendBufferSize(conn *net.UDPConn) (int, error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var size int
+	var sockErr error
+	if err := rc.Control(func(fd uintptr) {
+		size, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	}); err != nil {
+		return 0, err
+	}
+	return size, sockErr
+}
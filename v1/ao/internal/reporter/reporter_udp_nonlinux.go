@@ -0,0 +1,17 @@
+// +build !linux
+
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// udpSendBufferSize returns the send buffer size of the socket granted by the
+// OS, which is not supported on this platform.
+func udpSendBufferSize(conn *net.UDPConn) (int, error) {
+	return 0, errors.New("not supported")
+}
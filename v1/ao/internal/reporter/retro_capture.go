@@ -0,0 +1,130 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+)
+
+// the maximum size in bytes of the events captured for retroactive sending
+const retroCaptureMaxBytes = 4 * 1024 * 1024
+
+// capturedTrace is an unsampled trace which has ended, whose events are
+// retained for retroactive sending.
+type capturedTrace struct {
+	ctx    *oboeContext
+	events []*event
+	size   int
+	ended  time.Time
+}
+
+// retroCapture retains the unsampled traces ended within the capture window
+// (APPOPTICS_RETROACTIVE_CAPTURE_WINDOW), so that they can be sent once the
+// sample rate is raised or an error is reported. The memory footprint is
+// bounded by retroCaptureMaxBytes, the oldest traces are evicted first.
+//
+// It is safe for concurrent use.
+type retroCapture struct {
+	sync.Mutex
+	// the oldest one comes first
+	traces []*capturedTrace
+	bytes  int
+
+	// the flushes in the background are coalesced into one flusher at a time
+	flushing       int32
+	flushRequested int32
+}
+
+var globalRetroCapture = &retroCapture{}
+
+// retroCaptureEnabled returns if the unsampled traces are captured.
+func retroCaptureEnabled() bool {
+	return config.GetRetroactiveCaptureWindow() > 0
+}
+
+// add captures the events of an ended trace, which must be prepared already.
+func (rc *retroCapture) add(ctx *oboeContext, events []*event, size int) {
+	if size > retroCaptureMaxBytes {
+		log.Debugf("Retroactive capture: trace dropped as it's larger than %d bytes.", retroCaptureMaxBytes)
+		return
+	}
+	now := time.Now()
+
+	rc.Lock()
+	defer rc.Unlock()
+	rc.traces = append(rc.traces, &capturedTrace{ctx: ctx, events: events, size: size, ended: now})
+	rc.bytes += size
+	rc.evict(now)
+}
+
+// evict drops the traces which are out of the capture window, and the oldest
+// ones until the capture is within its memory limit.
+func (rc *retroCapture) evict(now time.Time) {
+	window := config.GetRetroactiveCaptureWindow()
+	for len(rc.traces) > 0 &&
+		(rc.bytes > retroCaptureMaxBytes || now.Sub(rc.traces[0].ended) > window) {
+		rc.bytes -= rc.traces[0].size
+		rc.traces[0] = nil
+		rc.traces = rc.traces[1:]
+	}
+}
+
+// flush sends the traces captured within the capture window with the reporter
+// and clears the capture. It returns the number of traces sent.
+func (rc *retroCapture) flush(r reporter) int {
+	rc.Lock()
+	rc.evict(time.Now())
+	traces := rc.traces
+	rc.traces = nil
+	rc.bytes = 0
+	rc.Unlock()
+
+	for _, t := range traces {
+		for _, e := range t.events {
+			// the events are prepared already, so the context is left untouched.
+			if err := r.reportEvent(t.ctx, e); err != nil {
+				log.Debugf("Retroactive capture: failed to send the event: %v", err)
+			}
+		}
+	}
+	if len(traces) > 0 {
+		log.Infof("Retroactive capture: sent %d unsampled traces.", len(traces))
+	}
+	return len(traces)
+}
+
+// flushInBackground flushes the capture in the background. The requests made
+// while a flush is in progress are coalesced into one more flush.
+func (rc *retroCapture) flushInBackground(r reporter) {
+	atomic.StoreInt32(&rc.flushRequested, 1)
+	if !atomic.CompareAndSwapInt32(&rc.flushing, 0, 1) {
+		return
+	}
+	go func() {
+		for {
+			for atomic.SwapInt32(&rc.flushRequested, 0) == 1 {
+				rc.flush(r)
+			}
+			atomic.StoreInt32(&rc.flushing, 0)
+			// a request may have been made before the flag is cleared
+			if atomic.LoadInt32(&rc.flushRequested) == 0 ||
+				!atomic.CompareAndSwapInt32(&rc.flushing, 0, 1) {
+				return
+			}
+		}
+	}()
+}
+
+// FlushRetroactiveTraces sends the unsampled traces captured within the
+// capture window, if it's enabled, and returns the number of traces sent.
+func FlushRetroactiveTraces() int {
+	if !retroCaptureEnabled() {
+		return 0
+	}
+	return globalRetroCapture.flush(globalReporter)
+}
@@ -0,0 +1,147 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setRetroactiveCapture(t *testing.T) func() {
+	require.NoError(t, os.Setenv("APPOPTICS_RETROACTIVE_CAPTURE_WINDOW", "10"))
+	config.Load()
+	globalRetroCapture = &retroCapture{}
+	return func() {
+		os.Unsetenv("APPOPTICS_RETROACTIVE_CAPTURE_WINDOW")
+		config.Load()
+		globalRetroCapture = &retroCapture{}
+	}
+}
+
+func TestRetroactiveCaptureFlush(t *testing.T) {
+	defer setRetroactiveCapture(t)()
+	r := SetTestReporter(TestReporterSampleRate(0))
+
+	ctx, ok := NewContext("retro", "", true, nil)
+	require.True(t, ok)
+	assert.NoError(t, ctx.ReportEvent(LabelInfo, "retro", "K", "V"))
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "retro"))
+	assert.Empty(t, r.EventBufs)
+	assert.Zero(t, atomic.LoadInt64(&traceBufferBytes))
+
+	assert.Equal(t, 1, FlushRetroactiveTraces())
+	// nothing is sent twice
+	assert.Equal(t, 0, FlushRetroactiveTraces())
+
+	r.Close(3)
+	g.AssertGraph(t, r.EventBufs, 3, g.AssertNodeMap{
+		{"retro", "entry"}: {},
+		{"retro", "info"}:  {Edges: g.Edges{{"retro", "entry"}}},
+		{"retro", "exit"}:  {Edges: g.Edges{{"retro", "info"}}},
+	})
+}
+
+func TestRetroactiveCaptureFlushOnError(t *testing.T) {
+	defer setRetroactiveCapture(t)()
+	require.NoError(t, os.Setenv("APPOPTICS_KEEP_ERRORS", "true"))
+	config.Load()
+	defer os.Unsetenv("APPOPTICS_KEEP_ERRORS")
+	r := SetTestReporter(TestReporterSampleRate(0))
+
+	ctx, ok := NewContext("retro", "", true, nil)
+	require.True(t, ok)
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "retro"))
+
+	// an error reported by another trace sends the captured one, and the
+	// failing trace itself
+	ctx, ok = NewContext("failing", "", true, nil)
+	require.True(t, ok)
+	assert.NoError(t, ctx.ReportEvent(LabelError, "failing", "ErrorClass", "error"))
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "failing"))
+
+	r.Close(5)
+	g.AssertGraph(t, r.EventBufs, 5, g.AssertNodeMap{
+		{"retro", "entry"}:   {},
+		{"retro", "exit"}:    {Edges: g.Edges{{"retro", "entry"}}},
+		{"failing", "entry"}: {},
+		{"failing", "error"}: {Edges: g.Edges{{"failing", "entry"}}},
+		{"failing", "exit"}:  {Edges: g.Edges{{"failing", "error"}}},
+	})
+}
+
+func TestRetroactiveCaptureFlushOnErrorNotKept(t *testing.T) {
+	defer setRetroactiveCapture(t)()
+	r := SetTestReporter(TestReporterSampleRate(0))
+
+	ctx, ok := NewContext("retro", "", true, nil)
+	require.True(t, ok)
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "retro"))
+
+	// the failing trace is not sent without KeepErrors
+	ctx, ok = NewContext("failing", "", true, nil)
+	require.True(t, ok)
+	assert.NoError(t, ctx.ReportEvent(LabelError, "failing", "ErrorClass", "error"))
+	waitRetroFlushed(globalRetroCapture)
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "failing"))
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"retro", "entry"}: {},
+		{"retro", "exit"}:  {Edges: g.Edges{{"retro", "entry"}}},
+	})
+}
+
+func TestRetroactiveCaptureFlushInBackground(t *testing.T) {
+	defer setRetroactiveCapture(t)()
+	r := SetTestReporter(TestReporterSampleRate(0))
+
+	ctx, ok := NewContext("retro", "", true, nil)
+	require.True(t, ok)
+	assert.NoError(t, ctx.ReportEvent(LabelExit, "retro"))
+
+	// the requests are coalesced and every trace is sent once
+	rc := globalRetroCapture
+	for i := 0; i < 10; i++ {
+		rc.flushInBackground(r)
+	}
+	waitRetroFlushed(rc)
+	r.Close(2)
+	assert.Len(t, r.EventBufs, 2)
+	assert.Empty(t, rc.traces)
+}
+
+func waitRetroFlushed(rc *retroCapture) {
+	for atomic.LoadInt32(&rc.flushing) != 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRetroactiveCaptureBounded(t *testing.T) {
+	defer setRetroactiveCapture(t)()
+
+	// the oldest traces are evicted to keep the memory bounded
+	rc := globalRetroCapture
+	for i := 0; i < 3; i++ {
+		rc.add(nil, nil, retroCaptureMaxBytes/2)
+	}
+	assert.Len(t, rc.traces, 2)
+	assert.Equal(t, retroCaptureMaxBytes, rc.bytes)
+
+	// a trace larger than the limit is not captured at all
+	rc.add(nil, nil, retroCaptureMaxBytes+1)
+	assert.Len(t, rc.traces, 2)
+
+	// the traces out of the capture window are evicted
+	rc.Lock()
+	rc.evict(time.Now().Add(11 * time.Second))
+	rc.Unlock()
+	assert.Empty(t, rc.traces)
+	assert.Zero(t, rc.bytes)
+}
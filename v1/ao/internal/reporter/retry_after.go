@@ -0,0 +1,95 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/collector"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// retryAfterKey is the metadata key by which the collector asks the reporter
+// to back off for a while when it's overloaded.
+const retryAfterKey = "retry-after"
+
+// retryAfterClient captures the header and trailer metadata of the RPC calls,
+// so that the Retry-After returned by the collector, if any, can be honored.
+type retryAfterClient struct {
+	collector.TraceCollectorClient
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (c *retryAfterClient) callOptions(opts []grpc.CallOption) []grpc.CallOption {
+	return append(opts, grpc.Header(&c.header), grpc.Trailer(&c.trailer))
+}
+
+func (c *retryAfterClient) PostEvents(ctx context.Context, in *collector.MessageRequest,
+	opts ...grpc.CallOption) (*collector.MessageResult, error) {
+	return c.TraceCollectorClient.PostEvents(ctx, in, c.callOptions(opts)...)
+}
+
+func (c *retryAfterClient) PostMetrics(ctx context.Context, in *collector.MessageRequest,
+	opts ...grpc.CallOption) (*collector.MessageResult, error) {
+	return c.TraceCollectorClient.PostMetrics(ctx, in, c.callOptions(opts)...)
+}
+
+func (c *retryAfterClient) PostStatus(ctx context.Context, in *collector.MessageRequest,
+	opts ...grpc.CallOption) (*collector.MessageResult, error) {
+	return c.TraceCollectorClient.PostStatus(ctx, in, c.callOptions(opts)...)
+}
+
+func (c *retryAfterClient) GetSettings(ctx context.Context, in *collector.SettingsRequest,
+	opts ...grpc.CallOption) (*collector.SettingsResult, error) {
+	return c.TraceCollectorClient.GetSettings(ctx, in, c.callOptions(opts)...)
+}
+
+func (c *retryAfterClient) Ping(ctx context.Context, in *collector.PingRequest,
+	opts ...grpc.CallOption) (*collector.MessageResult, error) {
+	return c.TraceCollectorClient.Ping(ctx, in, c.callOptions(opts)...)
+}
+
+// retryAfter returns the delay before the next call asked by the collector in
+// the metadata of the last call, and false if it's not provided or invalid.
+func (c *retryAfterClient) retryAfter() (time.Duration, bool) {
+	for _, md := range []metadata.MD{c.header, c.trailer} {
+		if v := md.Get(retryAfterKey); len(v) > 0 {
+			return parseRetryAfter(v[0], time.Now())
+		}
+	}
+	return 0, false
+}
+
+// parseRetryAfter parses the value of Retry-After, which is either a number of
+// seconds or an HTTP-date, into the delay from now. The delay is capped at the
+// maximum retry delay.
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	var delay time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		} else if secs > grpcRetryDelayMax {
+			secs = grpcRetryDelayMax
+		}
+		delay = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		delay = t.Sub(now)
+		if delay < 0 {
+			delay = 0
+		}
+	} else {
+		return 0, false
+	}
+
+	if max := grpcRetryDelayMax * time.Second; delay > max {
+		delay = max
+	}
+	return delay, true
+}
@@ -0,0 +1,74 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"testing"
+	"time"
+
+	pb "github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/collector"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	d, ok := parseRetryAfter("5", now)
+	assert.True(t, ok)
+	assert.Equal(t, 5*time.Second, d)
+
+	d, ok = parseRetryAfter("Wed, 01 May 2019 10:00:30 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, d)
+
+	// a date in the past means retrying immediately
+	d, ok = parseRetryAfter("Wed, 01 May 2019 09:00:00 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, time.Duration(0), d)
+
+	// capped at the maximum retry delay
+	d, ok = parseRetryAfter("3600", now)
+	assert.True(t, ok)
+	assert.Equal(t, grpcRetryDelayMax*time.Second, d)
+	d, ok = parseRetryAfter("Thu, 02 May 2019 10:00:00 GMT", now)
+	assert.True(t, ok)
+	assert.Equal(t, grpcRetryDelayMax*time.Second, d)
+
+	for _, v := range []string{"", "-1", "soon"} {
+		_, ok = parseRetryAfter(v, now)
+		assert.False(t, ok, v)
+	}
+}
+
+func TestInvokeRPCRetryAfter(t *testing.T) {
+	var delays []time.Duration
+	c, err := newGrpcConnection("events channel", "test-addr", WithDialer(&NoopDialer{}),
+		WithBackoff(func(retries int, wait func(d time.Duration)) error {
+			start := time.Now()
+			wait(time.Millisecond)
+			delays = append(delays, time.Since(start))
+			return nil
+		}))
+	require.NoError(t, err)
+
+	// the collector is overloaded and asks to retry after a second
+	client := &mocks.TraceCollectorClient{}
+	client.On("PostEvents", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			md := args[2].(grpc.HeaderCallOption).HeaderAddr
+			*md = metadata.Pairs(retryAfterKey, "1")
+		}).Return(&pb.MessageResult{Result: pb.ResultCode_TRY_LATER}, nil).Once()
+	client.On("PostEvents", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(&pb.MessageResult{Result: pb.ResultCode_OK}, nil)
+	c.client = client
+
+	require.NoError(t, c.InvokeRPC(make(chan struct{}), newPostEventsMethod("key", [][]byte{[]byte("x")})))
+	require.Len(t, delays, 1)
+	assert.True(t, delays[0] >= time.Second, delays[0])
+	client.AssertNumberOfCalls(t, "PostEvents", 2)
+}
@@ -0,0 +1,248 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// TraceRingSizeDefault is the default number of finished traces retained
+	TraceRingSizeDefault = 100
+	// TraceRingMaxBytesDefault is the default upper bound of the memory used by
+	// the events retained in the ring, in bytes.
+	TraceRingMaxBytesDefault = 4 * 1024 * 1024
+)
+
+// recordedTrace is a trace captured by the TraceRing. It's finished when all
+// the spans it started are ended.
+type recordedTrace struct {
+	taskID   string
+	events   [][]byte
+	depth    int
+	size     int
+	finished time.Time
+}
+
+// TraceRing retains the most recent finished traces in process, mainly for
+// on-box debugging. The memory footprint is bounded by both the number of
+// traces and the total size of the events.
+//
+// It is safe for concurrent use.
+type TraceRing struct {
+	size     int
+	maxBytes int
+
+	// finished traces, the oldest one comes first
+	traces []*recordedTrace
+	// traces which have not finished yet, keyed by the task ID
+	pending map[string]*recordedTrace
+	// the task IDs of the pending traces, in the order of creation
+	pendingOrder []string
+	// the total bytes of the events retained, including the pending ones.
+	bytes int
+
+	lock sync.Mutex
+}
+
+// NewTraceRing creates a TraceRing which retains up to `size` finished traces
+// and up to `maxBytes` bytes of events. The default value is used for any
+// argument which is not positive.
+func NewTraceRing(size, maxBytes int) *TraceRing {
+	if size <= 0 {
+		size = TraceRingSizeDefault
+	}
+	if maxBytes <= 0 {
+		maxBytes = TraceRingMaxBytesDefault
+	}
+	return &TraceRing{
+		size:     size,
+		maxBytes: maxBytes,
+		pending:  make(map[string]*recordedTrace),
+	}
+}
+
+// record adds an event into the ring. The event must be prepared already.
+func (r *TraceRing) record(e *event) {
+	buf := e.bbuf.GetBuf()
+	if len(buf) > r.maxBytes {
+		log.Debugf("Trace ring: event dropped as it's larger than %d bytes.", r.maxBytes)
+		return
+	}
+	taskID := string(e.metadata.ids.taskID)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	t, ok := r.pending[taskID]
+	if !ok {
+		t = &recordedTrace{taskID: taskID}
+		r.pending[taskID] = t
+		r.pendingOrder = append(r.pendingOrder, taskID)
+		// Pending traces which never finish are evicted when there are too many.
+		if len(r.pendingOrder) > r.size {
+			r.dropPending(r.pendingOrder[0])
+		}
+	}
+
+	t.events = append(t.events, buf)
+	t.size += len(buf)
+	r.bytes += len(buf)
+
+	switch e.label {
+	case LabelEntry, LabelProfileEntry:
+		t.depth++
+	case LabelExit, LabelProfileExit:
+		t.depth--
+		if t.depth <= 0 {
+			r.finish(t)
+		}
+	}
+	r.evict()
+}
+
+// finish moves a pending trace into the finished list.
+func (r *TraceRing) finish(t *recordedTrace) {
+	delete(r.pending, t.taskID)
+	for i, id := range r.pendingOrder {
+		if id == t.taskID {
+			r.pendingOrder = append(r.pendingOrder[:i], r.pendingOrder[i+1:]...)
+			break
+		}
+	}
+	t.finished = time.Now()
+	r.traces = append(r.traces, t)
+}
+
+// dropPending removes a pending trace and releases its bytes.
+func (r *TraceRing) dropPending(taskID string) {
+	if t, ok := r.pending[taskID]; ok {
+		r.bytes -= t.size
+		delete(r.pending, taskID)
+	}
+	for i, id := range r.pendingOrder {
+		if id == taskID {
+			r.pendingOrder = append(r.pendingOrder[:i], r.pendingOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// evict drops the oldest traces until the ring is within its limits. The
+// finished traces are evicted first, followed by the pending ones.
+func (r *TraceRing) evict() {
+	for len(r.traces) > r.size {
+		r.dropFinished()
+	}
+	for r.bytes > r.maxBytes && len(r.traces) > 0 {
+		r.dropFinished()
+	}
+	for r.bytes > r.maxBytes && len(r.pendingOrder) > 0 {
+		r.dropPending(r.pendingOrder[0])
+	}
+}
+
+// dropFinished removes the oldest finished trace.
+func (r *TraceRing) dropFinished() {
+	r.bytes -= r.traces[0].size
+	r.traces[0] = nil
+	r.traces = r.traces[1:]
+}
+
+// RecordedEvent is the decoded representation of an event.
+type RecordedEvent map[string]interface{}
+
+// RecordedTrace is the representation of a finished trace retained by the ring.
+type RecordedTrace struct {
+	TraceID  string          `json:"TraceID"`
+	Finished time.Time       `json:"Finished"`
+	Events   []RecordedEvent `json:"Events"`
+}
+
+// Traces returns the finished traces retained by the ring, the most recent
+// one comes first.
+func (r *TraceRing) Traces() []RecordedTrace {
+	r.lock.Lock()
+	traces := make([]*recordedTrace, len(r.traces))
+	copy(traces, r.traces)
+	r.lock.Unlock()
+
+	result := make([]RecordedTrace, 0, len(traces))
+	for i := len(traces) - 1; i >= 0; i-- {
+		t := traces[i]
+		rt := RecordedTrace{
+			TraceID:  strings.ToUpper(hex.EncodeToString([]byte(t.taskID))),
+			Finished: t.finished,
+		}
+		for _, buf := range t.events {
+			m := make(RecordedEvent)
+			if err := bson.Unmarshal(buf, m); err != nil {
+				log.Debugf("Trace ring: failed to decode event: %v", err)
+				continue
+			}
+			rt.Events = append(rt.Events, m)
+		}
+		result = append(result, rt)
+	}
+	return result
+}
+
+// ServeHTTP renders the finished traces as JSON.
+func (r *TraceRing) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(r.Traces()); err != nil {
+		log.Warningf("Trace ring: failed to render traces: %v", err)
+	}
+}
+
+// teeReporter sends the events to the underlying reporter and records a copy
+// of them into a TraceRing.
+type teeReporter struct {
+	reporter
+	ring *TraceRing
+}
+
+func newTeeReporter(r reporter, ring *TraceRing) *teeReporter {
+	return &teeReporter{reporter: r, ring: ring}
+}
+
+func (t *teeReporter) reportEvent(ctx *oboeContext, e *event) error {
+	// Prepare and record it before handing it over, so the event is in the
+	// ring by the time the underlying reporter makes it visible. The null
+	// reporter drops the event without preparing it, we still want to record it
+	// for debugging when the agent cannot talk to the collector.
+	if err := prepareEvent(ctx, e); err != nil {
+		return err
+	}
+	e.prepared = true
+	t.ring.record(e)
+
+	if _, ok := t.reporter.(*nullReporter); ok {
+		return nil
+	}
+	return t.reporter.reportEvent(ctx, e)
+}
+
+// EnableTraceRing tees the events reported by the current reporter into the
+// TraceRing provided. It should be called before any trace is started as the
+// global reporter is not protected by a mutex.
+func EnableTraceRing(ring *TraceRing) {
+	if ring == nil {
+		return
+	}
+	if tee, ok := globalReporter.(*teeReporter); ok {
+		tee.ring = ring
+		return
+	}
+	globalReporter = newTeeReporter(globalReporter, ring)
+}
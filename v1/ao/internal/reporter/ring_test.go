@@ -0,0 +1,101 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// reportTestTrace reports a trace of a root span and a child span through the
+// reporter provided and returns the context of the trace.
+func reportTestTrace(t *testing.T, r reporter) *oboeContext {
+	ctx := newTestContext(t)
+	for _, label := range []Label{LabelEntry, LabelEntry, LabelInfo, LabelExit, LabelExit} {
+		e, err := ctx.newEvent(label, testLayer)
+		require.NoError(t, err)
+		require.NoError(t, r.reportEvent(ctx, e))
+	}
+	return ctx
+}
+
+func TestTraceRing(t *testing.T) {
+	ring := NewTraceRing(2, 0)
+	tee := newTeeReporter(newNullReporter(), ring)
+
+	// a pending trace is not visible
+	ctx := newTestContext(t)
+	e, err := ctx.newEvent(LabelEntry, testLayer)
+	require.NoError(t, err)
+	require.NoError(t, tee.reportEvent(ctx, e))
+	assert.Len(t, ring.Traces(), 0)
+
+	ctx1 := reportTestTrace(t, tee)
+	traces := ring.Traces()
+	require.Len(t, traces, 1)
+	assert.Equal(t, ctx1.MetadataString()[2:42], traces[0].TraceID)
+	require.Len(t, traces[0].Events, 5)
+	assert.Equal(t, LabelEntry, traces[0].Events[0]["Label"])
+	assert.Equal(t, testLayer, traces[0].Events[0]["Layer"])
+	assert.Equal(t, LabelExit, traces[0].Events[4]["Label"])
+
+	// only the most recent traces are retained
+	ctx2 := reportTestTrace(t, tee)
+	ctx3 := reportTestTrace(t, tee)
+	traces = ring.Traces()
+	require.Len(t, traces, 2)
+	assert.Equal(t, ctx3.MetadataString()[2:42], traces[0].TraceID)
+	assert.Equal(t, ctx2.MetadataString()[2:42], traces[1].TraceID)
+
+	// render the traces as JSON
+	w := httptest.NewRecorder()
+	ring.ServeHTTP(w, httptest.NewRequest("GET", "/traces", nil))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var rendered []RecordedTrace
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rendered))
+	require.Len(t, rendered, 2)
+	assert.Equal(t, ctx3.MetadataString()[2:42], rendered[0].TraceID)
+	assert.Len(t, rendered[0].Events, 5)
+}
+
+func TestTraceRingMaxBytes(t *testing.T) {
+	ring := NewTraceRing(10, 0)
+	tee := newTeeReporter(newNullReporter(), ring)
+	reportTestTrace(t, tee)
+	require.Len(t, ring.Traces(), 1)
+
+	// allow a single trace only
+	ring = NewTraceRing(10, ring.bytes+1)
+	tee = newTeeReporter(newNullReporter(), ring)
+	reportTestTrace(t, tee)
+	ctx := reportTestTrace(t, tee)
+	traces := ring.Traces()
+	require.Len(t, traces, 1)
+	assert.Equal(t, ctx.MetadataString()[2:42], traces[0].TraceID)
+	assert.True(t, ring.bytes <= ring.maxBytes)
+}
+
+func TestEnableTraceRing(t *testing.T) {
+	r := SetTestReporter()
+	ring := NewTraceRing(0, 0)
+	EnableTraceRing(ring)
+
+	ctx := newTestContext(t)
+	go func() {
+		for _, label := range []Label{LabelEntry, LabelExit} {
+			e, err := ctx.newEvent(label, testLayer)
+			assert.NoError(t, err)
+			assert.NoError(t, e.Report(ctx))
+		}
+	}()
+	r.Close(2)
+	assert.Len(t, r.EventBufs, 2)
+
+	traces := ring.Traces()
+	require.Len(t, traces, 1)
+	assert.Len(t, traces[0].Events, 2)
+}
@@ -0,0 +1,180 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"bytes"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/hdrhist"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/pkg/errors"
+)
+
+const (
+	// the maximum size of a StatsD packet, which fits in the MTU of most
+	// networks after the IP and UDP headers.
+	statsdMaxPacketSize = 1432
+)
+
+// the StatsD metric types
+const (
+	statsdCounter = "c"
+	statsdGauge   = "g"
+	statsdTimer   = "ms"
+)
+
+// statsdBatch collects the metrics of a metrics cycle as lines of the StatsD
+// line protocol, which are sent to the StatsD server at the end of the cycle.
+// All the methods are no-ops on a nil batch, which is the case when StatsD
+// is not configured.
+type statsdBatch struct {
+	addr   string
+	prefix string // the metrics namespace followed by a dot
+	format string
+	tags   map[string]string // the global metric tags
+	lines  []string
+}
+
+// newStatsDBatch returns a batch to collect the StatsD metrics, or nil if no
+// StatsD server is configured or the metrics are disabled.
+func newStatsDBatch() *statsdBatch {
+	addr := config.GetStatsDAddr()
+	if addr == "" || config.GetDisableMetrics() {
+		return nil
+	}
+	return &statsdBatch{
+		addr:   addr,
+		prefix: config.GetMetricsNamespace() + ".",
+		format: config.GetStatsDTagFormat(),
+		tags:   config.GetMetricTags(),
+	}
+}
+
+// count adds a counter
+func (b *statsdBatch) count(name string, value int64, tags map[string]string) {
+	if b == nil {
+		return
+	}
+	b.add(name, strconv.FormatInt(value, 10), statsdCounter, 1, tags)
+}
+
+// gauge adds a gauge
+func (b *statsdBatch) gauge(name string, value float64, tags map[string]string) {
+	if b == nil {
+		return
+	}
+	b.add(name, strconv.FormatFloat(value, 'f', -1, 64), statsdGauge, 1, tags)
+}
+
+// timings adds the values of a histogram of durations in microseconds as
+// timers in milliseconds. A value recorded n times is sent once with the
+// sample rate 1/n, so the StatsD server counts it n times.
+func (b *statsdBatch) timings(name string, h *hdrhist.Hist, tags map[string]string) {
+	if b == nil {
+		return
+	}
+	for _, v := range h.AllVals() {
+		ms := float64(v.Value) / float64(time.Millisecond/time.Microsecond)
+		b.add(name, strconv.FormatFloat(ms, 'f', -1, 64), statsdTimer, 1/float64(v.Count), tags)
+	}
+}
+
+// measurement adds the count of a measurement as a counter, and its sum as
+// another counter if the sum is reported.
+func (b *statsdBatch) measurement(m *Measurement) {
+	if b == nil {
+		return
+	}
+	b.count(m.Name+".count", int64(m.Count), m.Tags)
+	if m.ReportSum {
+		b.add(m.Name+".sum", strconv.FormatFloat(m.Sum, 'f', -1, 64), statsdCounter, 1, m.Tags)
+	}
+}
+
+// add formats a metric in the StatsD line protocol and adds it to the batch.
+func (b *statsdBatch) add(name, value, typ string, rate float64, tags map[string]string) {
+	var sb bytes.Buffer
+	sb.WriteString(b.prefix)
+	sb.WriteString(statsdEscaper.Replace(name))
+
+	tags = mergeMetricTags(tags, b.tags)
+	var names []string
+	for k := range tags {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	if b.format == config.StatsDTagsInfluxDB {
+		for _, k := range names {
+			sb.WriteString("," + statsdEscaper.Replace(k) + "=" + statsdEscaper.Replace(tags[k]))
+		}
+	}
+	sb.WriteString(":" + value + "|" + typ)
+	if rate < 1 {
+		sb.WriteString("|@" + strconv.FormatFloat(rate, 'g', 6, 64))
+	}
+	if b.format == config.StatsDTagsDogStatsD && len(names) != 0 {
+		for i, k := range names {
+			if i == 0 {
+				sb.WriteString("|#")
+			} else {
+				sb.WriteString(",")
+			}
+			sb.WriteString(statsdEscaper.Replace(k) + ":" + statsdEscaper.Replace(tags[k]))
+		}
+	}
+	b.lines = append(b.lines, sb.String())
+}
+
+// the characters which are part of the StatsD line protocol are replaced in
+// the names and tags
+var statsdEscaper = strings.NewReplacer(
+	":", "_", "|", "_", "@", "_", ",", "_", "#", "_", "=", "_", " ", "_", "\n", "_")
+
+// packets splits the lines into packets of at most statsdMaxPacketSize bytes,
+// separated by newlines. A line longer than the limit is sent in a packet of
+// its own.
+func (b *statsdBatch) packets() [][]byte {
+	var pkts [][]byte
+	var pkt []byte
+	for _, line := range b.lines {
+		if len(pkt) != 0 && len(pkt)+1+len(line) > statsdMaxPacketSize {
+			pkts = append(pkts, pkt)
+			pkt = nil
+		}
+		if len(pkt) != 0 {
+			pkt = append(pkt, '\n')
+		}
+		pkt = append(pkt, line...)
+	}
+	if len(pkt) != 0 {
+		pkts = append(pkts, pkt)
+	}
+	return pkts
+}
+
+// send sends the metrics of the batch to the StatsD server.
+func (b *statsdBatch) send() error {
+	if b == nil || len(b.lines) == 0 {
+		return nil
+	}
+	conn, err := net.Dial("udp", b.addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to connect to the StatsD server")
+	}
+	defer conn.Close()
+
+	for _, pkt := range b.packets() {
+		if _, err := conn.Write(pkt); err != nil {
+			return errors.Wrap(err, "failed to send the StatsD metrics")
+		}
+	}
+	log.Debugf("Sent %d metrics to the StatsD server %s", len(b.lines), b.addr)
+	return nil
+}
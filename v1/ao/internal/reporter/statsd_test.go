@@ -0,0 +1,141 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsDLineProtocol(t *testing.T) {
+	tags := map[string]string{"TransactionName": "my:txn", "HttpMethod": "GET"}
+	global := map[string]string{"env": "prod"}
+
+	b := &statsdBatch{prefix: "appoptics.", format: config.StatsDTagsDogStatsD, tags: global}
+	b.count("RequestCount", 3, nil)
+	b.gauge("QueueLargest", 1.5, nil)
+	b.add("TransactionResponseTime", "0.1", statsdTimer, 0.5, tags)
+	assert.Equal(t, []string{
+		"appoptics.RequestCount:3|c|#env:prod",
+		"appoptics.QueueLargest:1.5|g|#env:prod",
+		"appoptics.TransactionResponseTime:0.1|ms|@0.5|#HttpMethod:GET,TransactionName:my_txn,env:prod",
+	}, b.lines)
+
+	b = &statsdBatch{prefix: "appoptics.", format: config.StatsDTagsInfluxDB, tags: global}
+	b.count("RequestCount", 3, tags)
+	assert.Equal(t, []string{
+		"appoptics.RequestCount,HttpMethod=GET,TransactionName=my_txn,env=prod:3|c",
+	}, b.lines)
+
+	b = &statsdBatch{prefix: "appoptics.", format: config.StatsDTagsNone, tags: global}
+	b.measurement(&Measurement{Name: "TransactionResponseTime", Tags: tags, Count: 2, Sum: 300, ReportSum: true})
+	assert.Equal(t, []string{
+		"appoptics.TransactionResponseTime.count:2|c",
+		"appoptics.TransactionResponseTime.sum:300|c",
+	}, b.lines)
+
+	// all the methods are no-ops on a nil batch
+	var nb *statsdBatch
+	nb.count("RequestCount", 1, nil)
+	nb.measurement(&Measurement{Name: "TransactionResponseTime"})
+	assert.Nil(t, nb.send())
+}
+
+func TestStatsDPackets(t *testing.T) {
+	b := &statsdBatch{}
+	line := strings.Repeat("x", statsdMaxPacketSize/2)
+	b.lines = []string{line, line, line, strings.Repeat("y", statsdMaxPacketSize+1)}
+
+	pkts := b.packets()
+	require.Len(t, pkts, 4)
+	for _, pkt := range pkts[:3] {
+		assert.Equal(t, line, string(pkt))
+	}
+
+	b.lines = []string{"a:1|c", "b:2|c"}
+	assert.Equal(t, [][]byte{[]byte("a:1|c\nb:2|c")}, b.packets())
+}
+
+// readStatsDLines reads the lines sent to the fake StatsD server until no
+// more packets arrive.
+func readStatsDLines(server net.PacketConn) []string {
+	var lines []string
+	buf := make([]byte, statsdMaxPacketSize)
+	for {
+		server.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := server.ReadFrom(buf)
+		if err != nil {
+			return lines
+		}
+		lines = append(lines, strings.Split(string(buf[:n]), "\n")...)
+	}
+}
+
+func TestStatsDExport(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer server.Close()
+
+	os.Setenv("APPOPTICS_STATSD_ADDR", server.LocalAddr().String())
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_STATSD_ADDR")
+		config.Load()
+	}()
+
+	recordHistogram(metricsHTTPHistograms, "statsd", 100*time.Microsecond)
+	recordHistogram(metricsHTTPHistograms, "statsd", 100*time.Microsecond)
+	generateMetricsMessage(15, &eventQueueStats{numSent: 5, queueLargest: 2})
+
+	lines := readStatsDLines(server)
+	assert.Contains(t, lines, "appoptics.RequestCount:0|c")
+	assert.Contains(t, lines, "appoptics.NumSent:5|c")
+	assert.Contains(t, lines, "appoptics.QueueLargest:2|g")
+	assert.Contains(t, lines, "appoptics.TransactionResponseTime:0.1|ms|@0.5|#TransactionName:statsd")
+	for _, line := range lines {
+		assert.True(t, strings.HasPrefix(line, "appoptics."), line)
+	}
+}
+
+func TestMetricsNamespace(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer server.Close()
+
+	os.Setenv("APPOPTICS_STATSD_ADDR", server.LocalAddr().String())
+	os.Setenv("APPOPTICS_METRICS_NAMESPACE", "appoptics_agent")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_STATSD_ADDR")
+		os.Unsetenv("APPOPTICS_METRICS_NAMESPACE")
+		config.Load()
+	}()
+
+	recordHistogram(metricsHTTPHistograms, "ns", 100*time.Microsecond)
+
+	var buf bytes.Buffer
+	assert.Nil(t, WritePrometheusHistograms(&buf))
+	prom := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.NotEmpty(t, prom)
+	for _, line := range prom {
+		line = strings.TrimPrefix(line, "# HELP ")
+		line = strings.TrimPrefix(line, "# TYPE ")
+		assert.True(t, strings.HasPrefix(line, "appoptics_agent_transaction_response_time_seconds"), line)
+	}
+
+	generateMetricsMessage(15, &eventQueueStats{})
+	lines := readStatsDLines(server)
+	assert.Contains(t, lines, "appoptics_agent.RequestCount:0|c")
+	assert.Contains(t, lines, "appoptics_agent.TransactionResponseTime:0.1|ms|#TransactionName:ns")
+	for _, line := range lines {
+		assert.True(t, strings.HasPrefix(line, "appoptics_agent."), line)
+	}
+}
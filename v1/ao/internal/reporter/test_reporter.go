@@ -19,6 +19,7 @@ type TestReporter struct {
 	ShouldError           bool
 	UseSettings           bool
 	DisableDefaultSetting bool
+	SampleRate            int // the sample rate of the default setting
 	CaptureMetrics        bool
 	ErrorEvents           map[int]bool // whether to drop an event
 	eventCount            int64
@@ -42,6 +43,11 @@ func TestReporterDisableDefaultSetting(val bool) TestReporterOption {
 	return func(r *TestReporter) { r.DisableDefaultSetting = val }
 }
 
+// TestReporterSampleRate sets the sample rate (0 - 1000000) of the default setting.
+func TestReporterSampleRate(rate int) TestReporterOption {
+	return func(r *TestReporter) { r.SampleRate = rate }
+}
+
 // TestReporterTimeout sets a timeout for the TestReporter to wait before shutting down its writer.
 func TestReporterTimeout(timeout time.Duration) TestReporterOption {
 	return func(r *TestReporter) { r.Timeout = timeout }
@@ -74,6 +80,7 @@ func SetTestReporter(options ...TestReporterOption) *TestReporter {
 	r := &TestReporter{
 		ShouldTrace: true,
 		UseSettings: true,
+		SampleRate:  1000000,
 		Timeout:     defaultTestReporterTimeout,
 		done:        make(chan int),
 		eventChan:   make(chan []byte),
@@ -198,8 +205,8 @@ func (r *TestReporter) reportSpan(span SpanMessage) error {
 }
 
 func (r *TestReporter) addDefaultSetting() {
-	// add default setting with 100% sampling
+	// add default setting with 100% sampling, unless specified otherwise
 	updateSetting(int32(TYPE_DEFAULT), "",
 		[]byte("SAMPLE_START,SAMPLE_THROUGH_ALWAYS"),
-		1000000, 120, argsToMap(1000000, 1000000, -1, -1))
+		int64(r.SampleRate), 120, argsToMap(1000000, 1000000, -1, -1))
 }
@@ -0,0 +1,43 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/pkg/errors"
+)
+
+// the number of events whose timestamps are skewed further than the maximum
+// timestamp skew (flushed on each metrics report cycle)
+var skewedEvents int64
+
+// errSkewedTimestamp is returned for an event rejected for its timestamp
+var errSkewedTimestamp = errors.New("invalid event, timestamp skewed from the current time")
+
+// checkTimestampSkew checks the timestamp of an event against the current
+// time of the agent. A timestamp skewed further than the maximum timestamp
+// skew is counted and, as configured, either rejected with an error or
+// clamped to the nearest bound of the skew.
+func checkTimestampSkew(ts time.Time) (time.Time, error) {
+	maxSkew := config.GetMaxTimestampSkew()
+	if maxSkew <= 0 {
+		return ts, nil
+	}
+	now := eventClock.Now()
+	earliest, latest := now.Add(-maxSkew), now.Add(maxSkew)
+	if !ts.Before(earliest) && !ts.After(latest) {
+		return ts, nil
+	}
+
+	atomic.AddInt64(&skewedEvents, 1)
+	if config.GetSkewedEvents() != config.SkewedEventsClamp {
+		return ts, errSkewedTimestamp
+	}
+	if ts.Before(earliest) {
+		return earliest, nil
+	}
+	return latest, nil
+}
@@ -0,0 +1,89 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package reporter
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// reportSkewedEvents reports the events with the timestamps far in the future
+// and far in the past, and returns the errors of reporting them and the
+// timestamps of the events reported.
+func reportSkewedEvents(t *testing.T, n int) ([]error, []int64) {
+	c, restore := setFakeClock()
+	defer restore()
+	r := SetTestReporter()
+	atomic.StoreInt64(&skewedEvents, 0)
+
+	ctx, ok := NewContext("skew", "", true, nil)
+	require.True(t, ok)
+	var errs []error
+	for _, skew := range []time.Duration{time.Hour, -24 * time.Hour, 30 * time.Second} {
+		errs = append(errs, ctx.ReportEvent(LabelInfo, "skew", TimestampKey, c.wall.Add(skew)))
+	}
+	r.Close(n)
+
+	var timestamps []int64
+	for _, buf := range r.EventBufs {
+		m := bson.M{}
+		require.NoError(t, bson.Unmarshal(buf, m))
+		timestamps = append(timestamps, m["Timestamp_u"].(int64))
+	}
+	return errs, timestamps
+}
+
+func setTimestampSkew(skewedEvents string) func() {
+	os.Setenv("APPOPTICS_MAX_TIMESTAMP_SKEW", "60")
+	os.Setenv("APPOPTICS_SKEWED_EVENTS", skewedEvents)
+	config.Load()
+	return func() {
+		os.Unsetenv("APPOPTICS_MAX_TIMESTAMP_SKEW")
+		os.Unsetenv("APPOPTICS_SKEWED_EVENTS")
+		config.Load()
+	}
+}
+
+func TestSkewedEventsRejected(t *testing.T) {
+	defer setTimestampSkew(config.SkewedEventsReject)()
+
+	errs, ts := reportSkewedEvents(t, 2)
+	assert.Equal(t, []error{errSkewedTimestamp, errSkewedTimestamp, nil}, errs)
+	start := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, []int64{
+		start.UnixNano() / 1000,
+		start.Add(30*time.Second).UnixNano() / 1000,
+	}, ts)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&skewedEvents))
+}
+
+func TestSkewedEventsClamped(t *testing.T) {
+	defer setTimestampSkew(config.SkewedEventsClamp)()
+
+	errs, ts := reportSkewedEvents(t, 4)
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+	start := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, []int64{
+		start.UnixNano() / 1000,
+		start.Add(time.Minute).UnixNano() / 1000,
+		start.Add(-time.Minute).UnixNano() / 1000,
+		start.Add(30*time.Second).UnixNano() / 1000,
+	}, ts)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&skewedEvents))
+}
+
+func TestTimestampSkewDisabled(t *testing.T) {
+	errs, ts := reportSkewedEvents(t, 4)
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+	require.Len(t, ts, 4)
+	start := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, start.Add(time.Hour).UnixNano()/1000, ts[1])
+	assert.Zero(t, atomic.LoadInt64(&skewedEvents))
+}
@@ -5,7 +5,10 @@ package reporter
 import (
 	"path/filepath"
 	"regexp"
+	"regexp/syntax"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
@@ -21,11 +24,10 @@ func init() {
 }
 
 // ReloadURLsConfig reloads the configuration and build the transaction filtering
-// filters and cache.
-// This function is used for testing purpose only. It's not thread-safe.
+// filters and cache. It's safe to be called at runtime, e.g., to apply the
+// filters fetched from a remote config source.
 func ReloadURLsConfig(filters []config.TransactionFilter) {
 	urls.LoadConfig(filters)
-	urls.cache.Clear()
 }
 
 // urlCache is a cache to store the disabled url patterns
@@ -33,42 +35,85 @@ type urlCache struct{ *freecache.Cache }
 
 const (
 	cacheExpireSeconds = 600
+
+	// a warning is printed if there are more transaction filters than this.
+	urlFiltersWarnThreshold = 1000
+
+	// the sample rate of a URL filter which doesn't set one
+	noURLSampleRate = -1
 )
 
-// setURLTrace sets a url and its trace decision into the cache
-func (c *urlCache) setURLTrace(url string, trace tracingMode) {
-	_ = c.Set([]byte(url), []byte(trace.ToString()), cacheExpireSeconds)
+// setURLTrace sets a url and its trace decision and sample rate into the cache
+func (c *urlCache) setURLTrace(url string, trace tracingMode, rate int) {
+	val := trace.ToString()
+	if rate != noURLSampleRate {
+		val += ":" + strconv.Itoa(rate)
+	}
+	_ = c.Set([]byte(url), []byte(val), cacheExpireSeconds)
 }
 
-// getURLTrace gets the trace decision of a URL
-func (c *urlCache) getURLTrace(url string) (tracingMode, error) {
-	traceStr, err := c.Get([]byte(url))
+// getURLTrace gets the trace decision and the sample rate of a URL
+func (c *urlCache) getURLTrace(url string) (tracingMode, int, error) {
+	val, err := c.Get([]byte(url))
 	if err != nil {
-		return TRACE_UNKNOWN, err
+		return TRACE_UNKNOWN, noURLSampleRate, err
 	}
 
-	return newTracingMode(config.TracingMode(string(traceStr))), nil
+	traceStr, rate := string(val), noURLSampleRate
+	if idx := strings.IndexByte(traceStr, ':'); idx >= 0 {
+		if rate, err = strconv.Atoi(traceStr[idx+1:]); err != nil {
+			return TRACE_UNKNOWN, noURLSampleRate, err
+		}
+		traceStr = traceStr[:idx]
+	}
+	return newTracingMode(config.TracingMode(traceStr)), rate, nil
 }
 
 // urlFilter defines a URL filter
 type urlFilter interface {
 	match(url string) bool
 	tracingMode() tracingMode
+	sampleRate() int
+}
+
+// filterSetting is the tracing mode and the sample rate of a URL filter
+type filterSetting struct {
+	trace tracingMode
+	rate  int
+}
+
+// tracingMode returns the tracing mode of the URL filter
+func (s filterSetting) tracingMode() tracingMode {
+	return s.trace
+}
+
+// sampleRate returns the sample rate of the URL filter, or noURLSampleRate
+func (s filterSetting) sampleRate() int {
+	return s.rate
+}
+
+// newFilterSetting returns the setting of the transaction filter
+func newFilterSetting(filter config.TransactionFilter) filterSetting {
+	s := filterSetting{trace: newTracingMode(filter.Tracing), rate: noURLSampleRate}
+	if filter.SampleRate != nil {
+		s.rate = *filter.SampleRate
+	}
+	return s
 }
 
 // regexFilter is a regular expression based URL filter
 type regexFilter struct {
+	filterSetting
 	regex *regexp.Regexp
-	trace tracingMode
 }
 
 // newRegexFilter creates a new regexFilter instance
-func newRegexFilter(regex string, mode tracingMode) (*regexFilter, error) {
+func newRegexFilter(regex string, setting filterSetting) (*regexFilter, error) {
 	re, err := regexp.Compile(regex)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse regexp")
 	}
-	return &regexFilter{regex: re, trace: mode}, nil
+	return &regexFilter{regex: re, filterSetting: setting}, nil
 }
 
 // match checks if the url matches the filter
@@ -76,41 +121,70 @@ func (f *regexFilter) match(url string) bool {
 	return f.regex.MatchString(url)
 }
 
-// tracingMode returns the tracing mode of this url pattern
-func (f *regexFilter) tracingMode() tracingMode {
-	return f.trace
-}
-
 // extensionFilter is a extension-based filter
 type extensionFilter struct {
-	Exts  map[string]struct{}
-	trace tracingMode
+	filterSetting
+	Exts map[string]struct{}
 }
 
 // newExtensionFilter create a new instance of extensionFilter
-func newExtensionFilter(extensions []string, mode tracingMode) *extensionFilter {
+func newExtensionFilter(extensions []string, setting filterSetting) *extensionFilter {
 	exts := make(map[string]struct{})
 	for _, ext := range extensions {
 		exts[ext] = struct{}{}
 	}
-	return &extensionFilter{Exts: exts, trace: mode}
+	return &extensionFilter{Exts: exts, filterSetting: setting}
 }
 
 // match checks if the url matches the filter
 func (f *extensionFilter) match(url string) bool {
-	ext := strings.TrimLeft(filepath.Ext(url), ".")
-	_, ok := f.Exts[ext]
+	_, ok := f.Exts[urlExtension(url)]
 	return ok
 }
 
-// tracingMode returns the tracing mode of this extension pattern
-func (f *extensionFilter) tracingMode() tracingMode {
-	return f.trace
+// urlExtension returns the extension of the url without the leading dot.
+func urlExtension(url string) string {
+	return strings.TrimLeft(filepath.Ext(url), ".")
+}
+
+// literalURL returns the url which the regular expression matches exactly, in
+// case the regular expression is in the form of `^literal$`.
+func literalURL(expr string) (string, bool) {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return "", false
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat || len(re.Sub) != 3 {
+		return "", false
+	}
+	if re.Sub[0].Op != syntax.OpBeginText ||
+		re.Sub[1].Op != syntax.OpLiteral ||
+		re.Sub[2].Op != syntax.OpEndText ||
+		re.Sub[1].Flags&syntax.FoldCase != 0 {
+		return "", false
+	}
+	return string(re.Sub[1].Rune), true
 }
 
+// urlFilters is the list of URL filters. The first filter matched decides the
+// tracing mode of a URL.
+//
+// As the list may be huge, the filters which match exact URLs or extensions
+// are indexed so only the other regular expressions need to be scanned.
 type urlFilters struct {
+	// it protects the filters and the cache from being reloaded while a URL is
+	// looked up
+	lock    sync.RWMutex
 	cache   *urlCache
 	filters []urlFilter
+
+	// the position of the first filter which matches the exact URL
+	exact map[string]int
+	// the position of the first filter which matches the extension
+	exts map[string]int
+	// the positions of the regular expression filters which are not indexed
+	regexes []int
 }
 
 func newURLFilters() *urlFilters {
@@ -120,23 +194,49 @@ func newURLFilters() *urlFilters {
 }
 
 // LoadConfig reads transaction filtering settings from the global configuration
+// and clears the cached decisions of the previous filters.
 func (f *urlFilters) LoadConfig(filters []config.TransactionFilter) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
 	f.loadConfig(filters)
+	f.cache.Clear()
 }
 
 func (f *urlFilters) loadConfig(filters []config.TransactionFilter) {
 	f.filters = nil
+	f.exact = make(map[string]int)
+	f.exts = make(map[string]int)
+	f.regexes = nil
+
+	if len(filters) > urlFiltersWarnThreshold {
+		log.Warningf("There are %d transaction filters, which may slow down the "+
+			"sampling decision. Please consider merging them.", len(filters))
+	}
 
 	for _, filter := range filters {
+		pos := len(f.filters)
+		setting := newFilterSetting(filter)
 		if filter.RegEx != "" {
-			re, err := newRegexFilter(filter.RegEx, newTracingMode(filter.Tracing))
+			re, err := newRegexFilter(filter.RegEx, setting)
 			if err != nil {
-				log.Warningf("Ignore bad regex: %s, error=", filter.RegEx, err.Error())
+				log.Warningf("Ignore bad regex: %s, error=%s", filter.RegEx, err.Error())
+				continue
 			}
 			f.filters = append(f.filters, re)
+			if url, ok := literalURL(filter.RegEx); ok {
+				if _, exist := f.exact[url]; !exist {
+					f.exact[url] = pos
+				}
+			} else {
+				f.regexes = append(f.regexes, pos)
+			}
 		} else {
-			f.filters = append(f.filters,
-				newExtensionFilter(filter.Extensions, newTracingMode(filter.Tracing)))
+			f.filters = append(f.filters, newExtensionFilter(filter.Extensions, setting))
+			for _, ext := range filter.Extensions {
+				if _, exist := f.exts[ext]; !exist {
+					f.exts[ext] = pos
+				}
+			}
 		}
 	}
 }
@@ -144,26 +244,61 @@ func (f *urlFilters) loadConfig(filters []config.TransactionFilter) {
 // getTracingMode checks if the URL should be traced or not. It returns TRACE_UNKNOWN
 // if the url is not found.
 func (f *urlFilters) getTracingMode(url string) tracingMode {
+	trace, _ := f.getSetting(url)
+	return trace
+}
+
+// getSetting returns the tracing mode and the sample rate of the URL. It
+// returns TRACE_UNKNOWN if the url is not found, and noURLSampleRate if the
+// filter matched doesn't set a sample rate.
+func (f *urlFilters) getSetting(url string) (tracingMode, int) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
 	if len(f.filters) == 0 || url == "" {
-		return TRACE_UNKNOWN
+		return TRACE_UNKNOWN, noURLSampleRate
 	}
 
-	trace, err := f.cache.getURLTrace(url)
+	trace, rate, err := f.cache.getURLTrace(url)
 	if err == nil {
-		return trace
+		return trace, rate
 	}
 
-	trace = f.lookupTracingMode(url)
-	f.cache.setURLTrace(url, trace)
+	trace, rate = f.lookupSetting(url)
+	f.cache.setURLTrace(url, trace, rate)
 
-	return trace
+	return trace, rate
 }
 
+// lookupTracingMode finds the tracing mode of the first filter which matches
+// the url.
 func (f *urlFilters) lookupTracingMode(url string) tracingMode {
-	for _, filter := range f.filters {
-		if filter.match(url) {
-			return filter.tracingMode()
+	trace, _ := f.lookupSetting(url)
+	return trace
+}
+
+// lookupSetting finds the first filter which matches the url. The indexes
+// are checked first, and the remaining regular expressions are scanned only if
+// they come before the filter found.
+func (f *urlFilters) lookupSetting(url string) (tracingMode, int) {
+	first := len(f.filters)
+	if pos, ok := f.exact[url]; ok {
+		first = pos
+	}
+	if pos, ok := f.exts[urlExtension(url)]; ok && pos < first {
+		first = pos
+	}
+	for _, pos := range f.regexes {
+		if pos >= first {
+			break
+		}
+		if f.filters[pos].match(url) {
+			first = pos
+			break
 		}
 	}
-	return TRACE_UNKNOWN
+
+	if first == len(f.filters) {
+		return TRACE_UNKNOWN, noURLSampleRate
+	}
+	return f.filters[first].tracingMode(), f.filters[first].sampleRate()
 }
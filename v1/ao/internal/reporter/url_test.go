@@ -1,6 +1,7 @@
 package reporter
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
@@ -11,21 +12,21 @@ import (
 func TestCache(t *testing.T) {
 	cache := &urlCache{freecache.NewCache(1024 * 1024)}
 
-	cache.setURLTrace("traced_1", TRACE_ENABLED)
-	cache.setURLTrace("not_traced_1", TRACE_DISABLED)
+	cache.setURLTrace("traced_1", TRACE_ENABLED, noURLSampleRate)
+	cache.setURLTrace("not_traced_1", TRACE_DISABLED, noURLSampleRate)
 	assert.Equal(t, int64(2), cache.EntryCount())
 
-	trace, err := cache.getURLTrace("traced_1")
+	trace, _, err := cache.getURLTrace("traced_1")
 	assert.Nil(t, err)
 	assert.Equal(t, TRACE_ENABLED, trace)
 	assert.Equal(t, int64(1), cache.HitCount())
 
-	trace, err = cache.getURLTrace("not_traced_1")
+	trace, _, err = cache.getURLTrace("not_traced_1")
 	assert.Nil(t, err)
 	assert.Equal(t, TRACE_DISABLED, trace)
 	assert.Equal(t, int64(2), cache.HitCount())
 
-	trace, err = cache.getURLTrace("non_exist_1")
+	trace, _, err = cache.getURLTrace("non_exist_1")
 	assert.NotNil(t, err)
 	assert.Equal(t, TRACE_UNKNOWN, trace)
 	assert.Equal(t, int64(2), cache.HitCount())
@@ -58,3 +59,139 @@ func TestUrlFilter(t *testing.T) {
 	assert.Equal(t, TRACE_DISABLED, filter.getTracingMode("http://user.com/eric/avatar.png"))
 	assert.Equal(t, int64(4), filter.cache.EntryCount())
 }
+
+func TestUrlFilterOrder(t *testing.T) {
+	filter := newURLFilters()
+	filter.loadConfig([]config.TransactionFilter{
+		{Type: "url", RegEx: `^/health$`, Tracing: config.DisabledTracingMode},
+		{Type: "url", RegEx: `^/static/`, Tracing: config.EnabledTracingMode},
+		{Type: "url", Extensions: []string{"png"}, Tracing: config.DisabledTracingMode},
+		{Type: "url", RegEx: `^/static/logo\.png$`, Tracing: config.EnabledTracingMode},
+		{Type: "url", RegEx: `[`, Tracing: config.EnabledTracingMode},
+		{Type: "url", RegEx: `^/health$`, Tracing: config.EnabledTracingMode},
+		{Type: "url", RegEx: `^/admin$`, Tracing: config.EnabledTracingMode},
+		{Type: "url", RegEx: `^/ADMIN`, Tracing: config.DisabledTracingMode},
+		{Type: "url", Extensions: []string{"png", "css"}, Tracing: config.EnabledTracingMode},
+	})
+
+	// the bad regex is ignored
+	assert.Len(t, filter.filters, 8)
+	assert.Equal(t, map[string]int{"/health": 0, "/static/logo.png": 3, "/admin": 5}, filter.exact)
+	assert.Equal(t, map[string]int{"png": 2, "css": 7}, filter.exts)
+	assert.Equal(t, []int{1, 6}, filter.regexes)
+
+	// the first filter matched wins
+	assert.Equal(t, TRACE_DISABLED, filter.lookupTracingMode("/health"))
+	assert.Equal(t, TRACE_ENABLED, filter.lookupTracingMode("/static/logo.png"))
+	assert.Equal(t, TRACE_DISABLED, filter.lookupTracingMode("/img/logo.png"))
+	assert.Equal(t, TRACE_ENABLED, filter.lookupTracingMode("/admin"))
+	assert.Equal(t, TRACE_DISABLED, filter.lookupTracingMode("/ADMIN/users"))
+	assert.Equal(t, TRACE_ENABLED, filter.lookupTracingMode("/main.css"))
+	assert.Equal(t, TRACE_UNKNOWN, filter.lookupTracingMode("/users"))
+}
+
+func TestUrlFilterSampleRate(t *testing.T) {
+	rate := 10000
+	filter := newURLFilters()
+	filter.loadConfig([]config.TransactionFilter{
+		{Type: "url", RegEx: `^/health$`, Tracing: config.EnabledTracingMode, SampleRate: &rate},
+		{Type: "url", RegEx: `^/checkout`, Tracing: config.EnabledTracingMode},
+		{Type: "url", Extensions: []string{"png"}, Tracing: config.DisabledTracingMode},
+	})
+
+	for i := 0; i < 2; i++ { // the second round hits the cache
+		trace, r := filter.getSetting("/health")
+		assert.Equal(t, TRACE_ENABLED, trace)
+		assert.Equal(t, 10000, r)
+
+		trace, r = filter.getSetting("/checkout/cart")
+		assert.Equal(t, TRACE_ENABLED, trace)
+		assert.Equal(t, noURLSampleRate, r)
+
+		trace, r = filter.getSetting("/logo.png")
+		assert.Equal(t, TRACE_DISABLED, trace)
+		assert.Equal(t, noURLSampleRate, r)
+
+		trace, r = filter.getSetting("/users")
+		assert.Equal(t, TRACE_UNKNOWN, trace)
+		assert.Equal(t, noURLSampleRate, r)
+	}
+	assert.Equal(t, int64(4), filter.cache.HitCount())
+}
+
+func TestLiteralURL(t *testing.T) {
+	testCases := []struct {
+		expr  string
+		url   string
+		exact bool
+	}{
+		{`^/health$`, "/health", true},
+		{`^/a\.b$`, "/a.b", true},
+		{`\A/health\z`, "/health", true},
+		{`/health`, "", false},
+		{`^/health`, "", false},
+		{`^/health$|^/ping$`, "", false},
+		{`(?i)^/health$`, "", false},
+		{`^/user\d+$`, "", false},
+		{`^$`, "", false},
+		{`[`, "", false},
+	}
+	for _, tc := range testCases {
+		url, exact := literalURL(tc.expr)
+		assert.Equal(t, tc.exact, exact, tc.expr)
+		assert.Equal(t, tc.url, url, tc.expr)
+	}
+}
+
+// newLargeURLFilters returns the transaction filters generated for lots of
+// exact URLs and extensions, plus a few regular expressions.
+func newLargeURLFilters() *urlFilters {
+	var filters []config.TransactionFilter
+	for i := 0; i < 5000; i++ {
+		filters = append(filters, config.TransactionFilter{
+			Type:    "url",
+			RegEx:   fmt.Sprintf(`^/api/v1/resource%d$`, i),
+			Tracing: config.DisabledTracingMode,
+		})
+		filters = append(filters, config.TransactionFilter{
+			Type:       "url",
+			Extensions: []string{fmt.Sprintf("ext%d", i)},
+			Tracing:    config.DisabledTracingMode,
+		})
+	}
+	for i := 0; i < 10; i++ {
+		filters = append(filters, config.TransactionFilter{
+			Type:    "url",
+			RegEx:   fmt.Sprintf(`^/user%d/\d+`, i),
+			Tracing: config.DisabledTracingMode,
+		})
+	}
+	f := newURLFilters()
+	f.loadConfig(filters)
+	return f
+}
+
+var benchURLs = []string{"/api/v1/resource4999", "/index.ext4999", "/user9/123", "/not/filtered"}
+
+// BenchmarkURLFiltersLinear scans all the filters in order, which is how the
+// lookup worked before the filters were indexed.
+func BenchmarkURLFiltersLinear(b *testing.B) {
+	f := newLargeURLFilters()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		url := benchURLs[i%len(benchURLs)]
+		for _, filter := range f.filters {
+			if filter.match(url) {
+				break
+			}
+		}
+	}
+}
+
+func BenchmarkURLFiltersIndexed(b *testing.B) {
+	f := newLargeURLFilters()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		f.lookupTracingMode(benchURLs[i%len(benchURLs)])
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package utils
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type internalRequestKey struct{}
+
+// internalTransport marks the requests made by the agent itself, so that they
+// are never traced by the HTTP client instrumentation, even if the application
+// instruments http.DefaultTransport transparently.
+type internalTransport struct{}
+
+// RoundTrip implements the http.RoundTripper interface.
+func (internalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !IsInternalRequest(req.Context()) {
+		req = req.WithContext(context.WithValue(req.Context(), internalRequestKey{}, true))
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// NewHTTPClient returns the HTTP client for the agent's own outbound requests.
+// All the requests made by it are marked as internal.
+func NewHTTPClient(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: internalTransport{}}
+}
+
+// IsInternalRequest reports whether the request context belongs to a request
+// made by the agent itself.
+func IsInternalRequest(ctx context.Context) bool {
+	internal, _ := ctx.Value(internalRequestKey{}).(bool)
+	return internal
+}
@@ -0,0 +1,64 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+const (
+	keyJobName    = "JobName"
+	keyJobOutcome = "JobOutcome"
+)
+
+// the timeout of flushing the events and metrics after a job, which is
+// independent of the job's context as it may have been canceled
+const jobFlushTimeout = 5 * time.Second
+
+// RunJob runs a cron or scheduled job named name as the root span of a new
+// trace, whose transaction name is the job name. The error returned by job,
+// or its panic, is reported on the trace and the outcome, either "success" or
+// "failure", is reported by the JobOutcome KV of the exit event. The duration
+// of the job is aggregated into the JobDuration metric tagged by the job name
+// and outcome, and the events and metrics are flushed before RunJob returns,
+// within 5 seconds, as the process of a job is usually short-lived. It returns the error of job, and a
+// panic of job is re-panicked after the trace is ended.
+func RunJob(ctx context.Context, name string, job func(ctx context.Context) error) (err error) {
+	if Disabled() {
+		return job(ctx)
+	}
+
+	t, ctx := beginTrace(ctx, name, TraceOriginJob)
+	t.SetTransactionName(name)
+	start := time.Now()
+
+	panicked := true
+	defer func() {
+		outcome := reporter.JobOutcomeSuccess
+		if panicked {
+			outcome = reporter.JobOutcomeFailure
+			if r := recover(); r != nil {
+				t.Error("panic", fmt.Sprintf("%v", r))
+				defer panic(r)
+			}
+		} else if err != nil {
+			outcome = reporter.JobOutcomeFailure
+			t.Err(err)
+		}
+		t.End(keyJobName, name, keyJobOutcome, outcome)
+		reporter.RecordJob(name, roundDuration(time.Since(start)), outcome)
+
+		flushCtx, cancel := context.WithTimeout(context.Background(), jobFlushTimeout)
+		defer cancel()
+		reporter.FlushEvents(flushCtx)
+		FlushMetrics(flushCtx)
+	}()
+
+	err = job(ctx)
+	panicked = false
+	return err
+}
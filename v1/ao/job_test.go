@@ -0,0 +1,125 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunJob(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+
+	err := ao.RunJob(context.Background(), "cleanup", func(ctx context.Context) error {
+		assert.True(t, ao.IsSampled(ctx))
+		l, _ := ao.BeginSpan(ctx, "db")
+		l.End()
+		return nil
+	})
+	assert.Nil(t, err)
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"cleanup", "entry"}: {},
+		{"db", "entry"}:      {Edges: g.Edges{{"cleanup", "entry"}}},
+		{"db", "exit"}:       {Edges: g.Edges{{"db", "entry"}}},
+		{"cleanup", "exit"}: {Edges: g.Edges{{"db", "exit"}, {"cleanup", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "cleanup", n.Map["JobName"])
+			assert.Equal(t, "success", n.Map["JobOutcome"])
+			assert.Equal(t, "cleanup", n.Map["TransactionName"])
+		}},
+	})
+}
+
+func TestRunJobFailure(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+
+	jobErr := errors.New("disk full")
+	err := ao.RunJob(context.Background(), "backup", func(ctx context.Context) error {
+		return jobErr
+	})
+	assert.Equal(t, jobErr, err)
+
+	r.Close(3)
+	g.AssertGraph(t, r.EventBufs, 3, g.AssertNodeMap{
+		{"backup", "entry"}: {},
+		{"backup", "error"}: {Edges: g.Edges{{"backup", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "error", n.Map["ErrorClass"])
+			assert.Equal(t, "disk full", n.Map["ErrorMsg"])
+		}},
+		{"backup", "exit"}: {Edges: g.Edges{{"backup", "error"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "failure", n.Map["JobOutcome"])
+		}},
+	})
+}
+
+func TestRunJobPanic(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+
+	assert.PanicsWithValue(t, "boom", func() {
+		ao.RunJob(context.Background(), "report", func(ctx context.Context) error {
+			panic("boom")
+		})
+	})
+
+	r.Close(3)
+	g.AssertGraph(t, r.EventBufs, 3, g.AssertNodeMap{
+		{"report", "entry"}: {},
+		{"report", "error"}: {Edges: g.Edges{{"report", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "panic", n.Map["ErrorClass"])
+			assert.Equal(t, "boom", n.Map["ErrorMsg"])
+		}},
+		{"report", "exit"}: {Edges: g.Edges{{"report", "error"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "failure", n.Map["JobOutcome"])
+		}},
+	})
+}
+
+func TestRunJobOriginSampleRate(t *testing.T) {
+	defer config.Load()
+
+	// the HTTP-origin trace is sampled, the job-origin one is not
+	config.Load(config.WithOriginSampleRates(map[string]int{"http": 1000000, "job": 0}))
+	r := reporter.SetTestReporter(reporter.TestReporterSampleRate(0))
+
+	httpTest(handler404)
+	err := ao.RunJob(context.Background(), "sync", func(ctx context.Context) error {
+		assert.False(t, ao.IsSampled(ctx))
+		return nil
+	})
+	assert.Nil(t, err)
+
+	r.Close(3)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"http.HandlerFunc", "entry"}: {Callback: func(n g.Node) {
+			assert.Equal(t, 1000000, n.Map["SampleRate"])
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}},
+	})
+
+	// the job-origin trace is sampled, the HTTP-origin one is not
+	config.Load(config.WithOriginSampleRates(map[string]int{"http": 0, "job": 1000000}))
+	r = reporter.SetTestReporter(reporter.TestReporterSampleRate(0))
+
+	httpTest(handler404)
+	err = ao.RunJob(context.Background(), "sync", func(ctx context.Context) error {
+		assert.True(t, ao.IsSampled(ctx))
+		return nil
+	})
+	assert.Nil(t, err)
+
+	r.Close(3)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"sync", "entry"}: {Callback: func(n g.Node) {
+			assert.Equal(t, 1000000, n.Map["SampleRate"])
+		}},
+		{"sync", "exit"}: {Edges: g.Edges{{"sync", "entry"}}},
+	})
+}
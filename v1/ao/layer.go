@@ -6,10 +6,17 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math/rand"
+	"reflect"
 	"runtime"
 	"runtime/debug"
+	"strings"
 	"sync"
+	"time"
+	"unicode"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	aolog "github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 )
 
@@ -45,9 +52,17 @@ const (
 	keyHTTPHost        = "HTTP-Host"
 	keyURL             = "URL"
 	keyRemoteHost      = "Remote-Host"
+	keyClientIP        = "ClientIP"
 	keyQueryString     = "Query-String"
 	keyRemoteStatus    = "RemoteStatus"
 	keyContentLength   = "ContentLength"
+	keyQueueTime       = "QueueTime"
+	keyRequestID       = "Request-ID"
+	keySpanStatus      = "SpanStatus"
+	keyErrorCount      = "ErrorCount"
+
+	keyRequestHeaderPrefix  = "Request-Header-"
+	keyResponseHeaderPrefix = "Response-Header-"
 )
 
 // Span is used to measure a span of time associated with an activity
@@ -67,6 +82,11 @@ type Span interface {
 	// AddEndArgs adds additional KV pairs that will be serialized (and
 	// dereferenced, for pointer values) at the end of this trace's span.
 	AddEndArgs(args ...interface{})
+	// AddLazyKV adds a KV reported at the end of this Span, whose value is
+	// computed by calling fn only if the Span is sampled and kept, e.g., not
+	// folded or merged, so no expensive value is computed for nothing. The fn
+	// is called while the Span is ending and must not call its methods.
+	AddLazyKV(key string, fn func() interface{})
 
 	// Info reports KV pairs provided by args for this Span.
 	Info(args ...interface{})
@@ -141,10 +161,101 @@ func WithBackTrace() SpanOpt {
 
 // BeginSpan starts a new Span, provided a parent context and name. It returns a Span
 // and context bound to the new child Span.
+//
+// The identity of the parent is captured when the span is started, so a span
+// started before its parent ends, e.g., in a handler, can be passed to and
+// ended by a goroutine which outlives the parent, and is still reported as the
+// child of it. The span must be started before the goroutine is spawned though,
+// as no span can be started from the context of an ended span.
 func BeginSpan(ctx context.Context, spanName string, args ...interface{}) (Span, context.Context) {
 	return BeginSpanWithOptions(ctx, spanName, SpanOptions{}, args...)
 }
 
+// BeginSpanf starts a new Span named by formatting the arguments according to
+// the format specifier, e.g., BeginSpanf(ctx, "query-%s", table). It returns a
+// Span and context bound to the new child Span.
+//
+// The span names should be of low cardinality. A warning is logged, once per
+// format for up to 100 formats, if the name looks like containing an ID, which
+// should be reported as a KV pair instead.
+func BeginSpanf(ctx context.Context, format string, args ...interface{}) (Span, context.Context) {
+	spanName := fmt.Sprintf(format, args...)
+	if highCardinalityName(spanName) {
+		spanfWarned.warn(format, spanName)
+	}
+	return BeginSpan(ctx, spanName)
+}
+
+// the maximum number of formats warned of high cardinality span names, the
+// formats beyond it are not warned.
+const spanfWarnedMax = 100
+
+// spanfWarnings records the formats which have been warned of high cardinality
+// span names, bounded by spanfWarnedMax.
+type spanfWarnings struct {
+	sync.Mutex
+	formats map[string]struct{}
+}
+
+var spanfWarned = &spanfWarnings{formats: make(map[string]struct{})}
+
+func (w *spanfWarnings) warn(format, spanName string) {
+	w.Lock()
+	_, warned := w.formats[format]
+	full := len(w.formats) >= spanfWarnedMax
+	if !warned && !full {
+		w.formats[format] = struct{}{}
+	}
+	last := len(w.formats) == spanfWarnedMax
+	w.Unlock()
+	if warned || full {
+		return
+	}
+
+	aolog.Warningf("Span name %q (format %q) may be of high cardinality, "+
+		"consider reporting the IDs as KV pairs instead.", spanName, format)
+	if last {
+		aolog.Warningf("%d span name formats warned of high cardinality, the others are not warned.",
+			spanfWarnedMax)
+	}
+}
+
+const (
+	// the minimum length of a number to be considered as an ID
+	idMinDigits = 3
+	// the minimum length of a hex string (containing both digits and letters)
+	// to be considered as an ID, e.g., a UUID segment or a hash.
+	idMinHexLen = 8
+)
+
+// highCardinalityName checks if the span name contains something which looks
+// like an ID, e.g., a number or a hex string.
+func highCardinalityName(name string) bool {
+	tokens := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, token := range tokens {
+		digits, hex, letters := 0, true, 0
+		for _, r := range token {
+			switch {
+			case r >= '0' && r <= '9':
+				digits++
+			case (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F'):
+				letters++
+			default:
+				hex = false
+			}
+		}
+		if digits == len(token) && digits >= idMinDigits {
+			return true
+		}
+		if hex && digits > 0 && letters > 0 && len(token) >= idMinHexLen {
+			return true
+		}
+	}
+	return false
+}
+
 // addKVsFromOpts adds the KVs correspond to the options to the args
 func addKVsFromOpts(opts SpanOptions, args ...interface{}) []interface{} {
 	kvs := args
@@ -181,10 +292,22 @@ func fromKVs(kvs ...interface{}) KVMap {
 
 // BeginSpanWithOptions starts a span with provided options
 func BeginSpanWithOptions(ctx context.Context, spanName string, opts SpanOptions, args ...interface{}) (Span, context.Context) {
-	kvs := addKVsFromOpts(opts, args...)
+	if tracingDisabled(ctx) {
+		return nullSpan{}, ctx
+	}
 	if parent, ok := fromContext(ctx); ok && parent.ok() { // report span entry from parent context
+		if p, ok := parent.(unsampledParent); ok && !parent.IsSampled() {
+			l := p.unsampledChild()
+			return l, l.context(ctx)
+		}
+		kvs := addKVsFromOpts(opts, args...)
 		l := newSpan(parent.aoContext().Copy(), spanName, parent, kvs...)
+		if ls, ok := l.(*layerSpan); ok {
+			ls.ctxErr = ctx.Err
+		}
 		return l, newSpanContext(ctx, l)
+	} else if l, ok := parent.(*unsampledSpan); ok {
+		return l, ctx
 	}
 	return nullSpan{}, ctx
 }
@@ -197,8 +320,11 @@ func (s *layerSpan) BeginSpan(spanName string, args ...interface{}) Span {
 // BeginSpanWithOptions starts a new child span with provided options
 func (s *layerSpan) BeginSpanWithOptions(spanName string, opts SpanOptions, args ...interface{}) Span {
 	if s.ok() { // copy parent context and report entry from child
+		if !s.aoCtx.IsSampled() {
+			return s.unsampledChild()
+		}
 		kvs := addKVsFromOpts(opts, args...)
-		return newSpan(s.aoCtx.Copy(), spanName, s, kvs...)
+		return newSpan(s.aoContext().Copy(), spanName, s, kvs...)
 	}
 	return nullSpan{}
 }
@@ -210,7 +336,10 @@ func (s *layerSpan) BeginSpanWithOptions(spanName string, opts SpanOptions, args
 //       // ... do something ...
 //    }
 func BeginProfile(ctx context.Context, profileName string, args ...interface{}) Profile {
-	if parent, ok := fromContext(ctx); ok && parent.ok() { // report profile entry from parent context
+	if tracingDisabled(ctx) {
+		return nullSpan{}
+	}
+	if parent, ok := fromContext(ctx); ok && parent.ok() && parent.IsSampled() { // report profile entry from parent context
 		return newProfile(parent.aoContext().Copy(), profileName, parent, args...)
 	}
 	return nullSpan{}
@@ -219,8 +348,8 @@ func BeginProfile(ctx context.Context, profileName string, args ...interface{})
 // BeginProfile starts a new Profile, used to measure a named span of time spent in this Span.
 // The returned Profile should be closed with End().
 func (s *layerSpan) BeginProfile(profileName string, args ...interface{}) Profile {
-	if s.ok() { // copy parent context and report entry from child
-		return newProfile(s.aoCtx.Copy(), profileName, s, args...)
+	if s.sampled() { // copy parent context and report entry from child
+		return newProfile(s.aoContext().Copy(), profileName, s, args...)
 	}
 	return nullSpan{}
 }
@@ -230,10 +359,39 @@ func (s *span) End(args ...interface{}) {
 	if s.ok() {
 		s.lock.Lock()
 		defer s.lock.Unlock()
+		// the span may have been ended by another goroutine after the check
+		// of s.ok(), so it's checked again under the lock.
+		if s.ended {
+			return
+		}
+		reporter.AddActiveSpans(-1)
+		// a cancelled span is neither folded nor merged, so it stands out
+		status := s.cancelStatusLocked()
+		if s.entry != nil {
+			end := time.Now()
+			if s.entry.err == nil && len(s.dedupErrors) == 0 && status == "" &&
+				end.Sub(s.entry.start) < s.settings.minSpanDuration {
+				s.fold()
+				return
+			}
+			if s.settings.mergeSiblingSpans && status == "" && s.mergeIntoParent(end, append(args, s.endArgs...)) {
+				return
+			}
+			s.reportEntryLocked()
+		}
+		s.reportMergedLocked()
+		s.reportDedupErrorsLocked()
 		for _, prof := range s.childProfiles {
-			prof.End()
+			// the profiles may have been ended explicitly
+			if p, ok := prof.(*profileSpan); !ok || p.ok() {
+				prof.End()
+			}
 		}
 		args = append(args, s.endArgs...)
+		args = append(args, s.lazyKVArgsLocked()...)
+		if status != "" {
+			args = append(args, keySpanStatus, status)
+		}
 		for _, edge := range s.childEdges { // add Edge KV for each joined child
 			args = append(args, keyEdge, edge)
 		}
@@ -245,6 +403,26 @@ func (s *span) End(args ...interface{}) {
 		if s.parent != nil && s.parent.ok() {
 			s.parent.addChildEdge(s.aoCtx)
 		}
+	} else {
+		s.warnEnded()
+	}
+}
+
+// warnEnded logs a warning, once per span, when an ended span is ended again.
+// The call is ignored so no duplicate exit event is reported.
+func (s *span) warnEnded() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ended && !s.endWarned {
+		s.endWarned = true
+		name := s.layerName()
+		if l, ok := s.labeler.(profileLabeler); ok {
+			name = l.name
+		}
+		aolog.Warningf("Span %q is ended more than once, ignored.", name)
 	}
 }
 
@@ -269,8 +447,10 @@ func (s *layerSpan) Info(args ...interface{}) {
 
 // InfoWithOptions reports a new info event with the KVs and options provided
 func (s *layerSpan) InfoWithOptions(opts SpanOptions, args ...interface{}) {
-	if s.ok() {
+	if s.sampled() {
 		kvs := addKVsFromOpts(opts, args...)
+		s.reportEntry()
+		s.reportMerged()
 		s.aoCtx.ReportEvent(reporter.LabelInfo, s.layerName(), kvs...)
 	}
 }
@@ -279,7 +459,7 @@ func (s *layerSpan) InfoWithOptions(opts SpanOptions, args ...interface{}) {
 // tracing (to create a remote child span). If the Span has ended, an empty string is returned.
 func (s *layerSpan) MetadataString() string {
 	if s.ok() {
-		return s.aoCtx.MetadataString()
+		return s.aoContext().MetadataString()
 	}
 	return ""
 }
@@ -328,20 +508,32 @@ func (s *span) GetTransactionName() string {
 
 // Error reports an error, distinguished by its class and message
 func (s *span) Error(class, msg string) {
-	if s.ok() {
-		s.aoCtx.ReportEvent(reporter.LabelError, s.layerName(),
-			keySpec, "error",
-			keyErrorClass, class,
-			keyErrorMsg, msg,
-			KeyBackTrace, string(debug.Stack()))
+	if s.sampled() {
+		e := &spanError{class: class, msg: msg, backTrace: string(debug.Stack()), at: time.Now()}
+		if s.deferError(e) || s.dedupError(e) {
+			return
+		}
+		s.reportEntry()
+		s.reportMerged()
+		s.aoCtx.ReportEvent(reporter.LabelError, s.layerName(), e.kvs()...)
 	}
 }
 
-// Err reports the provided error type
+// Err reports the provided error type. A cancellation error, i.e.,
+// context.Canceled or context.DeadlineExceeded, is not reported as an error
+// but by the SpanStatus KV of the exit event, see SpanStatusCancelled.
 func (s *span) Err(err error) {
 	if err == nil {
 		return
 	}
+	if cancelStatus(err) != "" {
+		if s.ok() {
+			s.lock.Lock()
+			s.cancelErr = err
+			s.lock.Unlock()
+		}
+		return
+	}
 	s.Error("error", err.Error())
 }
 
@@ -354,7 +546,17 @@ type span struct {
 	childEdges    []reporter.Context // for reporting in exit event
 	childProfiles []Profile
 	endArgs       []interface{}
-	ended         bool // has exit event been reported?
+	ended         bool           // has exit event been reported?
+	endWarned     bool           // has the span been warned of ending more than once?
+	depth         int            // the depth of the span in the trace, 0 for the root span
+	unsampled     *unsampledSpan // the child span shared if the span is not sampled
+	entry         *deferredEntry // the entry event not reported yet, see newSpan
+	merged        *mergedSpans   // the run of the child spans being merged, if any
+	lazyKVs       []lazyKV       // the KVs computed when the exit event is reported
+	settings      *traceSettings // the settings captured when the trace started
+	ctxErr        func() error   // the Err method of the context the span is started with, if any
+	cancelErr     error          // the cancellation error reported by Err, if any
+	dedupErrors   []*spanError   // the errors reported when the span ends, see errorDedup
 	lock          sync.RWMutex
 }
 type layerSpan struct{ span }   // satisfies Span
@@ -368,6 +570,7 @@ func (s nullSpan) BeginSpanWithOptions(spanName string, opts SpanOptions, args .
 func (s nullSpan) BeginProfile(name string, args ...interface{}) Profile { return nullSpan{} }
 func (s nullSpan) End(args ...interface{})                               {}
 func (s nullSpan) AddEndArgs(args ...interface{})                        {}
+func (s nullSpan) AddLazyKV(key string, fn func() interface{})           {}
 func (s nullSpan) Error(class, msg string)                               {}
 func (s nullSpan) Err(err error)                                         {}
 func (s nullSpan) Info(args ...interface{})                              {}
@@ -384,6 +587,66 @@ func (s nullSpan) SetOperationName(string)                               {}
 func (s nullSpan) SetTransactionName(string) error                       { return nil }
 func (s nullSpan) GetTransactionName() string                            { return "" }
 
+// unsampledSpan is the child span of an unsampled span. As the events of an
+// unsampled trace are never reported, it's a no-op except that it carries the
+// context of the trace, for propagating the unsampled decision downstream and
+// naming the transaction. It's shared by all the descendants of the parent to
+// start them without allocations.
+type unsampledSpan struct {
+	nullSpan
+	aoCtx   reporter.Context
+	lock    sync.Mutex
+	ctx     context.Context // the last context the span is started from
+	spanCtx context.Context // the context bound to the span, derived from ctx
+}
+
+// unsampledParent is a span which starts an unsampledSpan as its child if the
+// span is not sampled.
+type unsampledParent interface {
+	unsampledChild() *unsampledSpan
+}
+
+func (s *unsampledSpan) BeginSpan(spanName string, args ...interface{}) Span { return s }
+func (s *unsampledSpan) BeginSpanWithOptions(spanName string, opts SpanOptions, args ...interface{}) Span {
+	return s
+}
+func (s *unsampledSpan) aoContext() reporter.Context { return s.aoCtx }
+func (s *unsampledSpan) MetadataString() string      { return s.aoCtx.MetadataString() }
+func (s *unsampledSpan) GetTransactionName() string  { return s.aoCtx.GetTransactionName() }
+func (s *unsampledSpan) SetTransactionName(name string) error {
+	if name == "" || len(name) > MaxCustomTransactionNameLength {
+		return errTransactionNameLength
+	}
+	s.aoCtx.SetTransactionName(name)
+	return nil
+}
+
+// context returns the context bound to the span. The context derived from the
+// last parent context is reused, as the spans are usually started from the
+// same context, e.g., the one of a request.
+func (s *unsampledSpan) context(ctx context.Context) context.Context {
+	if ctx == nil || !reflect.TypeOf(ctx).Comparable() {
+		return newSpanContext(ctx, s)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ctx != ctx {
+		s.ctx, s.spanCtx = ctx, newSpanContext(ctx, s)
+	}
+	return s.spanCtx
+}
+
+// unsampledChild returns the child span of an unsampled span, which is created
+// on the first call.
+func (s *span) unsampledChild() *unsampledSpan {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.unsampled == nil {
+		s.unsampled = &unsampledSpan{aoCtx: s.aoCtx}
+	}
+	return s.unsampled
+}
+
 // is this span still valid (has it timed out, expired, not sampled)
 func (s *span) ok() bool {
 	if s == nil {
@@ -393,13 +656,72 @@ func (s *span) ok() bool {
 	defer s.lock.RUnlock()
 	return !s.ended
 }
-func (s *span) IsReporting() bool           { return s.ok() }
-func (s *span) aoContext() reporter.Context { return s.aoCtx }
+func (s *span) IsReporting() bool { return s.ok() }
+
+// sampled checks if the events of the span are reported, i.e., the span is
+// still valid and sampled.
+func (s *span) sampled() bool {
+	if s == nil {
+		return false
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return !s.ended && s.aoCtx.IsSampled()
+}
+func (s *span) aoContext() reporter.Context {
+	s.reportEntry()
+	return s.aoCtx
+}
 
-// addChildEdge keeps track of edges to closed child spans
+// deferredEntry is the entry event of a span which may be folded into its
+// parent if it's shorter than the minimum span duration, or merged with its
+// siblings.
+type deferredEntry struct {
+	args  []interface{}
+	start time.Time
+	err   *spanError // the error of the span not reported yet, if any
+}
+
+// reportEntry reports the deferred entry event of the span, if any. It must be
+// called before any other event of the span or its children is reported, as
+// they are the successors of the entry event.
+func (s *span) reportEntry() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reportEntryLocked()
+}
+
+func (s *span) reportEntryLocked() {
+	if s.entry == nil {
+		return
+	}
+	args := append([]interface{}{reporter.TimestampKey, s.entry.start}, s.entry.args...)
+	_ = s.aoCtx.ReportEvent(s.entryLabel(), s.layerName(), args...)
+	if e := s.entry.err; e != nil {
+		_ = s.aoCtx.ReportEvent(reporter.LabelError, s.layerName(), e.kvs()...)
+	}
+	s.entry = nil
+}
+
+// fold ends the span without reporting any event, as if it's part of its parent.
+func (s *span) fold() {
+	s.entry = nil
+	s.endArgs = nil
+	s.lazyKVs = nil
+	s.ended = true
+	reporter.RecordSpanFolded()
+}
+
+// addChildEdge keeps track of edges to closed child spans. The edges of the
+// children ending after the span are dropped, as its exit event is reported
+// already. The ended flag is checked again under the lock as the span may be
+// ended by another goroutine after the child checked it.
 func (s *span) addChildEdge(ctx reporter.Context) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
+	if s.ended {
+		return
+	}
 	s.childEdges = append(s.childEdges, ctx)
 }
 func (s *span) addProfile(p Profile) {
@@ -429,14 +751,59 @@ func (l profileLabeler) layerName() string          { return "" }
 func (l profileLabeler) setName(name string)        { l.name = name }
 
 func newSpan(aoCtx reporter.Context, spanName string, parent Span, args ...interface{}) Span {
+	if config.IsBlockedSpan(spanName) {
+		return nullSpan{}
+	}
+	depth := spanDepth(parent) + 1
+	settings := settingsOf(parent)
+	if aoCtx.IsSampled() && !settings.sampleChildSpan() {
+		return nullSpan{}
+	}
 	ll := spanLabeler{spanName}
+	if aoCtx.IsSampled() && settings.deferEntry() {
+		// the entry event is not reported until the span lasts longer than
+		// the minimum duration and is not merged with its siblings, or an
+		// event of the span or its children is reported.
+		reporter.AddActiveSpans(1)
+		return &layerSpan{span: span{aoCtx: aoCtx, labeler: ll, parent: parent, depth: depth,
+			settings: settings, entry: &deferredEntry{args: args, start: time.Now()}}}
+	}
 	if err := aoCtx.ReportEvent(ll.entryLabel(), ll.layerName(), args...); err != nil {
 		return nullSpan{}
 	}
-	return &layerSpan{span: span{aoCtx: aoCtx.Copy(), labeler: ll, parent: parent}}
+	reporter.AddActiveSpans(1)
+	return &layerSpan{span: span{aoCtx: aoCtx.Copy(), labeler: ll, parent: parent, depth: depth,
+		settings: settings}}
+
+}
 
+// PropagationMetadata returns the metadata string of the Span to be injected
+// into an outbound request. An empty string is returned if the Span is not
+// sampled and the propagation of unsampled contexts is disabled, in which case
+// nothing should be injected.
+func PropagationMetadata(s Span) string {
+	if s == nil || (!s.IsSampled() && !config.GetPropagateUnsampled()) {
+		return ""
+	}
+	return s.MetadataString()
+}
+
+// spanDepth returns the depth of the span in the trace.
+func spanDepth(s Span) int {
+	switch sp := s.(type) {
+	case *layerSpan:
+		return sp.depth
+	case *aoTrace:
+		return sp.depth
+	default:
+		return 0
+	}
 }
 
+// the random number generator of the depth-aware span sampling, which can be
+// overridden for testing.
+var spanDepthRand = rand.Float64
+
 func newProfile(aoCtx reporter.Context, profileName string, parent Span, args ...interface{}) Profile {
 	var fname string
 	pc, file, line, ok := runtime.Caller(2) // Caller(1) is BeginProfile
@@ -451,8 +818,9 @@ func newProfile(aoCtx reporter.Context, profileName string, parent Span, args ..
 	); err != nil {
 		return nullSpan{}
 	}
-	p := &profileSpan{span{aoCtx: aoCtx.Copy(), labeler: pl, parent: parent,
+	p := &profileSpan{span{aoCtx: aoCtx.Copy(), labeler: pl, parent: parent, settings: settingsOf(parent),
 		endArgs: []interface{}{keyLanguage, "go", keyProfileName, profileName}}}
+	reporter.AddActiveSpans(1)
 	if parent != nil && parent.ok() {
 		parent.addProfile(p)
 	}
@@ -1,12 +1,20 @@
 package ao
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"log"
+	"math/rand"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	"github.com/stretchr/testify/assert"
 	"gopkg.in/mgo.v2/bson"
@@ -188,8 +196,8 @@ func TestWithTransactionFiltering(t *testing.T) {
 
 	// Reload config with transaction filtering settings
 	reporter.ReloadURLsConfig([]config.TransactionFilter{
-		{"url", `test\d{1}`, nil, "disabled"},
-		{"url", "", []string{"jpg"}, "disabled"},
+		{"url", `test\d{1}`, nil, "disabled", nil},
+		{"url", "", []string{"jpg"}, "disabled", nil},
 	})
 
 	// 2. “disabled” transaction settings not matched
@@ -263,8 +271,8 @@ func TestWithTransactionFiltering(t *testing.T) {
 
 	// service level trace mode is disabled
 	reporter.ReloadURLsConfig([]config.TransactionFilter{
-		{"url", `test\d{1}`, nil, "enabled"},
-		{"url", "", []string{"jpg"}, "enabled"},
+		{"url", `test\d{1}`, nil, "enabled", nil},
+		{"url", "", []string{"jpg"}, "enabled", nil},
 	})
 
 	// 9.“enabled” transaction settings not matched
@@ -320,3 +328,408 @@ func TestWithTransactionFiltering(t *testing.T) {
 	config.Load()
 	reporter.ReloadURLsConfig([]config.TransactionFilter{})
 }
+
+func TestBeginSpanf(t *testing.T) {
+	r := reporter.SetTestReporter()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	ctx := NewContext(context.Background(), NewTrace("test"))
+	s, _ := BeginSpanf(ctx, "query-%s-%d", "users", 1)
+	assert.Equal(t, "query-users-1", s.(*layerSpan).layerName())
+	s.End()
+	assert.Empty(t, buf.String())
+
+	for _, id := range []int{123, 456} {
+		s, _ = BeginSpanf(ctx, "user-%d", id)
+		s.End()
+	}
+	assert.Equal(t, 1, strings.Count(buf.String(), "may be of high cardinality"))
+	assert.Contains(t, buf.String(), `"user-123"`)
+	EndTrace(ctx)
+
+	r.Close(8)
+
+	// the warned formats are bounded
+	buf.Reset()
+	for i := 0; i < 2*spanfWarnedMax; i++ {
+		spanfWarned.warn(fmt.Sprintf("format-%d-%%d", i), "name-123")
+	}
+	assert.Len(t, spanfWarned.formats, spanfWarnedMax)
+	assert.Equal(t, spanfWarnedMax-1, strings.Count(buf.String(), "may be of high cardinality"))
+	assert.Equal(t, 1, strings.Count(buf.String(), "the others are not warned"))
+}
+
+func TestHighCardinalityName(t *testing.T) {
+	testCases := []struct {
+		name string
+		high bool
+	}{
+		{"query", false},
+		{"http2-client", false},
+		{"v1.users.get", false},
+		{"s3-upload", false},
+		{"deadbeef", false},
+		{"user-123", true},
+		{"order/20190101/items", true},
+		{"session 123e4567-e89b-12d3-a456-426655440000", true},
+		{"commit-5f3a9c1b", true},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.high, highCardinalityName(tc.name), tc.name)
+	}
+}
+
+func TestSpanDepthDecay(t *testing.T) {
+	os.Setenv("APPOPTICS_SPAN_DEPTH_DECAY", "0.5")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SPAN_DEPTH_DECAY")
+		config.Load()
+		spanDepthRand = rand.Float64
+	}()
+
+	// deeper spans are sampled less often, a span is only sampled if its
+	// parent is
+	spanDepthRand = rand.New(rand.NewSource(1)).Float64
+	var kept [4]int
+	for i := 0; i < 10000; i++ {
+		for depth := 1; depth < len(kept) && newTraceSettings().sampleChildSpan(); depth++ {
+			kept[depth]++
+		}
+	}
+	assert.InDelta(t, 5000, kept[1], 200)
+	assert.InDelta(t, 2500, kept[2], 200)
+	assert.InDelta(t, 1250, kept[3], 200)
+
+	// a span not reported drops its subtree
+	r := reporter.SetTestReporter()
+	draws := []float64{0.4, 0.6}
+	spanDepthRand = func() float64 {
+		x := draws[0]
+		draws = draws[1:]
+		return x
+	}
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+	child, ctx := BeginSpan(ctx, "child")
+	assert.True(t, child.IsReporting())
+	grandchild, ctx := BeginSpan(ctx, "grandchild")
+	assert.False(t, grandchild.IsReporting())
+	greatGrandchild, _ := BeginSpan(ctx, "great-grandchild")
+	assert.False(t, greatGrandchild.IsReporting())
+	greatGrandchild.End()
+	grandchild.End()
+	child.End()
+	tr.End()
+
+	r.Close(4)
+	assert.Len(t, r.EventBufs, 4)
+}
+
+func TestBlockedSpans(t *testing.T) {
+	os.Setenv("APPOPTICS_BLOCKED_SPANS", "keepalive,grpc.health.*")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_BLOCKED_SPANS")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+	keepalive, kctx := BeginSpan(ctx, "keepalive")
+	assert.False(t, keepalive.IsReporting())
+	// the children of a blocked span are dropped too
+	child, _ := BeginSpan(kctx, "child")
+	assert.False(t, child.IsReporting())
+	child.End()
+	keepalive.End()
+	health := tr.BeginSpan("grpc.health.Check")
+	assert.False(t, health.IsReporting())
+	health.End()
+	query, _ := BeginSpan(ctx, "query")
+	assert.True(t, query.IsReporting())
+	query.End()
+	tr.End()
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"root", "entry"}:  {},
+		{"query", "entry"}: {Edges: g.Edges{{"root", "entry"}}},
+		{"query", "exit"}:  {Edges: g.Edges{{"query", "entry"}}},
+		{"root", "exit"}:   {Edges: g.Edges{{"query", "exit"}, {"root", "entry"}}},
+	})
+}
+
+func TestActiveTracesAndSpans(t *testing.T) {
+	_ = reporter.SetTestReporter()
+	traces, spans := reporter.ActiveTracesAndSpans()
+	active := func() [2]int64 {
+		t, s := reporter.ActiveTracesAndSpans()
+		return [2]int64{t - traces, s - spans}
+	}
+
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+	parent, pctx := BeginSpan(ctx, "parent")
+	child, _ := BeginSpan(pctx, "child")
+	leaked, _ := BeginSpan(ctx, "leaked")
+	assert.Equal(t, [2]int64{1, 3}, active())
+	// a profile is an active span as well
+	profile := BeginProfile(pctx, "profile")
+	assert.Equal(t, [2]int64{1, 4}, active())
+	profile.End()
+	assert.Equal(t, [2]int64{1, 3}, active())
+
+	child.End()
+	child.End() // ended only once
+	parent.End()
+	tr.End()
+	// the span not ended is still active
+	assert.Equal(t, [2]int64{0, 1}, active())
+
+	leaked.End()
+	assert.Equal(t, [2]int64{0, 0}, active())
+}
+
+func TestAddChildEdgeAfterEnd(t *testing.T) {
+	r := reporter.SetTestReporter()
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+	parent, pctx := BeginSpan(ctx, "parent")
+	child, _ := BeginSpan(pctx, "child")
+
+	// the child checks the parent is not ended before the parent ends, and
+	// then adds its edge to the parent
+	parent.End()
+	parent.addChildEdge(child.aoContext())
+	assert.Empty(t, parent.(*layerSpan).childEdges)
+
+	child.End()
+	tr.End()
+	r.Close(6)
+	g.AssertGraph(t, r.EventBufs, 6, g.AssertNodeMap{
+		{"root", "entry"}:   {},
+		{"parent", "entry"}: {Edges: g.Edges{{"root", "entry"}}},
+		{"child", "entry"}:  {Edges: g.Edges{{"parent", "entry"}}},
+		{"parent", "exit"}:  {Edges: g.Edges{{"parent", "entry"}}},
+		{"child", "exit"}:   {Edges: g.Edges{{"child", "entry"}}},
+		{"root", "exit"}:    {Edges: g.Edges{{"parent", "exit"}, {"root", "entry"}}},
+	})
+}
+
+func TestEndTwice(t *testing.T) {
+	r := reporter.SetTestReporter()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	tr := NewTrace("test")
+	ctx := NewContext(context.Background(), tr)
+	s, ctx := BeginSpan(ctx, "child")
+	p := BeginProfile(ctx, "profile")
+	p.End()
+	s.End()
+	s.End()
+	s.End()
+	p.End()
+	tr.End()
+	tr.End()
+
+	r.Close(6)
+	assert.Len(t, r.EventBufs, 6)
+	g.AssertGraph(t, r.EventBufs, 6, g.AssertNodeMap{
+		{"test", "entry"}:     {},
+		{"child", "entry"}:    {Edges: g.Edges{{"test", "entry"}}},
+		{"", "profile_entry"}: {Edges: g.Edges{{"child", "entry"}}},
+		{"", "profile_exit"}:  {Edges: g.Edges{{"", "profile_entry"}}},
+		{"child", "exit"}:     {Edges: g.Edges{{"", "profile_exit"}, {"child", "entry"}}},
+		{"test", "exit"}:      {Edges: g.Edges{{"child", "exit"}, {"test", "entry"}}},
+	})
+	// warned once per span
+	assert.Equal(t, 1, strings.Count(buf.String(), `Span "child" is ended more than once`))
+	assert.Equal(t, 1, strings.Count(buf.String(), `Span "profile" is ended more than once`))
+	assert.Equal(t, 1, strings.Count(buf.String(), `Span "test" is ended more than once`))
+}
+
+func TestEndConcurrently(t *testing.T) {
+	r := reporter.SetTestReporter()
+	tr := NewTrace("test")
+	ctx := NewContext(context.Background(), tr)
+	s, _ := BeginSpan(ctx, "child")
+	_, before := reporter.ActiveTracesAndSpans()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.End()
+		}()
+	}
+	wg.Wait()
+	// the span is only counted as ended once
+	_, after := reporter.ActiveTracesAndSpans()
+	assert.Equal(t, before-1, after)
+	tr.End()
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"test", "entry"}:  {},
+		{"child", "entry"}: {Edges: g.Edges{{"test", "entry"}}},
+		{"child", "exit"}:  {Edges: g.Edges{{"child", "entry"}}},
+		{"test", "exit"}:   {Edges: g.Edges{{"child", "exit"}, {"test", "entry"}}},
+	})
+}
+
+// unsampledSpanOps starts and ends spans without KVs, as the variadic KVs may
+// be allocated by the caller, which is out of the control of the fast path.
+func unsampledSpanOps(ctx context.Context) {
+	s, sctx := BeginSpan(ctx, "child")
+	s.AddEndArgs()
+	s.Info()
+	s.Error("class", "msg")
+	c, _ := BeginSpan(sctx, "grandchild")
+	c.End()
+	BeginProfile(sctx, "profile").End()
+	s.End()
+}
+
+func TestUnsampledFastPath(t *testing.T) {
+	r := reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+	tr := NewTrace("test")
+	assert.False(t, tr.IsSampled())
+	ctx := NewContext(context.Background(), tr)
+
+	s, sctx := BeginSpan(ctx, "child")
+	assert.IsType(t, &unsampledSpan{}, s)
+	assert.False(t, s.IsReporting())
+	assert.Equal(t, s, FromContext(sctx))
+	// the unsampled context is still propagated
+	assert.Equal(t, tr.MetadataString(), PropagationMetadata(s))
+	c, cctx := BeginSpan(sctx, "grandchild")
+	assert.Equal(t, s, c)
+	assert.Equal(t, sctx, cctx)
+	assert.Equal(t, s, tr.BeginSpan("child"))
+	assert.NoError(t, s.SetTransactionName("txn"))
+	assert.Equal(t, "txn", tr.GetTransactionName())
+
+	assert.Zero(t, testing.AllocsPerRun(100, func() { unsampledSpanOps(ctx) }))
+	tr.End()
+
+	r.Close(0)
+	assert.Empty(t, r.EventBufs)
+}
+
+func BenchmarkBeginSpanUnsampled(b *testing.B) {
+	_ = reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+	ctx := NewContext(context.Background(), NewTrace("test"))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		unsampledSpanOps(ctx)
+	}
+}
+
+func TestMinSpanDuration(t *testing.T) {
+	os.Setenv("APPOPTICS_MIN_SPAN_DURATION", "10000")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_MIN_SPAN_DURATION")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+
+	// folded into the root
+	fast, _ := BeginSpan(ctx, "fast", "K", "V")
+	fast.AddEndArgs("K2", "V2")
+	fast.End()
+	assert.False(t, fast.IsReporting())
+
+	// kept for being longer than the threshold
+	slow, _ := BeginSpan(ctx, "slow", "K", "V")
+	time.Sleep(15 * time.Millisecond)
+	slow.End()
+
+	// kept for having events and children of its own
+	info, _ := BeginSpan(ctx, "info")
+	info.Info("K", "V")
+	info.End()
+	parent, pctx := BeginSpan(ctx, "parent")
+	child, _ := BeginSpan(pctx, "child")
+	child.End()
+	parent.End()
+	tr.End()
+
+	r.Close(9)
+	g.AssertGraph(t, r.EventBufs, 9, g.AssertNodeMap{
+		{"root", "entry"}: {},
+		{"slow", "entry"}: {Edges: g.Edges{{"root", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "V", n.Map["K"])
+		}},
+		{"slow", "exit"}:    {Edges: g.Edges{{"slow", "entry"}}},
+		{"info", "entry"}:   {Edges: g.Edges{{"root", "entry"}}},
+		{"info", "info"}:    {Edges: g.Edges{{"info", "entry"}}},
+		{"info", "exit"}:    {Edges: g.Edges{{"info", "info"}}},
+		{"parent", "entry"}: {Edges: g.Edges{{"root", "entry"}}},
+		{"parent", "exit"}:  {Edges: g.Edges{{"parent", "entry"}}},
+		{"root", "exit"}: {Edges: g.Edges{
+			{"slow", "exit"}, {"info", "exit"}, {"parent", "exit"}, {"root", "entry"}}},
+	})
+}
+
+func TestSpanCancelled(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	ctx := NewContext(context.Background(), NewTrace("cancelTrace"))
+	cctx, cancel := context.WithCancel(ctx)
+	s, _ := BeginSpan(cctx, "cancelled")
+	cancel()
+	s.End()
+
+	dctx, dcancel := context.WithDeadline(ctx, time.Now().Add(-time.Second))
+	defer dcancel()
+	s, _ = BeginSpan(dctx, "deadline")
+	s.End()
+
+	// a cancellation error is not reported as an error
+	s, _ = BeginSpan(ctx, "canceledErr")
+	s.Err(context.Canceled)
+	s.End()
+
+	s, _ = BeginSpan(ctx, "failed")
+	s.Err(errors.New("boom"))
+	s.End()
+	EndTrace(ctx)
+
+	r.Close(11)
+	g.AssertGraph(t, r.EventBufs, 11, g.AssertNodeMap{
+		{"cancelTrace", "entry"}: {},
+		{"cancelled", "entry"}:   {Edges: g.Edges{{"cancelTrace", "entry"}}},
+		{"cancelled", "exit"}: {Edges: g.Edges{{"cancelled", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, SpanStatusCancelled, n.Map["SpanStatus"])
+		}},
+		{"deadline", "entry"}: {Edges: g.Edges{{"cancelTrace", "entry"}}},
+		{"deadline", "exit"}: {Edges: g.Edges{{"deadline", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, SpanStatusDeadlineExceeded, n.Map["SpanStatus"])
+		}},
+		{"canceledErr", "entry"}: {Edges: g.Edges{{"cancelTrace", "entry"}}},
+		{"canceledErr", "exit"}: {Edges: g.Edges{{"canceledErr", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, SpanStatusCancelled, n.Map["SpanStatus"])
+		}},
+		{"failed", "entry"}: {Edges: g.Edges{{"cancelTrace", "entry"}}},
+		{"failed", "error"}: {Edges: g.Edges{{"failed", "entry"}}},
+		{"failed", "exit"}: {Edges: g.Edges{{"failed", "error"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "SpanStatus")
+		}},
+		{"cancelTrace", "exit"}: {Edges: g.Edges{{"cancelled", "exit"}, {"deadline", "exit"},
+			{"canceledErr", "exit"}, {"failed", "exit"}, {"cancelTrace", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "SpanStatus")
+		}},
+	})
+}
@@ -0,0 +1,41 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+// lazyKV is a KV whose value is computed only when the exit event of its span
+// is reported, see Span.AddLazyKV.
+type lazyKV struct {
+	key string
+	fn  func() interface{}
+}
+
+// AddLazyKV adds a KV reported by the exit event of the span, whose value is
+// computed by fn only if the span is sampled and kept.
+func (s *layerSpan) AddLazyKV(key string, fn func() interface{}) {
+	if fn == nil || !s.ok() || !s.aoCtx.IsSampled() {
+		return
+	}
+	s.lock.Lock()
+	s.lazyKVs = append(s.lazyKVs, lazyKV{key: key, fn: fn})
+	s.lock.Unlock()
+}
+
+// lazyKVArgsLocked computes the lazy KVs of the span and clears them. The
+// caller must hold the lock of the span.
+func (s *span) lazyKVArgsLocked() []interface{} {
+	kvs := s.lazyKVs
+	s.lazyKVs = nil
+	if !s.aoCtx.IsSampled() {
+		return nil
+	}
+	return lazyKVArgs(kvs)
+}
+
+// lazyKVArgs computes the values of the lazy KVs.
+func lazyKVArgs(kvs []lazyKV) []interface{} {
+	var args []interface{}
+	for _, kv := range kvs {
+		args = append(args, kv.key, kv.fn())
+	}
+	return args
+}
@@ -0,0 +1,112 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddLazyKV(t *testing.T) {
+	r := reporter.SetTestReporter()
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+
+	calls := 0
+	s, _ := BeginSpan(ctx, "child")
+	s.AddLazyKV("Lazy", func() interface{} { calls++; return "value" })
+	s.AddLazyKV("Nil", nil)
+	assert.Zero(t, calls)
+	s.End()
+	assert.Equal(t, 1, calls)
+	tr.AddLazyKV("RootLazy", func() interface{} { calls++; return 1 })
+	tr.End()
+	assert.Equal(t, 2, calls)
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"root", "entry"}:  {},
+		{"child", "entry"}: {Edges: g.Edges{{"root", "entry"}}},
+		{"child", "exit"}: {Edges: g.Edges{{"child", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "value", n.Map["Lazy"])
+			assert.NotContains(t, n.Map, "Nil")
+		}},
+		{"root", "exit"}: {Edges: g.Edges{{"child", "exit"}, {"root", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, 1, n.Map["RootLazy"])
+		}},
+	})
+}
+
+func TestAddLazyKVUnsampled(t *testing.T) {
+	r := reporter.SetTestReporter(reporter.TestReporterDisableTracing())
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+
+	fn := func() interface{} { t.Error("called for an unsampled span"); return nil }
+	s, _ := BeginSpan(ctx, "child")
+	s.AddLazyKV("Lazy", fn)
+	s.End()
+	tr.AddLazyKV("Lazy", fn)
+	tr.End()
+
+	r.Close(0)
+	assert.Empty(t, r.EventBufs)
+}
+
+func TestAddLazyKVFolded(t *testing.T) {
+	os.Setenv("APPOPTICS_MIN_SPAN_DURATION", "1000000")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_MIN_SPAN_DURATION")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+
+	s, _ := BeginSpan(ctx, "fast")
+	s.AddLazyKV("Lazy", func() interface{} { t.Error("called for a folded span"); return nil })
+	s.End()
+	tr.End()
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"root", "entry"}: {},
+		{"root", "exit"}:  {Edges: g.Edges{{"root", "entry"}}},
+	})
+}
+
+func TestAddLazyKVMerged(t *testing.T) {
+	defer setMergeSiblingSpans()()
+	r := reporter.SetTestReporter()
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+
+	calls := 0
+	for i := 0; i < 3; i++ {
+		s, _ := BeginSpan(ctx, "lookup")
+		s.AddLazyKV("Lazy", func() interface{} { calls++; return "first" })
+		s.End()
+	}
+	tr.End()
+	// only the KV of the first span reported for the merged spans is computed
+	assert.Equal(t, 1, calls)
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"root", "entry"}:   {},
+		{"lookup", "entry"}: {Edges: g.Edges{{"root", "entry"}}},
+		{"lookup", "exit"}: {Edges: g.Edges{{"lookup", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "first", n.Map["Lazy"])
+			assert.Equal(t, 3, n.Map[keyMergedCount])
+		}},
+		{"root", "exit"}: {Edges: g.Edges{{"lookup", "exit"}, {"root", "entry"}}},
+	})
+}
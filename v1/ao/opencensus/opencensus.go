@@ -0,0 +1,135 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+// Package opencensus bridges the OpenCensus instrumentation and AppOptics, so
+// the spans of both appear in the same trace.
+//
+// An OpenCensus trace ID has 16 bytes while an AppOptics task ID has 20 bytes.
+// The trace ID is mapped to the first 16 bytes of the task ID, and the rest are
+// zero, so an OpenCensus trace continued by AppOptics keeps its trace ID. The
+// full task ID of a trace started by AppOptics is carried in the tracestate of
+// the OpenCensus span context when it's continued by OpenCensus, so it's
+// restored when the trace returns to AppOptics. The span ID maps to the op ID
+// directly.
+//
+// It's a separate package, like the opentracing package, so only the
+// applications which import it depend on go.opencensus.io.
+package opencensus
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"go.opencensus.io/trace"
+	"go.opencensus.io/trace/tracestate"
+)
+
+const (
+	// the header byte of an X-Trace of version 2 with a 20-byte task ID and
+	// an 8-byte op ID
+	xtraceHeader = "2B"
+	// the padding of a 16-byte trace ID to a 20-byte task ID
+	taskIDPadding = "00000000"
+	// the tracestate key of the full task ID
+	traceStateTaskIDKey = "ao-taskid"
+)
+
+// MetadataFromSpanContext converts an OpenCensus span context into an X-Trace
+// metadata string, which can be used to continue the trace, e.g., by
+// ao.NewTraceFromID. It returns an empty string if the span context is invalid.
+func MetadataFromSpanContext(sc trace.SpanContext) string {
+	if sc.TraceID == (trace.TraceID{}) || sc.SpanID == (trace.SpanID{}) {
+		return ""
+	}
+	flags := "00"
+	if sc.IsSampled() {
+		flags = "01"
+	}
+	return strings.ToUpper(xtraceHeader + taskIDOf(sc) + hex.EncodeToString(sc.SpanID[:]) + flags)
+}
+
+// taskIDOf returns the task ID of the span context in hex, which is the full
+// task ID carried in its tracestate if it matches the trace ID, or the padded
+// trace ID otherwise.
+func taskIDOf(sc trace.SpanContext) string {
+	traceID := hex.EncodeToString(sc.TraceID[:])
+	for _, e := range sc.Tracestate.Entries() {
+		if e.Key != traceStateTaskIDKey {
+			continue
+		}
+		taskID := strings.ToLower(e.Value)
+		if len(taskID) == len(traceID)+len(taskIDPadding) && strings.HasPrefix(taskID, traceID) {
+			if _, err := hex.DecodeString(taskID); err == nil {
+				return taskID
+			}
+		}
+	}
+	return traceID + taskIDPadding
+}
+
+// SpanContextFromMetadata converts an X-Trace metadata string into an
+// OpenCensus span context. The full task ID is kept in the tracestate if it
+// isn't the padded trace ID. It returns false if the metadata string is invalid.
+func SpanContextFromMetadata(mdStr string) (trace.SpanContext, bool) {
+	var sc trace.SpanContext
+	md, err := reporter.ParseMetadata(mdStr)
+	if err != nil {
+		return sc, false
+	}
+	taskID, err := hex.DecodeString(md.TaskID)
+	if err != nil || len(taskID) < len(sc.TraceID) {
+		return sc, false
+	}
+	opID, err := hex.DecodeString(md.OpID)
+	if err != nil || len(opID) != len(sc.SpanID) {
+		return sc, false
+	}
+	copy(sc.TraceID[:], taskID)
+	copy(sc.SpanID[:], opID)
+	if md.Flags&reporter.XTR_FLAGS_SAMPLED != 0 {
+		sc.TraceOptions = 1
+	}
+	if len(taskID) > len(sc.TraceID) && !strings.EqualFold(md.TaskID[2*len(sc.TraceID):], taskIDPadding) {
+		ts, err := tracestate.New(nil, tracestate.Entry{Key: traceStateTaskIDKey, Value: strings.ToLower(md.TaskID)})
+		if err != nil {
+			return sc, false
+		}
+		sc.Tracestate = ts
+	}
+	return sc, true
+}
+
+// StartTrace starts an AppOptics trace which continues the OpenCensus span in
+// the context, if any, and returns the trace and a copy of the context with it.
+// A new trace is started if there is no OpenCensus span in the context.
+func StartTrace(ctx context.Context, spanName string) (ao.Trace, context.Context) {
+	var mdStr string
+	if span := trace.FromContext(ctx); span != nil {
+		mdStr = MetadataFromSpanContext(span.SpanContext())
+	}
+	t := ao.NewTraceFromID(spanName, mdStr, nil)
+	return t, ao.NewContext(ctx, t)
+}
+
+// SpanContextFromContext returns the OpenCensus span context of the current
+// AppOptics span in the context. It returns false if there is no AppOptics
+// span in the context.
+func SpanContextFromContext(ctx context.Context) (trace.SpanContext, bool) {
+	mdStr := ao.MetadataString(ctx)
+	if mdStr == "" {
+		return trace.SpanContext{}, false
+	}
+	return SpanContextFromMetadata(mdStr)
+}
+
+// StartSpan starts an OpenCensus span as a child of the current AppOptics span
+// in the context, or of the current OpenCensus span if there is no AppOptics
+// span in the context.
+func StartSpan(ctx context.Context, name string, o ...trace.StartOption) (context.Context, *trace.Span) {
+	if sc, ok := SpanContextFromContext(ctx); ok {
+		return trace.StartSpanWithRemoteParent(ctx, name, sc, o...)
+	}
+	return trace.StartSpan(ctx, name, o...)
+}
@@ -0,0 +1,128 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package opencensus
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opencensus.io/trace"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestMetadataConversion(t *testing.T) {
+	sc := trace.SpanContext{
+		TraceID:      trace.TraceID{0x74, 0x35, 0xA9, 0xFE, 0x51, 0x0A, 0xE4, 0x53, 0x34, 0x14, 0xD4, 0x25, 0xDA, 0xDF, 0x4E, 0x18},
+		SpanID:       trace.SpanID{0x49, 0xE6, 0x07, 0x02, 0x46, 0x9D, 0xB0, 0x5F},
+		TraceOptions: 1,
+	}
+	mdStr := MetadataFromSpanContext(sc)
+	assert.Equal(t, "2B7435A9FE510AE4533414D425DADF4E180000000049E60702469DB05F01", mdStr)
+	assert.True(t, reporter.ValidMetadata(mdStr))
+
+	decoded, ok := SpanContextFromMetadata(mdStr)
+	require.True(t, ok)
+	assert.Equal(t, sc, decoded)
+
+	sc.TraceOptions = 0
+	mdStr = MetadataFromSpanContext(sc)
+	assert.True(t, strings.HasSuffix(mdStr, "00"))
+	decoded, ok = SpanContextFromMetadata(mdStr)
+	require.True(t, ok)
+	assert.False(t, decoded.IsSampled())
+
+	assert.Empty(t, MetadataFromSpanContext(trace.SpanContext{}))
+	_, ok = SpanContextFromMetadata("")
+	assert.False(t, ok)
+	_, ok = SpanContextFromMetadata("2B7435A9FE510AE4533414D425DADF4E180D2B4E36")
+	assert.False(t, ok)
+}
+
+func TestOpenCensusToAppOptics(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	ctx, ocSpan := trace.StartSpan(context.Background(), "oc", trace.WithSampler(trace.AlwaysSample()))
+	ocSC := ocSpan.SpanContext()
+
+	tr, ctx := StartTrace(ctx, "aoTrace")
+	assert.True(t, tr.IsSampled())
+
+	// the trace ID is kept and the AppOptics span is the new parent
+	sc, ok := SpanContextFromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, ocSC.TraceID, sc.TraceID)
+	assert.NotEqual(t, ocSC.SpanID, sc.SpanID)
+	assert.True(t, sc.IsSampled())
+
+	// and back to OpenCensus
+	_, child := StartSpan(ctx, "ocChild")
+	assert.Equal(t, ocSC.TraceID, child.SpanContext().TraceID)
+	assert.True(t, child.SpanContext().IsSampled())
+	child.End()
+
+	tr.End()
+	ocSpan.End()
+
+	r.Close(2)
+	require.Len(t, r.EventBufs, 2)
+	m := bson.M{}
+	require.NoError(t, bson.Unmarshal(r.EventBufs[0], m))
+	assert.Equal(t, "entry", m["Label"])
+	assert.Equal(t, strings.ToUpper(hex.EncodeToString(ocSC.SpanID[:])), m["Edge"])
+	assert.Contains(t, m["X-Trace"], strings.ToUpper(hex.EncodeToString(ocSC.TraceID[:])))
+}
+
+func TestOpenCensusUnsampled(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	ctx, ocSpan := trace.StartSpan(context.Background(), "oc", trace.WithSampler(trace.NeverSample()))
+	tr, ctx := StartTrace(ctx, "aoTrace")
+	assert.False(t, tr.IsSampled())
+
+	sc, ok := SpanContextFromContext(ctx)
+	require.True(t, ok)
+	assert.Equal(t, ocSpan.SpanContext().TraceID, sc.TraceID)
+	assert.False(t, sc.IsSampled())
+	tr.End()
+
+	r.Close(0)
+}
+
+func TestAppOpticsToOpenCensus(t *testing.T) {
+	r := reporter.SetTestReporter()
+
+	tr := ao.NewTrace("aoTrace")
+	ctx := ao.NewContext(context.Background(), tr)
+	mdStr := ao.MetadataString(ctx)
+
+	ctx, ocSpan := StartSpan(ctx, "oc")
+	sc := ocSpan.SpanContext()
+	assert.True(t, sc.IsSampled())
+	// the first 16 bytes of the task ID
+	assert.Equal(t, mdStr[2:34], strings.ToUpper(hex.EncodeToString(sc.TraceID[:])))
+	// the full task ID is restored
+	assert.Equal(t, mdStr[2:42], MetadataFromSpanContext(sc)[2:42])
+
+	// an AppOptics trace continuing the OpenCensus span joins the same trace
+	tr2, ctx2 := StartTrace(ctx, "aoTrace2")
+	assert.True(t, tr2.IsSampled())
+	sc2, ok := SpanContextFromContext(ctx2)
+	require.True(t, ok)
+	assert.Equal(t, sc.TraceID, sc2.TraceID)
+	assert.Equal(t, mdStr[2:42], ao.MetadataString(ctx2)[2:42])
+	tr2.End()
+	ocSpan.End()
+	tr.End()
+
+	r.Close(4)
+
+	// no AppOptics span in the context
+	_, ok = SpanContextFromContext(context.Background())
+	assert.False(t, ok)
+}
@@ -41,6 +41,18 @@ func (t *Tracer) Extract(format interface{}, carrier interface{}) (ot.SpanContex
 	return nil, ot.ErrUnsupportedFormat
 }
 
+// ExtractBaggage is the same as Extract, except that a span context carrying
+// only the baggage items is returned from a text map without a trace, rather
+// than ErrSpanContextNotFound. The new trace started from it is sampled by the
+// SamplingRules.
+func (t *Tracer) ExtractBaggage(format interface{}, carrier interface{}) (ot.SpanContext, error) {
+	switch format {
+	case ot.TextMap, ot.HTTPHeaders:
+		return t.textMapPropagator.extract(carrier, true)
+	}
+	return t.Extract(format, carrier)
+}
+
 type textMapPropagator struct{}
 type binaryPropagator struct {
 	marshaler binaryMarshaler
@@ -55,12 +67,12 @@ func (p *textMapPropagator) Inject(spanCtx ot.SpanContext, opaqueCarrier interfa
 	if !ok {
 		return ot.ErrInvalidCarrier
 	}
-	if md := sc.span.MetadataString(); md != "" {
+	if md := ao.PropagationMetadata(sc.span); md != "" {
 		carrier.Set(ao.HTTPHeaderName, md)
 	}
 	carrier.Set(fieldNameSampled, strconv.FormatBool(sc.span.IsReporting()))
 
-	for k, v := range sc.baggage {
+	for k, v := range ao.TrimBaggageWithKeyPrefix(sc.baggage, prefixBaggage) {
 		carrier.Set(prefixBaggage+k, v)
 	}
 	return nil
@@ -92,9 +104,9 @@ func (p *binaryPropagator) Inject(spanCtx ot.SpanContext, opaqueCarrier interfac
 	}
 
 	state := tracerState{
-		XTraceID:     sc.span.MetadataString(),
+		XTraceID:     ao.PropagationMetadata(sc.span),
 		Sampled:      sc.span.IsReporting(),
-		BaggageItems: sc.baggage,
+		BaggageItems: ao.TrimBaggage(sc.baggage),
 	}
 
 	b, err := p.marshaler.Marshal(&state)
@@ -144,6 +156,13 @@ func (p *binaryPropagator) Extract(opaqueCarrier interface{}) (ot.SpanContext, e
 }
 
 func (p *textMapPropagator) Extract(opaqueCarrier interface{}) (ot.SpanContext, error) {
+	return p.extract(opaqueCarrier, false)
+}
+
+// extract returns the span context from a text map. A span context carrying
+// only the baggage items is returned if there is no trace and baggageOnly is
+// true.
+func (p *textMapPropagator) extract(opaqueCarrier interface{}, baggageOnly bool) (ot.SpanContext, error) {
 	carrier, ok := opaqueCarrier.(ot.TextMapReader)
 	if !ok {
 		return nil, ot.ErrInvalidCarrier
@@ -156,6 +175,10 @@ func (p *textMapPropagator) Extract(opaqueCarrier interface{}) (ot.SpanContext,
 	err = carrier.ForeachKey(func(k, v string) error {
 		switch strings.ToLower(k) {
 		case strings.ToLower(ao.HTTPHeaderName):
+			if reporter.OversizedHeader(ao.HTTPHeaderName, v) {
+				// ignored as if no context is propagated
+				return nil
+			}
 			if reporter.ValidMetadata(v) {
 				xTraceID = v
 			} else {
@@ -170,6 +193,10 @@ func (p *textMapPropagator) Extract(opaqueCarrier interface{}) (ot.SpanContext,
 		default:
 			lowercaseK := strings.ToLower(k)
 			if strings.HasPrefix(lowercaseK, prefixBaggage) {
+				if reporter.OversizedHeader(k, v) {
+					// the oversized item is dropped
+					return nil
+				}
 				decodedBaggage[strings.TrimPrefix(lowercaseK, prefixBaggage)] = v
 			}
 		}
@@ -179,7 +206,10 @@ func (p *textMapPropagator) Extract(opaqueCarrier interface{}) (ot.SpanContext,
 		return nil, err
 	}
 	if xTraceID == "" {
-		return nil, ot.ErrSpanContextNotFound
+		if !baggageOnly || len(decodedBaggage) == 0 {
+			return nil, ot.ErrSpanContextNotFound
+		}
+		return spanContext{baggage: decodedBaggage}, nil
 	}
 	if xTraceID != "" && sawSampled == false {
 		sampled = true
@@ -7,9 +7,12 @@ import (
 	"encoding/binary"
 	"errors"
 	"io"
+	"os"
+	"strings"
 	"testing"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
@@ -131,6 +134,37 @@ func TestBinaryInject(t *testing.T) {
 	assert.Equal(t, 0, buf.Len())
 }
 
+func TestTextMapInjectTrimmedBaggage(t *testing.T) {
+	os.Setenv("APPOPTICS_BAGGAGE_MAX_BYTES", "36")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_BAGGAGE_MAX_BYTES")
+		config.Load()
+	}()
+	_ = reporter.SetTestReporter(reporter.TestReporterDisableDefaultSetting(true))
+	tr := NewTracer()
+	span := tr.StartSpan("op")
+	span.SetBaggageItem("tenant", "acme")
+	span.SetBaggageItem("user", "1")
+	span.SetBaggageItem("note", strings.Repeat("x", 32))
+
+	carrier := opentracing.TextMapCarrier{}
+	require.NoError(t, tr.Inject(span.Context(), opentracing.TextMap, carrier))
+	// the prefixes of the keys are counted, so ot-baggage-user (16 bytes) fits in
+	// the 36 bytes while ot-baggage-tenant (21 bytes) doesn't any more
+	var baggage []string
+	size := 0
+	for k, v := range carrier {
+		if strings.HasPrefix(k, prefixBaggage) {
+			baggage = append(baggage, k)
+			size += len(k) + len(v)
+		}
+	}
+	assert.Equal(t, []string{"ot-baggage-user"}, baggage)
+	assert.True(t, size <= 36, "%d", size)
+	span.Finish()
+}
+
 func TestTextMapExtract(t *testing.T) {
 	_ = reporter.SetTestReporter(reporter.TestReporterDisableDefaultSetting(true))
 	tr := NewTracer()
@@ -35,3 +35,12 @@ func translateTags(tags map[string]interface{}) map[string]interface{} {
 	}
 	return ret
 }
+
+// tagKVs translates the tags to a slice of KV pairs.
+func tagKVs(tags map[string]interface{}) []interface{} {
+	kvs := make([]interface{}, 0, 2*len(tags))
+	for k, v := range translateTags(tags) {
+		kvs = append(kvs, k, v)
+	}
+	return kvs
+}
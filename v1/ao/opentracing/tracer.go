@@ -3,6 +3,8 @@
 package opentracing
 
 import (
+	"context"
+	"strings"
 	"sync"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao"
@@ -23,6 +25,35 @@ type Tracer struct {
 	textMapPropagator  *textMapPropagator
 	binaryPropagator   *binaryPropagator
 	TrimUnsampledSpans bool
+	// SamplingRules are evaluated in order when a new trace is started from a
+	// span context which carries baggage items but no trace, as returned by
+	// ExtractBaggage, the first rule
+	// matched decides whether the trace is sampled. The trace is sampled by the
+	// sample rate if none of them is matched.
+	SamplingRules []BaggageSamplingRule
+}
+
+// BaggageSamplingRule overrides the sampling decision of a new trace if the
+// baggage item Key has the value Value, e.g., to sample a specific tenant at
+// 100%. Key is case-insensitive as the baggage items extracted from text maps
+// are in lower case.
+type BaggageSamplingRule struct {
+	Key      string
+	Value    string
+	Decision ao.SamplingDecision
+}
+
+// samplingDecision returns the decision of the first rule matched by the
+// baggage items.
+func (t *Tracer) samplingDecision(baggage map[string]string) ao.SamplingDecision {
+	for _, rule := range t.SamplingRules {
+		for k, v := range baggage {
+			if strings.EqualFold(k, rule.Key) && v == rule.Value {
+				return rule.Decision
+			}
+		}
+	}
+	return ao.SamplingDefault
 }
 
 // StartSpan belongs to the Tracer interface.
@@ -45,7 +76,18 @@ func (t *Tracer) startSpanWithOptions(operationName string, opts ot.StartSpanOpt
 			refCtx := ref.ReferencedContext.(spanContext)
 			if refCtx.span == nil { // referenced spanContext created by Extract()
 				var span ao.Span
-				if refCtx.sampled {
+				sampled := refCtx.sampled
+				if refCtx.remoteMD == "" {
+					// baggage only, start a new trace which honors the sampling rules
+					ctx := ao.WithSamplingHint(context.Background(), t.samplingDecision(refCtx.baggage))
+					var tr ao.Trace
+					tr, _ = ao.BeginTraceWithArgs(ctx, operationName, tagKVs(opts.Tags)...)
+					if !opts.StartTime.IsZero() {
+						tr.SetStartTime(opts.StartTime)
+					}
+					span = tr
+					sampled = span.IsSampled()
+				} else if refCtx.sampled {
 					span = ao.NewTraceFromID(operationName, refCtx.remoteMD, func() ao.KVMap {
 						return translateTags(opts.Tags)
 					})
@@ -54,7 +96,7 @@ func (t *Tracer) startSpanWithOptions(operationName string, opts ot.StartSpanOpt
 				}
 				return &spanImpl{tracer: t, context: spanContext{
 					span:    span,
-					sampled: refCtx.sampled,
+					sampled: sampled,
 					baggage: refCtx.baggage,
 				},
 				}
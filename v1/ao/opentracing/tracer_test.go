@@ -3,11 +3,16 @@
 package opentracing
 
 import (
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSpanBaggageUnsampled(t *testing.T) {
@@ -23,3 +28,64 @@ func TestSpanBaggageUnsampled(t *testing.T) {
 	childSpan := tr.StartSpan("op2", opentracing.ChildOf(sp.Context()))
 	assert.NotNil(t, childSpan)
 }
+
+func TestBaggageSamplingRules(t *testing.T) {
+	r := reporter.SetTestReporter(reporter.TestReporterSampleRate(0))
+	tr := NewTracer().(*Tracer)
+	tr.SamplingRules = []BaggageSamplingRule{
+		{Key: "Tenant", Value: "acme", Decision: ao.ForceSample},
+	}
+
+	startSpan := func(tenant string) opentracing.Span {
+		carrier := opentracing.TextMapCarrier{"ot-baggage-tenant": tenant}
+		sc, err := tr.ExtractBaggage(opentracing.TextMap, carrier)
+		require.NoError(t, err)
+		return tr.StartSpan("op", opentracing.ChildOf(sc), opentracing.Tag{Key: "component", Value: "kafka"},
+			opentracing.StartTime(time.Now().Add(-time.Second)))
+	}
+
+	// the matched tenant is sampled regardless of the sample rate
+	span := startSpan("acme")
+	assert.True(t, span.Context().(spanContext).sampled)
+	assert.Equal(t, "acme", span.BaggageItem("tenant"))
+	span.Finish()
+
+	// others are sampled by the sample rate
+	span = startSpan("other")
+	assert.False(t, span.Context().(spanContext).sampled)
+	span.Finish()
+
+	// the tags are reported by the entry event of the new trace
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"op", "entry"}: {Callback: func(n g.Node) {
+			assert.Equal(t, "kafka", n.Map["OTComponent"])
+		}},
+		{"op", "exit"}: {Edges: g.Edges{{"op", "entry"}}},
+	})
+}
+
+func TestExtractBaggageOnly(t *testing.T) {
+	tr := NewTracer()
+	_, err := tr.Extract(opentracing.TextMap, opentracing.TextMapCarrier{})
+	assert.Equal(t, opentracing.ErrSpanContextNotFound, err)
+
+	// Extract keeps returning ErrSpanContextNotFound without a trace
+	carrier := opentracing.TextMapCarrier{"ot-baggage-tenant": "acme"}
+	_, err = tr.Extract(opentracing.TextMap, carrier)
+	assert.Equal(t, opentracing.ErrSpanContextNotFound, err)
+
+	_, err = tr.(*Tracer).ExtractBaggage(opentracing.TextMap, opentracing.TextMapCarrier{})
+	assert.Equal(t, opentracing.ErrSpanContextNotFound, err)
+
+	sc, err := tr.(*Tracer).ExtractBaggage(opentracing.TextMap, carrier)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"tenant": "acme"}, sc.(spanContext).baggage)
+	assert.Empty(t, sc.(spanContext).remoteMD)
+
+	// the oversized baggage item is dropped
+	carrier["ot-baggage-blob"] = strings.Repeat("a", 1025)
+	sc, err = tr.(*Tracer).ExtractBaggage(opentracing.TextMap, carrier)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"tenant": "acme"}, sc.(spanContext).baggage)
+}
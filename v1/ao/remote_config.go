@@ -0,0 +1,265 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	aolog "github.com/appoptics/appoptics-apm-go/v1/ao/internal/log"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// the timeout of a request to the remote config source
+	remoteConfigTimeout = 10 * time.Second
+	// the maximum size of the config fetched from the remote config source
+	maxRemoteConfigSize = 1024 * 1024
+)
+
+// remoteConfig is the config fetched from the remote config source, which is
+// a JSON object of the sampling items, e.g.,
+//   {"TracingMode": "enabled", "SampleRate": 100000, "TransactionSettings":
+//     [{"Type": "url", "RegEx": "^/health$", "SampleRate": 10000}]}
+// Unlike the config file, the TracingMode and SampleRate are top-level items
+// rather than under Sampling. The items absent are not overridden.
+type remoteConfig struct {
+	TracingMode         config.TracingMode         `json:"TracingMode,omitempty"`
+	SampleRate          *int                       `json:"SampleRate,omitempty"`
+	TransactionSettings []config.TransactionFilter `json:"TransactionSettings,omitempty"`
+}
+
+// validate checks the items of the config before any of them is applied.
+func (rc *remoteConfig) validate() error {
+	if rc.TracingMode != "" {
+		rc.TracingMode = config.NormalizeTracingMode(rc.TracingMode)
+		if !config.IsValidTracingMode(rc.TracingMode) {
+			return errors.Errorf("invalid tracing mode: %s", rc.TracingMode)
+		}
+	}
+	if rc.SampleRate != nil && !config.IsValidSampleRate(*rc.SampleRate) {
+		return errors.Errorf("invalid sample rate: %d", *rc.SampleRate)
+	}
+	return nil
+}
+
+// covers checks if the config overrides all the items the other one does.
+func (rc *remoteConfig) covers(other *remoteConfig) bool {
+	return (other.TracingMode == "" || rc.TracingMode != "") &&
+		(other.SampleRate == nil || rc.SampleRate != nil) &&
+		(other.TransactionSettings == nil || rc.TransactionSettings != nil)
+}
+
+// remoteConfigSource polls the remote config source and applies the config
+// fetched, which overrides the local config until it expires.
+type remoteConfigSource struct {
+	url      string
+	header   string
+	interval time.Duration
+	ttl      time.Duration
+	client   *http.Client
+
+	// the config applied and when it expires, and the local sampling config
+	// and transaction filters before it's applied, which are refreshed once the
+	// local config is reloaded
+	sync.Mutex
+	applied       *remoteConfig
+	expiry        time.Time
+	localSampling config.SamplingConfig
+	localFilters  []config.TransactionFilter
+
+	// it's canceled to stop polling and abort the request in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+var (
+	globalRemoteConfig *remoteConfigSource
+	remoteConfigLock   sync.Mutex
+)
+
+// initRemoteConfig starts polling the remote config source if it's configured.
+func initRemoteConfig() {
+	url, header, interval, ttl := config.GetRemoteConfig()
+	if url == "" || disabled {
+		return
+	}
+	rc := newRemoteConfigSource(url, header, interval, ttl)
+	rc.start()
+	remoteConfigLock.Lock()
+	globalRemoteConfig = rc
+	remoteConfigLock.Unlock()
+	aolog.Infof("Polling the remote config source %s", url)
+}
+
+// closeRemoteConfig stops polling the remote config source, if any.
+func closeRemoteConfig() {
+	remoteConfigLock.Lock()
+	rc := globalRemoteConfig
+	globalRemoteConfig = nil
+	remoteConfigLock.Unlock()
+	if rc != nil {
+		rc.close()
+	}
+}
+
+func newRemoteConfigSource(url, header string, interval, ttl time.Duration) *remoteConfigSource {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &remoteConfigSource{
+		url:      url,
+		header:   header,
+		interval: interval,
+		ttl:      ttl,
+		client:   utils.NewHTTPClient(remoteConfigTimeout),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// start polls the remote config source in the background, starting with an
+// immediate fetch.
+func (rc *remoteConfigSource) start() {
+	rc.wg.Add(1)
+	go rc.poll()
+}
+
+// poll fetches the config every interval until the source is closed.
+func (rc *remoteConfigSource) poll() {
+	defer rc.wg.Done()
+	ticker := time.NewTicker(rc.interval)
+	defer ticker.Stop()
+	for {
+		rc.refresh(time.Now())
+		select {
+		case <-rc.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// refresh fetches and applies the config. The config applied is kept if it
+// can't be fetched, until it expires and the local config is restored.
+func (rc *remoteConfigSource) refresh(now time.Time) {
+	cfg, err := rc.fetch()
+	if rc.ctx.Err() != nil {
+		return
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	if err != nil {
+		aolog.Warningf("Failed to fetch the remote config: %v", err)
+		if rc.applied != nil && !now.Before(rc.expiry) {
+			aolog.Warning("The remote config expired, falling back to the local config.")
+			rc.restoreLocal()
+			rc.applied = nil
+		}
+		return
+	}
+	rc.apply(cfg)
+	rc.expiry = now.Add(rc.ttl)
+}
+
+// fetch requests the config from the remote config source.
+func (rc *remoteConfigSource) fetch() (*remoteConfig, error) {
+	req, err := http.NewRequest(http.MethodGet, rc.url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the request")
+	}
+	req = req.WithContext(rc.ctx)
+	if idx := strings.IndexByte(rc.header, ':'); idx > 0 {
+		req.Header.Set(strings.TrimSpace(rc.header[:idx]), strings.TrimSpace(rc.header[idx+1:]))
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	cfg := &remoteConfig{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxRemoteConfigSize)).Decode(cfg); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+	return cfg, nil
+}
+
+// apply applies the config via the runtime setters. It's re-applied on every
+// fetch in case the local config is reloaded in the meantime, and the items no
+// longer overridden are restored to the local config. The local config is
+// saved before the first config is applied.
+func (rc *remoteConfigSource) apply(cfg *remoteConfig) {
+	if rc.applied == nil {
+		rc.localSampling = config.GetSampling()
+		rc.localFilters = config.GetTransactionFiltering()
+		aolog.Info("The remote config is applied.")
+	} else if !cfg.covers(rc.applied) {
+		rc.restoreLocal()
+	}
+	if cfg.TracingMode != "" {
+		_ = SetTracingMode(string(cfg.TracingMode))
+	}
+	if cfg.SampleRate != nil {
+		_ = SetSampleRate(*cfg.SampleRate)
+	}
+	if cfg.TransactionSettings != nil {
+		reporter.ReloadURLsConfig(cfg.TransactionSettings)
+	}
+	rc.applied = cfg
+}
+
+// reload saves the local config just reloaded, which has discarded the config
+// applied, and applies the config again over it.
+func (rc *remoteConfigSource) reload() {
+	rc.Lock()
+	defer rc.Unlock()
+	if rc.applied == nil {
+		return
+	}
+	rc.localSampling = config.GetSampling()
+	rc.localFilters = config.GetTransactionFiltering()
+	rc.apply(rc.applied)
+}
+
+// reloadRemoteConfig applies the remote config, if any, again over the local
+// config just reloaded.
+func reloadRemoteConfig() {
+	remoteConfigLock.Lock()
+	rc := globalRemoteConfig
+	remoteConfigLock.Unlock()
+	if rc != nil {
+		rc.reload()
+	}
+}
+
+// restoreLocal restores the sampling settings and transaction filters to the
+// local config saved before the remote config was applied. The other config
+// items are left untouched.
+func (rc *remoteConfigSource) restoreLocal() {
+	config.SetSampling(rc.localSampling)
+	reporter.ReapplyLocalSettings()
+	reporter.ReloadURLsConfig(rc.localFilters)
+}
+
+// close stops polling the remote config source. The config applied, if any, is
+// kept.
+func (rc *remoteConfigSource) close() {
+	rc.cancel()
+	rc.wg.Wait()
+}
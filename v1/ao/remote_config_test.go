@@ -0,0 +1,241 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeConfigServer is a remote config source which serves the config set.
+type fakeConfigServer struct {
+	*httptest.Server
+	sync.Mutex
+	status int
+	body   string
+	auth   string
+}
+
+func newFakeConfigServer() *fakeConfigServer {
+	s := &fakeConfigServer{status: http.StatusOK}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Lock()
+		defer s.Unlock()
+		s.auth = r.Header.Get("Authorization")
+		w.WriteHeader(s.status)
+		w.Write([]byte(s.body))
+	}))
+	return s
+}
+
+// authorization returns the Authorization header of the last request
+func (s *fakeConfigServer) authorization() string {
+	s.Lock()
+	defer s.Unlock()
+	return s.auth
+}
+
+func (s *fakeConfigServer) serve(status int, body string) {
+	s.Lock()
+	defer s.Unlock()
+	s.status, s.body = status, body
+}
+
+func TestRemoteConfig(t *testing.T) {
+	r := reporter.SetTestReporter() // 100% sampling rate
+	defer r.Close(0)
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	require.NoError(t, config.Load())
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		config.Load()
+		reporter.ReapplyLocalSettings()
+		reporter.ReloadURLsConfig(nil)
+	}()
+
+	srv := newFakeConfigServer()
+	defer srv.Close()
+	sampled := func(url string) bool {
+		tr := NewTraceWithOptions("remote", SpanOptions{URL: url})
+		defer tr.End()
+		return tr.IsSampled()
+	}
+
+	rc := newRemoteConfigSource(srv.URL, "Authorization: Bearer token", time.Minute, 5*time.Minute)
+	now := time.Now()
+
+	srv.serve(http.StatusOK, `{"SampleRate": 10000, "TransactionSettings":
+		[{"Type": "url", "RegEx": "^/health$", "Tracing": "disabled"}]}`)
+	rc.refresh(now)
+	assert.Equal(t, "Bearer token", srv.authorization())
+	assert.Equal(t, 10000, config.GetSampleRate())
+	assert.Equal(t, 10000, Diagnostics().SampleRate)
+	assert.False(t, sampled("/health"))
+
+	// the config applied is kept until it expires
+	srv.serve(http.StatusServiceUnavailable, "")
+	rc.refresh(now.Add(time.Minute))
+	assert.Equal(t, 10000, config.GetSampleRate())
+	assert.False(t, sampled("/health"))
+
+	// the invalid config is not applied
+	srv.serve(http.StatusOK, `{"SampleRate": 2000000}`)
+	rc.refresh(now.Add(2 * time.Minute))
+	assert.Equal(t, 10000, config.GetSampleRate())
+	srv.serve(http.StatusOK, `{"TransactionSettings": [{"Type": "url", "Tracing": "enabled"}]}`)
+	rc.refresh(now.Add(3 * time.Minute))
+	assert.Equal(t, 10000, config.GetSampleRate())
+
+	// the local config is restored once the config expires
+	srv.Close()
+	rc.refresh(now.Add(5 * time.Minute))
+	assert.Equal(t, config.MaxSampleRate, config.GetSampleRate())
+	assert.Equal(t, config.MaxSampleRate, Diagnostics().SampleRate)
+	assert.True(t, sampled("/health"))
+	assert.Nil(t, rc.applied)
+}
+
+func TestRemoteConfigItemsRemoved(t *testing.T) {
+	r := reporter.SetTestReporter() // 100% sampling rate
+	defer r.Close(0)
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	require.NoError(t, config.Load())
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		config.Load()
+		reporter.ReapplyLocalSettings()
+	}()
+
+	srv := newFakeConfigServer()
+	defer srv.Close()
+	rc := newRemoteConfigSource(srv.URL, "", time.Minute, 5*time.Minute)
+
+	srv.serve(http.StatusOK, `{"TracingMode": "enabled", "SampleRate": 10000}`)
+	rc.refresh(time.Now())
+	assert.Equal(t, 10000, config.GetSampleRate())
+	assert.Equal(t, "", srv.authorization())
+
+	// the items no longer overridden are restored to the local config
+	srv.serve(http.StatusOK, `{"TracingMode": "never"}`)
+	rc.refresh(time.Now())
+	assert.Equal(t, config.MaxSampleRate, config.GetSampleRate())
+	assert.Equal(t, config.DisabledTracingMode, config.GetTracingMode())
+}
+
+func TestRemoteConfigRestoreLocal(t *testing.T) {
+	r := reporter.SetTestReporter() // 100% sampling rate
+	defer r.Close(0)
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	require.NoError(t, config.Load())
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		config.Load()
+		reporter.ReapplyLocalSettings()
+		reporter.ReloadURLsConfig(nil)
+	}()
+	assert.False(t, config.SamplingConfigured())
+
+	srv := newFakeConfigServer()
+	defer srv.Close()
+	rc := newRemoteConfigSource(srv.URL, "", time.Minute, 5*time.Minute)
+	now := time.Now()
+
+	srv.serve(http.StatusOK, `{"SampleRate": 10000}`)
+	rc.refresh(now)
+	assert.Equal(t, 10000, config.GetSampleRate())
+	assert.True(t, config.SamplingConfigured())
+
+	// the local config saved is restored rather than reloaded
+	os.Setenv("APPOPTICS_SAMPLE_RATE", "20000")
+	defer os.Unsetenv("APPOPTICS_SAMPLE_RATE")
+	srv.serve(http.StatusOK, `{"TracingMode": "enabled"}`)
+	rc.refresh(now.Add(time.Minute))
+	assert.Equal(t, config.MaxSampleRate, config.GetSampleRate())
+
+	srv.Close()
+	rc.refresh(now.Add(10 * time.Minute))
+	assert.Nil(t, rc.applied)
+	assert.Equal(t, config.MaxSampleRate, config.GetSampleRate())
+	assert.Equal(t, config.EnabledTracingMode, config.GetTracingMode())
+	assert.False(t, config.SamplingConfigured())
+}
+
+func TestRemoteConfigReload(t *testing.T) {
+	r := reporter.SetTestReporter() // 100% sampling rate
+	defer r.Close(0)
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go")
+	require.NoError(t, config.Load())
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		config.Load()
+		reporter.ReapplyLocalSettings()
+		reporter.ReloadURLsConfig(nil)
+	}()
+
+	srv := newFakeConfigServer()
+	defer srv.Close()
+	rc := newRemoteConfigSource(srv.URL, "", time.Minute, 5*time.Minute)
+	remoteConfigLock.Lock()
+	globalRemoteConfig = rc
+	remoteConfigLock.Unlock()
+	defer func() {
+		remoteConfigLock.Lock()
+		globalRemoteConfig = nil
+		remoteConfigLock.Unlock()
+	}()
+	now := time.Now()
+
+	srv.serve(http.StatusOK, `{"SampleRate": 10000}`)
+	rc.refresh(now)
+	assert.Equal(t, 10000, config.GetSampleRate())
+
+	// the config applied is kept over the local config reloaded
+	os.Setenv("APPOPTICS_SAMPLE_RATE", "20000")
+	defer os.Unsetenv("APPOPTICS_SAMPLE_RATE")
+	_, err := ReloadConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 10000, config.GetSampleRate())
+	assert.Equal(t, 10000, Diagnostics().SampleRate)
+
+	// and the local config reloaded is restored once the config expires
+	srv.Close()
+	rc.refresh(now.Add(10 * time.Minute))
+	assert.Nil(t, rc.applied)
+	assert.Equal(t, 20000, config.GetSampleRate())
+	assert.Equal(t, 20000, Diagnostics().SampleRate)
+}
+
+func TestRemoteConfigClose(t *testing.T) {
+	requested := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested <- struct{}{}
+		<-r.Context().Done() // never responds
+	}))
+	defer srv.Close()
+
+	rc := newRemoteConfigSource(srv.URL, "", time.Hour, time.Hour)
+	rc.start()
+	<-requested
+
+	// the request in flight is aborted
+	closed := make(chan struct{})
+	go func() {
+		rc.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the remote config source is not closed")
+	}
+	assert.Nil(t, rc.applied)
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// RemoteKind is the kind of the external system a remote span calls.
+type RemoteKind string
+
+const (
+	// RemoteKindDB is a database, e.g., MySQL or PostgreSQL.
+	RemoteKindDB RemoteKind = "db"
+	// RemoteKindCache is a cache or KV store, e.g., Redis or Memcached.
+	RemoteKindCache RemoteKind = "cache"
+	// RemoteKindHTTP is an HTTP service, e.g., a third-party API.
+	RemoteKindHTTP RemoteKind = "http"
+	// RemoteKindRPC is an RPC service, e.g., a gRPC server.
+	RemoteKindRPC RemoteKind = "rpc"
+)
+
+// The standard attributes of the remote spans, which are the same for all the
+// kinds of external systems so they can be connected on the service map.
+const (
+	keySpanKind    = "span.kind"
+	keyRemoteKind  = "RemoteKind"
+	keyPeerService = "peer.service"
+	keyPeerAddress = "peer.address"
+	keyDBSystem    = "db.system"
+
+	// the span.kind of the remote spans, which are all exit spans
+	spanKindClient = "client"
+)
+
+// the AppOptics span spec of each kind of the external systems
+var remoteSpecs = map[RemoteKind]string{
+	RemoteKindDB:    "query",
+	RemoteKindCache: "cache",
+	RemoteKindHTTP:  "rsc",
+	RemoteKindRPC:   "rsc",
+}
+
+// Peer is the external system a remote span calls.
+type Peer struct {
+	// Service is the logical name of the external system, e.g., "orders-db"
+	// or "payment-api", which is reported as peer.service.
+	Service string
+	// Address is the address of the external system, e.g., "db.internal:5432",
+	// which is reported as peer.address.
+	Address string
+	// DBSystem is the database product of a DB or cache, e.g., "postgresql" or
+	// "redis", which is reported as db.system.
+	DBSystem string
+}
+
+// BeginRemoteSpan returns a Span that represents a call to an external system,
+// e.g., a DB, cache or third-party API, with the standard attributes span.kind,
+// peer.service, peer.address and db.system, which the service map is built
+// from. The span is named after the peer service, or the kind if there is no
+// peer service. The integrations started with BeginQuerySpan, BeginCacheSpan,
+// BeginRPCSpan and BeginHTTPClientSpan report the same attributes.
+// Call or defer the returned Span's End() to time the call's client-side latency.
+//   l := ao.BeginRemoteSpan(ctx, ao.RemoteKindDB, ao.Peer{
+//       Service: "orders-db", Address: "db.internal:5432", DBSystem: "postgresql"})
+//   defer l.End()
+func BeginRemoteSpan(ctx context.Context, kind RemoteKind, peer Peer, args ...interface{}) Span {
+	spanName := peer.Service
+	if spanName == "" {
+		spanName = string(kind)
+	}
+	var specKVs []interface{}
+	if spec, ok := remoteSpecs[kind]; ok {
+		specKVs = []interface{}{keySpec, spec}
+	}
+	kvs := mergeKVs(specKVs, mergeKVs(remoteSpanKVs(kind, peer), args))
+	l, _ := BeginSpan(ctx, spanName, kvs...)
+	return l
+}
+
+// remoteSpanKVs returns the standard KVs of a remote span, the empty attributes
+// of the peer are not reported.
+func remoteSpanKVs(kind RemoteKind, peer Peer) []interface{} {
+	kvs := []interface{}{keySpanKind, spanKindClient, keyRemoteKind, string(kind)}
+	if peer.Service != "" {
+		kvs = append(kvs, keyPeerService, peer.Service)
+	}
+	if peer.Address != "" {
+		kvs = append(kvs, keyPeerAddress, peer.Address)
+	}
+	if peer.DBSystem != "" {
+		kvs = append(kvs, keyDBSystem, strings.ToLower(peer.DBSystem))
+	}
+	return kvs
+}
+
+// urlPeer returns the peer of a remote URL, which is addressed by its host.
+func urlPeer(remoteURL string) Peer {
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return Peer{}
+	}
+	return Peer{Address: u.Host}
+}
@@ -0,0 +1,117 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBeginRemoteSpan(t *testing.T) {
+	r := reporter.SetTestReporter() // enable test reporter
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("myExample"))
+
+	ao.BeginRemoteSpan(ctx, ao.RemoteKindDB, ao.Peer{
+		Service: "orders-db", Address: "db.internal:5432", DBSystem: "PostgreSQL"}).End()
+	ao.BeginRemoteSpan(ctx, ao.RemoteKindCache, ao.Peer{
+		Service: "sessions", Address: "redis.internal:6379", DBSystem: "redis"}).End()
+	ao.BeginRemoteSpan(ctx, ao.RemoteKindHTTP, ao.Peer{
+		Service: "payment-api", Address: "api.payment.com"}, "Extra", "value").End()
+	ao.BeginRemoteSpan(ctx, ao.RemoteKindRPC, ao.Peer{Address: "inventory:50051"}).End()
+	ao.End(ctx)
+
+	r.Close(10)
+	g.AssertGraph(t, r.EventBufs, 10, g.AssertNodeMap{
+		{"myExample", "entry"}: {},
+		{"orders-db", "entry"}: {Edges: g.Edges{{"myExample", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "query", n.Map["Spec"])
+			assert.Equal(t, "client", n.Map["span.kind"])
+			assert.Equal(t, "db", n.Map["RemoteKind"])
+			assert.Equal(t, "orders-db", n.Map["peer.service"])
+			assert.Equal(t, "db.internal:5432", n.Map["peer.address"])
+			assert.Equal(t, "postgresql", n.Map["db.system"])
+		}},
+		{"orders-db", "exit"}: {Edges: g.Edges{{"orders-db", "entry"}}},
+		{"sessions", "entry"}: {Edges: g.Edges{{"myExample", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "cache", n.Map["Spec"])
+			assert.Equal(t, "client", n.Map["span.kind"])
+			assert.Equal(t, "cache", n.Map["RemoteKind"])
+			assert.Equal(t, "sessions", n.Map["peer.service"])
+			assert.Equal(t, "redis.internal:6379", n.Map["peer.address"])
+			assert.Equal(t, "redis", n.Map["db.system"])
+		}},
+		{"sessions", "exit"}: {Edges: g.Edges{{"sessions", "entry"}}},
+		{"payment-api", "entry"}: {Edges: g.Edges{{"myExample", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "rsc", n.Map["Spec"])
+			assert.Equal(t, "client", n.Map["span.kind"])
+			assert.Equal(t, "http", n.Map["RemoteKind"])
+			assert.Equal(t, "payment-api", n.Map["peer.service"])
+			assert.Equal(t, "api.payment.com", n.Map["peer.address"])
+			assert.Equal(t, "value", n.Map["Extra"])
+			assert.NotContains(t, n.Map, "db.system")
+		}},
+		{"payment-api", "exit"}: {Edges: g.Edges{{"payment-api", "entry"}}},
+		// the span is named after the kind without a peer service
+		{"rpc", "entry"}: {Edges: g.Edges{{"myExample", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "rsc", n.Map["Spec"])
+			assert.Equal(t, "client", n.Map["span.kind"])
+			assert.Equal(t, "rpc", n.Map["RemoteKind"])
+			assert.Equal(t, "inventory:50051", n.Map["peer.address"])
+			assert.NotContains(t, n.Map, "peer.service")
+		}},
+		{"rpc", "exit"}: {Edges: g.Edges{{"rpc", "entry"}}},
+		{"myExample", "exit"}: {Edges: g.Edges{{"orders-db", "exit"}, {"sessions", "exit"},
+			{"payment-api", "exit"}, {"rpc", "exit"}, {"myExample", "entry"}}},
+	})
+}
+
+func TestRemoteSpanIntegrations(t *testing.T) {
+	r := reporter.SetTestReporter() // enable test reporter
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("myExample"))
+
+	ao.BeginQuerySpan(ctx, "querySpan", "SELECT 1", "MySQL", "mysql.net:3306").End()
+	ao.BeginCacheSpan(ctx, "redis", "GET", "key", "redis.net:6379", true).End()
+	ao.BeginRPCSpan(ctx, "rpcSpan", "grpc", "inventory.Service", "inventory:50051").End()
+	req, _ := http.NewRequest("GET", "http://api.example.com:8080/v1/items", nil)
+	ao.BeginHTTPClientSpan(ctx, req).End()
+	ao.End(ctx)
+
+	r.Close(10)
+	g.AssertGraph(t, r.EventBufs, 10, g.AssertNodeMap{
+		{"myExample", "entry"}: {},
+		{"querySpan", "entry"}: {Edges: g.Edges{{"myExample", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "client", n.Map["span.kind"])
+			assert.Equal(t, "db", n.Map["RemoteKind"])
+			assert.Equal(t, "mysql.net:3306", n.Map["peer.address"])
+			assert.Equal(t, "mysql", n.Map["db.system"])
+		}},
+		{"querySpan", "exit"}: {Edges: g.Edges{{"querySpan", "entry"}}},
+		{"redis", "entry"}: {Edges: g.Edges{{"myExample", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "client", n.Map["span.kind"])
+			assert.Equal(t, "cache", n.Map["RemoteKind"])
+			assert.Equal(t, "redis.net:6379", n.Map["peer.address"])
+		}},
+		{"redis", "exit"}: {Edges: g.Edges{{"redis", "entry"}}},
+		{"rpcSpan", "entry"}: {Edges: g.Edges{{"myExample", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "client", n.Map["span.kind"])
+			assert.Equal(t, "rpc", n.Map["RemoteKind"])
+			assert.Equal(t, "inventory.Service", n.Map["peer.service"])
+			assert.Equal(t, "inventory:50051", n.Map["peer.address"])
+		}},
+		{"rpcSpan", "exit"}: {Edges: g.Edges{{"rpcSpan", "entry"}}},
+		{"http.Client", "entry"}: {Edges: g.Edges{{"myExample", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "client", n.Map["span.kind"])
+			assert.Equal(t, "http", n.Map["RemoteKind"])
+			assert.Equal(t, "api.example.com:8080", n.Map["peer.address"])
+		}},
+		{"http.Client", "exit"}: {Edges: g.Edges{{"http.Client", "entry"}}},
+		{"myExample", "exit"}: {Edges: g.Edges{{"querySpan", "exit"}, {"redis", "exit"},
+			{"rpcSpan", "exit"}, {"http.Client", "exit"}, {"myExample", "entry"}}},
+	})
+}
@@ -0,0 +1,71 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"encoding/hex"
+	"math/rand"
+	"net/http"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+// RequestIDHeaderName is the HTTP header used to propagate the request ID,
+// which correlates the logs of a request across services. It's independent of
+// the trace ID carried by the X-Trace header, and can be disabled by
+// APPOPTICS_REQUEST_ID=false.
+const RequestIDHeaderName = "X-Request-ID"
+
+// the maximum length of an inbound request ID, the longer ones are truncated
+const maxRequestIDLen = 128
+
+var contextRequestIDKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.RequestID")
+
+// WithRequestID returns a copy of the parent context associated with the
+// request ID, which is injected into the outbound HTTP requests made by
+// BeginHTTPClientSpan.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextRequestIDKey, id)
+}
+
+// RequestID returns the request ID associated with the context, or an empty
+// string if there is none.
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	if id, ok := ctx.Value(contextRequestIDKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// newRequestID generates a random request ID of 32 hex characters. The request
+// IDs only correlate the logs, so they are generated by the math/rand source,
+// which is seeded by the reporter, rather than the costly crypto/rand.
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestIDFromHTTPRequest returns the request ID of an inbound request. It's
+// the one in the request header, or the one already in the context, e.g., of
+// an outer handler, or a newly generated one otherwise. It's empty if the
+// request ID is disabled by the configuration.
+func requestIDFromHTTPRequest(r *http.Request) string {
+	if !config.GetRequestID() {
+		return ""
+	}
+	if id := r.Header.Get(RequestIDHeaderName); id != "" {
+		if len(id) > maxRequestIDLen {
+			id = id[:maxRequestIDLen]
+		}
+		return id
+	}
+	if id := RequestID(r.Context()); id != "" {
+		return id
+	}
+	return newRequestID()
+}
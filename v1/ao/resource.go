@@ -0,0 +1,65 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/host"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
+)
+
+// The keys of the OpenTelemetry resource attributes, per the semantic
+// conventions.
+const (
+	ResourceServiceName    = "service.name"
+	ResourceServiceVersion = "service.version"
+	ResourceHostName       = "host.name"
+	ResourceSDKName        = "telemetry.sdk.name"
+	ResourceSDKLanguage    = "telemetry.sdk.language"
+	ResourceSDKVersion     = "telemetry.sdk.version"
+)
+
+// the telemetry.sdk.name of the agent
+const resourceSDKName = "appoptics-apm-go"
+
+// ResourceAttributes returns the OpenTelemetry resource attributes describing
+// the service, for the telemetry exported to an OpenTelemetry backend to be
+// associated with it, e.g., they're the labels of the target_info metric
+// written by WritePrometheusHistograms:
+//
+// service.name is the service name of the service key (APPOPTICS_SERVICE_KEY),
+// service.version is APPOPTICS_SERVICE_VERSION, host.name is the hostname alias
+// (APPOPTICS_HOSTNAME_ALIAS) or the hostname, and telemetry.sdk.* describe the
+// agent. The attributes without a value are omitted.
+func ResourceAttributes() map[string]string {
+	attrs := map[string]string{
+		ResourceSDKName:     resourceSDKName,
+		ResourceSDKLanguage: "go",
+		ResourceSDKVersion:  utils.Version(),
+	}
+	if name := serviceName(config.GetServiceKey()); name != "" {
+		attrs[ResourceServiceName] = name
+	}
+	if version := config.GetServiceVersion(); version != "" {
+		attrs[ResourceServiceVersion] = version
+	}
+	hostname := host.ConfiguredHostname()
+	if hostname == "" {
+		hostname = host.Hostname()
+	}
+	if hostname != "" {
+		attrs[ResourceHostName] = hostname
+	}
+	return attrs
+}
+
+// serviceName returns the service name of the service key, which is in the
+// format of <token>:<service name>.
+func serviceName(key string) string {
+	if idx := strings.Index(key, ":"); idx >= 0 {
+		return key[idx+1:]
+	}
+	return ""
+}
@@ -0,0 +1,68 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/host"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResourceAttributes(t *testing.T) {
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:orders")
+	os.Setenv("APPOPTICS_SERVICE_VERSION", "1.2.3")
+	os.Setenv("APPOPTICS_HOSTNAME_ALIAS", "orders-1")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_SERVICE_VERSION")
+		os.Unsetenv("APPOPTICS_HOSTNAME_ALIAS")
+		config.Load()
+	}()
+
+	assert.Equal(t, map[string]string{
+		"service.name":           "orders",
+		"service.version":        "1.2.3",
+		"host.name":              "orders-1",
+		"telemetry.sdk.name":     "appoptics-apm-go",
+		"telemetry.sdk.language": "go",
+		"telemetry.sdk.version":  utils.Version(),
+	}, ResourceAttributes())
+
+	// the hostname is used without an alias
+	os.Unsetenv("APPOPTICS_HOSTNAME_ALIAS")
+	os.Unsetenv("APPOPTICS_SERVICE_VERSION")
+	config.Load()
+	attrs := ResourceAttributes()
+	assert.Equal(t, host.Hostname(), attrs["host.name"])
+	assert.NotContains(t, attrs, "service.version")
+}
+
+func TestWritePrometheusTargetInfo(t *testing.T) {
+	os.Setenv("APPOPTICS_SERVICE_KEY", "ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:orders")
+	os.Setenv("APPOPTICS_HOSTNAME_ALIAS", "orders-1")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_SERVICE_KEY")
+		os.Unsetenv("APPOPTICS_HOSTNAME_ALIAS")
+		config.Load()
+	}()
+
+	var buf bytes.Buffer
+	assert.Nil(t, WritePrometheusHistograms(&buf))
+	assert.Contains(t, buf.String(), "# TYPE target_info gauge\n")
+	assert.Contains(t, buf.String(), `target_info{host_name="orders-1",service_name="orders",`+
+		`telemetry_sdk_language="go",telemetry_sdk_name="appoptics-apm-go",`+
+		`telemetry_sdk_version="`+utils.Version()+`"} 1`+"\n")
+}
+
+func TestServiceName(t *testing.T) {
+	assert.Equal(t, "go", serviceName("ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:go"))
+	assert.Equal(t, "", serviceName(""))
+	assert.Equal(t, "", serviceName("invalid"))
+}
@@ -0,0 +1,87 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// SamplingDecision is a per-request sampling decision made by the application.
+type SamplingDecision int
+
+const (
+	// SamplingDefault leaves the decision to the sample rate settings.
+	SamplingDefault SamplingDecision = iota
+	// ForceSample samples the request regardless of the sample rate and the
+	// rate limiting.
+	ForceSample
+	// ForceDrop does not sample the request.
+	ForceDrop
+)
+
+var contextSamplingHintKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.SamplingHint")
+
+// WithSamplingHint returns a copy of the parent context which carries the
+// sampling decision. The decision is honored when a new trace is started with
+// the context, e.g., by BeginTrace or the HTTP handler wrappers, and replaces
+// the sample rate and rate limiting of the trace.
+//
+// The precedence of the sampling decision of a trace, from the highest:
+//  1. the tracing mode: the hint cannot turn on tracing which is disabled by
+//     the local configuration or a transaction filter.
+//  2. the inbound context: a trace continued from a valid X-Trace header keeps
+//     its sampling flag and the hint is ignored.
+//  3. the hint.
+//  4. the sample rate and rate limiting.
+func WithSamplingHint(ctx context.Context, decision SamplingDecision) context.Context {
+	return context.WithValue(ctx, contextSamplingHintKey, decision)
+}
+
+// samplingHintFromContext returns the sampling hint bound to the context, if any.
+func samplingHintFromContext(ctx context.Context) reporter.SamplingHint {
+	if ctx == nil {
+		return reporter.SamplingHintNone
+	}
+	switch d, _ := ctx.Value(contextSamplingHintKey).(SamplingDecision); d {
+	case ForceSample:
+		return reporter.SamplingHintSample
+	case ForceDrop:
+		return reporter.SamplingHintDrop
+	default:
+		return reporter.SamplingHintNone
+	}
+}
+
+// BeginTrace starts a new trace with a root span named spanName, honoring the
+// sampling hint and the tenant of the context, if any. The trace is continued
+// from the trace context bound to the context by UnmarshalContext, if any. It
+// returns the trace and a copy of the context associated with it.
+func BeginTrace(ctx context.Context, spanName string) (Trace, context.Context) {
+	return beginTrace(ctx, spanName, TraceOriginManual)
+}
+
+// BeginTraceWithArgs is the same as BeginTrace, except that the args are
+// reported by the entry event of the root span if the trace is sampled.
+func BeginTraceWithArgs(ctx context.Context, spanName string, args ...interface{}) (Trace, context.Context) {
+	return beginTrace(ctx, spanName, TraceOriginManual, args...)
+}
+
+// beginTrace is the same as BeginTrace, except that a new trace is sampled at
+// the sample rate of the origin, if it's configured.
+func beginTrace(ctx context.Context, spanName string, origin TraceOrigin, args ...interface{}) (Trace, context.Context) {
+	md := remoteParentFromContext(ctx)
+	var cb func() KVMap
+	if len(args) > 0 {
+		cb = func() KVMap { return fromKVs(args...) }
+	}
+	t := newTrace(spanName, md, "", samplingHintFromContext(ctx), TenantFromContext(ctx), origin, cb)
+	if at, ok := t.(*aoTrace); ok {
+		at.ctxErr = ctx.Err
+	}
+	if md != "" { // the trace context is continued only once
+		ctx = context.WithValue(ctx, contextRemoteParentKey, "")
+	}
+	return t, NewContext(ctx, t)
+}
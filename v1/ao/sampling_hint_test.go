@@ -0,0 +1,69 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBeginTraceSamplingHint(t *testing.T) {
+	r := reporter.SetTestReporter() // 100% sampling rate
+
+	tr, ctx := ao.BeginTrace(ao.WithSamplingHint(context.Background(), ao.ForceDrop), "dropped")
+	assert.False(t, tr.IsSampled())
+	assert.False(t, ao.IsSampled(ctx))
+	tr.End()
+
+	tr, ctx = ao.BeginTrace(ao.WithSamplingHint(context.Background(), ao.ForceSample), "sampled")
+	assert.True(t, tr.IsSampled())
+	assert.True(t, ao.IsSampled(ctx))
+	tr.End()
+
+	tr, _ = ao.BeginTrace(context.Background(), "default")
+	assert.True(t, tr.IsSampled())
+	tr.End()
+
+	r.Close(4)
+	assert.Len(t, r.EventBufs, 4)
+}
+
+func TestHTTPHandlerSamplingHint(t *testing.T) {
+	r := reporter.SetTestReporter() // 100% sampling rate
+	h := http.HandlerFunc(ao.HTTPHandler(handler200))
+
+	// the hint is honored for a new trace
+	req := httptest.NewRequest("GET", "http://test.com/hello", nil)
+	req = req.WithContext(ao.WithSamplingHint(req.Context(), ao.ForceDrop))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	md := w.Header().Get(ao.HTTPHeaderName)
+	require.True(t, reporter.ValidMetadata(md), md)
+	assert.True(t, strings.HasSuffix(md, "00"), md)
+
+	// a sampled inbound context takes precedence over the hint
+	inbound := "2BF4CAA9299299E3D38A58A9821BD34F6268E576CFAB2198D447EA220301"
+	req = httptest.NewRequest("GET", "http://test.com/hello", nil)
+	req.Header.Set(ao.HTTPHeaderName, inbound)
+	req = req.WithContext(ao.WithSamplingHint(req.Context(), ao.ForceDrop))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	md = w.Header().Get(ao.HTTPHeaderName)
+	require.True(t, reporter.ValidMetadata(md), md)
+	assert.Equal(t, inbound[2:42], md[2:42])
+	assert.True(t, strings.HasSuffix(md, "01"), md)
+
+	// the sampled trace reports an entry and an exit event, and both requests
+	// report a span message
+	r.Close(4)
+	assert.Len(t, r.EventBufs, 2)
+	assert.Len(t, r.SpanMessages, 2)
+}
@@ -0,0 +1,144 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// SamplingPriority is the importance of a trace, which is reported with the
+// trace and propagated downstream, so the backend and the downstream services
+// may retain the important traces longer.
+type SamplingPriority int32
+
+// The sampling priorities
+const (
+	PriorityUnset SamplingPriority = iota
+	PriorityLow
+	PriorityNormal
+	PriorityHigh
+)
+
+// TraceStateHeaderName is the W3C trace context header which propagates the
+// sampling priority of the trace, along with the entries of the other vendors.
+const TraceStateHeaderName = "tracestate"
+
+const (
+	// the KV of the trace's entry or exit event reporting the sampling priority
+	keySamplingPriority = "SamplingPriority"
+	// the tracestate key of the sampling priority
+	traceStatePriorityKey = "ao-priority"
+	// the max number of entries of a tracestate header
+	traceStateMaxEntries = 32
+	// the max size in bytes of a tracestate header propagated
+	traceStateMaxSize = 512
+)
+
+var priorityNames = map[SamplingPriority]string{
+	PriorityLow:    "low",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+}
+
+// String returns the name of the priority, or an empty string if it's unset
+// or invalid.
+func (p SamplingPriority) String() string {
+	return priorityNames[p]
+}
+
+// ParseSamplingPriority returns the priority of the name provided, e.g., high.
+func ParseSamplingPriority(name string) (SamplingPriority, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for p, n := range priorityNames {
+		if n == name {
+			return p, true
+		}
+	}
+	return PriorityUnset, false
+}
+
+// SetSamplingPriority sets the sampling priority of the trace bound to the
+// context. The priority inherited from the upstream service is reported as the
+// SamplingPriority KV of the trace's entry event, and the one set or changed
+// afterwards is reported by the trace's exit event. It's propagated in the tracestate header of the outbound requests made by
+// BeginHTTPClientSpan. The priority is propagated even if the trace is not
+// sampled.
+func SetSamplingPriority(ctx context.Context, p SamplingPriority) {
+	if t, ok := traceFromContext(ctx); ok {
+		setTracePriority(t, p)
+	}
+}
+
+// SamplingPriorityFromContext returns the sampling priority of the trace bound
+// to the context, which may be inherited from the upstream service.
+func SamplingPriorityFromContext(ctx context.Context) SamplingPriority {
+	if t, ok := traceFromContext(ctx); ok {
+		if at, ok := t.(*aoTrace); ok {
+			return SamplingPriority(atomic.LoadInt32(&at.priority))
+		}
+	}
+	return PriorityUnset
+}
+
+func setTracePriority(t Trace, p SamplingPriority) {
+	if _, valid := priorityNames[p]; !valid && p != PriorityUnset {
+		return
+	}
+	if at, ok := t.(*aoTrace); ok {
+		atomic.StoreInt32(&at.priority, int32(p))
+	}
+}
+
+// inheritTracePriority sets the sampling priority of the trace inherited from
+// the upstream service, which has been reported by the trace's entry event.
+func inheritTracePriority(t Trace, p SamplingPriority) {
+	setTracePriority(t, p)
+	if at, ok := t.(*aoTrace); ok {
+		at.entryPriority = int32(p)
+	}
+}
+
+// priorityFromTraceState returns the sampling priority carried by the
+// tracestate header, if any. An oversized header is ignored.
+func priorityFromTraceState(state string) SamplingPriority {
+	if reporter.OversizedHeader(TraceStateHeaderName, state) {
+		return PriorityUnset
+	}
+	for _, entry := range strings.Split(state, ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(kv) == 2 && kv[0] == traceStatePriorityKey {
+			p, _ := ParseSamplingPriority(kv[1])
+			return p
+		}
+	}
+	return PriorityUnset
+}
+
+// traceStateWithPriority returns the tracestate header with the sampling
+// priority as its first entry, which replaces the existing one, if any. The
+// entries of the other vendors are kept, as required by the W3C trace context,
+// within traceStateMaxEntries entries and traceStateMaxSize bytes. An entry
+// which doesn't fit is dropped.
+func traceStateWithPriority(state string, p SamplingPriority) string {
+	entries := []string{traceStatePriorityKey + "=" + p.String()}
+	size := len(entries[0])
+	for _, entry := range strings.Split(state, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, traceStatePriorityKey+"=") {
+			continue
+		}
+		if len(entries) == traceStateMaxEntries {
+			break
+		}
+		if size+1+len(entry) > traceStateMaxSize {
+			continue
+		}
+		entries = append(entries, entry)
+		size += 1 + len(entry)
+	}
+	return strings.Join(entries, ",")
+}
@@ -0,0 +1,107 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSamplingPriority(t *testing.T) {
+	for name, expected := range map[string]ao.SamplingPriority{
+		"low": ao.PriorityLow, "normal": ao.PriorityNormal, " HIGH": ao.PriorityHigh,
+	} {
+		p, ok := ao.ParseSamplingPriority(name)
+		assert.True(t, ok)
+		assert.Equal(t, expected, p)
+	}
+	p, ok := ao.ParseSamplingPriority("urgent")
+	assert.False(t, ok)
+	assert.Equal(t, ao.PriorityUnset, p)
+	assert.Equal(t, "high", ao.PriorityHigh.String())
+	assert.Equal(t, "", ao.PriorityUnset.String())
+}
+
+func TestSamplingPriority(t *testing.T) {
+	r := reporter.SetTestReporter()
+	ctx := ao.NewContext(context.Background(), ao.NewTrace("priority"))
+	assert.Equal(t, ao.PriorityUnset, ao.SamplingPriorityFromContext(ctx))
+
+	// not propagated if it's unset
+	req, _ := http.NewRequest("GET", "http://test.com/downstream", nil)
+	ao.BeginHTTPClientSpan(ctx, req).End()
+	assert.Empty(t, req.Header.Get(ao.TraceStateHeaderName))
+
+	ao.SetSamplingPriority(ctx, ao.PriorityHigh)
+	assert.Equal(t, ao.PriorityHigh, ao.SamplingPriorityFromContext(ctx))
+
+	// the entries of the other vendors are kept
+	req, _ = http.NewRequest("GET", "http://test.com/downstream", nil)
+	req.Header.Set(ao.TraceStateHeaderName, "ao-priority=low,vendor=abc")
+	ao.BeginHTTPClientSpan(ctx, req).End()
+	assert.Equal(t, "ao-priority=high,vendor=abc", req.Header.Get(ao.TraceStateHeaderName))
+
+	// the tracestate propagated is capped, the entries which don't fit are dropped
+	large := "big=" + strings.Repeat("a", 500)
+	req, _ = http.NewRequest("GET", "http://test.com/downstream", nil)
+	req.Header.Set(ao.TraceStateHeaderName, "vendor=abc,"+large+",other=def")
+	ao.BeginHTTPClientSpan(ctx, req).End()
+	assert.Equal(t, "ao-priority=high,vendor=abc,other=def", req.Header.Get(ao.TraceStateHeaderName))
+	ao.EndTrace(ctx)
+
+	r.Close(8)
+	g.AssertGraph(t, r.EventBufs, 8, g.AssertNodeMap{
+		{"priority", "entry"}:    {},
+		{"http.Client", "entry"}: {Count: 3, Edges: g.Edges{{"priority", "entry"}}},
+		{"http.Client", "exit"}:  {Count: 3, Edges: g.Edges{{"http.Client", "entry"}}},
+		{"priority", "exit"}: {Edges: g.Edges{
+			{"http.Client", "exit"}, {"http.Client", "exit"}, {"http.Client", "exit"}, {"priority", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "high", n.Map["SamplingPriority"])
+		}},
+	})
+}
+
+func TestSamplingPriorityFromUpstream(t *testing.T) {
+	r := reporter.SetTestReporter()
+	var priority ao.SamplingPriority
+	h := http.HandlerFunc(ao.HTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		priority = ao.SamplingPriorityFromContext(r.Context())
+	}))
+	req, _ := http.NewRequest("GET", "http://test.com/hello", nil)
+	req.Header.Set(ao.TraceStateHeaderName, "vendor=abc, ao-priority=low")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, ao.PriorityLow, priority)
+
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		// the inherited priority is reported on entry only
+		{"http.HandlerFunc", "entry"}: {Callback: func(n g.Node) {
+			assert.Equal(t, "low", n.Map["SamplingPriority"])
+		}},
+		{"http.HandlerFunc", "exit"}: {Edges: g.Edges{{"http.HandlerFunc", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "SamplingPriority")
+		}},
+	})
+}
+
+func TestSamplingPriorityOversizedTraceState(t *testing.T) {
+	r := reporter.SetTestReporter()
+	priority := ao.PriorityHigh
+	h := http.HandlerFunc(ao.HTTPHandler(func(w http.ResponseWriter, r *http.Request) {
+		priority = ao.SamplingPriorityFromContext(r.Context())
+	}))
+	req, _ := http.NewRequest("GET", "http://test.com/hello", nil)
+	req.Header.Set(ao.TraceStateHeaderName, "ao-priority=low,vendor="+strings.Repeat("a", 1024))
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	// the oversized tracestate is ignored
+	assert.Equal(t, ao.PriorityUnset, priority)
+	r.Close(2)
+}
@@ -0,0 +1,97 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/pkg/errors"
+)
+
+var contextRemoteParentKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.RemoteParent")
+var contextBaggageKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.Baggage")
+
+// the separator of the trace context and the baggage items in the string
+// returned by MarshalContext
+const serializedBaggageSep = ";"
+
+// WithBaggage returns a copy of the parent context which carries the baggage
+// items, which are serialized along with the trace context by MarshalContext.
+// The map must not be modified afterwards.
+func WithBaggage(ctx context.Context, baggage map[string]string) context.Context {
+	return context.WithValue(ctx, contextBaggageKey, baggage)
+}
+
+// BaggageFromContext returns the baggage items bound to the context, if any.
+func BaggageFromContext(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+	baggage, _ := ctx.Value(contextBaggageKey).(map[string]string)
+	return baggage
+}
+
+// MarshalContext serializes the trace context of the span bound to the
+// context, i.e., the trace ID, the span ID and the flags, and the baggage
+// items of the context into a compact string, so the trace can be continued
+// later by UnmarshalContext, e.g., after a process restart or by a job stored
+// in an external system. The baggage items beyond the limits are dropped, see
+// TrimBaggage. It returns an empty string if there is no trace context to
+// propagate.
+func MarshalContext(ctx context.Context) string {
+	md := PropagationMetadata(FromContext(ctx))
+	if md == "" {
+		return ""
+	}
+	baggage := TrimBaggage(BaggageFromContext(ctx))
+	if len(baggage) == 0 {
+		return md
+	}
+	values := url.Values{}
+	for k, v := range baggage {
+		values.Set(k, v)
+	}
+	return md + serializedBaggageSep + values.Encode()
+}
+
+// UnmarshalContext decodes a string returned by MarshalContext. It returns a
+// context which carries the baggage items, and the trace context which a
+// trace started with the context by BeginTrace is continued from, so the
+// root span of the trace is a child of the span which the string is
+// marshaled from.
+func UnmarshalContext(s string) (context.Context, error) {
+	md, encoded := s, ""
+	if i := strings.Index(s, serializedBaggageSep); i >= 0 {
+		md, encoded = s[:i], s[i+len(serializedBaggageSep):]
+	}
+	if _, err := reporter.ParseMetadata(md); err != nil {
+		return nil, errors.Wrap(err, "invalid serialized trace context")
+	}
+	ctx := context.WithValue(context.Background(), contextRemoteParentKey, md)
+	if encoded == "" {
+		return ctx, nil
+	}
+
+	values, err := url.ParseQuery(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid serialized baggage")
+	}
+	baggage := make(map[string]string, len(values))
+	for k := range values {
+		baggage[k] = values.Get(k)
+	}
+	return WithBaggage(ctx, baggage), nil
+}
+
+// remoteParentFromContext returns the metadata string of the remote parent
+// bound to the context by UnmarshalContext, if any.
+func remoteParentFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	md, _ := ctx.Value(contextRemoteParentKey).(string)
+	return md
+}
@@ -0,0 +1,94 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalContext(t *testing.T) {
+	r := reporter.SetTestReporter()
+	baggage := map[string]string{"tenant": "acme", "note": "a b;c=d&e"}
+
+	tr := ao.NewTrace("producer")
+	ctx := ao.WithBaggage(ao.NewContext(context.Background(), tr), baggage)
+	s, sctx := ao.BeginSpan(ctx, "enqueue")
+	serialized := ao.MarshalContext(sctx)
+	md := s.MetadataString()
+	assert.True(t, strings.HasPrefix(serialized, md+";"), serialized)
+	s.End()
+	tr.End()
+
+	// continued later from the string
+	rctx, err := ao.UnmarshalContext(serialized)
+	require.NoError(t, err)
+	assert.Equal(t, baggage, ao.BaggageFromContext(rctx))
+	tr2, ctx2 := ao.BeginTrace(rctx, "consumer")
+	assert.True(t, tr2.IsSampled())
+	assert.Equal(t, md[2:42], tr2.MetadataString()[2:42]) // the same trace ID
+	assert.Equal(t, baggage, ao.BaggageFromContext(ctx2))
+	assert.Equal(t, md[:42], ao.MarshalContext(ctx2)[:42])
+
+	// the trace context is continued only once
+	tr3, _ := ao.BeginTrace(ctx2, "other")
+	assert.NotEqual(t, md[2:42], tr3.MetadataString()[2:42])
+	tr3.End()
+	tr2.End()
+
+	r.Close(8)
+	g.AssertGraph(t, r.EventBufs, 8, g.AssertNodeMap{
+		{"producer", "entry"}: {},
+		{"enqueue", "entry"}:  {Edges: g.Edges{{"producer", "entry"}}},
+		{"enqueue", "exit"}:   {Edges: g.Edges{{"enqueue", "entry"}}},
+		{"producer", "exit"}:  {Edges: g.Edges{{"enqueue", "exit"}, {"producer", "entry"}}},
+		// the root span of the continued trace is a child of the marshaled span
+		{"consumer", "entry"}: {Edges: g.Edges{{"enqueue", "entry"}}},
+		{"consumer", "exit"}:  {Edges: g.Edges{{"consumer", "entry"}}},
+		{"other", "entry"}:    {},
+		{"other", "exit"}:     {Edges: g.Edges{{"other", "entry"}}},
+	})
+}
+
+func TestMarshalContextWithoutBaggage(t *testing.T) {
+	r := reporter.SetTestReporter()
+	tr := ao.NewTrace("test")
+	ctx := ao.NewContext(context.Background(), tr)
+	assert.Equal(t, tr.MetadataString(), ao.MarshalContext(ctx))
+
+	rctx, err := ao.UnmarshalContext(ao.MarshalContext(ctx))
+	require.NoError(t, err)
+	assert.Nil(t, ao.BaggageFromContext(rctx))
+	tr.End()
+	r.Close(2)
+
+	// no trace context to marshal
+	assert.Empty(t, ao.MarshalContext(context.Background()))
+}
+
+func TestUnmarshalContextInvalid(t *testing.T) {
+	r := reporter.SetTestReporter()
+	tr := ao.NewTrace("test")
+	md := tr.MetadataString()
+	tr.End()
+	r.Close(2)
+
+	for _, s := range []string{
+		"",
+		"garbage",
+		";tenant=acme",
+		md[:len(md)-2],
+		md + ";%zz",
+	} {
+		ctx, err := ao.UnmarshalContext(s)
+		assert.Error(t, err, s)
+		assert.Nil(t, ctx, s)
+	}
+}
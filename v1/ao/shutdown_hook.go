@@ -0,0 +1,91 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// the signals ShutdownOnSignal listens on by default
+var defaultShutdownSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// shutdownAgent shuts down the agent, which can be overridden for testing.
+var shutdownAgent = Shutdown
+
+// shutdownWithin flushes and shuts down the agent, waiting for up to timeout.
+func shutdownWithin(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return shutdownAgent(ctx)
+}
+
+// RunWithAgent runs f, typically the body of main, and flushes and shuts down
+// the agent within the timeout once f returns or panics.
+//   func main() {
+//       ao.RunWithAgent(5*time.Second, func() {
+//           // ... the application ...
+//       })
+//   }
+func RunWithAgent(timeout time.Duration, f func()) {
+	defer shutdownWithin(timeout)
+	f()
+}
+
+// ShutdownOnSignal is an opt-in hook which flushes and shuts down the agent,
+// within the timeout, when the program receives one of the signals, or SIGINT
+// and SIGTERM if none is provided. It returns a function to unregister the hook.
+//
+// The hook listens on its own channel and doesn't replace the signal handling
+// of the application, which still receives the signals. It stops listening
+// once a signal is received and leaves the exit to the application. As the
+// signals are caught, the program is no longer terminated by them unless the
+// application handles them, see ShutdownOnSignalAndReraise otherwise.
+func ShutdownOnSignal(timeout time.Duration, sigs ...os.Signal) (stop func()) {
+	return shutdownOnSignal(timeout, false, sigs...)
+}
+
+// ShutdownOnSignalAndReraise is the same as ShutdownOnSignal, except that the
+// signal is re-raised after the shutdown, so that the program still terminates
+// if the application doesn't handle the signal. Applications which handle the
+// signals themselves would receive it twice, and should use ShutdownOnSignal
+// instead.
+func ShutdownOnSignalAndReraise(timeout time.Duration, sigs ...os.Signal) (stop func()) {
+	return shutdownOnSignal(timeout, true, sigs...)
+}
+
+func shutdownOnSignal(timeout time.Duration, reraise bool, sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = defaultShutdownSignals
+	}
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sigs...)
+
+	go func() {
+		select {
+		case sig := <-ch:
+			signal.Stop(ch)
+			shutdownWithin(timeout)
+			if !reraise {
+				return
+			}
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Signal(sig)
+			}
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
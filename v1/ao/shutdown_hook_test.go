@@ -0,0 +1,128 @@
+// +build !windows
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockShutdown replaces the agent shutdown and returns a channel which receives
+// the deadline of each shutdown.
+func mockShutdown(t *testing.T) (chan time.Time, func()) {
+	calls := make(chan time.Time, 10)
+	shutdownAgent = func(ctx context.Context) error {
+		d, ok := ctx.Deadline()
+		assert.True(t, ok)
+		calls <- d
+		return nil
+	}
+	return calls, func() { shutdownAgent = Shutdown }
+}
+
+func TestShutdownOnSignal(t *testing.T) {
+	calls, restore := mockShutdown(t)
+	defer restore()
+
+	// the application's own handler
+	appCh := make(chan os.Signal, 2)
+	signal.Notify(appCh, syscall.SIGUSR1)
+	defer signal.Stop(appCh)
+
+	stop := ShutdownOnSignal(time.Second, syscall.SIGUSR1)
+	defer stop()
+	start := time.Now()
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+
+	select {
+	case d := <-calls:
+		assert.WithinDuration(t, start.Add(time.Second), d, 500*time.Millisecond)
+	case <-time.After(time.Second):
+		t.Fatal("the agent is not shut down on the signal")
+	}
+
+	// the application still receives the signal, which is not re-raised
+	select {
+	case sig := <-appCh:
+		assert.Equal(t, syscall.SIGUSR1, sig)
+	case <-time.After(time.Second):
+		t.Fatal("the signal is not passed to the application")
+	}
+	select {
+	case <-appCh:
+		t.Fatal("the signal is re-raised")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestShutdownOnSignalAndReraise(t *testing.T) {
+	calls, restore := mockShutdown(t)
+	defer restore()
+
+	// the application's own handler, which also keeps the re-raised signal
+	// from terminating the test
+	appCh := make(chan os.Signal, 2)
+	signal.Notify(appCh, syscall.SIGUSR1)
+	defer signal.Stop(appCh)
+
+	stop := ShutdownOnSignalAndReraise(time.Second, syscall.SIGUSR1)
+	defer stop()
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatal("the agent is not shut down on the signal")
+	}
+
+	// the application receives the signal, and the re-raised one
+	for i := 0; i < 2; i++ {
+		select {
+		case sig := <-appCh:
+			assert.Equal(t, syscall.SIGUSR1, sig)
+		case <-time.After(time.Second):
+			t.Fatal("the signal is not passed to the application")
+		}
+	}
+}
+
+func TestShutdownOnSignalStop(t *testing.T) {
+	calls, restore := mockShutdown(t)
+	defer restore()
+
+	appCh := make(chan os.Signal, 1)
+	signal.Notify(appCh, syscall.SIGUSR2)
+	defer signal.Stop(appCh)
+
+	ShutdownOnSignal(time.Second, syscall.SIGUSR2)()
+	assert.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR2))
+
+	<-appCh
+	select {
+	case <-calls:
+		t.Fatal("the agent is shut down after the hook is unregistered")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestRunWithAgent(t *testing.T) {
+	calls, restore := mockShutdown(t)
+	defer restore()
+
+	ran := false
+	RunWithAgent(time.Second, func() { ran = true })
+	assert.True(t, ran)
+	assert.Len(t, calls, 1)
+
+	assert.Panics(t, func() {
+		RunWithAgent(time.Second, func() { panic("panicking") })
+	})
+	assert.Len(t, calls, 2)
+}
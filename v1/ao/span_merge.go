@@ -0,0 +1,153 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+)
+
+// The KVs of a span merged from its siblings
+const (
+	keyMergedCount    = "MergedCount"
+	keyMergedDuration = "MergedDuration"
+	keyMergedErrors   = "MergedErrorCount"
+)
+
+// spanError is an error of a span, which may be reported after it happened.
+type spanError struct {
+	class     string
+	msg       string
+	backTrace string
+	at        time.Time
+	count     int // the number of the identical errors collapsed, see errorDedup
+}
+
+func (e *spanError) kvs() []interface{} {
+	kvs := []interface{}{
+		reporter.TimestampKey, e.at,
+		keySpec, "error",
+		keyErrorClass, e.class,
+		keyErrorMsg, e.msg,
+		KeyBackTrace, e.backTrace,
+	}
+	if e.count > 0 {
+		kvs = append(kvs, keyErrorCount, e.count)
+	}
+	return kvs
+}
+
+// mergedSpans is a run of consecutive sibling spans of the same name, which
+// are reported as a single span, e.g., the per-item lookups of a loop. The
+// merged span starts when the first span starts and ends when the last one
+// ends, with the number of the spans and the sum of their durations as KVs.
+type mergedSpans struct {
+	name     string
+	aoCtx    reporter.Context // the context of the first span
+	args     []interface{}    // the entry KVs of the first span
+	endArgs  []interface{}    // the exit KVs of the first span
+	lazyKVs  []lazyKV         // the lazy KVs of the first span
+	start    time.Time
+	end      time.Time
+	count    int
+	duration time.Duration
+	err      *spanError // the first error of the spans, if any
+	errors   int
+}
+
+// siblingMerger is a span which merges its child spans of the same name.
+type siblingMerger interface {
+	mergeChild(c *span, end time.Time, endArgs []interface{}) bool
+}
+
+// deferError keeps the error of the span until it's known whether the span
+// is merged with its siblings, in which case the merged span reports it.
+func (s *span) deferError(e *spanError) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.entry == nil || !s.settings.mergeSiblingSpans {
+		return false
+	}
+	if s.entry.err == nil {
+		s.entry.err = e
+	}
+	return true
+}
+
+// mergeIntoParent ends the span by merging it into the run of its siblings
+// kept by its parent. It returns false if the parent doesn't merge it, e.g.,
+// it's ended already. The caller must hold the lock of the span.
+func (s *span) mergeIntoParent(end time.Time, endArgs []interface{}) bool {
+	p, ok := s.parent.(siblingMerger)
+	if !ok || !p.mergeChild(s, end, endArgs) {
+		return false
+	}
+	s.entry = nil
+	s.endArgs = nil
+	s.lazyKVs = nil
+	s.ended = true
+	return true
+}
+
+// mergeChild adds the ended child span to the run of its siblings of the same
+// name, or starts a new run, reporting the previous one, if its name differs.
+func (s *span) mergeChild(c *span, end time.Time, endArgs []interface{}) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ended {
+		return false
+	}
+	if s.merged != nil && s.merged.name != c.layerName() {
+		s.reportMergedLocked()
+	}
+	m := s.merged
+	if m == nil {
+		m = &mergedSpans{name: c.layerName(), aoCtx: c.aoCtx, args: c.entry.args,
+			endArgs: endArgs, lazyKVs: c.lazyKVs, start: c.entry.start}
+		s.merged = m
+	}
+	m.count++
+	m.duration += end.Sub(c.entry.start)
+	m.end = end
+	if c.entry.err != nil {
+		m.errors++
+		if m.err == nil {
+			m.err = c.entry.err
+		}
+	}
+	return true
+}
+
+// reportMerged reports the run of the child spans being merged, if any.
+func (s *span) reportMerged() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.reportMergedLocked()
+}
+
+func (s *span) reportMergedLocked() {
+	m := s.merged
+	if m == nil {
+		return
+	}
+	s.merged = nil
+
+	args := append([]interface{}{reporter.TimestampKey, m.start}, m.args...)
+	_ = m.aoCtx.ReportEvent(reporter.LabelEntry, m.name, args...)
+	if m.err != nil {
+		_ = m.aoCtx.ReportEvent(reporter.LabelError, m.name, m.err.kvs()...)
+	}
+	endArgs := append([]interface{}{reporter.TimestampKey, m.end}, m.endArgs...)
+	endArgs = append(endArgs, lazyKVArgs(m.lazyKVs)...)
+	if m.count > 1 {
+		endArgs = append(endArgs,
+			keyMergedCount, m.count,
+			keyMergedDuration, int64(roundDuration(m.duration)/time.Microsecond))
+		if m.errors > 0 {
+			endArgs = append(endArgs, keyMergedErrors, m.errors)
+		}
+	}
+	_ = m.aoCtx.ReportEvent(reporter.LabelExit, m.name, endArgs...)
+	s.childEdges = append(s.childEdges, m.aoCtx)
+}
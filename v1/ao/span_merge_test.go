@@ -0,0 +1,162 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func setMergeSiblingSpans() func() {
+	os.Setenv("APPOPTICS_MERGE_SIBLING_SPANS", "true")
+	config.Load()
+	return func() {
+		os.Unsetenv("APPOPTICS_MERGE_SIBLING_SPANS")
+		config.Load()
+	}
+}
+
+func TestMergeSiblingSpans(t *testing.T) {
+	defer setMergeSiblingSpans()()
+	r := reporter.SetTestReporter()
+
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+	for i := 0; i < 10; i++ {
+		s, _ := BeginSpan(ctx, "lookup", "Key", "first")
+		s.End()
+	}
+	other, _ := BeginSpan(ctx, "other")
+	other.End()
+	tr.End()
+
+	r.Close(6)
+	g.AssertGraph(t, r.EventBufs, 6, g.AssertNodeMap{
+		{"root", "entry"}: {},
+		{"lookup", "entry"}: {Edges: g.Edges{{"root", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "first", n.Map["Key"])
+		}},
+		{"lookup", "exit"}: {Edges: g.Edges{{"lookup", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, 10, n.Map[keyMergedCount])
+			assert.IsType(t, int64(0), n.Map[keyMergedDuration])
+			assert.NotContains(t, n.Map, keyMergedErrors)
+		}},
+		// a single span is reported as is
+		{"other", "entry"}: {Edges: g.Edges{{"root", "entry"}}},
+		{"other", "exit"}: {Edges: g.Edges{{"other", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, keyMergedCount)
+		}},
+		{"root", "exit"}: {Edges: g.Edges{{"lookup", "exit"}, {"other", "exit"}, {"root", "entry"}}},
+	})
+}
+
+func TestMergeSiblingSpansDurationPrecision(t *testing.T) {
+	defer setMergeSiblingSpans()()
+	os.Setenv("APPOPTICS_DURATION_PRECISION", "ms")
+	config.Load()
+	defer os.Unsetenv("APPOPTICS_DURATION_PRECISION")
+	r := reporter.SetTestReporter()
+
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+	for i := 0; i < 2; i++ {
+		s, _ := BeginSpan(ctx, "lookup")
+		time.Sleep(700 * time.Microsecond)
+		s.End()
+	}
+	tr.End()
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"root", "entry"}:   {},
+		{"lookup", "entry"}: {Edges: g.Edges{{"root", "entry"}}},
+		{"lookup", "exit"}: {Edges: g.Edges{{"lookup", "entry"}}, Callback: func(n g.Node) {
+			d := n.Map[keyMergedDuration].(int64)
+			assert.NotZero(t, d)
+			assert.Zero(t, d%1000, "%d", d)
+		}},
+		{"root", "exit"}: {Edges: g.Edges{{"lookup", "exit"}, {"root", "entry"}}},
+	})
+}
+
+func TestMergeSiblingSpansError(t *testing.T) {
+	defer setMergeSiblingSpans()()
+	r := reporter.SetTestReporter()
+
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+	for i := 0; i < 5; i++ {
+		s, _ := BeginSpan(ctx, "lookup")
+		if i == 2 {
+			s.Err(errors.New("not found"))
+		}
+		s.End()
+	}
+	tr.End()
+
+	r.Close(5)
+	g.AssertGraph(t, r.EventBufs, 5, g.AssertNodeMap{
+		{"root", "entry"}:   {},
+		{"lookup", "entry"}: {Edges: g.Edges{{"root", "entry"}}},
+		{"lookup", "error"}: {Edges: g.Edges{{"lookup", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "error", n.Map[keyErrorClass])
+			assert.Equal(t, "not found", n.Map[keyErrorMsg])
+		}},
+		{"lookup", "exit"}: {Edges: g.Edges{{"lookup", "error"}}, Callback: func(n g.Node) {
+			assert.Equal(t, 5, n.Map[keyMergedCount])
+			assert.Equal(t, 1, n.Map[keyMergedErrors])
+		}},
+		{"root", "exit"}: {Edges: g.Edges{{"lookup", "exit"}, {"root", "entry"}}},
+	})
+}
+
+func TestMergeSiblingSpansConsecutive(t *testing.T) {
+	defer setMergeSiblingSpans()()
+	r := reporter.SetTestReporter()
+
+	tr := NewTrace("root")
+	ctx := NewContext(context.Background(), tr)
+	for _, run := range []string{"1", "1", "", "2", "2", "2"} {
+		name := "a"
+		if run == "" {
+			name = "b"
+		}
+		s, _ := BeginSpan(ctx, name, "Run", run)
+		s.End("Run", run)
+	}
+	// a span with events of its own is not merged
+	s, _ := BeginSpan(ctx, "a", "Run", "3")
+	s.Info("K", "V")
+	s.End("Run", "3")
+	tr.End()
+
+	r.Close(11)
+	g.AssertGraph(t, r.EventBufs, 11, g.AssertNodeKVMap{
+		{"root", "entry", "", ""}:  {},
+		{"a", "entry", "Run", "1"}: {Edges: g.Edges{{"root", "entry"}}},
+		{"a", "exit", "Run", "1"}: {Edges: g.Edges{{"a", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, 2, n.Map[keyMergedCount])
+		}},
+		{"b", "entry", "", ""}:     {Edges: g.Edges{{"root", "entry"}}},
+		{"b", "exit", "", ""}:      {Edges: g.Edges{{"b", "entry"}}},
+		{"a", "entry", "Run", "2"}: {Edges: g.Edges{{"root", "entry"}}},
+		{"a", "exit", "Run", "2"}: {Edges: g.Edges{{"a", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, 3, n.Map[keyMergedCount])
+		}},
+		{"a", "entry", "Run", "3"}: {Edges: g.Edges{{"root", "entry"}}},
+		{"a", "info", "K", "V"}:    {Edges: g.Edges{{"a", "entry"}}},
+		{"a", "exit", "Run", "3"}: {Edges: g.Edges{{"a", "info"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, keyMergedCount)
+		}},
+		{"root", "exit", "", ""}: {Edges: g.Edges{
+			{"a", "exit"}, {"b", "exit"}, {"a", "exit"}, {"a", "exit"}, {"root", "entry"}}},
+	})
+}
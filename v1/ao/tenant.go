@@ -0,0 +1,30 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import "context"
+
+var contextTenantKey = contextKeyT("github.com/appoptics/appoptics-apm-go/v1/ao.Tenant")
+
+// WithTenant returns a copy of the parent context which carries the tenant
+// name. A trace started with the context, e.g., by BeginTrace or the HTTP
+// handler wrappers, is reported with the service key configured for the
+// tenant in APPOPTICS_TENANT_SERVICE_KEYS, instead of the default service key,
+// so one process can report the traces to multiple AppOptics accounts. The
+// trace is reported with the default service key if the tenant is not
+// configured.
+//
+// The transaction metrics are always reported with the default service key.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, contextTenantKey, tenant)
+}
+
+// TenantFromContext returns the tenant name bound to the context, or an empty
+// string if there is none.
+func TenantFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	tenant, _ := ctx.Value(contextTenantKey).(string)
+	return tenant
+}
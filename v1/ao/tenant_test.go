@@ -0,0 +1,48 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithTenant(t *testing.T) {
+	os.Setenv("APPOPTICS_TENANT_SERVICE_KEYS",
+		"tenantA=ae38315f6116585d64d82ec2455aa3ec61e02fee25d286f74ace9e4fea189217:tenant-a")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_TENANT_SERVICE_KEYS")
+		config.Load()
+	}()
+
+	assert.Empty(t, ao.TenantFromContext(context.Background()))
+	ctx := ao.WithTenant(context.Background(), "tenantA")
+	assert.Equal(t, "tenantA", ao.TenantFromContext(ctx))
+
+	r := reporter.SetTestReporter()
+	tr, _ := ao.BeginTrace(ctx, "tenant")
+	assert.True(t, tr.IsSampled())
+	tr.End()
+
+	// the traces of an unknown tenant are reported with the default service key
+	ctx = ao.WithTenant(context.Background(), "tenantB")
+	tr, _ = ao.BeginTrace(ctx, "unknown")
+	assert.True(t, tr.IsSampled())
+	tr.End()
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"tenant", "entry"}:  {},
+		{"tenant", "exit"}:   {Edges: g.Edges{{"tenant", "entry"}}},
+		{"unknown", "entry"}: {},
+		{"unknown", "exit"}:  {Edges: g.Edges{{"unknown", "entry"}}},
+	})
+}
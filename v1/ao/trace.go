@@ -4,12 +4,14 @@ package ao
 
 import (
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"context"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -85,10 +87,32 @@ type aoTrace struct {
 	layerSpan
 	exitEvent reporter.Event
 	httpSpan  traceHTTPSpan
+	priority  int32 // the SamplingPriority, accessed atomically
+	// the SamplingPriority reported by the entry event
+	entryPriority int32
 }
 
 func (t *aoTrace) aoContext() reporter.Context { return t.aoCtx }
 
+// TraceOrigin is the entry point which starts a trace. The new traces of an
+// origin are sampled at its own sample rate, if it's configured by
+// OriginSampleRates (APPOPTICS_ORIGIN_SAMPLE_RATES).
+type TraceOrigin string
+
+// The origins of the traces
+const (
+	// TraceOriginHTTP is the origin of the traces started by the HTTP handlers.
+	TraceOriginHTTP TraceOrigin = config.TraceOriginHTTP
+	// TraceOriginGRPC is the origin of the traces started by the gRPC server
+	// interceptors.
+	TraceOriginGRPC TraceOrigin = config.TraceOriginGRPC
+	// TraceOriginJob is the origin of the traces started by RunJob.
+	TraceOriginJob TraceOrigin = config.TraceOriginJob
+	// TraceOriginManual is the origin of the traces started by the other
+	// functions, e.g., NewTrace and BeginTrace.
+	TraceOriginManual TraceOrigin = config.TraceOriginManual
+)
+
 // NewTrace creates a new Trace for reporting to AppOptics and immediately records
 // the beginning of a root span named spanName. If this trace is sampled, it may report
 // event data to AppOptics; otherwise event reporting will be a no-op.
@@ -115,11 +139,25 @@ func NewTraceFromID(spanName, mdStr string, cb func() KVMap) Trace {
 // provided an incoming trace ID (e.g. from a incoming RPC or service call's "X-Trace" header).
 // If callback is provided & trace is sampled, cb will be called for entry event KVs
 func NewTraceFromIDForURL(spanName, mdStr string, url string, cb func() KVMap) Trace {
+	return NewTraceFromIDForOrigin(spanName, mdStr, url, TraceOriginManual, cb)
+}
+
+// NewTraceFromIDForOrigin is the same as NewTraceFromIDForURL, except that a
+// new trace is sampled at the sample rate of its origin, if it's configured.
+func NewTraceFromIDForOrigin(spanName, mdStr string, url string, origin TraceOrigin, cb func() KVMap) Trace {
+	return newTrace(spanName, mdStr, url, reporter.SamplingHintNone, "", origin, cb)
+}
+
+// newTrace creates a new Trace, the sampling hint is honored if the trace is
+// not continued from mdStr. The trace is reported for the tenant if it's not
+// empty, and sampled at the sample rate of its origin, if any.
+func newTrace(spanName, mdStr string, url string, hint reporter.SamplingHint, tenant string, origin TraceOrigin,
+	cb func() KVMap) Trace {
 	if Disabled() || Closed() {
 		return NewNullTrace()
 	}
 
-	ctx, ok := reporter.NewContextForURL(spanName, mdStr, true, url, func() map[string]interface{} {
+	ctx, ok := reporter.NewContextForOrigin(spanName, mdStr, true, url, hint, tenant, string(origin), func() map[string]interface{} {
 		if cb != nil {
 			return cb()
 		}
@@ -129,13 +167,19 @@ func NewTraceFromIDForURL(spanName, mdStr string, url string, cb func() KVMap) T
 		return NewNullTrace()
 	}
 	t := &aoTrace{
-		layerSpan: layerSpan{span: span{aoCtx: ctx, labeler: spanLabeler{spanName}}},
+		layerSpan: layerSpan{span: span{aoCtx: ctx, labeler: spanLabeler{spanName},
+			settings: newTraceSettings()}},
 	}
 	t.SetStartTime(time.Now())
+	reporter.AddActiveTraces(1)
 	return t
 }
 
 // SetTransactionName can be called inside a http handler to set the custom transaction name.
+// It can be called at any time before the trace bound to the context ends, e.g., once the
+// routing resolves the logical transaction name, and overrides the name set previously. The
+// final name is reported by the root span and used as the key of the transaction metrics.
+// It returns an error if the trace has ended.
 func SetTransactionName(ctx context.Context, name string) error {
 	return TraceFromContext(ctx).SetTransactionName(name)
 }
@@ -145,12 +189,50 @@ func GetTransactionName(ctx context.Context) string {
 	return TraceFromContext(ctx).GetTransactionName()
 }
 
+var errQueueTimeInFuture = errors.New("the enqueue time is in the future")
+
+// SetQueueTime records the time a request, e.g., a task of an async worker,
+// spent waiting in a queue before its processing started, given the time it
+// was enqueued. The queue time is measured up to the start of the trace bound
+// to the context, and is reported as the QueueTime KV (in microseconds) of the
+// root span and aggregated into the TransactionQueueTime metric.
+//
+// It returns an error if the enqueue time is in the future.
+func SetQueueTime(ctx context.Context, enqueuedAt time.Time) error {
+	if enqueuedAt.After(time.Now()) {
+		return errQueueTimeInFuture
+	}
+	if t, ok := TraceFromContext(ctx).(*aoTrace); ok {
+		t.setQueueTime(enqueuedAt)
+	}
+	return nil
+}
+
+func (t *aoTrace) setQueueTime(enqueuedAt time.Time) {
+	if !t.ok() {
+		return
+	}
+	start := t.httpSpan.start
+	if start.IsZero() {
+		start = time.Now()
+	}
+	queueTime := start.Sub(enqueuedAt)
+	if queueTime < 0 {
+		// enqueued after the trace started
+		queueTime = 0
+	}
+	t.httpSpan.span.QueueTime = queueTime
+	t.AddEndArgs(keyQueueTime, int64(queueTime/time.Microsecond))
+}
+
 // End reports the exit event for the span name that was used when calling NewTrace().
 // No more events should be reported from this trace.
 func (t *aoTrace) End(args ...interface{}) {
 	if t.ok() {
 		t.AddEndArgs(args...)
 		t.reportExit()
+	} else {
+		t.warnEnded()
 	}
 }
 
@@ -165,6 +247,8 @@ func (t *aoTrace) EndCallback(cb func() KVMap) {
 			t.AddEndArgs(args...)
 		}
 		t.reportExit()
+	} else {
+		t.warnEnded()
 	}
 }
 
@@ -205,13 +289,29 @@ func (t *aoTrace) reportExit() {
 		if t.ended {
 			return
 		}
+		reporter.AddActiveTraces(-1)
 
 		// if this is an HTTP trace, record a new span
 		if !t.httpSpan.start.IsZero() {
-			t.httpSpan.span.Duration = time.Now().Sub(t.httpSpan.start)
+			// Sub uses the monotonic clock if the start time is obtained
+			// from time.Now, otherwise the wall clock may have been stepped
+			// backward since the start.
+			t.httpSpan.span.Duration = roundDuration(time.Now().Sub(t.httpSpan.start))
+			if t.httpSpan.span.Duration < 0 {
+				t.httpSpan.span.Duration = 0
+			}
 			t.recordHTTPSpan()
 		}
 
+		t.reportMergedLocked()
+		t.reportDedupErrorsLocked()
+		t.endArgs = append(t.endArgs, t.lazyKVArgsLocked()...)
+		if p := SamplingPriority(atomic.LoadInt32(&t.priority)); p != PriorityUnset && int32(p) != t.entryPriority {
+			t.endArgs = append(t.endArgs, keySamplingPriority, p.String())
+		}
+		if status := t.cancelStatusLocked(); status != "" {
+			t.endArgs = append(t.endArgs, keySpanStatus, status)
+		}
 		for _, edge := range t.childEdges { // add Edge KV for each joined child
 			t.endArgs = append(t.endArgs, keyEdge, edge)
 		}
@@ -242,10 +342,26 @@ func (t *aoTrace) ExitMetadata() (mdHex string) {
 	return
 }
 
+// removeArg returns the KV args with the ones of the key removed.
+func removeArg(args []interface{}, key string) []interface{} {
+	kept := args[:0]
+	for i := 0; i+1 < len(args); i += 2 {
+		if k, ok := args[i].(string); ok && k == key {
+			continue
+		}
+		kept = append(kept, args[i], args[i+1])
+	}
+	if len(args)%2 == 1 {
+		kept = append(kept, args[len(args)-1])
+	}
+	return kept
+}
+
 // recordHTTPSpan extract http status, controller and action from the deferred endArgs
 // and fill them into trace's httpSpan struct. The data is then sent to the span message channel.
 func (t *aoTrace) recordHTTPSpan() {
 	var controller, action string
+	hasStatus := false
 	num := len([]string{keyStatus, keyController, keyAction})
 	for i := 0; (i+1 < len(t.endArgs)) && (num > 0); i += 2 {
 		k, isStr := t.endArgs[i].(string)
@@ -259,6 +375,7 @@ func (t *aoTrace) recordHTTPSpan() {
 			case *int:
 				t.httpSpan.span.Status = *v
 			}
+			hasStatus = true
 			num--
 		} else if k == keyController {
 			controller += t.endArgs[i+1].(string)
@@ -271,8 +388,16 @@ func (t *aoTrace) recordHTTPSpan() {
 
 	t.finalizeTxnName(controller, action)
 
-	if t.httpSpan.span.Status >= 500 && t.httpSpan.span.Status < 600 {
-		t.httpSpan.span.HasError = true
+	t.httpSpan.span.HasError = config.IsErrorStatusCode(t.httpSpan.span.Status)
+	// the status set by SetStatus is reported by the exit event too, so that
+	// an unsampled trace with an error status can still be kept.
+	if !hasStatus && t.httpSpan.span.Status != 0 {
+		t.endArgs = append(t.endArgs, keyStatus, t.httpSpan.span.Status)
+	}
+	if config.IsHTTPKVDisabled(keyStatus) {
+		// the trace may still be kept by its status
+		reporter.SetTraceStatus(t.aoCtx, t.httpSpan.span.Status)
+		t.endArgs = removeArg(t.endArgs, keyStatus)
 	}
 
 	if t.aoCtx.GetEnabled() {
@@ -299,12 +424,29 @@ func (t *aoTrace) finalizeTxnName(controller string, action string) {
 		t.httpSpan.span.Transaction = reporter.GetTransactionFromPath(t.httpSpan.span.Path)
 	}
 
+	t.httpSpan.span.Transaction = normalizeTxnName(t.httpSpan.span.Transaction)
+
 	if t.httpSpan.span.Transaction == "" {
 		t.httpSpan.span.Transaction = reporter.UnknownTransactionName
 	}
 	t.prependDomainToTxnName()
 }
 
+// normalizeTxnName removes the trailing slashes of the transaction name if
+// APPOPTICS_TRIM_TRAILING_SLASH = true, and converts it to lower case if
+// APPOPTICS_LOWERCASE_TRANSACTION_NAME = true.
+func normalizeTxnName(name string) string {
+	if config.GetTrimTrailingSlash() && len(name) > 1 {
+		if name = strings.TrimRight(name, "/"); name == "" {
+			name = "/"
+		}
+	}
+	if config.GetLowercaseTransactionName() {
+		name = strings.ToLower(name)
+	}
+	return name
+}
+
 // prependDomainToTxnName prepends the domain to the transaction name if APPOPTICS_PREPEND_DOMAIN = true
 func (t *aoTrace) prependDomainToTxnName() {
 	if !config.GetPrependDomain() ||
@@ -349,3 +491,9 @@ func (t *nullTrace) recordMetrics()               {}
 
 // NewNullTrace returns a trace that is not sampled.
 func NewNullTrace() Trace { return &nullTrace{} }
+
+// roundDuration rounds the duration to be reported to the precision configured
+// by APPOPTICS_DURATION_PRECISION.
+func roundDuration(d time.Duration) time.Duration {
+	return d.Round(config.GetDurationPrecision())
+}
@@ -0,0 +1,67 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"net/http"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/pkg/errors"
+)
+
+// ErrNoTraceContext is returned by ParseTraceContext if the headers don't
+// carry any trace context.
+var ErrNoTraceContext = errors.New("no trace context found")
+
+// TraceContext is the decoded trace context propagated in the request headers.
+type TraceContext struct {
+	// Header is the name of the header which carries the trace context.
+	Header string
+	// Value is the raw value of the header.
+	Value string
+	// Version is the version of the X-Trace format.
+	Version uint8
+	// TraceID is the hex encoded ID of the trace.
+	TraceID string
+	// SpanID is the hex encoded ID of the span which sends the request.
+	SpanID string
+	// Flags is the trace flags.
+	Flags uint8
+	// Sampled indicates if the trace is sampled by the upstream service.
+	Sampled bool
+}
+
+// ParseTraceContext decodes the trace context carried by the headers and
+// returns the decoded fields, or an error describing why the trace context
+// is invalid. It's intended for debugging the propagation issues, for
+// example, in a debug endpoint or tests. It doesn't start a trace or change
+// any state of the agent.
+func ParseTraceContext(headers http.Header) (TraceContext, error) {
+	tc := TraceContext{Header: HTTPHeaderName}
+	if headers == nil {
+		return tc, ErrNoTraceContext
+	}
+	tc.Value = headers.Get(HTTPHeaderName)
+	if tc.Value == "" {
+		return tc, ErrNoTraceContext
+	}
+	if reporter.OversizedHeader(HTTPHeaderName, tc.Value) {
+		tc.Value = ""
+		return tc, ErrNoTraceContext
+	}
+
+	value, err := reporter.ValidateHeader(HTTPHeaderName, tc.Value)
+	if err != nil {
+		return tc, err
+	}
+	md, err := reporter.ParseMetadata(value)
+	if err != nil {
+		return tc, errors.Wrapf(err, "invalid %s header", HTTPHeaderName)
+	}
+	tc.Version = md.Version
+	tc.TraceID = md.TaskID
+	tc.SpanID = md.OpID
+	tc.Flags = md.Flags
+	tc.Sampled = md.Flags&reporter.XTR_FLAGS_SAMPLED != 0
+	return tc, nil
+}
@@ -0,0 +1,78 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao_test
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTraceContext(t *testing.T) {
+	md := "2B7435A9FE510AE4533414D425DADF4E180D2B4E3649E60702469DB05F01"
+	tc, err := ao.ParseTraceContext(http.Header{ao.HTTPHeaderName: {md}})
+	require.NoError(t, err)
+	assert.Equal(t, ao.TraceContext{
+		Header:  ao.HTTPHeaderName,
+		Value:   md,
+		Version: 2,
+		TraceID: "7435A9FE510AE4533414D425DADF4E180D2B4E36",
+		SpanID:  "49E60702469DB05F",
+		Flags:   1,
+		Sampled: true,
+	}, tc)
+
+	// unsampled
+	md = "2B7435A9FE510AE4533414D425DADF4E180D2B4E3649E60702469DB05F00"
+	tc, err = ao.ParseTraceContext(http.Header{ao.HTTPHeaderName: {md}})
+	require.NoError(t, err)
+	assert.Equal(t, uint8(0), tc.Flags)
+	assert.False(t, tc.Sampled)
+
+	// the header name is case-insensitive
+	h := http.Header{}
+	h.Set("x-trace", md)
+	tc, err = ao.ParseTraceContext(h)
+	require.NoError(t, err)
+	assert.Equal(t, "49E60702469DB05F", tc.SpanID)
+}
+
+func TestParseTraceContextErrors(t *testing.T) {
+	_, err := ao.ParseTraceContext(nil)
+	assert.Equal(t, ao.ErrNoTraceContext, err)
+
+	_, err = ao.ParseTraceContext(http.Header{"Other": {"value"}})
+	assert.Equal(t, ao.ErrNoTraceContext, err)
+
+	testCases := []struct {
+		md  string
+		err string
+	}{
+		{"2B7435A9FE510AE4533414D425DADF4E180D2B4E3649E60702469DB05F0", "hex not even"},
+		{"2B7435A9FE510AE4533414D425DADF4E180D2B4E3649E60702469DB05FZZ", "hex not valid"},
+		{"1B7435A9FE510AE4533414D425DADF4E180D2B4E3649E60702469DB05F01", "unrecognized X-Trace version"},
+		{"2B7435A9FE510AE4533414D425DADF4E180D2B4E36", "wrong header length"},
+	}
+	for _, tc := range testCases {
+		decoded, err := ao.ParseTraceContext(http.Header{ao.HTTPHeaderName: {tc.md}})
+		if assert.Error(t, err, tc.md) {
+			assert.Contains(t, err.Error(), tc.err)
+			assert.Contains(t, err.Error(), "invalid X-Trace header")
+		}
+		assert.Equal(t, tc.md, decoded.Value)
+		assert.Empty(t, decoded.TraceID)
+	}
+}
+
+func TestParseTraceContextOversized(t *testing.T) {
+	// a valid X-Trace padded beyond the default maximum size of 1024 bytes
+	md := "2B7435A9FE510AE4533414D425DADF4E180D2B4E3649E60702469DB05F01" + strings.Repeat("0", 2*1024*1024)
+	tc, err := ao.ParseTraceContext(http.Header{ao.HTTPHeaderName: {md}})
+	assert.Equal(t, ao.ErrNoTraceContext, err)
+	assert.Empty(t, tc.Value)
+	assert.Empty(t, tc.TraceID)
+}
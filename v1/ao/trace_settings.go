@@ -0,0 +1,78 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"time"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+)
+
+// traceSettings are the settings which decide how the spans of a trace are
+// sampled and reported. They are captured when the trace starts and shared by
+// all its spans, so reloading the configuration only affects the traces
+// started afterwards and a trace in flight is reported consistently. The
+// sampling decision and the transaction filtering of a trace are made once
+// when it starts, so they are not part of the settings.
+type traceSettings struct {
+	minSpanDuration   time.Duration
+	mergeSiblingSpans bool
+	spanDepthDecay    float64
+	sqlSampleRates    map[string]int // read-only, shared with the config
+	errorDedup        *errorDedup    // nil if the identical errors are not collapsed
+}
+
+// newTraceSettings captures the trace settings from the current configuration.
+func newTraceSettings() *traceSettings {
+	ts := &traceSettings{
+		minSpanDuration:   config.GetMinSpanDuration(),
+		mergeSiblingSpans: config.GetMergeSiblingSpans(),
+		spanDepthDecay:    config.GetSpanDepthDecay(),
+		sqlSampleRates:    config.GetSQLSampleRates(),
+	}
+	if config.GetDedupErrors() {
+		ts.errorDedup = newErrorDedup()
+	}
+	return ts
+}
+
+// deferEntry returns if the entry events of the spans are deferred, which is
+// required by the span folding and merging.
+func (ts *traceSettings) deferEntry() bool {
+	return ts.minSpanDuration > 0 || ts.mergeSiblingSpans
+}
+
+// sampleChildSpan decides if a child span of a reported span is reported. A
+// span is only started if its parent is reported, so the probability is
+// conditional on the parent and decays by the configured factor once per
+// level, i.e., a span at depth d is reported at decay^d in effect.
+func (ts *traceSettings) sampleChildSpan() bool {
+	if ts.spanDepthDecay >= 1 {
+		return true
+	}
+	return spanDepthRand() < ts.spanDepthDecay
+}
+
+// sampleQuery decides if the span of the query is kept by the sample rate of
+// its SQL statement type.
+func (ts *traceSettings) sampleQuery(query string) bool {
+	if rate, ok := config.SQLSampleRate(ts.sqlSampleRates, sqlStatementType(query)); ok {
+		return sqlSampleRand(config.MaxSampleRate) < rate
+	}
+	return true
+}
+
+// settingsOf returns the trace settings of the trace which the span belongs
+// to. The settings of the current configuration are returned if the span
+// doesn't carry any, e.g., it's a null span.
+func settingsOf(s Span) *traceSettings {
+	if h, ok := s.(interface{ traceSettings() *traceSettings }); ok {
+		if ts := h.traceSettings(); ts != nil {
+			return ts
+		}
+	}
+	return newTraceSettings()
+}
+
+// traceSettings returns the trace settings captured when the trace started.
+func (s *span) traceSettings() *traceSettings { return s.settings }
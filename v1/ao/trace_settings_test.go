@@ -0,0 +1,85 @@
+// Copyright (C) 2019 Librato, Inc. All rights reserved.
+
+package ao
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
+	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReloadKeepsTracesInFlight(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_SQL_SAMPLE_RATES")
+		os.Unsetenv("APPOPTICS_MIN_SPAN_DURATION")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	tr := NewTrace("inflight")
+	ctx := NewContext(context.Background(), tr)
+
+	// reload with the SELECT queries dropped and the short spans folded
+	os.Setenv("APPOPTICS_SQL_SAMPLE_RATES", "select=0")
+	os.Setenv("APPOPTICS_MIN_SPAN_DURATION", "1000000")
+	config.Load()
+
+	tr2 := NewTrace("new")
+	ctx2 := NewContext(context.Background(), tr2)
+
+	// the trace in flight is reported with the settings it started with
+	BeginQuerySpan(ctx, "query", "SELECT 1", "mysql", "remote.host").End()
+	fast, _ := BeginSpan(ctx, "fast")
+	fast.End()
+	tr.End()
+
+	// the trace started after the reload is affected
+	BeginQuerySpan(ctx2, "query2", "SELECT 1", "mysql", "remote.host").End()
+	fast2, _ := BeginSpan(ctx2, "fast2")
+	fast2.End()
+	tr2.End()
+
+	r.Close(8)
+	g.AssertGraph(t, r.EventBufs, 8, g.AssertNodeMap{
+		{"inflight", "entry"}: {},
+		{"query", "entry"}:    {Edges: g.Edges{{"inflight", "entry"}}},
+		{"query", "exit"}:     {Edges: g.Edges{{"query", "entry"}}},
+		{"fast", "entry"}:     {Edges: g.Edges{{"inflight", "entry"}}},
+		{"fast", "exit"}:      {Edges: g.Edges{{"fast", "entry"}}},
+		{"inflight", "exit"}: {Edges: g.Edges{
+			{"query", "exit"}, {"fast", "exit"}, {"inflight", "entry"}}},
+		{"new", "entry"}: {},
+		{"new", "exit"}:  {Edges: g.Edges{{"new", "entry"}}},
+	})
+}
+
+func TestTraceSettingsCapturedAtStart(t *testing.T) {
+	defer func() {
+		os.Unsetenv("APPOPTICS_MIN_SPAN_DURATION")
+		config.Load()
+	}()
+
+	r := reporter.SetTestReporter()
+	tr := NewTrace("root").(*aoTrace)
+	ctx := NewContext(context.Background(), tr)
+
+	os.Setenv("APPOPTICS_MIN_SPAN_DURATION", "1000000")
+	config.Load()
+
+	// the spans share the settings of their trace
+	s, sctx := BeginSpan(ctx, "span")
+	p := BeginProfile(sctx, "profile")
+	assert.Equal(t, tr.settings, s.(*layerSpan).settings)
+	assert.Equal(t, tr.settings, p.(*profileSpan).settings)
+	assert.False(t, tr.settings.deferEntry())
+	assert.True(t, newTraceSettings().deferEntry())
+	p.End()
+	s.End()
+	tr.End()
+	r.Close(6)
+}
@@ -5,6 +5,7 @@ package ao_test
 import (
 	"errors"
 	"os"
+	"runtime"
 	"strings"
 	"testing"
 	"time"
@@ -12,6 +13,7 @@ import (
 	"context"
 
 	"github.com/appoptics/appoptics-apm-go/v1/ao"
+	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/config"
 	g "github.com/appoptics/appoptics-apm-go/v1/ao/internal/graphtest"
 	"github.com/appoptics/appoptics-apm-go/v1/ao/internal/reporter"
 	"github.com/stretchr/testify/assert"
@@ -41,6 +43,45 @@ func TestTraceMetadata(t *testing.T) {
 	})
 }
 
+func TestTraceRuntimeKVs(t *testing.T) {
+	r := reporter.SetTestReporter()
+	tr := ao.NewTrace("runtime")
+	l := tr.BeginSpan("child")
+	l.End()
+	tr.End()
+
+	r.Close(4)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"runtime", "entry"}: {Callback: func(n g.Node) {
+			assert.Equal(t, strings.TrimPrefix(runtime.Version(), "go"), n.Map["Go.Version"])
+			assert.Equal(t, runtime.GOMAXPROCS(0), n.Map["Go.GOMAXPROCS"])
+		}},
+		// only the root span reports them
+		{"child", "entry"}: {Edges: g.Edges{{"runtime", "entry"}}, Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "Go.Version")
+		}},
+		{"child", "exit"}:   {Edges: g.Edges{{"child", "entry"}}},
+		{"runtime", "exit"}: {Edges: g.Edges{{"child", "exit"}, {"runtime", "entry"}}},
+	})
+
+	os.Setenv("APPOPTICS_RUNTIME_KVS", "false")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_RUNTIME_KVS")
+		config.Load()
+	}()
+	r = reporter.SetTestReporter()
+	ao.NewTrace("runtime").End()
+	r.Close(2)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"runtime", "entry"}: {Callback: func(n g.Node) {
+			assert.NotContains(t, n.Map, "Go.Version")
+			assert.NotContains(t, n.Map, "Go.GOMAXPROCS")
+		}},
+		{"runtime", "exit"}: {Edges: g.Edges{{"runtime", "entry"}}},
+	})
+}
+
 func TestNoTraceMetadata(t *testing.T) {
 	r := reporter.SetTestReporter(reporter.TestReporterDisableTracing())
 
@@ -389,3 +430,98 @@ func TestTraceWithOptions(t *testing.T) {
 		{"testWithBacktrace", "exit"}: {Edges: g.Edges{{"testWithBacktrace", "entry"}}},
 	})
 }
+
+func TestSetQueueTime(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+
+	enqueuedAt := time.Now().Add(-3 * time.Second)
+	tr := ao.NewTrace("worker")
+	ctx := ao.NewContext(context.Background(), tr)
+	assert.Error(t, ao.SetQueueTime(ctx, time.Now().Add(time.Hour)))
+	assert.NoError(t, ao.SetQueueTime(ctx, enqueuedAt))
+	tr.End()
+
+	// no-op without a trace
+	assert.NoError(t, ao.SetQueueTime(context.Background(), time.Now().Add(-time.Second)))
+
+	r.Close(3)
+	g.AssertGraph(t, r.EventBufs, 2, g.AssertNodeMap{
+		{"worker", "entry"}: {},
+		{"worker", "exit"}: {Edges: g.Edges{{"worker", "entry"}}, Callback: func(n g.Node) {
+			queueTime, ok := n.Map["QueueTime"].(int64)
+			assert.True(t, ok)
+			assert.True(t, queueTime >= int64(3*time.Second/time.Microsecond), queueTime)
+			assert.True(t, queueTime < int64(4*time.Second/time.Microsecond), queueTime)
+		}},
+	})
+	if assert.Len(t, r.SpanMessages, 1) {
+		m := r.SpanMessages[0].(*reporter.HTTPSpanMessage)
+		assert.True(t, m.QueueTime >= 3*time.Second, m.QueueTime)
+	}
+}
+
+func TestSetTransactionNameAfterStart(t *testing.T) {
+	r := reporter.SetTestReporter() // set up test reporter
+
+	tr := ao.NewTrace("router")
+	ctx := ao.NewContext(context.Background(), tr)
+	assert.NoError(t, ao.SetTransactionName(ctx, "initial"))
+
+	// the logical name is resolved later, in a child span
+	l, childCtx := ao.BeginSpan(ctx, "resolve")
+	assert.NoError(t, ao.SetTransactionName(childCtx, "orders.create"))
+	assert.Equal(t, "orders.create", ao.GetTransactionName(ctx))
+	l.End()
+	tr.End()
+
+	// it's too late once the trace has ended
+	assert.Error(t, ao.SetTransactionName(ctx, "late"))
+
+	r.Close(5)
+	g.AssertGraph(t, r.EventBufs, 4, g.AssertNodeMap{
+		{"router", "entry"}:  {},
+		{"resolve", "entry"}: {Edges: g.Edges{{"router", "entry"}}},
+		{"resolve", "exit"}:  {Edges: g.Edges{{"resolve", "entry"}}},
+		{"router", "exit"}: {Edges: g.Edges{{"resolve", "exit"}, {"router", "entry"}}, Callback: func(n g.Node) {
+			assert.Equal(t, "orders.create", n.Map["TransactionName"])
+		}},
+	})
+	if assert.Len(t, r.SpanMessages, 1) {
+		m := r.SpanMessages[0].(*reporter.HTTPSpanMessage)
+		assert.Equal(t, "orders.create", m.Transaction)
+	}
+}
+
+func traceTxnNames(t *testing.T, paths ...string) []string {
+	r := reporter.SetTestReporter() // set up test reporter
+	for _, path := range paths {
+		tr := ao.NewTrace("web")
+		tr.SetPath(path)
+		tr.End()
+	}
+	r.Close(3 * len(paths))
+
+	var names []string
+	for _, m := range r.SpanMessages {
+		names = append(names, m.(*reporter.HTTPSpanMessage).Transaction)
+	}
+	return names
+}
+
+func TestTransactionNameNormalization(t *testing.T) {
+	paths := []string{"/orders", "/orders/", "/Orders/", "/", "//"}
+	assert.Equal(t, []string{"/orders", "/orders/", "/Orders/", "/", "//"}, traceTxnNames(t, paths...))
+
+	os.Setenv("APPOPTICS_TRIM_TRAILING_SLASH", "true")
+	config.Load()
+	defer func() {
+		os.Unsetenv("APPOPTICS_TRIM_TRAILING_SLASH")
+		os.Unsetenv("APPOPTICS_LOWERCASE_TRANSACTION_NAME")
+		config.Load()
+	}()
+	assert.Equal(t, []string{"/orders", "/orders", "/Orders", "/", "/"}, traceTxnNames(t, paths...))
+
+	os.Setenv("APPOPTICS_LOWERCASE_TRANSACTION_NAME", "true")
+	config.Load()
+	assert.Equal(t, []string{"/orders", "/orders", "/orders", "/", "/"}, traceTxnNames(t, paths...))
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 func actionFromMethod(method string) string {
@@ -65,6 +66,23 @@ func getTopFramePkg(st StackTracer) (string, error) {
 	return fp.Base(fp.Dir(frames[1])), nil
 }
 
+// clientIP resolves the IP address of the client of the RPC from the peer of
+// the connection, or the forwarding headers set by a trusted proxy.
+func clientIP(ctx context.Context, md metadata.MD) string {
+	var remoteAddr string
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		remoteAddr = p.Addr.String()
+	}
+	var forwardedFor, realIP string
+	if v := md[strings.ToLower(ao.ForwardedForHeaderName)]; len(v) > 0 {
+		forwardedFor = v[0]
+	}
+	if v := md[strings.ToLower(ao.RealIPHeaderName)]; len(v) > 0 {
+		realIP = v[0]
+	}
+	return ao.ClientIP(remoteAddr, forwardedFor, realIP)
+}
+
 func tracingContext(ctx context.Context, serverName string, methodName string, statusCode *int) (context.Context, ao.Trace) {
 
 	action := actionFromMethod(methodName)
@@ -79,17 +97,21 @@ func tracingContext(ctx context.Context, serverName string, methodName string, s
 		}
 	}
 
-	t := ao.NewTraceFromIDForURL(serverName, xtID, methodName, func() ao.KVMap {
-		return ao.KVMap{
+	t := ao.NewTraceFromIDForOrigin(serverName, xtID, methodName, ao.TraceOriginGRPC, func() ao.KVMap {
+		kvs := ao.KVMap{
 			"Method":     "POST",
 			"Controller": serverName,
 			"Action":     action,
 			"URL":        methodName,
 			"Status":     statusCode,
 		}
+		if ip := clientIP(ctx, md); ip != "" {
+			kvs["ClientIP"] = ip
+		}
+		return kvs
 	})
 	t.SetMethod("POST")
-	t.SetTransactionName(serverName + "." + action)
+	t.SetTransactionName(ao.GRPCTransactionName(serverName, methodName))
 	t.SetStartTime(time.Now())
 
 	return ao.NewContext(ctx, t), t
@@ -181,7 +203,7 @@ func UnaryClientInterceptor(target string, serviceName string) grpc.UnaryClientI
 		action := actionFromMethod(method)
 		span := ao.BeginRPCSpan(ctx, action, "grpc", serviceName, target)
 		defer span.End()
-		xtID := span.MetadataString()
+		xtID := ao.PropagationMetadata(span)
 		if len(xtID) > 0 {
 			ctx = metadata.AppendToOutgoingContext(ctx, ao.HTTPHeaderName, xtID)
 		}
@@ -201,7 +223,7 @@ func StreamClientInterceptor(target string, serviceName string) grpc.StreamClien
 	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
 		action := actionFromMethod(method)
 		span := ao.BeginRPCSpan(ctx, action, "grpc", serviceName, target)
-		xtID := span.MetadataString()
+		xtID := ao.PropagationMetadata(span)
 		// lg.Debug("stream client interceptor", "x-trace", xtID)
 		if len(xtID) > 0 {
 			ctx = metadata.AppendToOutgoingContext(ctx, ao.HTTPHeaderName, xtID)
@@ -1,12 +1,17 @@
 package aogrpc
 
 import (
+	"net"
 	"testing"
 
+	"golang.org/x/net/context"
+
 	"github.com/appoptics/appoptics-apm-go/v1/ao"
 	"github.com/appoptics/appoptics-apm-go/v1/contrib/aogrpc/mocks"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 func TestGetTopFramePkg(t *testing.T) {
@@ -54,3 +59,15 @@ func TestGetTopFramePkg(t *testing.T) {
 	}
 
 }
+
+func TestClientIP(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 51234},
+	})
+	md := metadata.Pairs("x-forwarded-for", "203.0.113.7", "x-real-ip", "198.51.100.3")
+	// the forwarding headers are not trusted by default
+	assert.Equal(t, "10.0.0.1", clientIP(ctx, md))
+	assert.Equal(t, "10.0.0.1", clientIP(ctx, nil))
+	// no peer
+	assert.Equal(t, "", clientIP(context.Background(), nil))
+}